@@ -0,0 +1,179 @@
+package acme
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/cloudflare/cloudflare-go"
+)
+
+type CloudflareRecordProvider struct {
+	zoneApi *cloudflare.API
+	dnsApi  *cloudflare.API
+
+	mu      sync.Mutex
+	zoneIds map[string]string
+}
+
+// NewCloudflareRecordProvider uses the same credentials as the lego Cloudflare DNS provider
+func NewCloudflareRecordProvider() (*CloudflareRecordProvider, error) {
+
+	email := os.Getenv("CF_API_EMAIL")
+	key := os.Getenv("CF_API_KEY")
+	dnsToken := os.Getenv("CF_DNS_API_TOKEN")
+	zoneToken := os.Getenv("CF_ZONE_API_TOKEN")
+
+	var zoneApi, dnsApi *cloudflare.API
+	var err error
+	switch {
+	case dnsToken != "":
+		if zoneToken == "" {
+			zoneToken = dnsToken
+		}
+		dnsApi, err = cloudflare.NewWithAPIToken(dnsToken)
+		if err != nil {
+			return nil, fmt.Errorf("cloudflare: %w", err)
+		}
+		zoneApi, err = cloudflare.NewWithAPIToken(zoneToken)
+		if err != nil {
+			return nil, fmt.Errorf("cloudflare: %w", err)
+		}
+	case email != "" && key != "":
+		dnsApi, err = cloudflare.New(key, email)
+		if err != nil {
+			return nil, fmt.Errorf("cloudflare: %w", err)
+		}
+		zoneApi = dnsApi
+	default:
+		return nil, errors.New("cloudflare: some credentials information are missing: CF_DNS_API_TOKEN or CF_API_EMAIL,CF_API_KEY")
+	}
+
+	return &CloudflareRecordProvider{
+		zoneApi: zoneApi,
+		dnsApi:  dnsApi,
+		zoneIds: map[string]string{},
+	}, nil
+}
+
+func (p *CloudflareRecordProvider) FindZone(domain string) (string, error) {
+	return FindZone(domain)
+}
+
+func (p *CloudflareRecordProvider) ListRecords(zone string, type_ string) ([]DNSRecord, error) {
+
+	zoneId, err := p.zoneId(zone)
+	if err != nil {
+		return nil, err
+	}
+
+	records, _, err := p.dnsApi.ListDNSRecords(context.Background(), cloudflare.ZoneIdentifier(zoneId),
+		cloudflare.ListDNSRecordsParams{Type: type_})
+	if err != nil {
+		return nil, fmt.Errorf("cloudflare: failed to list records: %w", err)
+	}
+
+	var result []DNSRecord
+	for _, record := range records {
+		item := DNSRecord{
+			ID:      record.ID,
+			Type:    record.Type,
+			Name:    record.Name,
+			Content: record.Content,
+
+			CreatedTs: record.CreatedOn.UnixMilli(),
+		}
+		if record.Type == "CAA" {
+			item.Flags, item.Tag, item.Value = parseCloudflareCAA(record)
+		}
+		result = append(result, item)
+	}
+	return result, nil
+}
+
+func (p *CloudflareRecordProvider) CreateRecord(zone string, record DNSRecord) (DNSRecord, error) {
+
+	zoneId, err := p.zoneId(zone)
+	if err != nil {
+		return DNSRecord{}, err
+	}
+
+	params := cloudflare.CreateDNSRecordParams{
+		Type:    record.Type,
+		Name:    record.Name,
+		Content: record.Content,
+		TTL:     120,
+		Comment: "go-acme-service",
+	}
+	if record.Type == "CAA" {
+		params.Content = ""
+		params.Data = map[string]any{
+			"flags": record.Flags,
+			"tag":   record.Tag,
+			"value": record.Value,
+		}
+	}
+
+	created, err := p.dnsApi.CreateDNSRecord(context.Background(), cloudflare.ZoneIdentifier(zoneId), params)
+	if err != nil {
+		return DNSRecord{}, fmt.Errorf("cloudflare: failed to create record: %w", err)
+	}
+
+	record.ID = created.ID
+	record.Content = created.Content
+	record.CreatedTs = created.CreatedOn.UnixMilli()
+	return record, nil
+}
+
+func (p *CloudflareRecordProvider) DeleteRecord(zone string, id string) error {
+
+	zoneId, err := p.zoneId(zone)
+	if err != nil {
+		return err
+	}
+
+	err = p.dnsApi.DeleteDNSRecord(context.Background(), cloudflare.ZoneIdentifier(zoneId), id)
+	if err != nil {
+		return fmt.Errorf("cloudflare: failed to delete record: %w", err)
+	}
+	return nil
+}
+
+func (p *CloudflareRecordProvider) zoneId(zone string) (string, error) {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if id, ok := p.zoneIds[zone]; ok {
+		return id, nil
+	}
+
+	id, err := p.zoneApi.ZoneIDByName(zone)
+	if err != nil {
+		return "", fmt.Errorf("cloudflare: failed to find zone %s: %w", zone, err)
+	}
+	p.zoneIds[zone] = id
+	return id, nil
+}
+
+func parseCloudflareCAA(record cloudflare.DNSRecord) (uint8, string, string) {
+
+	if data, ok := record.Data.(map[string]any); ok {
+		flags, _ := data["flags"].(float64)
+		tag, _ := data["tag"].(string)
+		value, _ := data["value"].(string)
+		return uint8(flags), tag, value
+	}
+
+	// Fallback to content, e.g. 0 issue "letsencrypt.org"
+	parts := strings.SplitN(record.Content, " ", 3)
+	if len(parts) != 3 {
+		return 0, "", ""
+	}
+	flags, _ := strconv.Atoi(parts[0])
+	return uint8(flags), parts[1], strings.Trim(parts[2], `"`)
+}
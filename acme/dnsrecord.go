@@ -0,0 +1,45 @@
+package acme
+
+import (
+	"strings"
+
+	"github.com/go-acme/lego/v4/challenge/dns01"
+)
+
+type DNSRecord struct {
+	ID      string `json:"id"`
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+	Content string `json:"content"`
+
+	CreatedTs int64 `json:"created_ts,omitempty"`
+
+	// CAA only
+	Flags uint8  `json:"flags,omitempty"`
+	Tag   string `json:"tag,omitempty"`
+	Value string `json:"value,omitempty"`
+}
+
+// RecordProvider manages arbitrary records on the zones served by the DNS-01 provider
+type RecordProvider interface {
+	FindZone(domain string) (string, error)
+	ListRecords(zone string, type_ string) ([]DNSRecord, error)
+	CreateRecord(zone string, record DNSRecord) (DNSRecord, error)
+	DeleteRecord(zone string, id string) error
+}
+
+func NewRecordProvider() (RecordProvider, error) {
+
+	// Using cloudflare DNS provider
+	return NewCloudflareRecordProvider()
+}
+
+// FindZone returns the zone apex of domain without the trailing dot
+func FindZone(domain string) (string, error) {
+
+	zone, err := dns01.FindZoneByFqdnCustom(dns01.ToFqdn(strings.TrimPrefix(domain, "*.")), Resolvers)
+	if err != nil {
+		return "", err
+	}
+	return dns01.UnFqdn(zone), nil
+}
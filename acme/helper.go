@@ -2,28 +2,134 @@ package acme
 
 import (
 	"errors"
+	"strconv"
 	"strings"
 	"time"
+
+	"golang.org/x/net/idna"
+	"golang.org/x/net/publicsuffix"
 )
 
+// Max identifiers per order accepted by Let's Encrypt
+const MaxDomains = 100
+
+type DomainError struct {
+	Domain  string `json:"domain"`
+	Message string `json:"message"`
+}
+
+// DomainsError lists every rejected identifier of a request
+type DomainsError struct {
+	Errors []DomainError
+}
+
+func (e *DomainsError) Error() string {
+	var messages []string
+	for _, v := range e.Errors {
+		if v.Domain == "" {
+			messages = append(messages, v.Message)
+		} else {
+			messages = append(messages, v.Domain+": "+v.Message)
+		}
+	}
+	return strings.Join(messages, "; ")
+}
+
+// ValidateDomains normalizes and de-duplicates domains, rejecting the request if any identifier is invalid
 func ValidateDomains(domains []string) ([]string, error) {
 
 	if len(domains) == 0 {
-		return nil, errors.New("No domain was given")
+		return nil, &DomainsError{Errors: []DomainError{{Message: "No domain was given"}}}
 	}
 
 	map_ := make(map[string]struct{})
 
 	var result []string
+	var errs []DomainError
 	for _, str := range domains {
-		if _, exists := map_[str]; !exists {
-			map_[str] = struct{}{}
-			result = append(result, str)
+		domain, err := ValidateDomain(str)
+		if err != nil {
+			errs = append(errs, DomainError{Domain: str, Message: err.Error()})
+			continue
 		}
+		if _, exists := map_[domain]; !exists {
+			map_[domain] = struct{}{}
+			result = append(result, domain)
+		}
+	}
+
+	if len(result) > MaxDomains {
+		errs = append(errs, DomainError{Message: "Too many domains, max " + strconv.Itoa(MaxDomains)})
+	}
+	if len(errs) > 0 {
+		return nil, &DomainsError{Errors: errs}
 	}
 	return result, nil
 }
 
+// ValidateDomain returns the lowercase ASCII (punycode) form of domain
+func ValidateDomain(domain string) (string, error) {
+
+	domain = strings.TrimSuffix(strings.TrimSpace(domain), ".")
+	if domain == "" {
+		return "", errors.New("Empty domain")
+	}
+
+	// Wildcard is only allowed as the whole leftmost label
+	wildcard := strings.HasPrefix(domain, "*.")
+	name := strings.TrimPrefix(domain, "*.")
+	if strings.Contains(name, "*") {
+		return "", errors.New("Wildcard is only allowed as the leftmost label")
+	}
+
+	ascii, err := idna.Lookup.ToASCII(name)
+	if err != nil {
+		return "", errors.New("Invalid internationalized domain name: " + err.Error())
+	}
+	ascii = strings.ToLower(ascii)
+
+	if len(ascii) > 253 {
+		return "", errors.New("Domain is longer than 253 characters")
+	}
+
+	labels := strings.Split(ascii, ".")
+	if len(labels) < 2 {
+		return "", errors.New("Domain must have at least two labels")
+	}
+	for _, label := range labels {
+		if label == "" {
+			return "", errors.New("Domain has an empty label")
+		}
+		if len(label) > 63 {
+			return "", errors.New("Label " + label + " is longer than 63 characters")
+		}
+		if strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+			return "", errors.New("Label " + label + " starts or ends with a hyphen")
+		}
+		for _, r := range label {
+			if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-') {
+				return "", errors.New("Label " + label + " contains an invalid character")
+			}
+		}
+	}
+
+	tld := labels[len(labels)-1]
+	if strings.Trim(tld, "0123456789") == "" {
+		return "", errors.New("IP addresses are not supported")
+	}
+
+	// Public suffixes, e.g. co.uk, can not be certified
+	suffix, _ := publicsuffix.PublicSuffix(ascii)
+	if suffix == ascii {
+		return "", errors.New("Domain is a public suffix")
+	}
+
+	if wildcard {
+		return "*." + ascii, nil
+	}
+	return ascii, nil
+}
+
 func GetTimeoutAndIntervalForDomain(domain string) (bool, time.Duration, time.Duration) {
 
 	if strings.HasSuffix(domain,".id") {
@@ -0,0 +1,100 @@
+package acme
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestValidateDomain(t *testing.T) {
+
+	tests := []struct {
+		domain string
+		want   string // empty if rejected
+	}{
+		{"bücher.example", "xn--bcher-kva.example"},
+		{"*.bücher.example", "*.xn--bcher-kva.example"},
+		{"xn--bcher-kva.example", "xn--bcher-kva.example"},
+		{"WWW.Example.COM", "www.example.com"},
+		{"www.example.com.", "www.example.com"},
+		{" example.com ", "example.com"},
+		{"*.example.com", "*.example.com"},
+		{"example.co.uk", "example.co.uk"},
+		{"a.*.b.com", ""},
+		{"*a.b.com", ""},
+		{"*.*.b.com", ""},
+		{"a*.b.com", ""},
+		{"*", ""},
+		{strings.Repeat("a", 63) + ".com", strings.Repeat("a", 63) + ".com"},
+		{strings.Repeat("a", 64) + ".com", ""},
+		{strings.Repeat(strings.Repeat("a", 63)+".", 3) + strings.Repeat("a", 57) + ".com", strings.Repeat(strings.Repeat("a", 63)+".", 3) + strings.Repeat("a", 57) + ".com"},
+		{strings.Repeat(strings.Repeat("a", 63)+".", 3) + strings.Repeat("a", 58) + ".com", ""},
+		{"192.168.1.1", ""},
+		{"::1", ""},
+		{"[2001:db8::1]", ""},
+		{"co.uk", ""},
+		{"*.co.uk", ""},
+		{"com", ""},
+		{"*.com", ""},
+		{"-a.example.com", ""},
+		{"a_b.example.com", ""},
+		{"a..example.com", ""},
+		{"", ""},
+		{".", ""},
+	}
+	for _, test := range tests {
+		got, err := ValidateDomain(test.domain)
+		if test.want == "" {
+			if err == nil {
+				t.Errorf("ValidateDomain(%q) = %q, want an error", test.domain, got)
+			}
+			continue
+		}
+		if err != nil || got != test.want {
+			t.Errorf("ValidateDomain(%q) = %q %v, want %q", test.domain, got, err, test.want)
+		}
+	}
+}
+
+func TestValidateDomains(t *testing.T) {
+
+	// Duplicates are dropped once normalized, keeping the first occurrence first
+	domains, err := ValidateDomains([]string{"Example.com", "www.example.com", "example.com.", "WWW.example.com"})
+	if err != nil || strings.Join(domains, ",") != "example.com,www.example.com" {
+		t.Errorf("duplicates: got %v %v", domains, err)
+	}
+
+	// Every invalid identifier is reported, not only the first one
+	_, err = ValidateDomains([]string{"example.com", "a.*.example.com", "co.uk"})
+	var domainsErr *DomainsError
+	if !errors.As(err, &domainsErr) || len(domainsErr.Errors) != 2 ||
+		domainsErr.Errors[0].Domain != "a.*.example.com" || domainsErr.Errors[1].Domain != "co.uk" {
+		t.Errorf("invalid: got %v", err)
+	}
+
+	_, err = ValidateDomains(nil)
+	if !errors.As(err, &domainsErr) || len(domainsErr.Errors) != 1 {
+		t.Errorf("empty: got %v", err)
+	}
+
+	many := []string{}
+	for i := 0; i < MaxDomains; i++ {
+		many = append(many, "d"+strconv.Itoa(i)+".example.com")
+	}
+	domains, err = ValidateDomains(many)
+	if err != nil || len(domains) != MaxDomains {
+		t.Errorf("%d domains: got %d %v", MaxDomains, len(domains), err)
+	}
+
+	// Duplicates do not count towards the limit
+	domains, err = ValidateDomains(append(many, "D0.example.com"))
+	if err != nil || len(domains) != MaxDomains {
+		t.Errorf("%d domains and a duplicate: got %d %v", MaxDomains, len(domains), err)
+	}
+
+	_, err = ValidateDomains(append(many, "extra.example.com"))
+	if !errors.As(err, &domainsErr) || len(domainsErr.Errors) != 1 || domainsErr.Errors[0].Domain != "" {
+		t.Errorf("%d domains: got %v, want too many domains", MaxDomains+1, err)
+	}
+}
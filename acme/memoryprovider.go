@@ -0,0 +1,83 @@
+package acme
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryRecordProvider keeps records in memory, zones are the configured list
+type MemoryRecordProvider struct {
+	Zones []string
+
+	mu      sync.Mutex
+	nextId  int
+	records map[string][]DNSRecord
+}
+
+func NewMemoryRecordProvider(zones ...string) *MemoryRecordProvider {
+	return &MemoryRecordProvider{
+		Zones:   zones,
+		records: map[string][]DNSRecord{},
+	}
+}
+
+func (p *MemoryRecordProvider) FindZone(domain string) (string, error) {
+
+	name := strings.TrimPrefix(domain, "*.")
+	best := ""
+	for _, zone := range p.Zones {
+		if (name == zone || strings.HasSuffix(name, "."+zone)) && len(zone) > len(best) {
+			best = zone
+		}
+	}
+	if best == "" {
+		return "", errors.New("No zone found for " + domain)
+	}
+	return best, nil
+}
+
+func (p *MemoryRecordProvider) ListRecords(zone string, type_ string) ([]DNSRecord, error) {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var result []DNSRecord
+	for _, record := range p.records[zone] {
+		if type_ == "" || record.Type == type_ {
+			result = append(result, record)
+		}
+	}
+	return result, nil
+}
+
+func (p *MemoryRecordProvider) CreateRecord(zone string, record DNSRecord) (DNSRecord, error) {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.nextId++
+	record.ID = strconv.Itoa(p.nextId)
+	if record.CreatedTs == 0 {
+		record.CreatedTs = time.Now().UnixMilli()
+	}
+	p.records[zone] = append(p.records[zone], record)
+	return record, nil
+}
+
+func (p *MemoryRecordProvider) DeleteRecord(zone string, id string) error {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	records := p.records[zone]
+	for i, record := range records {
+		if record.ID == id {
+			p.records[zone] = append(records[:i], records[i+1:]...)
+			return nil
+		}
+	}
+	return errors.New("Record " + id + " not found")
+}
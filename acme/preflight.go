@@ -0,0 +1,311 @@
+package acme
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-acme/lego/v4/challenge"
+	"github.com/go-acme/lego/v4/challenge/dns01"
+	"github.com/go-acme/lego/v4/platform/wait"
+	"github.com/miekg/dns"
+)
+
+type PreflightCheck struct {
+	Name    string `json:"name"`
+	Ok      bool   `json:"ok"`
+	Message string `json:"message,omitempty"`
+}
+
+type PreflightDomain struct {
+	Domain string           `json:"domain"`
+	Ok     bool             `json:"ok"`
+	Checks []PreflightCheck `json:"checks"`
+}
+
+type PreflightResult struct {
+	Ok      bool              `json:"ok"`
+	Domains []PreflightDomain `json:"domains"`
+}
+
+// PreflightError is returned when issuance is refused because preflight failed
+type PreflightError struct {
+	Result *PreflightResult
+}
+
+func (e *PreflightError) Error() string {
+	var failed []string
+	for _, d := range e.Result.Domains {
+		if !d.Ok {
+			failed = append(failed, d.Domain)
+		}
+	}
+	return "Preflight failed for: " + strings.Join(failed, ", ")
+}
+
+// CAAError is returned when the CAA record set found at Name does not authorize Identity
+type CAAError struct {
+	Name     string
+	Identity string
+}
+
+func (e *CAAError) Error() string {
+	return fmt.Sprintf("CAA at %s does not authorize %s", e.Name, e.Identity)
+}
+
+// Preflight checks that every domain can be issued by the CA identified by caaIdentity,
+// without placing an order. A probe TXT record is created and removed through the provider.
+// Domains are checked concurrently, each waiting at most probeTimeout for its probe record.
+func Preflight(domains []string, provider challenge.Provider, caaIdentity string, resolvers []string) *PreflightResult {
+
+	result := &PreflightResult{
+		Ok:      true,
+		Domains: make([]PreflightDomain, len(domains)),
+	}
+
+	var wg sync.WaitGroup
+	for i, domain := range domains {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result.Domains[i] = preflightDomain(domain, provider, caaIdentity, resolvers)
+		}()
+	}
+	wg.Wait()
+
+	for _, item := range result.Domains {
+		if !item.Ok {
+			result.Ok = false
+		}
+	}
+	return result
+}
+
+func preflightDomain(domain string, provider challenge.Provider, caaIdentity string, resolvers []string) PreflightDomain {
+
+	item := PreflightDomain{
+		Domain: domain,
+		Ok:     true,
+	}
+	add := func(name string, err error, message string) {
+		check := PreflightCheck{
+			Name:    name,
+			Ok:      err == nil,
+			Message: message,
+		}
+		if err != nil {
+			check.Message = err.Error()
+			item.Ok = false
+		}
+		item.Checks = append(item.Checks, check)
+	}
+
+	// Syntax
+	domain, err := ValidateDomain(domain)
+	if err != nil {
+		add("syntax", err, "")
+		return item
+	}
+	add("syntax", nil, "")
+
+	name := strings.TrimPrefix(domain, "*.")
+
+	// CAA
+	message, err := CheckCAA(domain, caaIdentity, resolvers)
+	add("caa", err, message)
+
+	// Delegation
+	message, err = checkDelegation(name, resolvers)
+	add("delegation", err, message)
+
+	// Provider write access and resolver visibility
+	written, err := probe(name, provider, resolvers)
+	if !written {
+		add("dns_write", err, "")
+		return item
+	}
+	add("dns_write", nil, "")
+	add("dns_visible", err, "")
+
+	return item
+}
+
+// CheckCAA walks up the tree from domain and checks the first CAA record set found authorizes caaIdentity
+func CheckCAA(domain string, caaIdentity string, resolvers []string) (string, error) {
+
+	wildcard := strings.HasPrefix(domain, "*.")
+	labels := dns.SplitDomainName(strings.TrimPrefix(domain, "*."))
+
+	for i := range labels {
+
+		fqdn := dns.Fqdn(strings.Join(labels[i:], "."))
+		r, err := QueryDNS(fqdn, dns.TypeCAA, resolvers)
+		if err != nil {
+			return "", fmt.Errorf("Unable to query CAA for %s: %w", fqdn, err)
+		}
+
+		var records []*dns.CAA
+		for _, rr := range r.Answer {
+			if caa, ok := rr.(*dns.CAA); ok {
+				records = append(records, caa)
+			}
+		}
+		if len(records) == 0 {
+			continue
+		}
+
+		// issuewild takes precedence over issue for wildcard names
+		tag := "issue"
+		if wildcard {
+			for _, caa := range records {
+				if caa.Tag == "issuewild" {
+					tag = "issuewild"
+					break
+				}
+			}
+		}
+
+		found := false
+		for _, caa := range records {
+			if caa.Tag != tag {
+				continue
+			}
+			found = true
+			value := strings.TrimSpace(strings.SplitN(caa.Value, ";", 2)[0])
+			if strings.EqualFold(value, caaIdentity) {
+				return "Authorized by CAA at " + fqdn, nil
+			}
+		}
+		if !found {
+			return "No " + tag + " property at " + fqdn, nil
+		}
+		return "", &CAAError{Name: fqdn, Identity: caaIdentity}
+	}
+
+	return "No CAA records found", nil
+}
+
+func checkDelegation(name string, resolvers []string) (string, error) {
+
+	fqdn := "_acme-challenge." + dns.Fqdn(name)
+	r, err := QueryDNS(fqdn, dns.TypeCNAME, resolvers)
+	if err != nil {
+		return "", fmt.Errorf("Unable to query CNAME for %s: %w", fqdn, err)
+	}
+
+	for _, rr := range r.Answer {
+		if cname, ok := rr.(*dns.CNAME); ok && strings.EqualFold(cname.Hdr.Name, fqdn) {
+
+			// Delegated challenge, target zone must resolve
+			zone, err := dns01.FindZoneByFqdnCustom(cname.Target, resolvers)
+			if err != nil {
+				return "", fmt.Errorf("Delegation target %s has no zone: %w", cname.Target, err)
+			}
+			return "Delegated to " + cname.Target + " in zone " + zone, nil
+		}
+	}
+
+	return "Not delegated", nil
+}
+
+// probeTimeout caps the wait for the probe record to be visible
+const probeTimeout = 2 * time.Minute
+
+// probe creates a TXT record for name through provider and waits for resolvers to answer it. written
+// reports whether the record was created, err is then the failure to see it
+func probe(name string, provider challenge.Provider, resolvers []string) (written bool, err error) {
+
+	// A token of its own, the provider keys the records it created by domain and token
+	buf := make([]byte, 48)
+	_, err = rand.Read(buf)
+	if err != nil {
+		return false, err
+	}
+	token := "preflight-" + base64.RawURLEncoding.EncodeToString(buf[:16])
+	keyAuth := base64.RawURLEncoding.EncodeToString(buf[16:])
+
+	err = provider.Present(name, token, keyAuth)
+	if err != nil {
+		return false, fmt.Errorf("Unable to create probe record: %w", err)
+	}
+	defer func() {
+		err := provider.CleanUp(name, token, keyAuth)
+		if err != nil {
+			log.Println("Unable to remove probe record for", name, ":", err)
+		}
+	}()
+
+	timeout, interval := dns01.DefaultPropagationTimeout, dns01.DefaultPollingInterval
+	if p, ok := provider.(challenge.ProviderTimeout); ok {
+		timeout, interval = p.Timeout()
+	}
+
+	// Preflight answers a request, a slow zone is reported rather than waited for
+	propagation := timeout
+	limited := timeout > probeTimeout
+	if limited {
+		timeout = probeTimeout
+		interval = min(interval, probeTimeout/8)
+	}
+
+	info := dns01.GetChallengeInfo(name, keyAuth)
+	err = wait.For("preflight", timeout, interval, func() (bool, error) {
+		r, err := QueryDNS(info.EffectiveFQDN, dns.TypeTXT, resolvers)
+		if err != nil {
+			return false, err
+		}
+		for _, rr := range r.Answer {
+			if txt, ok := rr.(*dns.TXT); ok && strings.Join(txt.Txt, "") == info.Value {
+				return true, nil
+			}
+		}
+		return false, fmt.Errorf("Probe record %s not visible yet", info.EffectiveFQDN)
+	})
+	if err != nil {
+		if limited {
+			return true, fmt.Errorf("%w, propagation is given up to %v at issuance", err, propagation)
+		}
+		return true, err
+	}
+
+	return true, nil
+}
+
+// QueryDNS sends a recursive query to the first resolver that answers
+func QueryDNS(fqdn string, rtype uint16, resolvers []string) (*dns.Msg, error) {
+
+	if len(resolvers) == 0 {
+		return nil, errors.New("No resolver was given")
+	}
+
+	m := new(dns.Msg)
+	m.SetQuestion(fqdn, rtype)
+	m.SetEdns0(4096, false)
+
+	client := &dns.Client{Timeout: 10 * time.Second}
+
+	var errAll error
+	for _, resolver := range resolvers {
+		r, _, err := client.Exchange(m, resolver)
+		if err == nil && r.Truncated {
+			tcp := &dns.Client{Net: "tcp", Timeout: 10 * time.Second}
+			r, _, err = tcp.Exchange(m, resolver)
+		}
+		if err != nil {
+			errAll = errors.Join(errAll, err)
+			continue
+		}
+		if r.Rcode != dns.RcodeSuccess && r.Rcode != dns.RcodeNameError {
+			errAll = errors.Join(errAll, fmt.Errorf("%s returned %s", resolver, dns.RcodeToString[r.Rcode]))
+			continue
+		}
+		return r, nil
+	}
+
+	return nil, errAll
+}
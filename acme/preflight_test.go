@@ -0,0 +1,149 @@
+package acme
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-acme/lego/v4/challenge/dns01"
+	"github.com/miekg/dns"
+)
+
+// testZone is a DNS provider whose TXT records are served by a local resolver
+type testZone struct {
+	mu      sync.Mutex
+	records map[string]map[string]string
+	tokens  []string
+}
+
+func (z *testZone) Present(domain, token, keyAuth string) error {
+
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	info := dns01.GetChallengeInfo(domain, keyAuth)
+	if z.records[info.EffectiveFQDN] == nil {
+		z.records[info.EffectiveFQDN] = map[string]string{}
+	}
+	z.records[info.EffectiveFQDN][token] = info.Value
+	z.tokens = append(z.tokens, token)
+	return nil
+}
+
+func (z *testZone) CleanUp(domain, token, keyAuth string) error {
+
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	info := dns01.GetChallengeInfo(domain, keyAuth)
+	delete(z.records[info.EffectiveFQDN], token)
+	return nil
+}
+
+func (z *testZone) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+
+	m := new(dns.Msg)
+	m.SetReply(r)
+	question := r.Question[0]
+	if question.Qtype == dns.TypeTXT {
+		z.mu.Lock()
+		for _, value := range z.records[strings.ToLower(question.Name)] {
+			m.Answer = append(m.Answer, &dns.TXT{
+				Hdr: dns.RR_Header{Name: question.Name, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 60},
+				Txt: []string{value},
+			})
+		}
+		z.mu.Unlock()
+	}
+	w.WriteMsg(m)
+}
+
+func newTestResolver(t *testing.T, handler dns.Handler) string {
+
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan struct{})
+	server := &dns.Server{PacketConn: conn, Handler: handler, NotifyStartedFunc: func() { close(started) }}
+	go server.ActivateAndServe()
+	<-started
+	t.Cleanup(func() { server.Shutdown() })
+	return conn.LocalAddr().String()
+}
+
+func TestPreflightProbe(t *testing.T) {
+
+	t.Setenv("LEGO_DISABLE_CNAME_SUPPORT", "true")
+	zone := &testZone{records: map[string]map[string]string{}}
+	resolvers := []string{newTestResolver(t, zone)}
+
+	// The apex and wildcard probe the same record name at once
+	domains := []string{"a.example.com", "*.a.example.com", "b.example.com"}
+	result := Preflight(domains, zone, "letsencrypt.org", resolvers)
+	if !result.Ok {
+		t.Fatalf("preflight failed: %+v", result.Domains)
+	}
+	for i, item := range result.Domains {
+		if item.Domain != domains[i] {
+			t.Errorf("result %d is %s, want %s", i, item.Domain, domains[i])
+		}
+	}
+
+	// Every probe has a token of its own, all removed
+	seen := map[string]bool{}
+	for _, token := range zone.tokens {
+		if seen[token] {
+			t.Fatalf("token %q was used by more than one probe", token)
+		}
+		seen[token] = true
+	}
+	if len(seen) != len(domains) {
+		t.Fatalf("%d probes, want %d", len(seen), len(domains))
+	}
+	for fqdn, records := range zone.records {
+		if len(records) != 0 {
+			t.Errorf("probe records left at %s", fqdn)
+		}
+	}
+}
+
+// failingZone is a DNS provider refusing every record
+type failingZone struct{}
+
+func (failingZone) Present(domain, token, keyAuth string) error {
+	return errors.New("access denied")
+}
+
+func (failingZone) CleanUp(domain, token, keyAuth string) error {
+	return nil
+}
+
+func TestPreflightProbeFailure(t *testing.T) {
+
+	t.Setenv("LEGO_DISABLE_CNAME_SUPPORT", "true")
+	zone := &testZone{records: map[string]map[string]string{}}
+	resolvers := []string{newTestResolver(t, zone)}
+
+	written, err := probe("a.example.com", failingZone{}, resolvers)
+	if written || err == nil || !strings.Contains(err.Error(), "access denied") {
+		t.Fatalf("probe: written %v, err %v, want an unwritten record", written, err)
+	}
+
+	// Visibility is not checked for a record never written
+	result := Preflight([]string{"a.example.com"}, failingZone{}, "letsencrypt.org", resolvers)
+	if result.Ok {
+		t.Fatal("preflight passed with a failing provider")
+	}
+	checks := result.Domains[0].Checks
+	last := checks[len(checks)-1]
+	if last.Name != "dns_write" || last.Ok {
+		t.Errorf("last check %+v, want a failed dns_write", last)
+	}
+
+	written, err = probe("a.example.com", zone, resolvers)
+	if !written || err != nil {
+		t.Fatalf("probe: written %v, err %v, want a visible record", written, err)
+	}
+}
@@ -4,9 +4,15 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/go-acme/lego/v4/challenge"
 	"github.com/go-acme/lego/v4/providers/dns/cloudflare"
 )
 
+// Resolvers used for DNS-01 propagation checks and preflight lookups
+var Resolvers = []string{
+	"1.1.1.1:53",
+}
+
 type CloudflareDNSCustomTimeoutProvider struct {
 	*cloudflare.DNSProvider
 	timeout  time.Duration
@@ -33,3 +39,22 @@ func (p *CloudflareDNSCustomTimeoutProvider) Timeout() (timeout, interval time.D
 	fmt.Printf("Using custom timeout: %s, interval: %s\n", p.timeout, p.interval)
 	return p.timeout, p.interval
 }
+
+func NewDNSProvider(main string) (challenge.Provider, error) {
+
+	// Using cloudflare DNS provider
+	isDefault, timeout, interval := GetTimeoutAndIntervalForDomain(main)
+	if isDefault {
+		provider, err := cloudflare.NewDNSProvider()
+		if err != nil {
+			return nil, err
+		}
+		return provider, nil
+	}
+
+	provider, err := NewCloudflareDNSCustomTimeoutProvider(timeout, interval)
+	if err != nil {
+		return nil, err
+	}
+	return provider, nil
+}
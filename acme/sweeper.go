@@ -0,0 +1,77 @@
+package acme
+
+import (
+	"log"
+	"strings"
+)
+
+type SweptRecord struct {
+	Zone    string `json:"zone"`
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Content string `json:"content"`
+	Error   string `json:"error,omitempty"`
+}
+
+type SweepResult struct {
+	Ts      int64         `json:"ts"`
+	Zones   []string      `json:"zones"`
+	Removed []SweptRecord `json:"removed"`
+	Skipped []SweptRecord `json:"skipped"`
+	Failed  []SweptRecord `json:"failed"`
+}
+
+// SweepChallenges removes the _acme-challenge TXT records on zones reported as stale, the others are skipped
+func SweepChallenges(ts int64, provider RecordProvider, zones []string, stale func(record DNSRecord) bool) SweepResult {
+
+	result := SweepResult{
+		Ts:      ts,
+		Zones:   zones,
+		Removed: []SweptRecord{},
+		Skipped: []SweptRecord{},
+		Failed:  []SweptRecord{},
+	}
+
+	for _, zone := range zones {
+
+		records, err := provider.ListRecords(zone, "TXT")
+		if err != nil {
+			log.Println("Unable to list records on zone", zone, ":", err)
+			result.Failed = append(result.Failed, SweptRecord{Zone: zone, Error: err.Error()})
+			continue
+		}
+
+		for _, record := range records {
+
+			name := strings.TrimSuffix(strings.ToLower(record.Name), ".")
+			if !strings.HasPrefix(name, "_acme-challenge.") {
+				continue
+			}
+
+			item := SweptRecord{
+				Zone:    zone,
+				ID:      record.ID,
+				Name:    record.Name,
+				Content: record.Content,
+			}
+
+			if !stale(record) {
+				result.Skipped = append(result.Skipped, item)
+				continue
+			}
+
+			err = provider.DeleteRecord(zone, record.ID)
+			if err != nil {
+				log.Println("Unable to delete record", record.Name, "on zone", zone, ":", err)
+				item.Error = err.Error()
+				result.Failed = append(result.Failed, item)
+				continue
+			}
+
+			log.Println("Swept stale challenge record", record.Name, "on zone", zone)
+			result.Removed = append(result.Removed, item)
+		}
+	}
+
+	return result
+}
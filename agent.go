@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/widhaprasa/go-acme-service/service/agent"
+)
+
+// runAgent handles `agent -config agent.json [-once]`, pulling certs from the service to disk
+func runAgent(args []string) {
+
+	flags := flag.NewFlagSet("agent", flag.ExitOnError)
+	configPath := flags.String("config", "agent.json", "path of the agent config")
+	once := flags.Bool("once", false, "sync every certificate once then exit")
+	flags.Parse(args)
+
+	config, err := agent.LoadConfig(*configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	agent_, err := agent.NewAgent(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if *once {
+		err = agent_.Once(ctx)
+		if err != nil {
+			os.Exit(1)
+		}
+		return
+	}
+
+	log.Println("Agent", config.Id, "watching", len(config.Certs), "certificates of", config.Url)
+	agent_.Run(ctx)
+}
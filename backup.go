@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/widhaprasa/go-acme-service/env"
+	"github.com/widhaprasa/go-acme-service/repository"
+	"github.com/widhaprasa/go-acme-service/repository/sqldb"
+	"github.com/widhaprasa/go-acme-service/secret"
+	backupservice "github.com/widhaprasa/go-acme-service/service/backup"
+)
+
+// newBackupStore returns the configured backup store, nil if none is configured
+func newBackupStore(ctx context.Context) (backupservice.Store, error) {
+
+	if env.BACKUP_S3_BUCKET != "" {
+		return backupservice.NewS3Store(ctx, backupservice.S3Config{
+			Endpoint:  env.BACKUP_S3_ENDPOINT,
+			Bucket:    env.BACKUP_S3_BUCKET,
+			Prefix:    env.BACKUP_S3_PREFIX,
+			AccessKey: env.BACKUP_S3_ACCESS_KEY,
+			SecretKey: env.BACKUP_S3_SECRET_KEY,
+			Region:    env.BACKUP_S3_REGION,
+			Secure:    env.BACKUP_S3_SECURE,
+		})
+	}
+	if env.BACKUP_DIR != "" {
+		return &backupservice.DirStore{
+			Dir: env.BACKUP_DIR,
+		}, nil
+	}
+
+	return nil, nil
+}
+
+// newBackupService returns the backup service of db, nil if no backup store is configured
+func newBackupService(ctx context.Context, db *sqldb.DB, keyring *secret.Keyring) (*backupservice.BackupService, error) {
+
+	store, err := newBackupStore(ctx)
+	if err != nil || store == nil {
+		return nil, err
+	}
+
+	if db == nil || db.Dialect != sqldb.Sqlite {
+		return nil, errors.New("Backup is only supported for SQLite storage, back up PostgreSQL with pg_dump")
+	}
+
+	backupService := &backupservice.BackupService{
+		Db:        db,
+		Store:     store,
+		Retention: env.BACKUP_RETENTION,
+	}
+	if env.BACKUP_ENCRYPT {
+		if keyring == nil {
+			return nil, errors.New("Set MASTER_KEY or MASTER_KEY_FILE to encrypt backups")
+		}
+		backupService.Keyring = keyring
+	}
+
+	return backupService, nil
+}
+
+// runBackup handles `backup`, `backup list` and `backup prune`
+func runBackup(args []string) {
+
+	usage := "Usage: backup | backup list | backup prune"
+	command := ""
+	if len(args) > 0 {
+		command = args[0]
+	}
+
+	keyring, err := loadKeyring()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	db, err := repository.OpenDb(env.STORAGE, storageDSN())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	backupService, err := newBackupService(ctx, db, keyring)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if backupService == nil {
+		log.Fatal("Set BACKUP_DIR or BACKUP_S3_BUCKET to back up the database")
+	}
+
+	switch command {
+	case "":
+		snapshot, err := backupService.Backup(ctx, time.Now().UnixMilli())
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("Backed up database to", backupService.Store.String(), "as", snapshot.Name)
+
+	case "list":
+		list, err := backupService.List(ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tSIZE\tTAKEN\tENCRYPTED")
+		for _, v := range list {
+			fmt.Fprintf(w, "%s\t%d\t%s\t%t\n", v.Name, v.Size, time.UnixMilli(v.Ts).Format(time.RFC3339), v.Encrypted)
+		}
+		w.Flush()
+
+	case "prune":
+		count, err := backupService.Prune(ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("Pruned", count, "snapshots")
+
+	default:
+		log.Fatal(usage)
+	}
+}
+
+// runRestore handles `restore [-path file] <snapshot|latest>`, the service must be stopped
+func runRestore(args []string) {
+
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	path := flags.String("path", sqldb.FilePath(env.SQLITE_PATH), "SQLite database file to replace")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		log.Fatal("Usage: restore [-path file] <snapshot|" + backupservice.Latest + ">")
+	}
+	if env.STORAGE != repository.StorageSqlite && env.STORAGE != "" {
+		log.Fatal("Restore is only supported for SQLite storage")
+	}
+
+	keyring, err := loadKeyring()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx := context.Background()
+	store, err := newBackupStore(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if store == nil {
+		log.Fatal("Set BACKUP_DIR or BACKUP_S3_BUCKET to restore the database")
+	}
+
+	backupService := &backupservice.BackupService{
+		Store:   store,
+		Keyring: keyring,
+	}
+
+	ts := time.Now().UnixMilli()
+	snapshot, err := backupService.Restore(ctx, flags.Arg(0), *path, ts)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Println("Restored", *path, "from", snapshot.Name)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/widhaprasa/go-acme-service/env"
+	backupservice "github.com/widhaprasa/go-acme-service/service/backup"
+)
+
+// setTestEnv sets the configuration of a SQLite database at path backed up into dir, restored after the test
+func setTestEnv(t *testing.T, path string, dir string, masterKey string) {
+
+	t.Helper()
+	storage, sqlitePath, backupDir, encrypt, key := env.STORAGE, env.SQLITE_PATH, env.BACKUP_DIR, env.BACKUP_ENCRYPT, env.MASTER_KEY
+	t.Cleanup(func() {
+		env.STORAGE, env.SQLITE_PATH, env.BACKUP_DIR, env.BACKUP_ENCRYPT, env.MASTER_KEY = storage, sqlitePath, backupDir, encrypt, key
+	})
+
+	env.STORAGE = "sqlite"
+	env.SQLITE_PATH = path
+	env.BACKUP_DIR = dir
+	env.BACKUP_ENCRYPT = masterKey != ""
+	env.MASTER_KEY = masterKey
+}
+
+// execTestDb runs query on the SQLite database at path
+func execTestDb(t *testing.T, path string, query string, args ...any) {
+
+	t.Helper()
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	_, err = db.Exec(query, args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRunBackupRestore(t *testing.T) {
+
+	for _, encrypted := range []bool{false, true} {
+		t.Run("encrypted="+strconv.FormatBool(encrypted), func(t *testing.T) {
+
+			dir := t.TempDir()
+			path := filepath.Join(dir, "acme.db")
+			masterKey := ""
+			if encrypted {
+				key := make([]byte, 32)
+				_, err := rand.Read(key)
+				if err != nil {
+					t.Fatal(err)
+				}
+				masterKey = base64.StdEncoding.EncodeToString(key)
+			}
+			setTestEnv(t, path, filepath.Join(dir, "backups"), masterKey)
+
+			execTestDb(t, path, "CREATE TABLE item (value TEXT)")
+			execTestDb(t, path, "INSERT INTO item (value) VALUES ('before')")
+			runBackup(nil)
+
+			entries, err := os.ReadDir(env.BACKUP_DIR)
+			if err != nil || len(entries) != 1 {
+				t.Fatalf("backups: got %v %v, want one snapshot", entries, err)
+			}
+			snapshot, ok := backupservice.ParseSnapshotName(entries[0].Name())
+			if !ok || snapshot.Encrypted != encrypted {
+				t.Fatalf("snapshot: got %s", entries[0].Name())
+			}
+
+			execTestDb(t, path, "UPDATE item SET value = 'after'")
+			runRestore([]string{backupservice.Latest})
+
+			db, err := sql.Open("sqlite3", path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			var value string
+			err = db.QueryRow("SELECT value FROM item").Scan(&value)
+			if err != nil || value != "before" {
+				t.Errorf("restored value %q %v, want before", value, err)
+			}
+		})
+	}
+}
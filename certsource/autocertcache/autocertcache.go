@@ -0,0 +1,250 @@
+// Package autocertcache implements autocert.Cache against the service, so an autocert.Manager serves
+// the certificates issued and renewed centrally instead of ordering its own:
+//
+//	manager := &autocert.Manager{
+//		Prompt:      autocert.AcceptTOS,
+//		HostPolicy:  autocert.HostWhitelist("example.com"),
+//		RenewBefore: 20 * 24 * time.Hour,
+//		Cache: autocertcache.New(autocertcache.Config{
+//			Client:   certsource.Client{Url: "https://acme.internal:8080", Username: "user", Password: "pass"},
+//			Fallback: autocert.DirCache("/var/cache/autocert"),
+//			Email:    "ops@example.com",
+//		}),
+//	}
+//
+// RenewBefore must be shorter than the renewal period of the service, 30 days, or the manager renews
+// the certificate itself before the service does
+package autocertcache
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+
+	"github.com/widhaprasa/go-acme-service/certsource"
+)
+
+const (
+	DefaultGenerateTimeout = 5 * time.Minute
+	DefaultGeneratePoll    = 5 * time.Second
+)
+
+// errKeyType is returned for a certificate whose key type differs from the one the manager asked for,
+// which the manager would reject
+var errKeyType = errors.New("Certificate key type does not match")
+
+type Config struct {
+	Client certsource.Client
+
+	// Fallback holds what the service does not: the ACME account key, challenge tokens, certificates the
+	// manager ordered itself, and the last certificate fetched from the service to serve while it is
+	// unreachable. Kept in memory if nil
+	Fallback autocert.Cache
+
+	// Email, when set, requests a certificate the service does not hold, issued under it, instead of
+	// letting the manager order it
+	Email string
+
+	// GenerateTimeout bounds the wait for a requested certificate
+	GenerateTimeout time.Duration
+}
+
+// Cache reads the certificates through the service, keyed by domain, with "+rsa" for the RSA one
+type Cache struct {
+	config Config
+
+	mu     sync.Mutex
+	memory map[string][]byte
+}
+
+func New(config Config) *Cache {
+
+	if config.GenerateTimeout <= 0 {
+		config.GenerateTimeout = DefaultGenerateTimeout
+	}
+	return &Cache{
+		config: config,
+		memory: map[string][]byte{},
+	}
+}
+
+func (c *Cache) Get(ctx context.Context, key string) ([]byte, error) {
+
+	domain, rsaKey, ok := certKey(key)
+	if !ok {
+		return c.fallbackGet(ctx, key)
+	}
+
+	bundle, err := c.config.Client.Fetch(ctx, domain, "")
+	if errors.Is(err, certsource.ErrNotFound) {
+
+		// A certificate the manager ordered itself
+		data, fallbackErr := c.fallbackGet(ctx, key)
+		if fallbackErr == nil || c.config.Email == "" {
+			return data, fallbackErr
+		}
+
+		bundle, err = c.generate(ctx, domain)
+	}
+	if err != nil {
+		log.Println("Unable to fetch certificate", domain, ", reading the last good one:", err)
+		return c.fallbackGet(ctx, key)
+	}
+
+	// The manager asks for ECDSA first and only accepts that key type. A miss has it order its own certificate,
+	// or read the one it ordered before, for the clients the service holds no certificate for
+	data, err := autocertPEM(bundle.PEM, rsaKey)
+	if errors.Is(err, errKeyType) {
+		return c.fallbackGet(ctx, key)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.fallbackPut(ctx, key, data)
+	if err != nil {
+		log.Println("Unable to keep certificate", domain, ":", err)
+	}
+	return data, nil
+}
+
+func (c *Cache) Put(ctx context.Context, key string, data []byte) error {
+	return c.fallbackPut(ctx, key, data)
+}
+
+func (c *Cache) Delete(ctx context.Context, key string) error {
+
+	if c.config.Fallback != nil {
+		return c.config.Fallback.Delete(ctx, key)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.memory, key)
+	return nil
+}
+
+// generate requests the certificate of domain and waits until the service stores it
+func (c *Cache) generate(ctx context.Context, domain string) (*certsource.Bundle, error) {
+
+	ctx, cancel := context.WithTimeout(ctx, c.config.GenerateTimeout)
+	defer cancel()
+
+	err := c.config.Client.Generate(ctx, domain, c.config.Email)
+	if err != nil {
+		return nil, err
+	}
+	log.Println("Requested certificate", domain, "from the service")
+
+	ticker := time.NewTicker(DefaultGeneratePoll)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil, errors.New("Timed out waiting for certificate " + domain)
+		}
+
+		bundle, err := c.config.Client.Fetch(ctx, domain, "")
+		if errors.Is(err, certsource.ErrNotFound) {
+			continue
+		}
+		return bundle, err
+	}
+}
+
+func (c *Cache) fallbackGet(ctx context.Context, key string) ([]byte, error) {
+
+	if c.config.Fallback != nil {
+		return c.config.Fallback.Get(ctx, key)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	data, ok := c.memory[key]
+	if !ok {
+		return nil, autocert.ErrCacheMiss
+	}
+	return data, nil
+}
+
+func (c *Cache) fallbackPut(ctx context.Context, key string, data []byte) error {
+
+	if c.config.Fallback != nil {
+		return c.config.Fallback.Put(ctx, key, data)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.memory[key] = data
+	return nil
+}
+
+// certKey returns the domain of a certificate key, false for the account key and challenge tokens
+func certKey(key string) (string, bool, bool) {
+
+	if domain, ok := strings.CutSuffix(key, "+rsa"); ok {
+		return domain, true, true
+	}
+	if strings.Contains(key, "+") {
+		return "", false, false
+	}
+	return key, false, true
+}
+
+// autocertPEM re-encodes a combined bundle the way the manager reads it, the private key followed by
+// the chain with nothing in between
+func autocertPEM(data []byte, rsaKey bool) ([]byte, error) {
+
+	var key *pem.Block
+	var chain []*pem.Block
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type == "CERTIFICATE" {
+			chain = append(chain, block)
+		} else if strings.HasSuffix(block.Type, "PRIVATE KEY") && key == nil {
+			key = block
+		}
+	}
+	if key == nil || len(chain) == 0 {
+		return nil, errors.New("Incomplete certificate bundle")
+	}
+
+	leaf, err := x509.ParseCertificate(chain[0].Bytes)
+	if err != nil {
+		return nil, err
+	}
+	switch leaf.PublicKey.(type) {
+	case *rsa.PublicKey:
+		if !rsaKey {
+			return nil, errKeyType
+		}
+	case *ecdsa.PublicKey:
+		if rsaKey {
+			return nil, errKeyType
+		}
+	}
+
+	var buf bytes.Buffer
+	for _, block := range append([]*pem.Block{key}, chain...) {
+		err = pem.Encode(&buf, &pem.Block{Type: block.Type, Bytes: block.Bytes})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,109 @@
+package autocertcache
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+
+	"github.com/widhaprasa/go-acme-service/certsource"
+)
+
+// newTestService serves an RSA certificate of domain from the distribution endpoint
+func newTestService(t *testing.T, domain string) *httptest.Server {
+
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: domain},
+		DNSNames:     []string{domain},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Combined format, the chain first
+	var combined bytes.Buffer
+	pem.Encode(&combined, &pem.Block{Type: "CERTIFICATE", Bytes: der})
+	pem.Encode(&combined, &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("domain") != domain {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("ETag", `"1"`)
+		w.Write(combined.Bytes())
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestGetKeyTypes(t *testing.T) {
+
+	ctx := context.Background()
+	server := newTestService(t, "a.test")
+	cache := New(Config{
+		Client: certsource.Client{Url: server.URL},
+	})
+
+	// The RSA certificate the service holds, key first
+	data, err := cache.Get(ctx, "a.test+rsa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, rest := pem.Decode(data)
+	if key == nil || key.Type != "RSA PRIVATE KEY" {
+		t.Fatalf("first block is not the private key")
+	}
+	if block, _ := pem.Decode(rest); block == nil || block.Type != "CERTIFICATE" {
+		t.Fatalf("second block is not the certificate")
+	}
+
+	// Asked for ECDSA, the manager would reject the RSA certificate, so it is a miss
+	_, err = cache.Get(ctx, "a.test")
+	if !errors.Is(err, autocert.ErrCacheMiss) {
+		t.Fatalf("ECDSA key: got %v, want cache miss", err)
+	}
+
+	// The certificate the manager then ordered itself
+	own := []byte("own certificate")
+	err = cache.Put(ctx, "a.test", own)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err = cache.Get(ctx, "a.test")
+	if err != nil || !bytes.Equal(data, own) {
+		t.Fatalf("ECDSA key: got %q %v, want the manager certificate", data, err)
+	}
+
+	// Unknown to the service without Email
+	_, err = cache.Get(ctx, "b.test+rsa")
+	if !errors.Is(err, autocert.ErrCacheMiss) {
+		t.Fatalf("unknown domain: got %v, want cache miss", err)
+	}
+
+	// Account key and tokens never reach the service
+	_, err = cache.Get(ctx, "acme_account+key")
+	if !errors.Is(err, autocert.ErrCacheMiss) {
+		t.Fatalf("account key: got %v, want cache miss", err)
+	}
+}
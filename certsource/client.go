@@ -0,0 +1,133 @@
+package certsource
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// ErrNotFound is returned when the service has no certificate for a domain
+var ErrNotFound = errors.New("Certificate not found")
+
+// Bundle is a version of a certificate, its private key followed by its full chain in PEM
+type Bundle struct {
+	ETag string
+	PEM  []byte
+}
+
+// Client fetches certificates from the distribution endpoint of the service
+type Client struct {
+
+	// Url of the service, along with its basic authentication credentials if enabled
+	Url      string
+	Username string
+	Password string
+
+	// Id names this consumer in the consumer tracking of the service
+	Id string
+
+	// Wildcard resolves a domain to the wildcard certificate of its parent domain when it has none
+	Wildcard bool
+
+	// HTTPClient defaults to http.DefaultClient
+	HTTPClient *http.Client
+}
+
+// Fetch returns the certificate of domain, or nil if etag is still its current version
+func (c *Client) Fetch(ctx context.Context, domain string, etag string) (*Bundle, error) {
+
+	query := url.Values{}
+	query.Set("domain", domain)
+	query.Set("wildcard", strconv.FormatBool(c.Wildcard))
+	query.Set("format", "combined")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(c.Url, "/")+"/certs/distribute?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.Username != "" {
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+	if c.Id != "" {
+		req.Header.Set("X-Agent-Id", c.Id)
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		data, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		return &Bundle{ETag: resp.Header.Get("ETag"), PEM: data}, nil
+	case http.StatusNotModified:
+		return nil, nil
+	case http.StatusNotFound:
+		return nil, ErrNotFound
+	}
+	return nil, errors.New("Unexpected status fetching " + domain + ": " + resp.Status)
+}
+
+// Generate requests a certificate of domain issued under email, fetched once the service stores it
+func (c *Client) Generate(ctx context.Context, domain string, email string) error {
+
+	body, err := json.Marshal(map[string]any{
+		"domain": domain,
+		"email":  email,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(c.Url, "/")+"/certs/generate", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.Username != "" {
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	var result struct {
+		Message string `json:"message"`
+	}
+	json.NewDecoder(resp.Body).Decode(&result)
+	if result.Message == "" {
+		result.Message = resp.Status
+	}
+	return errors.New("Unable to generate " + domain + ": " + result.Message)
+}
@@ -0,0 +1,476 @@
+// Package certsource serves certificates managed by the service to Go TLS servers:
+//
+//	source, err := certsource.New(certsource.Config{
+//		Client: certsource.Client{
+//			Url:      "https://acme.internal:8080",
+//			Username: "user",
+//			Password: "pass",
+//			Wildcard: true,
+//		},
+//		HostPolicy: certsource.AllowHosts("example.com", "*.example.com"),
+//		CacheDir:   "/var/cache/certsource",
+//	})
+//	server := &http.Server{
+//		TLSConfig: &tls.Config{GetCertificate: source.GetCertificate},
+//	}
+package certsource
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultRefreshInterval = time.Hour
+	DefaultRefreshBefore   = 7 * 24 * time.Hour
+	DefaultMissTTL         = time.Minute
+	DefaultFetchTimeout    = 30 * time.Second
+)
+
+// Bounds of the wait before retrying a failed refresh, doubled on each failure
+const (
+	retryMin = 10 * time.Second
+	retryMax = 10 * time.Minute
+)
+
+// Number of missed names kept before the expired ones are dropped
+const missesPrune = 1024
+
+// ErrHostNotAllowed is returned for a name rejected by the host policy, it is not fetched
+var ErrHostNotAllowed = errors.New("Host not allowed")
+
+// HostPolicy returns an error for a name the source must not fetch, the signature of autocert.HostPolicy
+type HostPolicy func(ctx context.Context, host string) error
+
+// AllowHosts allows the given names, *.example.com allowing every name under example.com
+func AllowHosts(hosts ...string) HostPolicy {
+
+	exact := map[string]bool{}
+	var suffixes []string
+	for _, host := range hosts {
+		host = strings.ToLower(strings.TrimSuffix(host, "."))
+		if base, ok := strings.CutPrefix(host, "*."); ok {
+			suffixes = append(suffixes, "."+base)
+		} else {
+			exact[host] = true
+		}
+	}
+
+	return func(ctx context.Context, host string) error {
+		if exact[host] {
+			return nil
+		}
+		for _, suffix := range suffixes {
+			if strings.HasSuffix(host, suffix) {
+				return nil
+			}
+		}
+		return ErrHostNotAllowed
+	}
+}
+
+type Config struct {
+	Client Client
+
+	// HostPolicy is checked before a name is fetched. Every handshake of a name unknown to the source fetches
+	// it from the service unless missed recently, so set it on servers reachable by any client
+	HostPolicy HostPolicy
+
+	// CacheDir keeps the certificates on disk to serve them across restarts while the service is unreachable,
+	// they are only kept in memory if empty
+	CacheDir string
+
+	// RefreshInterval is how often a served certificate is checked for a new version
+	RefreshInterval time.Duration
+
+	// RefreshBefore checks for a new version on every handshake once the certificate expires within it
+	RefreshBefore time.Duration
+
+	// MissTTL is how long a name the service has no certificate for, or failed to return, is not asked again
+	MissTTL time.Duration
+
+	// FetchTimeout bounds a fetch made during a handshake
+	FetchTimeout time.Duration
+}
+
+// Source returns the certificates of the service by SNI. A certificate is fetched on the first handshake
+// of its name, then served from memory and refreshed in the background. The last good certificate is served
+// while the service is unreachable
+type Source struct {
+	config Config
+
+	mu      sync.Mutex
+	entries map[string]*entry
+	misses  map[string]miss
+}
+
+type entry struct {
+	cert      *tls.Certificate
+	etag      string
+	notAfter  time.Time
+	checkedAt time.Time
+
+	// Failed refreshes in a row, the next one waits until retryAt
+	failures int
+	retryAt  time.Time
+
+	// Closed once the running refresh is done, nil if none is running
+	refreshing chan struct{}
+}
+
+// miss is a name that could not be fetched, not asked again until MissTTL is over
+type miss struct {
+	ts  time.Time
+	err error
+}
+
+// cacheMeta is kept next to a certificate in the cache dir
+type cacheMeta struct {
+	ETag string `json:"etag"`
+}
+
+func New(config Config) (*Source, error) {
+
+	if config.Client.Url == "" {
+		return nil, errors.New("Source requires the url of the service")
+	}
+	if config.RefreshInterval <= 0 {
+		config.RefreshInterval = DefaultRefreshInterval
+	}
+	if config.RefreshBefore <= 0 {
+		config.RefreshBefore = DefaultRefreshBefore
+	}
+	if config.MissTTL <= 0 {
+		config.MissTTL = DefaultMissTTL
+	}
+	if config.FetchTimeout <= 0 {
+		config.FetchTimeout = DefaultFetchTimeout
+	}
+	if config.CacheDir != "" {
+		err := os.MkdirAll(config.CacheDir, 0700)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &Source{
+		config:  config,
+		entries: map[string]*entry{},
+		misses:  map[string]miss{},
+	}, nil
+}
+
+// GetCertificate is meant for tls.Config
+func (s *Source) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+
+	if hello.ServerName == "" {
+		return nil, errors.New("Missing server name")
+	}
+
+	ctx := context.Background()
+	if hello.Context() != nil {
+		ctx = hello.Context()
+	}
+	return s.Get(ctx, hello.ServerName)
+}
+
+// Get returns the certificate of name, fetching it if it is neither in memory nor in the cache dir
+func (s *Source) Get(ctx context.Context, name string) (*tls.Certificate, error) {
+
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	if !validName(name) {
+		return nil, errors.New("Invalid server name")
+	}
+
+	now := time.Now()
+
+	s.mu.Lock()
+	e, exists := s.entries[name]
+	if !exists {
+		if m, missed := s.misses[name]; missed && now.Sub(m.ts) < s.config.MissTTL {
+			s.mu.Unlock()
+			return nil, m.err
+		}
+	}
+	s.mu.Unlock()
+
+	if !exists {
+		if s.config.HostPolicy != nil {
+			err := s.config.HostPolicy(ctx, name)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		s.mu.Lock()
+		e, exists = s.entries[name]
+		if !exists {
+			e = s.loadCache(name)
+			if e != nil {
+				s.entries[name] = e
+			}
+		}
+		s.mu.Unlock()
+	}
+
+	// First handshake of the name
+	if e == nil {
+		return s.fetchFirst(ctx, name)
+	}
+
+	s.mu.Lock()
+	due := now.Sub(e.checkedAt) >= s.config.RefreshInterval || e.notAfter.Sub(now) <= s.config.RefreshBefore
+	due = due && !now.Before(e.retryAt)
+	s.mu.Unlock()
+
+	if due {
+		done := s.refresh(name, e)
+
+		// An expired certificate is only served if no new version can be fetched in time
+		if now.After(e.notAfter) {
+			select {
+			case <-done:
+			case <-ctx.Done():
+			}
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.entries[name].cert, nil
+}
+
+// fetchFirst fetches a name not held yet, during the handshake
+func (s *Source) fetchFirst(ctx context.Context, name string) (*tls.Certificate, error) {
+
+	ctx, cancel := context.WithTimeout(ctx, s.config.FetchTimeout)
+	defer cancel()
+
+	bundle, err := s.config.Client.Fetch(ctx, name, "")
+	if err != nil {
+		s.addMiss(name, err)
+		return nil, err
+	}
+
+	e, err := s.store(name, bundle)
+	if err != nil {
+		return nil, err
+	}
+	return e.cert, nil
+}
+
+// refresh checks for a new version of name in the background, returning a channel closed once done
+func (s *Source) refresh(name string, e *entry) <-chan struct{} {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if e.refreshing != nil {
+		return e.refreshing
+	}
+	done := make(chan struct{})
+	e.refreshing = done
+
+	go func() {
+		defer close(done)
+
+		ctx, cancel := context.WithTimeout(context.Background(), s.config.FetchTimeout)
+		defer cancel()
+
+		bundle, err := s.config.Client.Fetch(ctx, name, e.etag)
+
+		s.mu.Lock()
+		e.refreshing = nil
+		if err == nil {
+			e.checkedAt = time.Now()
+			e.failures = 0
+			e.retryAt = time.Time{}
+		} else {
+			e.failures++
+			e.retryAt = time.Now().Add(retryDelay(e.failures))
+		}
+		s.mu.Unlock()
+
+		if err != nil {
+			log.Println("Unable to refresh certificate", name, ", serving the last good one:", err)
+			return
+		}
+		if bundle == nil {
+			return
+		}
+
+		_, err = s.store(name, bundle)
+		if err != nil {
+			log.Println("Unable to load certificate", name, ", serving the last good one:", err)
+		}
+	}()
+
+	return done
+}
+
+// addMiss keeps name from being fetched again until MissTTL is over
+func (s *Source) addMiss(name string, err error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if len(s.misses) >= missesPrune {
+		for missName, m := range s.misses {
+			if now.Sub(m.ts) >= s.config.MissTTL {
+				delete(s.misses, missName)
+			}
+		}
+	}
+	s.misses[name] = miss{ts: now, err: err}
+}
+
+// retryDelay is the wait before retrying after failures refreshes failed in a row
+func retryDelay(failures int) time.Duration {
+
+	delay := retryMin
+	for i := 1; i < failures && delay < retryMax; i++ {
+		delay *= 2
+	}
+	return min(delay, retryMax)
+}
+
+// validName reports whether name is a DNS name, with a wildcard first label at most. Names come from
+// clients and end up in cache file names
+func validName(name string) bool {
+
+	if len(name) == 0 || len(name) > 253 {
+		return false
+	}
+	for i, label := range strings.Split(name, ".") {
+		if label == "*" && i == 0 {
+			continue
+		}
+		if len(label) == 0 || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, c := range label {
+			if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '-' {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// store parses a fetched bundle and keeps it in memory and in the cache dir
+func (s *Source) store(name string, bundle *Bundle) (*entry, error) {
+
+	e, err := newEntry(bundle.PEM, bundle.ETag)
+	if err != nil {
+		return nil, err
+	}
+	e.checkedAt = time.Now()
+
+	s.mu.Lock()
+	s.entries[name] = e
+	delete(s.misses, name)
+	s.mu.Unlock()
+
+	if s.config.CacheDir != "" {
+		err = s.saveCache(name, bundle)
+		if err != nil {
+			log.Println("Unable to cache certificate", name, ":", err)
+		}
+	}
+
+	return e, nil
+}
+
+func newEntry(data []byte, etag string) (*entry, error) {
+
+	// Both blocks are looked up in the combined PEM
+	cert, err := tls.X509KeyPair(data, data)
+	if err != nil {
+		return nil, err
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+	cert.Leaf = leaf
+
+	return &entry{
+		cert:     &cert,
+		etag:     etag,
+		notAfter: leaf.NotAfter,
+	}, nil
+}
+
+// loadCache returns the entry of name kept in the cache dir, checked for a new version on its next use
+func (s *Source) loadCache(name string) *entry {
+
+	if s.config.CacheDir == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(s.cachePath(name, ".pem"))
+	if err != nil {
+		return nil
+	}
+	var meta cacheMeta
+	metaData, err := os.ReadFile(s.cachePath(name, ".json"))
+	if err == nil {
+		json.Unmarshal(metaData, &meta)
+	}
+
+	e, err := newEntry(data, meta.ETag)
+	if err != nil {
+		log.Println("Ignoring invalid cached certificate", name, ":", err)
+		return nil
+	}
+	return e
+}
+
+func (s *Source) saveCache(name string, bundle *Bundle) error {
+
+	metaData, _ := json.Marshal(cacheMeta{ETag: bundle.ETag})
+
+	err := writeFileAtomic(s.cachePath(name, ".pem"), bundle.PEM)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.cachePath(name, ".json"), metaData)
+}
+
+func (s *Source) cachePath(name string, ext string) string {
+
+	// A wildcard name is not a valid file name everywhere
+	return filepath.Join(s.config.CacheDir, strings.ReplaceAll(name, "*", "_")+ext)
+}
+
+func writeFileAtomic(path string, data []byte) error {
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	closeErr := tmp.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
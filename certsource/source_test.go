@@ -0,0 +1,253 @@
+package certsource
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testService serves a certificate of each of its names from the distribution endpoint, counting fetches
+type testService struct {
+	*httptest.Server
+	certs   map[string][]byte
+	fetches atomic.Int32
+	down    atomic.Bool
+}
+
+func newTestService(t *testing.T, names ...string) *testService {
+
+	t.Helper()
+	s := &testService{certs: map[string][]byte{}}
+	for _, name := range names {
+		s.certs[name] = newCombined(t, name)
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.fetches.Add(1)
+		if s.down.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		data, ok := s.certs[r.URL.Query().Get("domain")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.Header.Get("If-None-Match") == `"1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"1"`)
+		w.Write(data)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func newCombined(t *testing.T, name string) []byte {
+
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: der})
+	pem.Encode(&buf, &pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return buf.Bytes()
+}
+
+func TestHostPolicy(t *testing.T) {
+
+	service := newTestService(t, "a.example.com", "other.test")
+	source, err := New(Config{
+		Client:     Client{Url: service.URL},
+		HostPolicy: AllowHosts("*.example.com"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	_, err = source.Get(ctx, "A.Example.com.")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = source.Get(ctx, "other.test")
+	if !errors.Is(err, ErrHostNotAllowed) {
+		t.Fatalf("got %v, want host not allowed", err)
+	}
+	_, err = source.Get(ctx, "example.com")
+	if !errors.Is(err, ErrHostNotAllowed) {
+		t.Fatalf("got %v, want host not allowed", err)
+	}
+	if count := service.fetches.Load(); count != 1 {
+		t.Fatalf("fetched %d times, want the allowed name only", count)
+	}
+}
+
+func TestInvalidNames(t *testing.T) {
+
+	service := newTestService(t)
+	dir := t.TempDir()
+	source, err := New(Config{
+		Client:   Client{Url: service.URL},
+		CacheDir: dir,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := []string{"../secret", "a/b.example.com", `a\b`, "a..b", ".example.com", "-a.example.com", "a.*.example.com",
+		"a b.example.com", string(bytes.Repeat([]byte("a"), 64)) + ".example.com"}
+	for _, name := range names {
+		_, err = source.Get(context.Background(), name)
+		if err == nil || errors.Is(err, ErrNotFound) {
+			t.Errorf("%q: got %v, want invalid name", name, err)
+		}
+	}
+	if count := service.fetches.Load(); count != 0 {
+		t.Fatalf("fetched %d times, want none", count)
+	}
+
+	// Nothing was written outside the cache dir either
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 0 {
+		t.Fatalf("cache dir has %d entries", len(entries))
+	}
+}
+
+func TestMisses(t *testing.T) {
+
+	service := newTestService(t)
+	source, err := New(Config{
+		Client:  Client{Url: service.URL},
+		MissTTL: time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		_, err = source.Get(context.Background(), "missing.test")
+		if !errors.Is(err, ErrNotFound) {
+			t.Fatalf("got %v, want not found", err)
+		}
+	}
+	if count := service.fetches.Load(); count != 1 {
+		t.Fatalf("fetched %d times, want once within MissTTL", count)
+	}
+
+	// Expired misses are dropped once there are enough of them
+	source.mu.Lock()
+	for i := 0; i < missesPrune; i++ {
+		source.misses["name"+strconv.Itoa(i)+".test"] = miss{ts: time.Now().Add(-2 * time.Hour)}
+	}
+	source.mu.Unlock()
+
+	source.Get(context.Background(), "another.test")
+	source.mu.Lock()
+	count := len(source.misses)
+	source.mu.Unlock()
+	if count != 2 {
+		t.Fatalf("%d misses kept, want the 2 recent ones", count)
+	}
+}
+
+func TestRefreshBackoff(t *testing.T) {
+
+	service := newTestService(t, "a.test")
+	source, err := New(Config{
+		Client:          Client{Url: service.URL},
+		RefreshInterval: time.Nanosecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	_, err = source.Get(ctx, "a.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every handshake is due for a refresh, only the first one after a failure fetches
+	service.down.Store(true)
+	for i := 0; i < 5; i++ {
+		cert, err := source.Get(ctx, "a.test")
+		if err != nil || cert == nil {
+			t.Fatalf("last good certificate not served: %v", err)
+		}
+		waitRefresh(source, "a.test")
+	}
+	if count := service.fetches.Load(); count != 2 {
+		t.Fatalf("fetched %d times, want 2", count)
+	}
+
+	source.mu.Lock()
+	e := source.entries["a.test"]
+	if e.failures != 1 || time.Until(e.retryAt) <= 0 {
+		t.Fatalf("failures %d retry at %v, want a backoff", e.failures, e.retryAt)
+	}
+
+	// Once the backoff is over, a success resets it
+	e.retryAt = time.Now()
+	source.mu.Unlock()
+	service.down.Store(false)
+	source.Get(ctx, "a.test")
+	waitRefresh(source, "a.test")
+
+	source.mu.Lock()
+	defer source.mu.Unlock()
+	if e.failures != 0 {
+		t.Fatalf("failures %d after a success, want 0", e.failures)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+
+	if retryDelay(1) != retryMin || retryDelay(2) != 2*retryMin || retryDelay(100) != retryMax {
+		t.Fatalf("unexpected delays %v %v %v", retryDelay(1), retryDelay(2), retryDelay(100))
+	}
+}
+
+// waitRefresh waits for the running refresh of name, if any
+func waitRefresh(source *Source, name string) {
+
+	source.mu.Lock()
+	done := source.entries[name].refreshing
+	source.mu.Unlock()
+	if done != nil {
+		<-done
+	}
+}
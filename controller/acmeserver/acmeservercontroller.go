@@ -0,0 +1,416 @@
+package acmeserver
+
+import (
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	acmeserverrepository "github.com/widhaprasa/go-acme-service/repository/acmeserver"
+	acmeserverservice "github.com/widhaprasa/go-acme-service/service/acmeserver"
+)
+
+// AcmeServerController serves the RFC 8555 endpoints of the ACME server under /acme
+type AcmeServerController struct {
+	AcmeServerRepository acmeserverrepository.AcmeServerRepository
+	AcmeServerService    *acmeserverservice.AcmeServerService
+}
+
+func (a *AcmeServerController) Directory(ctx *gin.Context) {
+
+	base := baseUrl(ctx) + "/acme"
+
+	ctx.Header("Cache-Control", "public, max-age=0, no-cache")
+	ctx.JSON(http.StatusOK, DirectoryResponse{
+		NewNonce:   base + "/new-nonce",
+		NewAccount: base + "/new-account",
+		NewOrder:   base + "/new-order",
+		RevokeCert: base + "/revoke-cert",
+		Meta: DirectoryMeta{
+			ExternalAccountRequired: true,
+		},
+	})
+}
+
+func (a *AcmeServerController) NewNonce(ctx *gin.Context) {
+
+	a.writeHeaders(ctx)
+	if ctx.Writer.Header().Get("Replay-Nonce") == "" {
+		a.writeProblem(ctx, &acmeserverservice.Problem{
+			Type:   acmeserverservice.ProblemServerInternal,
+			Detail: "Unable to issue a nonce",
+			Status: http.StatusServiceUnavailable,
+		})
+		return
+	}
+	if ctx.Request.Method == http.MethodHead {
+		ctx.Status(http.StatusOK)
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
+
+func (a *AcmeServerController) NewAccount(ctx *gin.Context) {
+
+	// Server time
+	ts := time.Now().UnixMilli()
+
+	req, ok := a.verify(ctx, true)
+	if !ok {
+		return
+	}
+	var payload NewAccountPayload
+	if !a.bindPayload(ctx, req, &payload) {
+		return
+	}
+
+	account, created, err := a.AcmeServerService.NewAccount(ts, req.jwk, payload.Contact, payload.OnlyReturnExisting,
+		payload.ExternalAccountBinding, baseUrl(ctx)+ctx.Request.URL.Path)
+	if err != nil {
+		a.writeError(ctx, err)
+		return
+	}
+
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	a.writeHeaders(ctx)
+	ctx.Header("Location", accountUrl(ctx, account))
+	ctx.JSON(status, newAccountResponse(ctx, account))
+}
+
+// Account returns the account, updating its contact or deactivating it when the payload says so
+func (a *AcmeServerController) Account(ctx *gin.Context) {
+
+	req, ok := a.verify(ctx, false)
+	if !ok {
+		return
+	}
+	if req.account.AccountId != ctx.Param("id") {
+		a.writeProblem(ctx, unauthorized("Request is not signed by this account"))
+		return
+	}
+	var payload AccountPayload
+	if !a.bindPayload(ctx, req, &payload) {
+		return
+	}
+
+	account, err := a.AcmeServerService.UpdateAccount(req.account, payload.Contact, payload.Status)
+	if err != nil {
+		a.writeError(ctx, err)
+		return
+	}
+
+	a.writeHeaders(ctx)
+	ctx.JSON(http.StatusOK, newAccountResponse(ctx, account))
+}
+
+func (a *AcmeServerController) AccountOrders(ctx *gin.Context) {
+
+	req, ok := a.verify(ctx, false)
+	if !ok {
+		return
+	}
+	if req.account.AccountId != ctx.Param("id") {
+		a.writeProblem(ctx, unauthorized("Request is not signed by this account"))
+		return
+	}
+
+	list, err := a.AcmeServerService.ListOrders(req.account)
+	if err != nil {
+		a.writeError(ctx, err)
+		return
+	}
+
+	orders := []string{}
+	for _, v := range list {
+		orders = append(orders, orderUrl(ctx, v.OrderId))
+	}
+
+	a.writeHeaders(ctx)
+	ctx.JSON(http.StatusOK, OrdersResponse{
+		Orders: orders,
+	})
+}
+
+func (a *AcmeServerController) NewOrder(ctx *gin.Context) {
+
+	// Server time
+	ts := time.Now().UnixMilli()
+
+	req, ok := a.verify(ctx, false)
+	if !ok {
+		return
+	}
+	var payload NewOrderPayload
+	if !a.bindPayload(ctx, req, &payload) {
+		return
+	}
+	if len(payload.Identifiers) == 0 {
+		a.writeProblem(ctx, malformed("No identifier was given"))
+		return
+	}
+
+	order, err := a.AcmeServerService.NewOrder(ts, req.account, req.key, payload.Identifiers)
+	if err != nil {
+		a.writeError(ctx, err)
+		return
+	}
+
+	a.writeHeaders(ctx)
+	ctx.Header("Location", orderUrl(ctx, order.OrderId))
+	ctx.JSON(http.StatusCreated, newOrderResponse(ctx, order))
+}
+
+func (a *AcmeServerController) Order(ctx *gin.Context) {
+
+	// Server time
+	ts := time.Now().UnixMilli()
+
+	req, ok := a.verify(ctx, false)
+	if !ok {
+		return
+	}
+
+	order, err := a.AcmeServerService.Order(ts, req.account, ctx.Param("id"))
+	if err != nil {
+		a.writeError(ctx, err)
+		return
+	}
+
+	a.writeOrder(ctx, order)
+}
+
+func (a *AcmeServerController) Finalize(ctx *gin.Context) {
+
+	// Server time
+	ts := time.Now().UnixMilli()
+
+	req, ok := a.verify(ctx, false)
+	if !ok {
+		return
+	}
+	var payload FinalizePayload
+	if !a.bindPayload(ctx, req, &payload) {
+		return
+	}
+	csr, err := base64.RawURLEncoding.DecodeString(payload.Csr)
+	if err != nil || len(csr) == 0 {
+		a.writeProblem(ctx, malformed("Invalid CSR encoding"))
+		return
+	}
+
+	order, err := a.AcmeServerService.Finalize(ts, req.account, req.key, ctx.Param("id"), csr)
+	if err != nil {
+		a.writeError(ctx, err)
+		return
+	}
+
+	a.writeOrder(ctx, order)
+}
+
+// Authorization returns an authorization of an order, valid since the order was allowed by the EAB key
+func (a *AcmeServerController) Authorization(ctx *gin.Context) {
+
+	// Server time
+	ts := time.Now().UnixMilli()
+
+	req, ok := a.verify(ctx, false)
+	if !ok {
+		return
+	}
+
+	order, index, ok := a.getIdentifier(ctx, ts, req)
+	if !ok {
+		return
+	}
+
+	a.writeHeaders(ctx)
+	ctx.JSON(http.StatusOK, newAuthorizationResponse(ctx, order, index))
+}
+
+// Challenge returns the challenge of an authorization, already valid so responding to it changes nothing
+func (a *AcmeServerController) Challenge(ctx *gin.Context) {
+
+	// Server time
+	ts := time.Now().UnixMilli()
+
+	req, ok := a.verify(ctx, false)
+	if !ok {
+		return
+	}
+
+	order, index, ok := a.getIdentifier(ctx, ts, req)
+	if !ok {
+		return
+	}
+
+	a.writeHeaders(ctx)
+	ctx.Header("Link", "<"+authorizationUrl(ctx, order.OrderId, index)+">;rel=\"up\"")
+	ctx.JSON(http.StatusOK, newAuthorizationResponse(ctx, order, index).Challenges[0])
+}
+
+func (a *AcmeServerController) Certificate(ctx *gin.Context) {
+
+	// Server time
+	ts := time.Now().UnixMilli()
+
+	req, ok := a.verify(ctx, false)
+	if !ok {
+		return
+	}
+
+	order, err := a.AcmeServerService.Order(ts, req.account, ctx.Param("id"))
+	if err != nil {
+		a.writeError(ctx, err)
+		return
+	}
+	if order.Status != acmeserverservice.StatusValid {
+		a.writeProblem(ctx, &acmeserverservice.Problem{
+			Type:   acmeserverservice.ProblemMalformed,
+			Detail: "Certificate is not issued",
+			Status: http.StatusNotFound,
+		})
+		return
+	}
+
+	a.writeHeaders(ctx)
+	ctx.Data(http.StatusOK, "application/pem-certificate-chain", order.Certificate)
+}
+
+func (a *AcmeServerController) RevokeCert(ctx *gin.Context) {
+
+	// Server time
+	ts := time.Now().UnixMilli()
+
+	req, ok := a.verify(ctx, false)
+	if !ok {
+		return
+	}
+	var payload RevokeCertPayload
+	if !a.bindPayload(ctx, req, &payload) {
+		return
+	}
+	certificate_, err := base64.RawURLEncoding.DecodeString(payload.Certificate)
+	if err != nil || len(certificate_) == 0 {
+		a.writeProblem(ctx, malformed("Invalid certificate encoding"))
+		return
+	}
+
+	err = a.AcmeServerService.Revoke(ts, req.account, req.key, certificate_, payload.Reason)
+	if err != nil {
+		a.writeError(ctx, err)
+		return
+	}
+
+	a.writeHeaders(ctx)
+	ctx.Status(http.StatusOK)
+}
+
+// getIdentifier returns the order of an authorization or challenge URL along with the index of its identifier
+func (a *AcmeServerController) getIdentifier(ctx *gin.Context, ts int64, req signedRequest) (acmeserverrepository.Order, int, bool) {
+
+	order, err := a.AcmeServerService.Order(ts, req.account, ctx.Param("id"))
+	if err != nil {
+		a.writeError(ctx, err)
+		return acmeserverrepository.Order{}, 0, false
+	}
+
+	index, err := strconv.Atoi(ctx.Param("index"))
+	if err != nil || index < 0 || index >= len(order.Identifiers) {
+		a.writeProblem(ctx, &acmeserverservice.Problem{
+			Type:   acmeserverservice.ProblemMalformed,
+			Detail: "Authorization does not exist",
+			Status: http.StatusNotFound,
+		})
+		return acmeserverrepository.Order{}, 0, false
+	}
+
+	return order, index, true
+}
+
+func (a *AcmeServerController) writeOrder(ctx *gin.Context, order acmeserverrepository.Order) {
+
+	a.writeHeaders(ctx)
+	if order.Status == acmeserverservice.StatusProcessing {
+		ctx.Header("Retry-After", "5")
+	}
+	ctx.Header("Location", orderUrl(ctx, order.OrderId))
+	ctx.JSON(http.StatusOK, newOrderResponse(ctx, order))
+}
+
+func newAccountResponse(ctx *gin.Context, account acmeserverrepository.Account) AccountResponse {
+	return AccountResponse{
+		Status:  account.Status,
+		Contact: account.Contact,
+		Orders:  accountUrl(ctx, account) + "/orders",
+	}
+}
+
+func newOrderResponse(ctx *gin.Context, order acmeserverrepository.Order) OrderResponse {
+
+	result := OrderResponse{
+		Status:         order.Status,
+		Expires:        formatTs(order.ExpiresTs),
+		Identifiers:    []acmeserverservice.Identifier{},
+		Authorizations: []string{},
+		Finalize:       orderUrl(ctx, order.OrderId) + "/finalize",
+	}
+	for i, v := range order.Identifiers {
+		result.Identifiers = append(result.Identifiers, acmeserverservice.Identifier{Type: "dns", Value: v})
+		result.Authorizations = append(result.Authorizations, authorizationUrl(ctx, order.OrderId, i))
+	}
+	if order.Status == acmeserverservice.StatusValid {
+		result.Certificate = baseUrl(ctx) + "/acme/cert/" + order.OrderId
+	}
+	if order.Status == acmeserverservice.StatusInvalid {
+		result.Error = &acmeserverservice.Problem{
+			Type:   acmeserverservice.ProblemServerInternal,
+			Detail: order.Error,
+			Status: http.StatusInternalServerError,
+		}
+	}
+	return result
+}
+
+func newAuthorizationResponse(ctx *gin.Context, order acmeserverrepository.Order, index int) AuthorizationResponse {
+
+	domain := order.Identifiers[index]
+	value, wildcard := strings.CutPrefix(domain, "*.")
+
+	return AuthorizationResponse{
+		Identifier: acmeserverservice.Identifier{Type: "dns", Value: value},
+		Status:     acmeserverservice.StatusValid,
+		Expires:    formatTs(order.ExpiresTs),
+		Challenges: []ChallengeResponse{
+			{
+				Type:      "dns-01",
+				Url:       baseUrl(ctx) + "/acme/challenge/" + order.OrderId + "/" + strconv.Itoa(index),
+				Status:    acmeserverservice.StatusValid,
+				Token:     order.OrderId + strconv.Itoa(index),
+				Validated: formatTs(order.CreatedTs),
+			},
+		},
+		Wildcard: wildcard,
+	}
+}
+
+func accountUrl(ctx *gin.Context, account acmeserverrepository.Account) string {
+	return baseUrl(ctx) + "/acme/account/" + account.AccountId
+}
+
+func orderUrl(ctx *gin.Context, orderId string) string {
+	return baseUrl(ctx) + "/acme/order/" + orderId
+}
+
+func authorizationUrl(ctx *gin.Context, orderId string, index int) string {
+	return baseUrl(ctx) + "/acme/authz/" + orderId + "/" + strconv.Itoa(index)
+}
+
+func formatTs(ts int64) string {
+	return time.UnixMilli(ts).UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,567 @@
+package acmeserver
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-jose/go-jose/v4"
+
+	"github.com/widhaprasa/go-acme-service/env"
+	"github.com/widhaprasa/go-acme-service/repository"
+	certsrepository "github.com/widhaprasa/go-acme-service/repository/certs"
+	acmeserverservice "github.com/widhaprasa/go-acme-service/service/acmeserver"
+	certsservice "github.com/widhaprasa/go-acme-service/service/certs"
+	clientservice "github.com/widhaprasa/go-acme-service/service/client"
+	zoneservice "github.com/widhaprasa/go-acme-service/service/zone"
+)
+
+const testBaseUrl = "http://acme.test"
+
+type testServer struct {
+	t            *testing.T
+	router       *gin.Engine
+	service      *acmeserverservice.AcmeServerService
+	repositories *repository.Repositories
+}
+
+type testAccount struct {
+	key *ecdsa.PrivateKey
+	url string
+}
+
+func newTestServer(t *testing.T) *testServer {
+
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	previous := env.ACME_SERVER_URL
+	env.ACME_SERVER_URL = testBaseUrl
+	t.Cleanup(func() {
+		env.ACME_SERVER_URL = previous
+	})
+
+	repositories := repository.NewMemory()
+	clientService := clientservice.ClientService{
+		Clientrepository: repositories.Client,
+	}
+	zoneService := &zoneservice.ZoneService{
+		ZoneRepository:   repositories.Zone,
+		ClientRepository: repositories.Client,
+	}
+	certsService := certsservice.NewCertsService(repositories.Certs, clientService, repositories.Webhook, zoneService)
+	service := acmeserverservice.NewAcmeServerService(repositories.AcmeServer, &certsService)
+
+	controller := &AcmeServerController{
+		AcmeServerRepository: repositories.AcmeServer,
+		AcmeServerService:    service,
+	}
+
+	r := gin.New()
+	r.GET("/acme/directory", controller.Directory)
+	r.HEAD("/acme/new-nonce", controller.NewNonce)
+	r.POST("/acme/new-account", controller.NewAccount)
+	r.POST("/acme/account/:id", controller.Account)
+	r.POST("/acme/account/:id/orders", controller.AccountOrders)
+	r.POST("/acme/new-order", controller.NewOrder)
+	r.POST("/acme/order/:id", controller.Order)
+	r.POST("/acme/order/:id/finalize", controller.Finalize)
+	r.POST("/acme/authz/:id/:index", controller.Authorization)
+	r.POST("/acme/cert/:id", controller.Certificate)
+	r.POST("/acme/revoke-cert", controller.RevokeCert)
+
+	return &testServer{
+		t:            t,
+		router:       r,
+		service:      service,
+		repositories: repositories,
+	}
+}
+
+func (s *testServer) nonce() string {
+
+	s.t.Helper()
+	rec := httptest.NewRecorder()
+	s.router.ServeHTTP(rec, httptest.NewRequest(http.MethodHead, "/acme/new-nonce", nil))
+	nonce := rec.Header().Get("Replay-Nonce")
+	if nonce == "" {
+		s.t.Fatalf("new-nonce: no nonce, status %d", rec.Code)
+	}
+	return nonce
+}
+
+func (s *testServer) post(path string, body []byte) *httptest.ResponseRecorder {
+
+	req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/jose+json")
+	rec := httptest.NewRecorder()
+	s.router.ServeHTTP(rec, req)
+	return rec
+}
+
+// postAs posts payload to path signed by the kid of account, or the jwk of key when account has no url yet
+func (s *testServer) postAs(account testAccount, path string, payload any) *httptest.ResponseRecorder {
+
+	s.t.Helper()
+	return s.post(path, signJWS(s.t, account.key, account.url, s.nonce(), testBaseUrl+path, payload))
+}
+
+// register creates an EAB key of domains and an account bound to it
+func (s *testServer) register(domains ...string) testAccount {
+
+	s.t.Helper()
+	account := testAccount{key: newKey(s.t)}
+	keyId, hmacKey := s.createEabKey(domains...)
+
+	rec := s.postAs(account, "/acme/new-account", map[string]any{
+		"termsOfServiceAgreed":   true,
+		"externalAccountBinding": eabJWS(s.t, keyId, hmacKey, &account.key.PublicKey, testBaseUrl+"/acme/new-account"),
+	})
+	if rec.Code != http.StatusCreated {
+		s.t.Fatalf("new-account: status %d %s", rec.Code, rec.Body)
+	}
+	account.url = rec.Header().Get("Location")
+	return account
+}
+
+func (s *testServer) createEabKey(domains ...string) (string, []byte) {
+
+	s.t.Helper()
+	key, err := s.service.CreateEabKey(time.Now().UnixMilli(), "ops@example.com", domains)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	return key.KeyId, key.HmacKey
+}
+
+// newOrder places an order of domains, returning its URL
+func (s *testServer) newOrder(account testAccount, domains ...string) string {
+
+	s.t.Helper()
+	rec := s.postAs(account, "/acme/new-order", newOrderPayload(domains...))
+	if rec.Code != http.StatusCreated {
+		s.t.Fatalf("new-order: status %d %s", rec.Code, rec.Body)
+	}
+	return rec.Header().Get("Location")
+}
+
+func newOrderPayload(domains ...string) map[string]any {
+
+	identifiers := []map[string]string{}
+	for _, domain := range domains {
+		identifiers = append(identifiers, map[string]string{"type": "dns", "value": domain})
+	}
+	return map[string]any{"identifiers": identifiers}
+}
+
+func signJWS(t *testing.T, key *ecdsa.PrivateKey, kid string, nonce string, url string, payload any) []byte {
+
+	t.Helper()
+	options := (&jose.SignerOptions{EmbedJWK: kid == ""}).
+		WithHeader("nonce", nonce).
+		WithHeader("url", url)
+	if kid != "" {
+		options = options.WithHeader("kid", kid)
+	}
+	return signWith(t, jose.SigningKey{Algorithm: jose.ES256, Key: key}, options, payload)
+}
+
+func eabJWS(t *testing.T, keyId string, hmacKey []byte, accountKey *ecdsa.PublicKey, url string) json.RawMessage {
+
+	t.Helper()
+	jwk, err := (&jose.JSONWebKey{Key: accountKey}).MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	options := (&jose.SignerOptions{}).
+		WithHeader("kid", keyId).
+		WithHeader("url", url)
+	return signWith(t, jose.SigningKey{Algorithm: jose.HS256, Key: hmacKey}, options, json.RawMessage(jwk))
+}
+
+func signWith(t *testing.T, key jose.SigningKey, options *jose.SignerOptions, payload any) []byte {
+
+	t.Helper()
+	var data []byte
+	if payload != nil {
+		var err error
+		data, err = json.Marshal(payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	signer, err := jose.NewSigner(key, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jws, err := signer.Sign(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) > 0 {
+		return []byte(jws.FullSerialize())
+	}
+
+	// POST-as-GET has an empty payload, which the serialization leaves out
+	var raw map[string]any
+	json.Unmarshal([]byte(jws.FullSerialize()), &raw)
+	raw["payload"] = ""
+	body, _ := json.Marshal(raw)
+	return body
+}
+
+func newKey(t *testing.T) *ecdsa.PrivateKey {
+
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func newCSR(t *testing.T, key *ecdsa.PrivateKey, domains ...string) string {
+
+	t.Helper()
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: domains[0]},
+		DNSNames: domains,
+	}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(der)
+}
+
+// expectProblem fails unless rec is a problem of type_ with status
+func expectProblem(t *testing.T, rec *httptest.ResponseRecorder, status int, type_ string) {
+
+	t.Helper()
+	var problem acmeserverservice.Problem
+	json.Unmarshal(rec.Body.Bytes(), &problem)
+	if rec.Code != status || problem.Type != type_ {
+		t.Fatalf("got status %d %s, want %d %s", rec.Code, rec.Body, status, type_)
+	}
+	if rec.Header().Get("Replay-Nonce") == "" {
+		t.Fatal("problem has no Replay-Nonce")
+	}
+}
+
+func TestNewAccountExternalAccountBinding(t *testing.T) {
+
+	s := newTestServer(t)
+	keyId, hmacKey := s.createEabKey("*.example.com")
+	url := testBaseUrl + "/acme/new-account"
+
+	account := testAccount{key: newKey(t)}
+	other := newKey(t)
+
+	// Missing binding
+	rec := s.postAs(account, "/acme/new-account", map[string]any{})
+	expectProblem(t, rec, http.StatusBadRequest, acmeserverservice.ProblemExternalAccountRequired)
+
+	// Binding of another account key
+	rec = s.postAs(account, "/acme/new-account", map[string]any{
+		"externalAccountBinding": eabJWS(t, keyId, hmacKey, &other.PublicKey, url),
+	})
+	expectProblem(t, rec, http.StatusForbidden, acmeserverservice.ProblemUnauthorized)
+
+	// Binding signed with another HMAC key
+	rec = s.postAs(account, "/acme/new-account", map[string]any{
+		"externalAccountBinding": eabJWS(t, keyId, []byte("another hmac key of 32 bytes....."), &account.key.PublicKey, url),
+	})
+	expectProblem(t, rec, http.StatusForbidden, acmeserverservice.ProblemUnauthorized)
+
+	// Binding for another url
+	rec = s.postAs(account, "/acme/new-account", map[string]any{
+		"externalAccountBinding": eabJWS(t, keyId, hmacKey, &account.key.PublicKey, testBaseUrl+"/acme/new-order"),
+	})
+	expectProblem(t, rec, http.StatusBadRequest, acmeserverservice.ProblemMalformed)
+
+	// Valid binding creates the account once
+	payload := map[string]any{
+		"externalAccountBinding": eabJWS(t, keyId, hmacKey, &account.key.PublicKey, url),
+	}
+	rec = s.postAs(account, "/acme/new-account", payload)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("new-account: status %d %s", rec.Code, rec.Body)
+	}
+	location := rec.Header().Get("Location")
+
+	rec = s.postAs(account, "/acme/new-account", map[string]any{"onlyReturnExisting": true})
+	if rec.Code != http.StatusOK || rec.Header().Get("Location") != location {
+		t.Fatalf("existing account: status %d location %q, want %q", rec.Code, rec.Header().Get("Location"), location)
+	}
+}
+
+func TestNonceIsUsedOnce(t *testing.T) {
+
+	s := newTestServer(t)
+	account := s.register("*.example.com")
+	url := testBaseUrl + "/acme/new-order"
+
+	nonce := s.nonce()
+	rec := s.post("/acme/new-order", signJWS(t, account.key, account.url, nonce, url, newOrderPayload("a.example.com")))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("new-order: status %d %s", rec.Code, rec.Body)
+	}
+
+	// Replayed
+	rec = s.post("/acme/new-order", signJWS(t, account.key, account.url, nonce, url, newOrderPayload("a.example.com")))
+	expectProblem(t, rec, http.StatusBadRequest, acmeserverservice.ProblemBadNonce)
+
+	// Not issued by the server
+	forged := base64.RawURLEncoding.EncodeToString(make([]byte, 32))
+	rec = s.post("/acme/new-order", signJWS(t, account.key, account.url, forged, url, newOrderPayload("a.example.com")))
+	expectProblem(t, rec, http.StatusBadRequest, acmeserverservice.ProblemBadNonce)
+
+	// Tampered with
+	b, _ := base64.RawURLEncoding.DecodeString(s.nonce())
+	b[0] ^= 1
+	tampered := base64.RawURLEncoding.EncodeToString(b)
+	rec = s.post("/acme/new-order", signJWS(t, account.key, account.url, tampered, url, newOrderPayload("a.example.com")))
+	expectProblem(t, rec, http.StatusBadRequest, acmeserverservice.ProblemBadNonce)
+}
+
+func TestUrlMustMatchRequest(t *testing.T) {
+
+	s := newTestServer(t)
+	account := s.register("*.example.com")
+
+	body := signJWS(t, account.key, account.url, s.nonce(), testBaseUrl+"/acme/new-order", newOrderPayload("a.example.com"))
+	rec := s.post("/acme/revoke-cert", body)
+	expectProblem(t, rec, http.StatusForbidden, acmeserverservice.ProblemUnauthorized)
+
+	body = signJWS(t, account.key, account.url, s.nonce(), "http://other.test/acme/new-order", newOrderPayload("a.example.com"))
+	rec = s.post("/acme/new-order", body)
+	expectProblem(t, rec, http.StatusForbidden, acmeserverservice.ProblemUnauthorized)
+}
+
+func TestKidAndJwk(t *testing.T) {
+
+	s := newTestServer(t)
+	account := s.register("*.example.com")
+
+	// New account signed with a kid
+	rec := s.post("/acme/new-account", signJWS(t, account.key, account.url, s.nonce(), testBaseUrl+"/acme/new-account", map[string]any{}))
+	expectProblem(t, rec, http.StatusBadRequest, acmeserverservice.ProblemMalformed)
+
+	// Other requests signed with a jwk
+	rec = s.post("/acme/new-order", signJWS(t, account.key, "", s.nonce(), testBaseUrl+"/acme/new-order", newOrderPayload("a.example.com")))
+	expectProblem(t, rec, http.StatusBadRequest, acmeserverservice.ProblemMalformed)
+
+	// Both
+	options := (&jose.SignerOptions{EmbedJWK: true}).
+		WithHeader("nonce", s.nonce()).
+		WithHeader("url", testBaseUrl+"/acme/new-order").
+		WithHeader("kid", account.url)
+	rec = s.post("/acme/new-order", signWith(t, jose.SigningKey{Algorithm: jose.ES256, Key: account.key}, options, newOrderPayload("a.example.com")))
+	expectProblem(t, rec, http.StatusBadRequest, acmeserverservice.ProblemMalformed)
+
+	// Unknown kid
+	rec = s.post("/acme/new-order", signJWS(t, account.key, testBaseUrl+"/acme/account/unknown", s.nonce(), testBaseUrl+"/acme/new-order",
+		newOrderPayload("a.example.com")))
+	expectProblem(t, rec, http.StatusBadRequest, acmeserverservice.ProblemAccountDoesNotExist)
+
+	// Kid of an account signed by another key
+	other := testAccount{key: newKey(t), url: account.url}
+	rec = s.postAs(other, "/acme/new-order", newOrderPayload("a.example.com"))
+	expectProblem(t, rec, http.StatusForbidden, acmeserverservice.ProblemUnauthorized)
+}
+
+func TestNewOrderPolicy(t *testing.T) {
+
+	s := newTestServer(t)
+	account := s.register("*.example.com", "exact.test")
+
+	allowed := [][]string{
+		{"a.example.com"},
+		{"*.example.com"},
+		{"a.b.example.com", "exact.test"},
+	}
+	for _, domains := range allowed {
+		rec := s.postAs(account, "/acme/new-order", newOrderPayload(domains...))
+		if rec.Code != http.StatusCreated {
+			t.Errorf("new-order %v: status %d %s", domains, rec.Code, rec.Body)
+		}
+	}
+
+	rejected := [][]string{
+		{"example.com"},
+		{"evilexample.com"},
+		{"a.evilexample.com"},
+		{"a.exact.test"},
+		{"a.example.com", "other.test"},
+	}
+	for _, domains := range rejected {
+		rec := s.postAs(account, "/acme/new-order", newOrderPayload(domains...))
+		var problem acmeserverservice.Problem
+		json.Unmarshal(rec.Body.Bytes(), &problem)
+		if rec.Code != http.StatusForbidden || problem.Type != acmeserverservice.ProblemRejectedIdentifier {
+			t.Errorf("new-order %v: status %d %s, want rejected", domains, rec.Code, rec.Body)
+		}
+	}
+}
+
+func TestFinalizeCsrMustMatchOrder(t *testing.T) {
+
+	s := newTestServer(t)
+	account := s.register("*.example.com")
+	orderUrl := s.newOrder(account, "a.example.com", "b.example.com")
+	finalize := strings.TrimPrefix(orderUrl, testBaseUrl) + "/finalize"
+	key := newKey(t)
+
+	csrs := [][]string{
+		{"a.example.com"},
+		{"a.example.com", "b.example.com", "c.example.com"},
+		{"a.example.com", "c.example.com"},
+	}
+	for _, domains := range csrs {
+		rec := s.postAs(account, finalize, map[string]any{"csr": newCSR(t, key, domains...)})
+		var problem acmeserverservice.Problem
+		json.Unmarshal(rec.Body.Bytes(), &problem)
+		if problem.Type != acmeserverservice.ProblemBadCSR {
+			t.Errorf("finalize %v: status %d %s, want badCSR", domains, rec.Code, rec.Body)
+		}
+	}
+
+	// The order is still ready
+	rec := s.postAs(account, strings.TrimPrefix(orderUrl, testBaseUrl), nil)
+	var order OrderResponse
+	json.Unmarshal(rec.Body.Bytes(), &order)
+	if order.Status != acmeserverservice.StatusReady {
+		t.Fatalf("order: status %q, want ready", order.Status)
+	}
+}
+
+func TestOrderOfAnotherAccount(t *testing.T) {
+
+	s := newTestServer(t)
+	owner := s.register("*.example.com")
+	other := s.register("*.example.com")
+
+	orderUrl := s.newOrder(owner, "a.example.com")
+	orderPath := strings.TrimPrefix(orderUrl, testBaseUrl)
+	orderId := strings.TrimPrefix(orderPath, "/acme/order/")
+
+	paths := []string{
+		orderPath,
+		"/acme/authz/" + orderId + "/0",
+		"/acme/cert/" + orderId,
+	}
+	for _, path := range paths {
+		rec := s.postAs(other, path, nil)
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("%s: status %d %s, want 404", path, rec.Code, rec.Body)
+		}
+	}
+
+	rec := s.postAs(other, orderPath+"/finalize", map[string]any{"csr": newCSR(t, newKey(t), "a.example.com")})
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("finalize: status %d %s, want 404", rec.Code, rec.Body)
+	}
+
+	ownerPath := strings.TrimPrefix(owner.url, testBaseUrl)
+	rec = s.postAs(other, ownerPath, nil)
+	expectProblem(t, rec, http.StatusForbidden, acmeserverservice.ProblemUnauthorized)
+	rec = s.postAs(other, ownerPath+"/orders", nil)
+	expectProblem(t, rec, http.StatusForbidden, acmeserverservice.ProblemUnauthorized)
+
+	// The owner still sees it
+	rec = s.postAs(owner, orderPath, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("owner order: status %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestFinalizeWithStoredCert(t *testing.T) {
+
+	s := newTestServer(t)
+	account := s.register("*.example.com")
+
+	// A stored cert of the key the client holds
+	key := newKey(t)
+	serial := storeTestCert(t, s.repositories, key, "a.example.com")
+
+	orderUrl := s.newOrder(account, "a.example.com")
+	orderPath := strings.TrimPrefix(orderUrl, testBaseUrl)
+	rec := s.postAs(account, orderPath+"/finalize", map[string]any{"csr": newCSR(t, key, "a.example.com")})
+	var order OrderResponse
+	json.Unmarshal(rec.Body.Bytes(), &order)
+	if rec.Code != http.StatusOK || order.Status != acmeserverservice.StatusValid {
+		t.Fatalf("finalize: status %d %s", rec.Code, rec.Body)
+	}
+
+	// Finalizing again is refused
+	rec = s.postAs(account, orderPath+"/finalize", map[string]any{"csr": newCSR(t, key, "a.example.com")})
+	expectProblem(t, rec, http.StatusForbidden, acmeserverservice.ProblemOrderNotReady)
+
+	rec = s.postAs(account, strings.TrimPrefix(order.Certificate, testBaseUrl), nil)
+	block, _ := pem.Decode(rec.Body.Bytes())
+	if rec.Code != http.StatusOK || block == nil {
+		t.Fatalf("certificate: status %d %s", rec.Code, rec.Body)
+	}
+	crt, err := x509.ParseCertificate(block.Bytes)
+	if err != nil || hex.EncodeToString(crt.SerialNumber.Bytes()) != serial {
+		t.Fatalf("certificate: got another cert, %v", err)
+	}
+
+	// The stored cert is still served by the service, so it is not revoked
+	rec = s.postAs(account, "/acme/revoke-cert", map[string]any{"certificate": base64.RawURLEncoding.EncodeToString(block.Bytes)})
+	expectProblem(t, rec, http.StatusForbidden, acmeserverservice.ProblemUnauthorized)
+
+	// Nor by another account
+	other := s.register("*.example.com")
+	rec = s.postAs(other, "/acme/revoke-cert", map[string]any{"certificate": base64.RawURLEncoding.EncodeToString(block.Bytes)})
+	expectProblem(t, rec, http.StatusForbidden, acmeserverservice.ProblemUnauthorized)
+}
+
+// storeTestCert stores a self-signed cert of key for domains, returning its serial
+func storeTestCert(t *testing.T, repositories *repository.Repositories, key *ecdsa.PrivateKey, domains ...string) string {
+
+	t.Helper()
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(now.UnixNano()),
+		Subject:      pkix.Name{CommonName: domains[0]},
+		DNSNames:     domains,
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(90 * 24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial := hex.EncodeToString(template.SerialNumber.Bytes())
+
+	_, err = repositories.Certs.UpsertCerts(certsrepository.Cert{
+		Main:        domains[0],
+		Sans:        domains,
+		Email:       "ops@example.com",
+		Certificate: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		NotBeforeTs: template.NotBefore.UnixMilli(),
+		NotAfterTs:  template.NotAfter.UnixMilli(),
+		UpsertedTs:  now.UnixMilli(),
+	}, certsrepository.VersionInfo{
+		Serial: serial,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return serial
+}
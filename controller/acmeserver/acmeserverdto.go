@@ -0,0 +1,111 @@
+package acmeserver
+
+import (
+	"encoding/json"
+
+	acmeserverrepository "github.com/widhaprasa/go-acme-service/repository/acmeserver"
+	acmeserverservice "github.com/widhaprasa/go-acme-service/service/acmeserver"
+)
+
+type EabCreateRequest struct {
+	Email   string   `json:"email" binding:"required,email"`
+	Domains []string `json:"domains" binding:"required,min=1"`
+}
+
+type EabDeleteRequest struct {
+	KeyId string `json:"key_id" binding:"required"`
+}
+
+type EabResponse struct {
+	KeyId     string   `json:"key_id"`
+	HmacKey   string   `json:"hmac_key,omitempty"`
+	Email     string   `json:"email"`
+	Domains   []string `json:"domains"`
+	CreatedTs int64    `json:"created_ts"`
+}
+
+func newEabResponse(key acmeserverrepository.EabKey) EabResponse {
+	return EabResponse{
+		KeyId:     key.KeyId,
+		Email:     key.Email,
+		Domains:   key.Domains,
+		CreatedTs: key.CreatedTs,
+	}
+}
+
+// Payloads of the ACME requests, RFC 8555 section 7
+
+type NewAccountPayload struct {
+	Contact                []string        `json:"contact"`
+	TermsOfServiceAgreed   bool            `json:"termsOfServiceAgreed"`
+	OnlyReturnExisting     bool            `json:"onlyReturnExisting"`
+	ExternalAccountBinding json.RawMessage `json:"externalAccountBinding"`
+}
+
+type AccountPayload struct {
+	Contact []string `json:"contact"`
+	Status  string   `json:"status"`
+}
+
+type NewOrderPayload struct {
+	Identifiers []acmeserverservice.Identifier `json:"identifiers"`
+}
+
+type FinalizePayload struct {
+	Csr string `json:"csr"`
+}
+
+type RevokeCertPayload struct {
+	Certificate string `json:"certificate"`
+	Reason      *uint  `json:"reason"`
+}
+
+// Objects of the ACME responses, RFC 8555 section 7.1
+
+type DirectoryResponse struct {
+	NewNonce   string        `json:"newNonce"`
+	NewAccount string        `json:"newAccount"`
+	NewOrder   string        `json:"newOrder"`
+	RevokeCert string        `json:"revokeCert"`
+	Meta       DirectoryMeta `json:"meta"`
+}
+
+type DirectoryMeta struct {
+	ExternalAccountRequired bool `json:"externalAccountRequired"`
+}
+
+type AccountResponse struct {
+	Status  string   `json:"status"`
+	Contact []string `json:"contact,omitempty"`
+	Orders  string   `json:"orders"`
+}
+
+type OrdersResponse struct {
+	Orders []string `json:"orders"`
+}
+
+type OrderResponse struct {
+	Status         string                         `json:"status"`
+	Expires        string                         `json:"expires"`
+	Identifiers    []acmeserverservice.Identifier `json:"identifiers"`
+	Authorizations []string                       `json:"authorizations"`
+	Finalize       string                         `json:"finalize"`
+	Certificate    string                         `json:"certificate,omitempty"`
+	Error          *acmeserverservice.Problem     `json:"error,omitempty"`
+}
+
+type AuthorizationResponse struct {
+	Identifier acmeserverservice.Identifier `json:"identifier"`
+	Status     string                       `json:"status"`
+	Expires    string                       `json:"expires"`
+	Challenges []ChallengeResponse          `json:"challenges"`
+	Wildcard   bool                         `json:"wildcard,omitempty"`
+}
+
+type ChallengeResponse struct {
+	Type      string `json:"type"`
+	Url       string `json:"url"`
+	Status    string `json:"status"`
+	Token     string `json:"token"`
+	Validated string `json:"validated"`
+}
@@ -0,0 +1,88 @@
+package acmeserver
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/widhaprasa/go-acme-service/acme"
+	"github.com/widhaprasa/go-acme-service/controller/request"
+)
+
+func (a *AcmeServerController) ListEabKeys(ctx *gin.Context) {
+
+	list, err := a.AcmeServerRepository.ListEabKeys()
+	if err != nil {
+		ctx.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	keys := []EabResponse{}
+	for _, v := range list {
+		keys = append(keys, newEabResponse(v))
+	}
+
+	ctx.JSON(http.StatusOK, map[string]any{
+		"keys": keys,
+	})
+}
+
+// CreateEabKey creates an EAB key, its HMAC key is only returned here
+func (a *AcmeServerController) CreateEabKey(ctx *gin.Context) {
+
+	// Server time
+	ts := time.Now().UnixMilli()
+
+	// Request body
+	var req EabCreateRequest
+	if !request.BindJSON(ctx, &req) {
+		return
+	}
+
+	key, err := a.AcmeServerService.CreateEabKey(ts, req.Email, req.Domains)
+	if err != nil {
+		var domainsErr *acme.DomainsError
+		if errors.As(err, &domainsErr) {
+			ctx.JSON(http.StatusBadRequest, map[string]any{
+				"message": "Invalid domains",
+				"errors":  domainsErr.Errors,
+			})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, map[string]any{
+			"message": err.Error(),
+		})
+		return
+	}
+
+	response := newEabResponse(key)
+	response.HmacKey = base64.RawURLEncoding.EncodeToString(key.HmacKey)
+	ctx.JSON(http.StatusOK, response)
+}
+
+// DeleteEabKey deletes an EAB key, the accounts registered with it can no longer be used
+func (a *AcmeServerController) DeleteEabKey(ctx *gin.Context) {
+
+	// Request body
+	var req EabDeleteRequest
+	if !request.BindJSON(ctx, &req) {
+		return
+	}
+
+	result, err := a.AcmeServerRepository.DeleteEabKey(req.KeyId)
+	if err != nil {
+		ctx.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	if count, _ := result.RowsAffected(); count == 0 {
+		ctx.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, map[string]any{
+		"key_id": req.KeyId,
+	})
+}
@@ -0,0 +1,223 @@
+package acmeserver
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-jose/go-jose/v4"
+
+	"github.com/widhaprasa/go-acme-service/env"
+	acmeserverrepository "github.com/widhaprasa/go-acme-service/repository/acmeserver"
+	acmeserverservice "github.com/widhaprasa/go-acme-service/service/acmeserver"
+)
+
+// Max size of a JWS request body
+const maxBodySize = 64 * 1024
+
+// Account key algorithms, MAC algorithms are only allowed in external account bindings
+var signatureAlgorithms = []jose.SignatureAlgorithm{
+	jose.RS256, jose.RS384, jose.RS512,
+	jose.PS256, jose.PS384, jose.PS512,
+	jose.ES256, jose.ES384, jose.ES512,
+	jose.EdDSA,
+}
+
+// signedRequest is a verified JWS request, signed by an account unless it is a new account request
+type signedRequest struct {
+	payload []byte
+	jwk     *jose.JSONWebKey
+	account acmeserverrepository.Account
+	key     acmeserverrepository.EabKey
+}
+
+// verify checks the JWS of the request, RFC 8555 section 6.2. A new account request is signed with the jwk
+// of the new account, any other by the kid of a registered account
+func (a *AcmeServerController) verify(ctx *gin.Context, newAccount bool) (signedRequest, bool) {
+
+	mediaType, _, _ := mime.ParseMediaType(ctx.GetHeader("Content-Type"))
+	if mediaType != "application/jose+json" {
+		a.writeProblem(ctx, &acmeserverservice.Problem{
+			Type:   acmeserverservice.ProblemMalformed,
+			Detail: "Content type must be application/jose+json",
+			Status: http.StatusUnsupportedMediaType,
+		})
+		return signedRequest{}, false
+	}
+
+	body, err := io.ReadAll(io.LimitReader(ctx.Request.Body, maxBodySize))
+	if err != nil {
+		a.writeProblem(ctx, malformed("Unable to read request"))
+		return signedRequest{}, false
+	}
+
+	// Only the flattened JSON serialization
+	var raw map[string]json.RawMessage
+	if json.Unmarshal(body, &raw) != nil || raw["signatures"] != nil || raw["header"] != nil {
+		a.writeProblem(ctx, malformed("Request must be a flattened JWS with a protected header only"))
+		return signedRequest{}, false
+	}
+
+	jws, err := jose.ParseSignedJSON(string(body), signatureAlgorithms)
+	if err != nil {
+		if strings.Contains(err.Error(), "algorithm") {
+			a.writeProblem(ctx, &acmeserverservice.Problem{
+				Type:   acmeserverservice.ProblemBadSignatureAlgorithm,
+				Detail: "Unsupported signature algorithm",
+				Status: http.StatusBadRequest,
+			})
+			return signedRequest{}, false
+		}
+		a.writeProblem(ctx, malformed("Invalid JWS: "+err.Error()))
+		return signedRequest{}, false
+	}
+	if len(jws.Signatures) != 1 {
+		a.writeProblem(ctx, malformed("JWS must have one signature"))
+		return signedRequest{}, false
+	}
+	header := jws.Signatures[0].Protected
+
+	if !a.AcmeServerService.UseNonce(header.Nonce) {
+		a.writeProblem(ctx, &acmeserverservice.Problem{
+			Type:   acmeserverservice.ProblemBadNonce,
+			Detail: "Invalid nonce",
+			Status: http.StatusBadRequest,
+		})
+		return signedRequest{}, false
+	}
+
+	if url, _ := header.ExtraHeaders["url"].(string); url != baseUrl(ctx)+ctx.Request.URL.Path {
+		a.writeProblem(ctx, unauthorized("JWS url does not match the request"))
+		return signedRequest{}, false
+	}
+
+	var result signedRequest
+	if newAccount {
+		if header.JSONWebKey == nil || header.KeyID != "" {
+			a.writeProblem(ctx, malformed("New account request must be signed with a jwk"))
+			return signedRequest{}, false
+		}
+		if !header.JSONWebKey.Valid() || !header.JSONWebKey.IsPublic() {
+			a.writeProblem(ctx, malformed("Invalid jwk"))
+			return signedRequest{}, false
+		}
+		result.jwk = header.JSONWebKey
+
+	} else {
+		if header.JSONWebKey != nil {
+			a.writeProblem(ctx, malformed("Request must be signed with the kid of an account"))
+			return signedRequest{}, false
+		}
+		accountId, ok := strings.CutPrefix(header.KeyID, baseUrl(ctx)+"/acme/account/")
+		if !ok {
+			a.writeProblem(ctx, &acmeserverservice.Problem{
+				Type:   acmeserverservice.ProblemAccountDoesNotExist,
+				Detail: "Account does not exist",
+				Status: http.StatusBadRequest,
+			})
+			return signedRequest{}, false
+		}
+		result.account, result.key, err = a.AcmeServerService.Account(accountId)
+		if err != nil {
+			a.writeError(ctx, err)
+			return signedRequest{}, false
+		}
+		result.jwk, err = a.AcmeServerService.AccountKey(result.account)
+		if err != nil {
+			a.writeProblem(ctx, unauthorized("Invalid account key"))
+			return signedRequest{}, false
+		}
+	}
+
+	result.payload, err = jws.Verify(result.jwk.Key)
+	if err != nil {
+		a.writeProblem(ctx, unauthorized("Invalid JWS signature"))
+		return signedRequest{}, false
+	}
+
+	return result, true
+}
+
+// bindPayload decodes the payload of a request, an empty payload decodes to the zero value
+func (a *AcmeServerController) bindPayload(ctx *gin.Context, req signedRequest, payload any) bool {
+
+	if len(req.payload) == 0 {
+		return true
+	}
+	err := json.Unmarshal(req.payload, payload)
+	if err != nil {
+		a.writeProblem(ctx, malformed("Invalid payload: "+err.Error()))
+		return false
+	}
+	return true
+}
+
+// baseUrl is the external URL of the service, ACME_SERVER_URL or else the URL the request was made to
+func baseUrl(ctx *gin.Context) string {
+
+	if env.ACME_SERVER_URL != "" {
+		return strings.TrimSuffix(env.ACME_SERVER_URL, "/")
+	}
+
+	scheme := "http"
+	if ctx.Request.TLS != nil {
+		scheme = "https"
+	}
+	if proto := ctx.GetHeader("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	return scheme + "://" + ctx.Request.Host
+}
+
+// writeError responds with the problem of err, a server error if it is not a problem
+func (a *AcmeServerController) writeError(ctx *gin.Context, err error) {
+
+	var problem *acmeserverservice.Problem
+	if !errors.As(err, &problem) {
+		problem = &acmeserverservice.Problem{
+			Type:   acmeserverservice.ProblemServerInternal,
+			Detail: err.Error(),
+			Status: http.StatusInternalServerError,
+		}
+	}
+	a.writeProblem(ctx, problem)
+}
+
+func (a *AcmeServerController) writeProblem(ctx *gin.Context, problem *acmeserverservice.Problem) {
+
+	a.writeHeaders(ctx)
+	data, _ := json.Marshal(problem)
+	ctx.Data(problem.Status, "application/problem+json", data)
+	ctx.Abort()
+}
+
+// writeHeaders sets the headers of every ACME response, a fresh nonce along with the directory.
+// The nonce is left out if none can be issued, the client then requests one from new-nonce
+func (a *AcmeServerController) writeHeaders(ctx *gin.Context) {
+
+	if nonce, err := a.AcmeServerService.NewNonce(); err == nil {
+		ctx.Header("Replay-Nonce", nonce)
+	}
+	ctx.Header("Link", "<"+baseUrl(ctx)+"/acme/directory>;rel=\"index\"")
+	ctx.Header("Cache-Control", "no-store")
+}
+
+func malformed(detail string) *acmeserverservice.Problem {
+	return &acmeserverservice.Problem{
+		Type:   acmeserverservice.ProblemMalformed,
+		Detail: detail,
+		Status: http.StatusBadRequest,
+	}
+}
+
+func unauthorized(detail string) *acmeserverservice.Problem {
+	return &acmeserverservice.Problem{
+		Type:   acmeserverservice.ProblemUnauthorized,
+		Detail: detail,
+		Status: http.StatusForbidden,
+	}
+}
@@ -0,0 +1,62 @@
+package admin
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	backupservice "github.com/widhaprasa/go-acme-service/service/backup"
+)
+
+type AdminController struct {
+	// Nil when no backup store is configured
+	BackupService *backupservice.BackupService
+}
+
+func (a *AdminController) Backup(ctx *gin.Context) {
+
+	// Server time
+	ts := time.Now().UnixMilli()
+
+	if a.BackupService == nil {
+		ctx.JSON(http.StatusNotFound, map[string]any{
+			"message": "Backup is not configured",
+		})
+		return
+	}
+
+	snapshot, err := a.BackupService.Backup(ctx.Request.Context(), ts)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, map[string]any{
+			"message": err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, map[string]any{
+		"snapshot": snapshot,
+	})
+}
+
+func (a *AdminController) ListBackups(ctx *gin.Context) {
+
+	if a.BackupService == nil {
+		ctx.JSON(http.StatusNotFound, map[string]any{
+			"message": "Backup is not configured",
+		})
+		return
+	}
+
+	list, err := a.BackupService.List(ctx.Request.Context())
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, map[string]any{
+			"message": err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, map[string]any{
+		"snapshots": list,
+	})
+}
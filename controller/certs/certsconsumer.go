@@ -0,0 +1,150 @@
+package a
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/widhaprasa/go-acme-service/controller/request"
+
+	certsrepository "github.com/widhaprasa/go-acme-service/repository/certs"
+	certsservice "github.com/widhaprasa/go-acme-service/service/certs"
+)
+
+// ReportAgent records the version of a cert an agent holds, telling it whether it is the current one
+func (c *CertsController) ReportAgent(ctx *gin.Context) {
+
+	// Server time
+	ts := time.Now().UnixMilli()
+
+	// Request body
+	var req AgentReportRequest
+	if !request.BindJSON(ctx, &req) {
+		return
+	}
+
+	// Retrieve from Db
+	certs, ok := c.getCerts(ctx, req.DomainRequest)
+	if !ok {
+		return
+	}
+
+	_, err := c.CertsRepository.UpsertConsumer(certsrepository.Consumer{
+		Main:     certs.Main,
+		Consumer: req.Agent,
+		Serial:   req.Serial,
+		SeenTs:   ts,
+	})
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, map[string]any{
+			"message": err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, map[string]any{
+		"main":    certs.Main,
+		"serial":  certs.Serial,
+		"current": req.Serial == certs.Serial,
+	})
+}
+
+func (c *CertsController) ListConsumers(ctx *gin.Context) {
+
+	// Server time
+	ts := time.Now().UnixMilli()
+
+	// Request body
+	var req ConsumersRequest
+	if !request.BindJSON(ctx, &req) {
+		return
+	}
+
+	// Retrieve from Db
+	certs, ok := c.getCerts(ctx, req.DomainRequest)
+	if !ok {
+		return
+	}
+
+	list, err := c.CertsService.ConsumerReport(ts, certs.Main, expiringWithin(req.ExpiringWithinDays))
+	if err != nil {
+		ctx.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	consumers := []ConsumerResponse{}
+	for _, v := range list {
+		consumers = append(consumers, newConsumerResponse(v))
+	}
+
+	ctx.JSON(http.StatusOK, map[string]any{
+		"main":      certs.Main,
+		"serial":    certs.Serial,
+		"consumers": consumers,
+	})
+}
+
+func (c *CertsController) ListStaleConsumers(ctx *gin.Context) {
+
+	// Server time
+	ts := time.Now().UnixMilli()
+
+	// Query string
+	var req StaleConsumersRequest
+	if !request.BindQuery(ctx, &req) {
+		return
+	}
+
+	list, err := c.CertsService.StaleConsumers(ts, expiringWithin(req.ExpiringWithinDays))
+	if err != nil {
+		ctx.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	consumers := []ConsumerResponse{}
+	for _, v := range list {
+		consumers = append(consumers, newConsumerResponse(v))
+	}
+
+	ctx.JSON(http.StatusOK, map[string]any{
+		"consumers": consumers,
+	})
+}
+
+// recordConsumer records that the consumer making the request was served the version serial of main
+func (c *CertsController) recordConsumer(ctx *gin.Context, main string, serial string) {
+
+	_, err := c.CertsRepository.UpsertConsumer(certsrepository.Consumer{
+		Main:     main,
+		Consumer: consumerName(ctx),
+		Serial:   serial,
+		SeenTs:   time.Now().UnixMilli(),
+	})
+	if err != nil {
+		log.Println("Unable to record consumer of", main, ":", err)
+	}
+}
+
+// consumerName identifies the consumer making the request by its agent id, else by the API credential it used
+// along with its address, as a credential may be shared by several hosts
+func consumerName(ctx *gin.Context) string {
+
+	if agent := ctx.GetHeader("X-Agent-Id"); agent != "" {
+		return agent
+	}
+	address := ctx.ClientIP()
+	if username, _, ok := ctx.Request.BasicAuth(); ok && username != "" {
+		return "credential:" + username + "@" + address
+	}
+	return "address:" + address
+}
+
+func expiringWithin(days int) time.Duration {
+
+	if days == 0 {
+		return certsservice.DefaultExpiringWithin
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
@@ -0,0 +1,41 @@
+package a
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestConsumerName(t *testing.T) {
+
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		name       string
+		agent      string
+		username   string
+		remoteAddr string
+		want       string
+	}{
+		{"agent", "web-1", "user", "10.0.0.1:1234", "web-1"},
+		{"credential", "", "user", "10.0.0.1:1234", "credential:user@10.0.0.1"},
+		{"credential from another address", "", "user", "10.0.0.2:1234", "credential:user@10.0.0.2"},
+		{"other credential", "", "deploy", "10.0.0.1:1234", "credential:deploy@10.0.0.1"},
+		{"address", "", "", "10.0.0.1:1234", "address:10.0.0.1"},
+	}
+	for _, test := range tests {
+		ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+		ctx.Request = httptest.NewRequest(http.MethodGet, "/certs/distribute", nil)
+		ctx.Request.RemoteAddr = test.remoteAddr
+		if test.username != "" {
+			ctx.Request.SetBasicAuth(test.username, "pass")
+		}
+		if test.agent != "" {
+			ctx.Request.Header.Set("X-Agent-Id", test.agent)
+		}
+		if got := consumerName(ctx); got != test.want {
+			t.Errorf("%s: consumer %q, want %q", test.name, got, test.want)
+		}
+	}
+}
@@ -1,11 +1,17 @@
 package a
 
 import (
+	"database/sql"
+	"errors"
 	"net/http"
 	"time"
 
 	"github.com/gin-gonic/gin"
 
+	"github.com/widhaprasa/go-acme-service/acme"
+	"github.com/widhaprasa/go-acme-service/controller/request"
+	"github.com/widhaprasa/go-acme-service/env"
+
 	certsrepository "github.com/widhaprasa/go-acme-service/repository/certs"
 	webhookrepository "github.com/widhaprasa/go-acme-service/repository/webhook"
 	certsservice "github.com/widhaprasa/go-acme-service/service/certs"
@@ -15,151 +21,144 @@ type CertsController struct {
 	CertsRepository   certsrepository.CertsRepository
 	CertsService      certsservice.CertsService
 	WebhookRepository webhookrepository.WebhookRepository
+
+	// CertsWatcher wakes long-polling distribution requests, they are held for the whole wait if nil
+	CertsWatcher certsrepository.Watcher
 }
 
 func (c *CertsController) List(ctx *gin.Context) {
 
-	list, err := c.CertsRepository.ListCerts()
+	// Server time
+	ts := time.Now().UnixMilli()
+
+	// Query string
+	var req ListRequest
+	if !request.BindQuery(ctx, &req) {
+		return
+	}
+	selector, ok := parseSelector(ctx, "label", req.Label)
+	if !ok {
+		return
+	}
+
+	page, err := c.CertsRepository.ListCertsPage(certsrepository.CertsQuery{
+		Ts:               ts,
+		Cursor:           req.Cursor,
+		Limit:            req.Limit,
+		Sort:             req.Sort,
+		Desc:             req.Order == "desc",
+		ExpiringBeforeTs: req.ExpiringBeforeTs,
+		San:              req.San,
+		Email:            req.Email,
+		Issuer:           req.Issuer,
+		Status:           req.Status,
+		Labels:           selector,
+	})
 	if err != nil {
+		if errors.Is(err, certsrepository.ErrInvalidCursor) {
+			ctx.JSON(http.StatusBadRequest, map[string]any{
+				"message": err.Error(),
+			})
+			return
+		}
 		ctx.AbortWithStatus(http.StatusInternalServerError)
 		return
 	}
 
-	webhookMap, err := c.WebhookRepository.MapWebhook()
+	// Webhooks of the page only
+	mains := make([]string, 0, len(page.Certs))
+	for _, v := range page.Certs {
+		mains = append(mains, v.Main)
+	}
+	webhookMap, err := c.WebhookRepository.MapWebhook(mains)
 	if err != nil {
 		ctx.AbortWithStatus(http.StatusInternalServerError)
 		return
 	}
 
-	certs := []any{}
-	for _, v := range list {
-
-		certsMap := v.(map[string]any)
-		main := certsMap["main"].(string)
-		sans := certsMap["sans"].(string)
-		email := certsMap["email"].(string)
-		notBeforeTs := certsMap["not_before_ts"].(int)
-		notAfterTs := certsMap["not_after_ts"].(int)
-		upsertedTs := certsMap["upserted_ts"].(int)
-
-		certItem := map[string]any{
-			"main":          main,
-			"sans":          sans,
-			"email":         email,
-			"not_before_ts": notBeforeTs,
-			"not_after_ts":  notAfterTs,
-			"upserted_ts":   upsertedTs,
-		}
+	certs := []CertResponse{}
+	for _, v := range page.Certs {
 
-		webhookItem, webhookOk := webhookMap[main].(map[string]any)
-		if webhookOk {
-			certItem["webhook_url"] = webhookItem["url"].(string)
-			certItem["webhook_headers"] = webhookItem["headers"].(map[string]any)
+		var webhook *webhookrepository.Webhook
+		if webhookItem, webhookOk := webhookMap[v.Main]; webhookOk {
+			webhook = &webhookItem
 		}
 
-		certs = append(certs, certItem)
+		certs = append(certs, newCertResponse(ts, v, webhook))
 	}
 
 	ctx.JSON(http.StatusOK, map[string]any{
-		"certs": certs,
+		"certs":       certs,
+		"next_cursor": page.NextCursor,
 	})
 }
 
 func (c *CertsController) Read(ctx *gin.Context) {
 
-	// Request body
-	var data map[string]any
-	if err := ctx.ShouldBindJSON(&data); err != nil {
-		ctx.AbortWithStatus(http.StatusNotFound)
-		return
-	}
+	// Server time
+	ts := time.Now().UnixMilli()
 
-	domain, domainOk := data["domain"].(string)
-	if !domainOk || domain == "" {
-		ctx.AbortWithStatus(http.StatusBadRequest)
+	// Request body
+	var req DomainRequest
+	if !request.BindJSON(ctx, &req) {
 		return
 	}
 
 	// Retrieve from Db
-	certs, err := c.CertsRepository.GetCerts(domain)
-	if err != nil {
-		ctx.AbortWithStatus(http.StatusNotFound)
+	certs, ok := c.getCerts(ctx, req)
+	if !ok {
 		return
 	}
 
-	main := certs["main"].(string)
-	sans := certs["sans"].(string)
-	email := certs["email"].(string)
-	notBeforeTs := certs["not_before_ts"].(int)
-	notAfterTs := certs["not_after_ts"].(int)
-	upsertedTs := certs["upserted_ts"].(int)
-
-	certItem := map[string]any{
-		"main":          main,
-		"sans":          sans,
-		"email":         email,
-		"not_before_ts": notBeforeTs,
-		"not_after_ts":  notAfterTs,
-		"upserted_ts":   upsertedTs,
-	}
-
-	webhook, err := c.WebhookRepository.GetWebhook(main)
+	var webhookPtr *webhookrepository.Webhook
+	webhook, err := c.WebhookRepository.GetWebhook(certs.Main)
 	if err == nil {
-		certItem["webhook_url"] = webhook["url"].(string)
-		certItem["webhook_headers"] = webhook["headers"].(map[string]any)
+		webhookPtr = &webhook
 	}
 
-	ctx.JSON(http.StatusOK, certItem)
+	ctx.JSON(http.StatusOK, newCertResponse(ts, certs, webhookPtr))
 }
 
 func (c *CertsController) GetPrivateKey(ctx *gin.Context) {
 
 	// Request body
-	var data map[string]any
-	if err := ctx.ShouldBindJSON(&data); err != nil {
-		ctx.AbortWithStatus(http.StatusNotFound)
-		return
-	}
-
-	domain, domainOk := data["domain"].(string)
-	if !domainOk || domain == "" {
-		ctx.AbortWithStatus(http.StatusBadRequest)
+	var req PrivateKeyRequest
+	if !request.BindJSON(ctx, &req) {
 		return
 	}
 
 	// Retrieve from Db
-	certs, err := c.CertsRepository.GetCerts(domain)
-	if err != nil {
-		ctx.AbortWithStatus(http.StatusNotFound)
+	certs, ok := c.getCerts(ctx, req.DomainRequest)
+	if !ok {
 		return
 	}
 
-	ctx.Data(http.StatusOK, "text/plain", certs["private_key"].([]byte))
+	output, err := certsservice.EncodePrivateKey(certs.PrivateKey, req.Format)
+	if err == nil {
+		c.recordConsumer(ctx, certs.Main, certs.Serial)
+	}
+	writeOutput(ctx, output, err)
 }
 
 func (c *CertsController) GetCertificate(ctx *gin.Context) {
 
 	// Request body
-	var data map[string]any
-	if err := ctx.ShouldBindJSON(&data); err != nil {
-		ctx.AbortWithStatus(http.StatusNotFound)
-		return
-	}
-
-	domain, domainOk := data["domain"].(string)
-	if !domainOk || domain == "" {
-		ctx.AbortWithStatus(http.StatusBadRequest)
+	var req CertificateRequest
+	if !request.BindJSON(ctx, &req) {
 		return
 	}
 
 	// Retrieve from Db
-	certs, err := c.CertsRepository.GetCerts(domain)
-	if err != nil {
-		ctx.AbortWithStatus(http.StatusNotFound)
+	certs, ok := c.getCerts(ctx, req.DomainRequest)
+	if !ok {
 		return
 	}
 
-	ctx.Data(http.StatusOK, "text/plain", certs["certificate"].([]byte))
+	output, err := certsservice.EncodeCertificate(certs.Main, certs.PrivateKey, certs.Certificate, req.Format, req.Password)
+	if err == nil {
+		c.recordConsumer(ctx, certs.Main, certs.Serial)
+	}
+	writeOutput(ctx, output, err)
 }
 
 func (c *CertsController) Generate(ctx *gin.Context) {
@@ -168,45 +167,31 @@ func (c *CertsController) Generate(ctx *gin.Context) {
 	ts := time.Now().UnixMilli()
 
 	// Request body
-	var data map[string]any
-	if err := ctx.ShouldBindJSON(&data); err != nil {
-		ctx.AbortWithStatus(http.StatusNotFound)
+	var req GenerateRequest
+	if !request.BindJSON(ctx, &req) {
 		return
 	}
 
 	// Handle single domain and SANS
-	var domains []string
-	domain, domainOk := data["domain"].(string)
-	if domainOk {
-		domains = append(domains, domain)
-	} else {
-		domainsAny, domainsOk := data["domains"].([]any)
-		if !domainsOk {
-			ctx.AbortWithStatus(http.StatusNotFound)
-			return
-		}
+	domains := requestDomains(req.Domain, req.Domains)
 
-		for _, v := range domainsAny {
-			if str, ok := v.(string); ok {
-				domains = append(domains, str)
-			}
-		}
+	webhookHeaderMap := req.WebhookHeaders
+	if webhookHeaderMap == nil {
+		webhookHeaderMap = map[string]any{}
 	}
 
-	email, emailOk := data["email"].(string)
-	if !emailOk || email == "" {
-		ctx.AbortWithStatus(http.StatusNotFound)
-		return
+	preflight := env.PREFLIGHT_ON_GENERATE
+	if req.Preflight != nil {
+		preflight = *req.Preflight
 	}
 
-	webhookUrl, webhookUrlOk := data["webhook_url"].(string)
-	if !webhookUrlOk {
-		webhookUrl = ""
+	overlap := req.Overlap
+	if overlap == "" {
+		overlap = certsservice.OverlapFail
 	}
 
-	webhookHeaderMap, webhookHeaderMapOk := data["webhook_headers"].(map[string]any)
-	if !webhookHeaderMapOk {
-		webhookHeaderMap = map[string]any{}
+	if !validateLabels(ctx, req.Labels) {
+		return
 	}
 
 	// shouldCheckPropagation, scpOk := data["check_propagation"].(bool)
@@ -215,8 +200,41 @@ func (c *CertsController) Generate(ctx *gin.Context) {
 	// }
 
 	// Generate certs
-	main, err := c.CertsService.GenerateCerts(ts, email, domains, webhookUrl, webhookHeaderMap)
+	main, err := c.CertsService.GenerateCerts(ts, req.Email, domains, req.WebhookUrl, webhookHeaderMap, preflight, overlap, req.Labels, req.Notes)
 	if err != nil {
+		var overlapErr *certsservice.OverlapError
+		if errors.As(err, &overlapErr) {
+			ctx.JSON(http.StatusConflict, map[string]any{
+				"message":  err.Error(),
+				"mode":     overlapErr.Mode,
+				"overlaps": overlapErr.Overlaps,
+			})
+			return
+		}
+		var trashedErr *certsservice.TrashedError
+		if errors.As(err, &trashedErr) {
+			ctx.JSON(http.StatusConflict, map[string]any{
+				"message": err.Error(),
+				"main":    trashedErr.Main,
+			})
+			return
+		}
+		var domainsErr *acme.DomainsError
+		if errors.As(err, &domainsErr) {
+			ctx.JSON(http.StatusBadRequest, map[string]any{
+				"message": "Invalid domains",
+				"errors":  domainsErr.Errors,
+			})
+			return
+		}
+		var preflightErr *acme.PreflightError
+		if errors.As(err, &preflightErr) {
+			ctx.JSON(http.StatusUnprocessableEntity, map[string]any{
+				"message":   err.Error(),
+				"preflight": preflightErr.Result,
+			})
+			return
+		}
 		ctx.JSON(http.StatusInternalServerError, map[string]any{
 			"message": err.Error(),
 		})
@@ -228,35 +246,113 @@ func (c *CertsController) Generate(ctx *gin.Context) {
 	})
 }
 
+func (c *CertsController) Preflight(ctx *gin.Context) {
+
+	// Request body
+	var req PreflightRequest
+	if !request.BindJSON(ctx, &req) {
+		return
+	}
+
+	result, err := c.CertsService.Preflight(requestDomains(req.Domain, req.Domains), req.Email)
+	if err != nil {
+		var domainsErr *acme.DomainsError
+		if errors.As(err, &domainsErr) {
+			ctx.JSON(http.StatusBadRequest, map[string]any{
+				"message": "Invalid domains",
+				"errors":  domainsErr.Errors,
+			})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, map[string]any{
+			"message": err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, result)
+}
+
+func (c *CertsController) Sweep(ctx *gin.Context) {
+
+	// Server time
+	ts := time.Now().UnixMilli()
+
+	result, err := c.CertsService.SweepChallenges(ts)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, map[string]any{
+			"message": err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, result)
+}
+
+// Delete moves the cert to the trash, it is purged once trashed longer than the retention period
 func (c *CertsController) Delete(ctx *gin.Context) {
 
 	// Server time
-	// ts := time.Now().UnixMilli()
+	ts := time.Now().UnixMilli()
 
 	// Request body
-	var data map[string]any
-	if err := ctx.ShouldBindJSON(&data); err != nil {
-		ctx.AbortWithStatus(http.StatusNotFound)
+	var req DomainRequest
+	if !request.BindJSON(ctx, &req) {
 		return
 	}
 
-	domain, domainOk := data["domain"].(string)
-	if !domainOk || domain == "" {
-		ctx.AbortWithStatus(http.StatusBadRequest)
+	// Retrieve from Db
+	certs, ok := c.getCerts(ctx, req)
+	if !ok {
 		return
 	}
+	main := certs.Main
 
-	// Retrieve from Db
-	certs, err := c.CertsRepository.GetCerts(domain)
+	// Move to trash
+	err := c.CertsService.TrashCerts(ts, main)
 	if err != nil {
-		ctx.AbortWithStatus(http.StatusNotFound)
+		ctx.JSON(http.StatusInternalServerError, map[string]any{
+			"message": err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, map[string]any{
+		"main": main,
+	})
+}
+
+func (c *CertsController) Restore(ctx *gin.Context) {
+
+	// Request body
+	var req DomainRequest
+	if !request.BindJSON(ctx, &req) {
 		return
 	}
-	main := certs["main"].(string)
 
-	// Delete from Db
-	_, err = c.CertsRepository.DeleteCerts(main)
+	main, err := c.CertsService.RestoreCerts(req.Domain)
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			ctx.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+		var conflictErr *certsrepository.ConflictError
+		if errors.As(err, &conflictErr) {
+			ctx.JSON(http.StatusConflict, map[string]any{
+				"message":    err.Error(),
+				"candidates": conflictErr.Candidates,
+			})
+			return
+		}
+		var overlapErr *certsservice.OverlapError
+		if errors.As(err, &overlapErr) {
+			ctx.JSON(http.StatusConflict, map[string]any{
+				"message":  err.Error(),
+				"mode":     overlapErr.Mode,
+				"overlaps": overlapErr.Overlaps,
+			})
+			return
+		}
 		ctx.JSON(http.StatusInternalServerError, map[string]any{
 			"message": err.Error(),
 		})
@@ -268,41 +364,216 @@ func (c *CertsController) Delete(ctx *gin.Context) {
 	})
 }
 
-func (c *CertsController) UpdateWebhook(ctx *gin.Context) {
+func (c *CertsController) AddSans(ctx *gin.Context) {
+	c.modifySans(ctx, true)
+}
+
+func (c *CertsController) RemoveSans(ctx *gin.Context) {
+	c.modifySans(ctx, false)
+}
+
+func (c *CertsController) modifySans(ctx *gin.Context, add bool) {
+
+	// Server time
+	ts := time.Now().UnixMilli()
 
 	// Request body
-	var data map[string]any
-	if err := ctx.ShouldBindJSON(&data); err != nil {
-		ctx.AbortWithStatus(http.StatusNotFound)
+	var req SansRequest
+	if !request.BindJSON(ctx, &req) {
 		return
 	}
 
-	domain, domainOk := data["domain"].(string)
-	if !domainOk || domain == "" {
-		ctx.AbortWithStatus(http.StatusBadRequest)
+	// Retrieve from Db
+	certs, ok := c.getCerts(ctx, req.DomainRequest)
+	if !ok {
 		return
 	}
+	main := certs.Main
+
+	var added, removed []string
+	var err error
+	if add {
+		added, removed, err = c.CertsService.ModifySans(ts, main, req.Domains, nil)
+	} else {
+		added, removed, err = c.CertsService.ModifySans(ts, main, nil, req.Domains)
+	}
+	if err != nil {
+		var domainsErr *acme.DomainsError
+		if errors.As(err, &domainsErr) {
+			ctx.JSON(http.StatusBadRequest, map[string]any{
+				"message": "Invalid domains",
+				"errors":  domainsErr.Errors,
+			})
+			return
+		}
+		var overlapErr *certsservice.OverlapError
+		if errors.As(err, &overlapErr) {
+			ctx.JSON(http.StatusConflict, map[string]any{
+				"message":  err.Error(),
+				"mode":     overlapErr.Mode,
+				"overlaps": overlapErr.Overlaps,
+			})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, map[string]any{
+			"message": err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, map[string]any{
+		"main":         main,
+		"sans_added":   added,
+		"sans_removed": removed,
+	})
+}
+
+func (c *CertsController) ListVersions(ctx *gin.Context) {
+
+	// Request body
+	var req DomainRequest
+	if !request.BindJSON(ctx, &req) {
+		return
+	}
+
+	// Retrieve from Db
+	certs, ok := c.getCerts(ctx, req)
+	if !ok {
+		return
+	}
+	main := certs.Main
+
+	list, err := c.CertsService.ListVersions(main)
+	if err != nil {
+		ctx.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	versions := []VersionResponse{}
+	for _, v := range list {
+		versions = append(versions, newVersionResponse(v))
+	}
+
+	ctx.JSON(http.StatusOK, map[string]any{
+		"main":     main,
+		"versions": versions,
+	})
+}
+
+func (c *CertsController) GetVersionPrivateKey(ctx *gin.Context) {
+
+	// Request body
+	var req VersionPrivateKeyRequest
+	if !request.BindJSON(ctx, &req) {
+		return
+	}
+
+	version, ok := c.getVersion(ctx, req.VersionRequest)
+	if !ok {
+		return
+	}
+
+	output, err := certsservice.EncodePrivateKey(version.PrivateKey, req.Format)
+	if err == nil {
+		c.recordConsumer(ctx, version.Main, version.Serial)
+	}
+	writeOutput(ctx, output, err)
+}
+
+func (c *CertsController) GetVersionCertificate(ctx *gin.Context) {
+
+	// Request body
+	var req VersionCertificateRequest
+	if !request.BindJSON(ctx, &req) {
+		return
+	}
+
+	version, ok := c.getVersion(ctx, req.VersionRequest)
+	if !ok {
+		return
+	}
+
+	output, err := certsservice.EncodeCertificate(version.Main, version.PrivateKey, version.Certificate, req.Format, req.Password)
+	if err == nil {
+		c.recordConsumer(ctx, version.Main, version.Serial)
+	}
+	writeOutput(ctx, output, err)
+}
+
+func (c *CertsController) Rollback(ctx *gin.Context) {
+
+	// Server time
+	ts := time.Now().UnixMilli()
+
+	// Request body
+	var req VersionRequest
+	if !request.BindJSON(ctx, &req) {
+		return
+	}
+
+	version, ok := c.getVersion(ctx, req)
+	if !ok {
+		return
+	}
+	main := version.Main
+	serial := version.Serial
+
+	err := c.CertsService.RollbackCerts(ts, main, serial)
+	if err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity, map[string]any{
+			"message": err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, map[string]any{
+		"main":   main,
+		"serial": serial,
+	})
+}
+
+func (c *CertsController) getVersion(ctx *gin.Context, req VersionRequest) (certsrepository.CertVersion, bool) {
 
 	// Retrieve from Db
-	certs, err := c.CertsRepository.GetCerts(domain)
+	certs, ok := c.getCerts(ctx, req.DomainRequest)
+	if !ok {
+		return certsrepository.CertVersion{}, false
+	}
+
+	version, err := c.CertsRepository.GetVersion(certs.Main, req.Serial)
 	if err != nil {
 		ctx.AbortWithStatus(http.StatusNotFound)
+		return certsrepository.CertVersion{}, false
+	}
+
+	return version, true
+}
+
+func (c *CertsController) UpdateWebhook(ctx *gin.Context) {
+
+	// Request body
+	var req WebhookRequest
+	if !request.BindJSON(ctx, &req) {
 		return
 	}
-	main := certs["main"].(string)
 
-	url, urlOk := data["url"].(string)
-	if !urlOk || url == "" {
-		ctx.AbortWithStatus(http.StatusBadRequest)
+	// Retrieve from Db
+	certs, ok := c.getCerts(ctx, req.DomainRequest)
+	if !ok {
 		return
 	}
+	main := certs.Main
 
-	headerMap, headerMapOk := data["headers"].(map[string]any)
-	if !headerMapOk {
+	headerMap := req.Headers
+	if headerMap == nil {
 		headerMap = map[string]any{}
 	}
 
-	_, err = c.WebhookRepository.UpsertWebhook(main, url, headerMap)
+	_, err := c.WebhookRepository.UpsertWebhook(webhookrepository.Webhook{
+		Main:    main,
+		Url:     req.Url,
+		Headers: headerMap,
+	})
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, map[string]any{
 			"message": err.Error(),
@@ -318,27 +589,19 @@ func (c *CertsController) UpdateWebhook(ctx *gin.Context) {
 func (c *CertsController) DeleteWebhook(ctx *gin.Context) {
 
 	// Request body
-	var data map[string]any
-	if err := ctx.ShouldBindJSON(&data); err != nil {
-		ctx.AbortWithStatus(http.StatusNotFound)
-		return
-	}
-
-	domain, domainOk := data["domain"].(string)
-	if !domainOk || domain == "" {
-		ctx.AbortWithStatus(http.StatusBadRequest)
+	var req DomainRequest
+	if !request.BindJSON(ctx, &req) {
 		return
 	}
 
 	// Retrieve from Db
-	certs, err := c.CertsRepository.GetCerts(domain)
-	if err != nil {
-		ctx.AbortWithStatus(http.StatusNotFound)
+	certs, ok := c.getCerts(ctx, req)
+	if !ok {
 		return
 	}
-	main := certs["main"].(string)
+	main := certs.Main
 
-	_, err = c.WebhookRepository.DeleteWebhook(main)
+	_, err := c.WebhookRepository.DeleteWebhook(main)
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, map[string]any{
 			"message": err.Error(),
@@ -350,3 +613,68 @@ func (c *CertsController) DeleteWebhook(ctx *gin.Context) {
 		"main": main,
 	})
 }
+
+// getCerts resolves domain to a single cert, aborting with 404 if none, 409 listing the candidates if ambiguous
+// or 500 if the lookup fails
+func (c *CertsController) getCerts(ctx *gin.Context, req DomainRequest) (certsrepository.Cert, bool) {
+
+	main := ""
+	if req.Main != "" {
+		main, _ = acme.ValidateDomain(req.Main)
+	}
+
+	certs, err := c.CertsRepository.GetCerts(req.Domain, req.Wildcard)
+
+	// Candidates of a conflict are picked by their main
+	var conflictErr *certsrepository.ConflictError
+	if errors.As(err, &conflictErr) && main != "" {
+		for _, candidate := range conflictErr.Candidates {
+			if candidate == main {
+				certs, err = c.CertsRepository.GetCertsByMain(main)
+				break
+			}
+		}
+	}
+
+	if err != nil {
+		if errors.As(err, &conflictErr) {
+			ctx.AbortWithStatusJSON(http.StatusConflict, map[string]any{
+				"message":    err.Error(),
+				"candidates": conflictErr.Candidates,
+			})
+			return certsrepository.Cert{}, false
+		}
+		if errors.Is(err, sql.ErrNoRows) {
+			ctx.AbortWithStatus(http.StatusNotFound)
+			return certsrepository.Cert{}, false
+		}
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, map[string]any{
+			"message": err.Error(),
+		})
+		return certsrepository.Cert{}, false
+	}
+
+	// A main other than the cert of domain finds nothing
+	if main != "" && certs.Main != main {
+		ctx.AbortWithStatus(http.StatusNotFound)
+		return certsrepository.Cert{}, false
+	}
+
+	return certs, true
+}
+
+// writeOutput responds with a cert encoded in an output format, as an attachment for binary formats
+func writeOutput(ctx *gin.Context, output certsservice.Output, err error) {
+
+	if err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity, map[string]any{
+			"message": err.Error(),
+		})
+		return
+	}
+
+	if output.Filename != "" {
+		ctx.Header("Content-Disposition", `attachment; filename="`+output.Filename+`"`)
+	}
+	ctx.Data(http.StatusOK, output.ContentType, output.Data)
+}
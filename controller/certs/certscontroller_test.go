@@ -0,0 +1,121 @@
+package a
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/widhaprasa/go-acme-service/repository"
+	certsrepository "github.com/widhaprasa/go-acme-service/repository/certs"
+)
+
+func TestGetCerts(t *testing.T) {
+
+	r, repositories := newTestRouter(t)
+	upsertTestCert(t, repositories, "xn--bcher-kva.example", "01")
+	upsertTestCert(t, repositories, "example.com", "02", "www.example.com")
+	upsertTestCert(t, repositories, "www.example.com", "03")
+
+	tests := []struct {
+		name   string
+		query  string
+		status int
+		serial string
+	}{
+		{"unicode", "domain=B%C3%BCcher.example.", http.StatusOK, "01"},
+		{"ascii", "domain=xn--bcher-kva.example", http.StatusOK, "01"},
+		{"single match", "domain=example.com", http.StatusOK, "02"},
+		{"conflict named after the domain", "domain=www.example.com", http.StatusConflict, ""},
+		{"candidate by main", "domain=www.example.com&main=example.com", http.StatusOK, "02"},
+		{"other candidate by main", "domain=WWW.example.com&main=WWW.example.com", http.StatusOK, "03"},
+		{"main not a candidate", "domain=www.example.com&main=other.com", http.StatusConflict, ""},
+		{"main of another cert", "domain=example.com&main=www.example.com", http.StatusNotFound, ""},
+		{"unknown", "domain=unknown.example.com", http.StatusNotFound, ""},
+	}
+	for _, test := range tests {
+		w := distribute(r, test.query, nil)
+		if w.Code != test.status {
+			t.Errorf("%s: status %d, want %d", test.name, w.Code, test.status)
+			continue
+		}
+		if test.serial != "" && w.Header().Get("ETag") != `"`+test.serial+`-fullchain"` {
+			t.Errorf("%s: ETag %s, want serial %s", test.name, w.Header().Get("ETag"), test.serial)
+		}
+		if w.Code == http.StatusConflict {
+			var body struct {
+				Candidates []string `json:"candidates"`
+			}
+			json.Unmarshal(w.Body.Bytes(), &body)
+			if len(body.Candidates) != 2 {
+				t.Errorf("%s: candidates %v, want both certs", test.name, body.Candidates)
+			}
+		}
+	}
+}
+
+// failingCertsRepository fails every lookup as a broken database would
+type failingCertsRepository struct {
+	certsrepository.CertsRepository
+}
+
+func (f failingCertsRepository) GetCerts(domain string, wildcard bool) (certsrepository.Cert, error) {
+	return certsrepository.Cert{}, errors.New("database is locked")
+}
+
+func TestGetCertsFailure(t *testing.T) {
+
+	gin.SetMode(gin.TestMode)
+	repositories := repository.NewMemory()
+	controller := &CertsController{
+		CertsRepository: failingCertsRepository{repositories.Certs},
+	}
+	r := gin.New()
+	r.GET("/certs/distribute", controller.Distribute)
+
+	// A failed lookup is not reported as a missing cert
+	w := distribute(r, "domain=example.com", nil)
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("status %d, want %d", w.Code, http.StatusInternalServerError)
+	}
+}
+
+func TestGenerateInvalidDomains(t *testing.T) {
+
+	r, _ := newTestRouter(t)
+
+	body := `{"email": "admin@example.com", "domains": ["example.com", "a.*.example.com", "co.uk", "192.168.1.1"]}`
+	req := httptest.NewRequest(http.MethodPost, "/certs/generate", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	// Every rejected identifier is listed with its own message
+	var response struct {
+		Message string `json:"message"`
+		Errors  []struct {
+			Domain  string `json:"domain"`
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Message != "Invalid domains" || len(response.Errors) != 3 {
+		t.Fatalf("got %s", w.Body.String())
+	}
+	for i, domain := range []string{"a.*.example.com", "co.uk", "192.168.1.1"} {
+		if response.Errors[i].Domain != domain || response.Errors[i].Message == "" {
+			t.Errorf("error %d: got %+v, want %s", i, response.Errors[i], domain)
+		}
+	}
+}
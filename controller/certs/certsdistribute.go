@@ -0,0 +1,167 @@
+package a
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/widhaprasa/go-acme-service/controller/request"
+
+	certsrepository "github.com/widhaprasa/go-acme-service/repository/certs"
+	certsservice "github.com/widhaprasa/go-acme-service/service/certs"
+)
+
+// Distribute serves the certificate of a domain conditionally on its serial. With wait, a request whose
+// If-None-Match holds the current version is held until a new version is stored or wait seconds elapse
+func (c *CertsController) Distribute(ctx *gin.Context) {
+
+	// Query string
+	var req DistributeRequest
+	if !request.BindQuery(ctx, &req) {
+		return
+	}
+	password, ok := keystorePassword(ctx, req.Format)
+	if !ok {
+		return
+	}
+
+	// Retrieve from Db
+	certs, ok := c.getCerts(ctx, DomainRequest{
+		Domain:   req.Domain,
+		Wildcard: req.Wildcard,
+		Main:     req.Main,
+	})
+	if !ok {
+		return
+	}
+	main := certs.Main
+
+	deadline := time.Now().Add(time.Duration(req.Wait) * time.Second)
+	for {
+		if !notModified(ctx, certs, req.Format) {
+			break
+		}
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			c.recordConsumer(ctx, main, certs.Serial)
+			writeCacheHeaders(ctx, certs, req.Format)
+			ctx.Status(http.StatusNotModified)
+			return
+		}
+
+		// Watch before reading again, so an upsert in between is not missed
+		var changed <-chan struct{}
+		if c.CertsWatcher != nil {
+			changed = c.CertsWatcher.Watch(main)
+		}
+
+		var err error
+		certs, err = c.CertsRepository.GetCertsByMain(main)
+		if err != nil {
+			ctx.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+		if !notModified(ctx, certs, req.Format) {
+			break
+		}
+
+		timer := time.NewTimer(remaining)
+		select {
+		case <-changed:
+		case <-timer.C:
+		case <-ctx.Request.Context().Done():
+			timer.Stop()
+			return
+		}
+		timer.Stop()
+
+		// Also catches an upsert made by another replica once the wait is over
+		certs, err = c.CertsRepository.GetCertsByMain(main)
+		if err != nil {
+			ctx.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+	}
+
+	output, err := certsservice.EncodeCertificate(main, certs.PrivateKey, certs.Certificate, req.Format, password)
+	if err == nil {
+		c.recordConsumer(ctx, main, certs.Serial)
+		writeCacheHeaders(ctx, certs, req.Format)
+	}
+	writeOutput(ctx, output, err)
+}
+
+// keystorePassword returns the password of pkcs12 and jks from the X-Keystore-Password header, as a query
+// string ends up in access logs. A password in the query string is refused so it is not sent again
+func keystorePassword(ctx *gin.Context, format string) (string, bool) {
+
+	message := ""
+	password := ctx.GetHeader("X-Keystore-Password")
+	if _, ok := ctx.GetQuery("password"); ok {
+		message = "Password is taken from the X-Keystore-Password header, not the query string"
+	} else if password == "" && (format == "pkcs12" || format == "jks") {
+		message = "Header X-Keystore-Password is required when format is " + format
+	}
+	if message == "" {
+		return password, true
+	}
+
+	ctx.AbortWithStatusJSON(http.StatusBadRequest, map[string]any{
+		"message": "Invalid request",
+		"errors": []request.FieldError{{
+			Field:   "password",
+			Message: message,
+		}},
+	})
+	return "", false
+}
+
+// notModified reports whether the client already holds the current version of certs, by If-None-Match
+// or else If-Modified-Since
+func notModified(ctx *gin.Context, certs certsrepository.Cert, format string) bool {
+
+	if header := ctx.GetHeader("If-None-Match"); header != "" {
+		current := certsETag(certs, format)
+		for _, tag := range strings.Split(header, ",") {
+			tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+			if tag == current || tag == "*" {
+				return true
+			}
+		}
+		return false
+	}
+
+	if header := ctx.GetHeader("If-Modified-Since"); header != "" {
+		since, err := http.ParseTime(header)
+		if err != nil {
+			return false
+		}
+		return certs.UpsertedTs/1000 <= since.Unix()
+	}
+
+	return false
+}
+
+// certsETag identifies the version of certs by its serial, falling back to its upsert time, in format
+func certsETag(certs certsrepository.Cert, format string) string {
+
+	version := certs.Serial
+	if version == "" {
+		version = strconv.FormatInt(certs.UpsertedTs, 10)
+	}
+	if format == "" {
+		format = certsservice.FormatFullchain
+	}
+	return `"` + version + "-" + format + `"`
+}
+
+func writeCacheHeaders(ctx *gin.Context, certs certsrepository.Cert, format string) {
+
+	ctx.Header("ETag", certsETag(certs, format))
+	ctx.Header("Last-Modified", time.UnixMilli(certs.UpsertedTs).UTC().Format(http.TimeFormat))
+	ctx.Header("Cache-Control", "private, no-cache")
+}
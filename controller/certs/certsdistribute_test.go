@@ -0,0 +1,221 @@
+package a
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/widhaprasa/go-acme-service/repository"
+	certsrepository "github.com/widhaprasa/go-acme-service/repository/certs"
+	certsservice "github.com/widhaprasa/go-acme-service/service/certs"
+	"github.com/widhaprasa/go-acme-service/service/client"
+	"github.com/widhaprasa/go-acme-service/service/zone"
+)
+
+func newTestRouter(t *testing.T) (*gin.Engine, *repository.Repositories) {
+
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	repositories := repository.NewMemory()
+	clientService := client.ClientService{
+		Clientrepository: repositories.Client,
+	}
+	zoneService := &zone.ZoneService{
+		ZoneRepository:   repositories.Zone,
+		ClientRepository: repositories.Client,
+	}
+	controller := &CertsController{
+		CertsRepository:   repositories.Certs,
+		CertsService:      certsservice.NewCertsService(repositories.Certs, clientService, repositories.Webhook, zoneService),
+		WebhookRepository: repositories.Webhook,
+		CertsWatcher:      repositories.CertsWatcher,
+	}
+
+	r := gin.New()
+	r.GET("/certs/distribute", controller.Distribute)
+	r.POST("/certs/generate", controller.Generate)
+	return r, repositories
+}
+
+// upsertTestCert stores a self-signed certificate of main and sans as a new version with serial
+func upsertTestCert(t *testing.T, repositories *repository.Repositories, main string, serial string, sans ...string) {
+
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: main},
+		DNSNames:     append([]string{main}, sans...),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = repositories.Certs.UpsertCerts(certsrepository.Cert{
+		Main:        main,
+		Sans:        append([]string{main}, sans...),
+		Email:       "admin@example.com",
+		PrivateKey:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+		Certificate: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		NotBeforeTs: template.NotBefore.UnixMilli(),
+		NotAfterTs:  template.NotAfter.UnixMilli(),
+		UpsertedTs:  time.Now().UnixMilli(),
+	}, certsrepository.VersionInfo{
+		Serial: serial,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func distribute(r *gin.Engine, query string, header http.Header) *httptest.ResponseRecorder {
+
+	req := httptest.NewRequest(http.MethodGet, "/certs/distribute?"+query, nil)
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestDistributePassword(t *testing.T) {
+
+	r, repositories := newTestRouter(t)
+	upsertTestCert(t, repositories, "example.com", "01")
+
+	tests := []struct {
+		name     string
+		query    string
+		password string
+		status   int
+	}{
+		{"header", "format=pkcs12", "secret", http.StatusOK},
+		{"header jks", "format=jks", "secret", http.StatusOK},
+		{"missing", "format=pkcs12", "", http.StatusBadRequest},
+		{"query string", "format=pkcs12&password=secret", "", http.StatusBadRequest},
+		{"query string and header", "format=jks&password=secret", "secret", http.StatusBadRequest},
+		{"not a keystore", "format=fullchain", "", http.StatusOK},
+	}
+	for _, test := range tests {
+		header := http.Header{}
+		if test.password != "" {
+			header.Set("X-Keystore-Password", test.password)
+		}
+		w := distribute(r, "domain=example.com&"+test.query, header)
+		if w.Code != test.status {
+			t.Errorf("%s: status %d, want %d: %s", test.name, w.Code, test.status, w.Body.String())
+		}
+		if w.Code == http.StatusBadRequest && !bytes.Contains(w.Body.Bytes(), []byte("X-Keystore-Password")) {
+			t.Errorf("%s: error does not name the header: %s", test.name, w.Body.String())
+		}
+	}
+}
+
+func TestDistributeConditional(t *testing.T) {
+
+	r, repositories := newTestRouter(t)
+	upsertTestCert(t, repositories, "example.com", "01")
+
+	w := distribute(r, "domain=example.com", nil)
+	etag := w.Header().Get("ETag")
+	lastModified := w.Header().Get("Last-Modified")
+	if w.Code != http.StatusOK || etag != `"01-fullchain"` || lastModified == "" {
+		t.Fatalf("status %d ETag %s Last-Modified %s", w.Code, etag, lastModified)
+	}
+
+	tests := []struct {
+		name   string
+		query  string
+		header string
+		value  string
+		status int
+	}{
+		{"same version", "", "If-None-Match", etag, http.StatusNotModified},
+		{"weak and listed", "", "If-None-Match", `"00-fullchain", W/` + etag, http.StatusNotModified},
+		{"other version", "", "If-None-Match", `"00-fullchain"`, http.StatusOK},
+		{"other format", "&format=leaf", "If-None-Match", etag, http.StatusOK},
+		{"not modified since", "", "If-Modified-Since", lastModified, http.StatusNotModified},
+		{"modified since", "", "If-Modified-Since", time.Unix(0, 0).UTC().Format(http.TimeFormat), http.StatusOK},
+	}
+	for _, test := range tests {
+		header := http.Header{}
+		header.Set(test.header, test.value)
+		w := distribute(r, "domain=example.com"+test.query, header)
+		if w.Code != test.status {
+			t.Errorf("%s: status %d, want %d", test.name, w.Code, test.status)
+		}
+		if w.Code == http.StatusNotModified && w.Header().Get("ETag") != etag {
+			t.Errorf("%s: 304 without the current ETag", test.name)
+		}
+	}
+
+	// Both the served and the not modified fetches are recorded
+	consumers, _ := repositories.Certs.ListConsumers("example.com")
+	if len(consumers) != 1 || consumers[0].Serial != "01" {
+		t.Fatalf("consumers %+v, want the address of the test requests", consumers)
+	}
+}
+
+func TestDistributeLongPoll(t *testing.T) {
+
+	r, repositories := newTestRouter(t)
+	upsertTestCert(t, repositories, "example.com", "01")
+	header := http.Header{}
+	header.Set("If-None-Match", `"01-fullchain"`)
+
+	// Held until the wait is over
+	start := time.Now()
+	w := distribute(r, "domain=example.com&wait=1", header)
+	if w.Code != http.StatusNotModified || time.Since(start) < time.Second {
+		t.Fatalf("status %d after %v, want 304 after the wait", w.Code, time.Since(start))
+	}
+
+	// Answered with the new version as soon as it is stored
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		done <- distribute(r, "domain=example.com&wait=30", header)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	start = time.Now()
+	upsertTestCert(t, repositories, "example.com", "02")
+
+	select {
+	case w = <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("long poll not woken by the new version")
+	}
+	if w.Code != http.StatusOK || w.Header().Get("ETag") != `"02-fullchain"` {
+		t.Fatalf("status %d ETag %s, want the new version", w.Code, w.Header().Get("ETag"))
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("answered %v after the upsert", elapsed)
+	}
+
+	// A client already behind is answered right away
+	w = distribute(r, "domain=example.com&wait=30", header)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d, want the current version", w.Code)
+	}
+}
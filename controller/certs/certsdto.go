@@ -0,0 +1,266 @@
+package a
+
+import (
+	"strings"
+	"time"
+
+	certsrepository "github.com/widhaprasa/go-acme-service/repository/certs"
+	webhookrepository "github.com/widhaprasa/go-acme-service/repository/webhook"
+	certsservice "github.com/widhaprasa/go-acme-service/service/certs"
+)
+
+// Requests
+
+type DomainRequest struct {
+	Domain   string `json:"domain" binding:"required"`
+	Wildcard bool   `json:"wildcard"`
+
+	// Main picks one of the certs domain matches when there are several
+	Main string `json:"main"`
+}
+
+type GenerateRequest struct {
+	Domain         string            `json:"domain" binding:"required_without=Domains"`
+	Domains        []string          `json:"domains" binding:"required_without=Domain"`
+	Email          string            `json:"email" binding:"required"`
+	WebhookUrl     string            `json:"webhook_url" binding:"omitempty,url"`
+	WebhookHeaders map[string]any    `json:"webhook_headers"`
+	Preflight      *bool             `json:"preflight"`
+	Overlap        string            `json:"overlap" binding:"omitempty,oneof=fail extend separate"`
+	Labels         map[string]string `json:"labels"`
+	Notes          string            `json:"notes"`
+}
+
+type PreflightRequest struct {
+	Domain  string   `json:"domain" binding:"required_without=Domains"`
+	Domains []string `json:"domains" binding:"required_without=Domain"`
+	Email   string   `json:"email"`
+}
+
+type SansRequest struct {
+	DomainRequest
+	Domains []string `json:"domains" binding:"required,min=1"`
+}
+
+type VersionRequest struct {
+	DomainRequest
+	Serial string `json:"serial" binding:"required"`
+}
+
+type CertificateFormat struct {
+	Format   string `json:"format" binding:"omitempty,oneof=fullchain leaf chain combined der pkcs12 jks zip"`
+	Password string `json:"password" binding:"required_if=Format pkcs12,required_if=Format jks"`
+}
+
+type PrivateKeyFormat struct {
+	Format string `json:"format" binding:"omitempty,oneof=pem pkcs8"`
+}
+
+type CertificateRequest struct {
+	DomainRequest
+	CertificateFormat
+}
+
+type PrivateKeyRequest struct {
+	DomainRequest
+	PrivateKeyFormat
+}
+
+type VersionCertificateRequest struct {
+	VersionRequest
+	CertificateFormat
+}
+
+type VersionPrivateKeyRequest struct {
+	VersionRequest
+	PrivateKeyFormat
+}
+
+type WebhookRequest struct {
+	DomainRequest
+	Url     string         `json:"url" binding:"required,url"`
+	Headers map[string]any `json:"headers"`
+}
+
+type ListRequest struct {
+	Cursor           string `form:"cursor" json:"cursor"`
+	Limit            int    `form:"limit" json:"limit" binding:"omitempty,min=1,max=1000"`
+	Sort             string `form:"sort" json:"sort" binding:"omitempty,oneof=main not_after upserted"`
+	Order            string `form:"order" json:"order" binding:"omitempty,oneof=asc desc"`
+	ExpiringBeforeTs int64  `form:"expiring_before_ts" json:"expiring_before_ts"`
+	San              string `form:"san" json:"san"`
+	Email            string `form:"email" json:"email"`
+	Issuer           string `form:"issuer" json:"issuer"`
+	Status           string `form:"status" json:"status" binding:"omitempty,oneof=valid expired trashed"`
+	Label            string `form:"label" json:"label"`
+}
+
+type DistributeRequest struct {
+	Domain   string `form:"domain" json:"domain" binding:"required"`
+	Wildcard bool   `form:"wildcard" json:"wildcard"`
+	Main     string `form:"main" json:"main"`
+	Format   string `form:"format" json:"format" binding:"omitempty,oneof=fullchain leaf chain combined der pkcs12 jks zip"`
+	Wait     int    `form:"wait" json:"wait" binding:"min=0,max=300"`
+}
+
+type AgentReportRequest struct {
+	DomainRequest
+	Agent  string `json:"agent" binding:"required"`
+	Serial string `json:"serial" binding:"required"`
+}
+
+type ConsumersRequest struct {
+	DomainRequest
+	ExpiringWithinDays int `json:"expiring_within_days" binding:"min=0,max=365"`
+}
+
+type StaleConsumersRequest struct {
+	ExpiringWithinDays int `form:"expiring_within_days" json:"expiring_within_days" binding:"min=0,max=365"`
+}
+
+type LabelsRequest struct {
+	DomainRequest
+	Labels map[string]string `json:"labels"`
+	Remove []string          `json:"remove"`
+	Notes  *string           `json:"notes"`
+}
+
+type BulkRequest struct {
+	Selector string `json:"selector" binding:"required"`
+}
+
+type BulkLabelsRequest struct {
+	Selector string            `json:"selector" binding:"required"`
+	Labels   map[string]string `json:"labels"`
+	Remove   []string          `json:"remove"`
+}
+
+type SelectorWebhookRequest struct {
+	Selector string         `json:"selector" binding:"required"`
+	Url      string         `json:"url" binding:"required,url"`
+	Headers  map[string]any `json:"headers"`
+}
+
+// requestDomains returns domain as a single SAN or the SANS list
+func requestDomains(domain string, domains []string) []string {
+	if domain != "" {
+		return []string{domain}
+	}
+	return domains
+}
+
+// Responses
+
+type CertResponse struct {
+	Main           string            `json:"main"`
+	Sans           string            `json:"sans"`
+	Email          string            `json:"email"`
+	NotBeforeTs    int64             `json:"not_before_ts"`
+	NotAfterTs     int64             `json:"not_after_ts"`
+	UpsertedTs     int64             `json:"upserted_ts"`
+	Serial         string            `json:"serial,omitempty"`
+	Issuer         string            `json:"issuer,omitempty"`
+	DeletedTs      int64             `json:"deleted_ts,omitempty"`
+	Labels         map[string]string `json:"labels"`
+	Notes          string            `json:"notes,omitempty"`
+	Metadata       *MetadataResponse `json:"metadata,omitempty"`
+	WebhookUrl     string            `json:"webhook_url,omitempty"`
+	WebhookHeaders map[string]any    `json:"webhook_headers,omitempty"`
+}
+
+// MetadataResponse is the stored metadata of a cert along with the whole days left until it expires
+type MetadataResponse struct {
+	certsrepository.Metadata
+	DaysRemaining int64 `json:"days_remaining"`
+}
+
+func newCertResponse(ts int64, cert certsrepository.Cert, webhook *webhookrepository.Webhook) CertResponse {
+
+	result := CertResponse{
+		Main:        cert.Main,
+		Sans:        strings.Join(cert.Sans, ","),
+		Email:       cert.Email,
+		NotBeforeTs: cert.NotBeforeTs,
+		NotAfterTs:  cert.NotAfterTs,
+		UpsertedTs:  cert.UpsertedTs,
+		Serial:      cert.Serial,
+		Issuer:      cert.Issuer,
+		DeletedTs:   cert.DeletedTs,
+		Labels:      cert.Labels,
+		Notes:       cert.Notes,
+	}
+	if result.Labels == nil {
+		result.Labels = map[string]string{}
+	}
+	if cert.Metadata != nil {
+		result.Metadata = &MetadataResponse{
+			Metadata:      *cert.Metadata,
+			DaysRemaining: (cert.NotAfterTs - ts) / (24 * time.Hour).Milliseconds(),
+		}
+	}
+	if webhook != nil {
+		result.WebhookUrl = webhook.Url
+		result.WebhookHeaders = webhook.Headers
+	}
+	return result
+}
+
+type VersionResponse struct {
+	Serial         string `json:"serial"`
+	Sans           string `json:"sans"`
+	Email          string `json:"email"`
+	NotBeforeTs    int64  `json:"not_before_ts"`
+	NotAfterTs     int64  `json:"not_after_ts"`
+	KeyFingerprint string `json:"key_fingerprint"`
+	Issuer         string `json:"issuer"`
+	Trigger        string `json:"trigger"`
+	CreatedTs      int64  `json:"created_ts"`
+	Current        bool   `json:"current"`
+}
+
+func newVersionResponse(version certsservice.Version) VersionResponse {
+
+	return VersionResponse{
+		Serial:         version.Serial,
+		Sans:           strings.Join(version.Sans, ","),
+		Email:          version.Email,
+		NotBeforeTs:    version.NotBeforeTs,
+		NotAfterTs:     version.NotAfterTs,
+		KeyFingerprint: version.KeyFingerprint,
+		Issuer:         version.Issuer,
+		Trigger:        version.Trigger,
+		CreatedTs:      version.CreatedTs,
+		Current:        version.Current,
+	}
+}
+
+type SelectorWebhookResponse struct {
+	Selector string         `json:"selector"`
+	Url      string         `json:"url"`
+	Headers  map[string]any `json:"headers"`
+}
+
+type ConsumerResponse struct {
+	Main       string `json:"main"`
+	Consumer   string `json:"consumer"`
+	Serial     string `json:"serial"`
+	SeenTs     int64  `json:"seen_ts"`
+	NotAfterTs int64  `json:"not_after_ts,omitempty"`
+	Superseded bool   `json:"superseded"`
+	Expiring   bool   `json:"expiring"`
+	Stale      bool   `json:"stale"`
+}
+
+func newConsumerResponse(status certsservice.ConsumerStatus) ConsumerResponse {
+
+	return ConsumerResponse{
+		Main:       status.Main,
+		Consumer:   status.Consumer.Consumer,
+		Serial:     status.Serial,
+		SeenTs:     status.SeenTs,
+		NotAfterTs: status.NotAfterTs,
+		Superseded: status.Superseded,
+		Expiring:   status.Expiring,
+		Stale:      status.Stale(),
+	}
+}
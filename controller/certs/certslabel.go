@@ -0,0 +1,262 @@
+package a
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/widhaprasa/go-acme-service/controller/request"
+
+	certsrepository "github.com/widhaprasa/go-acme-service/repository/certs"
+	webhookrepository "github.com/widhaprasa/go-acme-service/repository/webhook"
+	certsservice "github.com/widhaprasa/go-acme-service/service/certs"
+)
+
+func (c *CertsController) UpdateLabels(ctx *gin.Context) {
+
+	// Request body
+	var req LabelsRequest
+	if !request.BindJSON(ctx, &req) {
+		return
+	}
+	if !validateLabels(ctx, req.Labels) {
+		return
+	}
+
+	// Retrieve from Db
+	certs, ok := c.getCerts(ctx, req.DomainRequest)
+	if !ok {
+		return
+	}
+	main := certs.Main
+
+	err := c.CertsRepository.UpdateLabels(main, req.Labels, req.Remove)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, map[string]any{
+			"message": err.Error(),
+		})
+		return
+	}
+
+	if req.Notes != nil {
+		err = c.CertsRepository.UpdateNotes(main, *req.Notes)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, map[string]any{
+				"message": err.Error(),
+			})
+			return
+		}
+	}
+
+	labels, err := c.CertsRepository.GetLabels(main)
+	if err != nil {
+		ctx.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, map[string]any{
+		"main":   main,
+		"labels": labels,
+	})
+}
+
+func (c *CertsController) BulkDelete(ctx *gin.Context) {
+
+	// Server time
+	ts := time.Now().UnixMilli()
+
+	// Request body
+	var req BulkRequest
+	if !request.BindJSON(ctx, &req) {
+		return
+	}
+	selector, ok := parseSelector(ctx, "selector", req.Selector)
+	if !ok {
+		return
+	}
+
+	mains, err := c.CertsService.BulkTrashCerts(ts, selector)
+	if err != nil {
+		bulkError(ctx, err, mains)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, map[string]any{
+		"mains": mains,
+	})
+}
+
+func (c *CertsController) BulkUpdateLabels(ctx *gin.Context) {
+
+	// Request body
+	var req BulkLabelsRequest
+	if !request.BindJSON(ctx, &req) {
+		return
+	}
+	selector, ok := parseSelector(ctx, "selector", req.Selector)
+	if !ok {
+		return
+	}
+	if !validateLabels(ctx, req.Labels) {
+		return
+	}
+
+	mains, err := c.CertsService.BulkUpdateLabels(selector, req.Labels, req.Remove)
+	if err != nil {
+		bulkError(ctx, err, mains)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, map[string]any{
+		"mains": mains,
+	})
+}
+
+func (c *CertsController) ListSelectorWebhooks(ctx *gin.Context) {
+
+	list, err := c.WebhookRepository.ListSelectorWebhook()
+	if err != nil {
+		ctx.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	webhooks := []SelectorWebhookResponse{}
+	for _, v := range list {
+		webhooks = append(webhooks, SelectorWebhookResponse{
+			Selector: v.Selector,
+			Url:      v.Url,
+			Headers:  v.Headers,
+		})
+	}
+
+	ctx.JSON(http.StatusOK, map[string]any{
+		"webhooks": webhooks,
+	})
+}
+
+func (c *CertsController) UpdateSelectorWebhook(ctx *gin.Context) {
+
+	// Request body
+	var req SelectorWebhookRequest
+	if !request.BindJSON(ctx, &req) {
+		return
+	}
+	selector, ok := parseSelector(ctx, "selector", req.Selector)
+	if !ok {
+		return
+	}
+	if len(selector) == 0 {
+		ctx.JSON(http.StatusBadRequest, map[string]any{
+			"message": certsservice.ErrEmptySelector.Error(),
+		})
+		return
+	}
+
+	headerMap := req.Headers
+	if headerMap == nil {
+		headerMap = map[string]any{}
+	}
+
+	_, err := c.WebhookRepository.UpsertSelectorWebhook(webhookrepository.SelectorWebhook{
+		Selector: selector.String(),
+		Url:      req.Url,
+		Headers:  headerMap,
+	})
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, map[string]any{
+			"message": err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, map[string]any{
+		"selector": selector.String(),
+	})
+}
+
+func (c *CertsController) DeleteSelectorWebhook(ctx *gin.Context) {
+
+	// Request body
+	var req BulkRequest
+	if !request.BindJSON(ctx, &req) {
+		return
+	}
+	selector, ok := parseSelector(ctx, "selector", req.Selector)
+	if !ok {
+		return
+	}
+
+	result, err := c.WebhookRepository.DeleteSelectorWebhook(selector.String())
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, map[string]any{
+			"message": err.Error(),
+		})
+		return
+	}
+	if count, _ := result.RowsAffected(); count == 0 {
+		ctx.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, map[string]any{
+		"selector": selector.String(),
+	})
+}
+
+// parseSelector parses the label selector of field, aborting with 400 if it is invalid
+func parseSelector(ctx *gin.Context, field string, value string) (certsrepository.Selector, bool) {
+
+	selector, err := certsrepository.ParseSelector(value)
+	if err != nil {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, map[string]any{
+			"message": "Invalid request",
+			"errors": []request.FieldError{{
+				Field:   field,
+				Message: err.Error(),
+			}},
+		})
+		return nil, false
+	}
+
+	return selector, true
+}
+
+// validateLabels aborts with 400 listing the invalid labels
+func validateLabels(ctx *gin.Context, labels map[string]string) bool {
+
+	errs := []request.FieldError{}
+	for key, value := range labels {
+		err := certsrepository.ValidateLabel(key, value)
+		if err != nil {
+			errs = append(errs, request.FieldError{
+				Field:   "labels",
+				Message: err.Error(),
+			})
+		}
+	}
+	if len(errs) == 0 {
+		return true
+	}
+
+	ctx.AbortWithStatusJSON(http.StatusBadRequest, map[string]any{
+		"message": "Invalid request",
+		"errors":  errs,
+	})
+	return false
+}
+
+// bulkError responds with the error of a bulk operation along with the mains done before it
+func bulkError(ctx *gin.Context, err error, mains []string) {
+
+	status := http.StatusInternalServerError
+	if errors.Is(err, certsservice.ErrEmptySelector) {
+		status = http.StatusBadRequest
+	}
+
+	ctx.JSON(status, map[string]any{
+		"message": err.Error(),
+		"mains":   mains,
+	})
+}
@@ -0,0 +1,101 @@
+package request
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+type FieldError struct {
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+func init() {
+
+	// Report fields by their JSON name
+	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		v.RegisterTagNameFunc(func(field reflect.StructField) string {
+			name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+			if name == "-" {
+				return ""
+			}
+			return name
+		})
+	}
+}
+
+// BindJSON binds the request body to req, aborting with 400 listing the invalid fields
+func BindJSON(ctx *gin.Context, req any) bool {
+	return bind(ctx, ctx.ShouldBindJSON(req))
+}
+
+// BindQuery binds the query string to req by its form tags, aborting with 400 listing the invalid fields
+func BindQuery(ctx *gin.Context, req any) bool {
+	return bind(ctx, ctx.ShouldBindQuery(req))
+}
+
+func bind(ctx *gin.Context, err error) bool {
+
+	if err == nil {
+		return true
+	}
+
+	errs := []FieldError{}
+	var validationErrs validator.ValidationErrors
+	if errors.As(err, &validationErrs) {
+		for _, v := range validationErrs {
+			errs = append(errs, FieldError{
+				Field:   v.Field(),
+				Message: fieldMessage(v),
+			})
+		}
+	} else {
+		errs = append(errs, FieldError{
+			Message: err.Error(),
+		})
+	}
+
+	ctx.AbortWithStatusJSON(http.StatusBadRequest, map[string]any{
+		"message": "Invalid request",
+		"errors":  errs,
+	})
+	return false
+}
+
+func fieldMessage(err validator.FieldError) string {
+
+	switch err.Tag() {
+	case "required":
+		return "Field is required"
+	case "required_without":
+		return "Field is required when " + strings.ToLower(err.Param()) + " is not set"
+	case "required_if":
+		param := strings.SplitN(err.Param(), " ", 2)
+		return "Field is required when " + strings.ToLower(param[0]) + " is " + param[len(param)-1]
+	case "min":
+		if isCollection(err.Kind()) {
+			return "Field must have at least " + err.Param() + " items"
+		}
+		return "Field must be at least " + err.Param()
+	case "max":
+		if isCollection(err.Kind()) {
+			return "Field must have at most " + err.Param() + " items"
+		}
+		return "Field must be at most " + err.Param()
+	case "oneof":
+		return "Field must be one of: " + strings.ReplaceAll(err.Param(), " ", ", ")
+	case "url":
+		return "Field must be a valid URL"
+	}
+	return "Field failed " + err.Tag() + " validation"
+}
+
+func isCollection(kind reflect.Kind) bool {
+	return kind == reflect.Slice || kind == reflect.Array || kind == reflect.Map
+}
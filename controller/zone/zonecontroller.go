@@ -0,0 +1,111 @@
+package zone
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/widhaprasa/go-acme-service/controller/request"
+	zonerepository "github.com/widhaprasa/go-acme-service/repository/zone"
+	zoneservice "github.com/widhaprasa/go-acme-service/service/zone"
+)
+
+type ZoneRequest struct {
+	Zone       string `json:"zone" binding:"required"`
+	AccountUri bool   `json:"account_uri"`
+}
+
+type ZoneResponse struct {
+	Zone          string   `json:"zone"`
+	CAAManaged    bool     `json:"caa_managed"`
+	CAAAccountUri bool     `json:"caa_account_uri"`
+	CAARecords    []string `json:"caa_records"`
+	UpsertedTs    int64    `json:"upserted_ts"`
+}
+
+type ZoneController struct {
+	ZoneRepository zonerepository.ZoneRepository
+	ZoneService    *zoneservice.ZoneService
+}
+
+func (z *ZoneController) List(ctx *gin.Context) {
+
+	list, err := z.ZoneRepository.ListZone()
+	if err != nil {
+		ctx.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	zones := []ZoneResponse{}
+	for _, v := range list {
+
+		zones = append(zones, ZoneResponse{
+			Zone:          v.Zone,
+			CAAManaged:    v.CAAManaged,
+			CAAAccountUri: v.CAAAccountUri,
+			CAARecords:    v.CAARecords,
+			UpsertedTs:    v.UpsertedTs,
+		})
+	}
+
+	ctx.JSON(http.StatusOK, map[string]any{
+		"zones": zones,
+	})
+}
+
+func (z *ZoneController) UpdateCAA(ctx *gin.Context) {
+
+	// Server time
+	ts := time.Now().UnixMilli()
+
+	// Request body
+	var req ZoneRequest
+	if !request.BindJSON(ctx, &req) {
+		return
+	}
+	zone := req.Zone
+
+	err := z.ZoneService.UpdateZone(ts, zone, req.AccountUri)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, map[string]any{
+			"message": err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, map[string]any{
+		"zone": zone,
+	})
+}
+
+func (z *ZoneController) DeleteCAA(ctx *gin.Context) {
+
+	// Request body
+	var req ZoneRequest
+	if !request.BindJSON(ctx, &req) {
+		return
+	}
+	zone := req.Zone
+
+	// Retrieve from Db
+	_, err := z.ZoneRepository.GetZone(zone)
+	if err != nil {
+		ctx.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	removed, err := z.ZoneService.DeleteZone(zone)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, map[string]any{
+			"message": err.Error(),
+			"removed": removed,
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, map[string]any{
+		"zone":    zone,
+		"removed": removed,
+	})
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/widhaprasa/go-acme-service/env"
+	"github.com/widhaprasa/go-acme-service/repository"
+	"github.com/widhaprasa/go-acme-service/secret"
+)
+
+// loadKeyring returns the keyring of the configured master keys, nil if no master key is configured
+func loadKeyring() (*secret.Keyring, error) {
+
+	current, err := secret.LoadKey(env.MASTER_KEY, env.MASTER_KEY_FILE)
+	if err != nil {
+		return nil, err
+	}
+
+	previous, err := secret.LoadKey(env.MASTER_KEY_PREVIOUS, env.MASTER_KEY_PREVIOUS_FILE)
+	if err != nil {
+		return nil, err
+	}
+
+	if current == nil {
+		if previous != nil {
+			return nil, errors.New("A previous master key is configured without a current one")
+		}
+		log.Println("No master key configured, private keys are stored as plaintext")
+		return nil, nil
+	}
+
+	if previous == nil {
+		return secret.NewKeyring(current)
+	}
+	return secret.NewKeyring(current, previous)
+}
+
+// runEncrypt seals the private keys still stored as plaintext with the current master key
+func runEncrypt(args []string) {
+
+	keyring, err := loadKeyring()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if keyring == nil {
+		log.Fatal("Set MASTER_KEY or MASTER_KEY_FILE to encrypt private keys")
+	}
+
+	repositories, err := repository.Open(env.STORAGE, storageDSN(), keyring)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer repositories.Close()
+	if repositories.Db == nil {
+		log.Fatal("Storage ", env.STORAGE, " has no private keys at rest")
+	}
+
+	count, err := repository.EncryptPrivateKeys(repositories.Db, keyring)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Println("Encrypted", count, "private keys with master key", keyring.CurrentId())
+}
+
+// runRotateKey wraps every data key with the current master key, data keys wrapped by the previous
+// master key are unwrapped with it
+func runRotateKey(args []string) {
+
+	keyring, err := loadKeyring()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if keyring == nil {
+		log.Fatal("Set MASTER_KEY to the new master key and MASTER_KEY_PREVIOUS to the old one")
+	}
+
+	repositories, err := repository.Open(env.STORAGE, storageDSN(), keyring)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer repositories.Close()
+	if repositories.Db == nil {
+		log.Fatal("Storage ", env.STORAGE, " has no private keys at rest")
+	}
+
+	count, err := repository.RewrapDataKeys(repositories.Db, keyring)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Println("Rewrapped", count, "data keys with master key", keyring.CurrentId())
+}
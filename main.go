@@ -1,81 +1,159 @@
 package main
 
 import (
-	"database/sql"
+	"context"
 	"log"
 	"net/http"
+	"os"
 	"strconv"
 	"time"
 
-	_ "github.com/mattn/go-sqlite3"
-
 	"github.com/widhaprasa/go-acme-service/env"
 	"github.com/widhaprasa/go-acme-service/middleware"
 
-	certsrepository "github.com/widhaprasa/go-acme-service/repository/certs"
-	clientrepository "github.com/widhaprasa/go-acme-service/repository/client"
-	webhookrepository "github.com/widhaprasa/go-acme-service/repository/webhook"
+	"github.com/widhaprasa/go-acme-service/repository"
 
+	acmeserverservice "github.com/widhaprasa/go-acme-service/service/acmeserver"
 	certsservice "github.com/widhaprasa/go-acme-service/service/certs"
 	clientservice "github.com/widhaprasa/go-acme-service/service/client"
+	zoneservice "github.com/widhaprasa/go-acme-service/service/zone"
 
+	acmeservercontroller "github.com/widhaprasa/go-acme-service/controller/acmeserver"
+	admincontroller "github.com/widhaprasa/go-acme-service/controller/admin"
 	certscontroller "github.com/widhaprasa/go-acme-service/controller/certs"
+	zonecontroller "github.com/widhaprasa/go-acme-service/controller/zone"
 
 	"github.com/gin-gonic/gin"
 )
 
 func main() {
 
-	db, err := sql.Open("sqlite3", "db/acme.db")
+	// Commands
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "migrate":
+			runMigrate(os.Args[2:])
+		case "encrypt":
+			runEncrypt(os.Args[2:])
+		case "rotate-key":
+			runRotateKey(os.Args[2:])
+		case "backup":
+			runBackup(os.Args[2:])
+		case "restore":
+			runRestore(os.Args[2:])
+		case "agent":
+			runAgent(os.Args[2:])
+		default:
+			log.Fatal("Unknown command: ", os.Args[1])
+		}
+		return
+	}
+
+	// Master key encrypting private keys at rest
+	keyring, err := loadKeyring()
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer db.Close()
 
-	certsRepository := certsrepository.CertsRepository{
-		Db: db,
-	}
-	clientRepository := clientrepository.ClientRepository{
-		Db: db,
+	// Key type of issued certificates
+	_, err = clientservice.KeyType()
+	if err != nil {
+		log.Fatal(err)
 	}
-	webhookRepository := webhookrepository.WebhookRepository{
-		Db: db,
+
+	// Open storage and apply pending migrations
+	repositories, err := repository.Open(env.STORAGE, storageDSN(), keyring)
+	if err != nil {
+		log.Fatal(err)
 	}
+	defer repositories.Close()
 
+	certsRepository := repositories.Certs
+	clientRepository := repositories.Client
+	webhookRepository := repositories.Webhook
+	zoneRepository := repositories.Zone
+
+	zoneService := &zoneservice.ZoneService{
+		ZoneRepository:   zoneRepository,
+		ClientRepository: clientRepository,
+	}
 	clientService := clientservice.ClientService{
 		Clientrepository: clientRepository,
+		TrackProvider:    zoneService.TrackProvider,
+	}
+	certsService := certsservice.NewCertsService(certsRepository, clientService, webhookRepository, zoneService)
+
+	// Nil when no backup store is configured
+	backupService, err := newBackupService(context.Background(), repositories.Db, keyring)
+	if err != nil {
+		log.Fatal(err)
 	}
-	certsService := certsservice.NewCertsService(certsRepository, clientService, webhookRepository)
 
 	certsController := &certscontroller.CertsController{
 		CertsRepository:   certsRepository,
 		CertsService:      certsService,
 		WebhookRepository: webhookRepository,
+		CertsWatcher:      repositories.CertsWatcher,
 	}
-
-	// Create table
-	_, err = certsRepository.CreateTable()
-	if err != nil {
-		log.Fatal(err)
+	zoneController := &zonecontroller.ZoneController{
+		ZoneRepository: zoneRepository,
+		ZoneService:    zoneService,
+	}
+	adminController := &admincontroller.AdminController{
+		BackupService: backupService,
 	}
-	_, err = clientRepository.CreateTable()
+	acmeServerService := acmeserverservice.NewAcmeServerService(repositories.AcmeServer, &certsService)
+	acmeServerController := &acmeservercontroller.AcmeServerController{
+		AcmeServerRepository: repositories.AcmeServer,
+		AcmeServerService:    acmeServerService,
+	}
+
+	// Initial server time
+	ts := time.Now().UnixMilli()
+
+	// Record current certs having no version history
+	err = certsService.BackfillVersions(ts)
 	if err != nil {
 		log.Fatal(err)
 	}
-	_, err = webhookRepository.CreateTable()
+
+	// Parse metadata of certs stored before it was kept
+	err = certsService.BackfillMetadata()
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	// Initial server time
-	ts := time.Now().UnixMilli()
-
-	// Initiate schedule for renew certificates
-	certsService.InitRenewSchedule(ts)
+	// Give up ACME orders left processing by a restart
+	if env.ACME_SERVER_ENABLED {
+		acmeServerService.ExpireOrders(ts)
+	}
 
 	// Initiate schedule for job
 	certsService.InitJobSchedule()
 
+	// Replicas sharing a database run the periodic schedules on one of them only
+	if env.SCHEDULE_ENABLED {
+
+		// Initiate schedule for renew certificates
+		certsService.InitRenewSchedule(ts)
+
+		// Initiate schedule for sweeping stale challenge records
+		certsService.InitSweepSchedule()
+
+		// Initiate schedule for purging trashed certificates
+		certsService.InitPurgeSchedule()
+
+		// Initiate schedule for pruning used ACME nonces
+		if env.ACME_SERVER_ENABLED {
+			acmeServerService.InitNonceSchedule()
+		}
+
+		// Initiate schedule for backing up the database
+		if backupService != nil && env.BACKUP_INTERVAL > 0 {
+			backupService.InitBackupSchedule(time.Duration(env.BACKUP_INTERVAL) * time.Hour)
+		}
+	}
+
 	r := gin.New()
 	r.Use(gin.Logger())
 
@@ -87,18 +165,73 @@ func main() {
 			"status": "ok",
 		})
 	})
+
+	// ACME server, requests are authenticated by their JWS
+	if env.ACME_SERVER_ENABLED {
+		r.GET("/acme/directory", acmeServerController.Directory)
+		r.HEAD("/acme/new-nonce", acmeServerController.NewNonce)
+		r.GET("/acme/new-nonce", acmeServerController.NewNonce)
+		r.POST("/acme/new-account", acmeServerController.NewAccount)
+		r.POST("/acme/account/:id", acmeServerController.Account)
+		r.POST("/acme/account/:id/orders", acmeServerController.AccountOrders)
+		r.POST("/acme/new-order", acmeServerController.NewOrder)
+		r.POST("/acme/order/:id", acmeServerController.Order)
+		r.POST("/acme/order/:id/finalize", acmeServerController.Finalize)
+		r.POST("/acme/authz/:id/:index", acmeServerController.Authorization)
+		r.POST("/acme/challenge/:id/:index", acmeServerController.Challenge)
+		r.POST("/acme/cert/:id", acmeServerController.Certificate)
+		r.POST("/acme/revoke-cert", acmeServerController.RevokeCert)
+	}
+
 	r.Use(middleware.AuthorizeHeader())
 	{
 		r.GET("/certs/list", certsController.List)
 		r.POST("/certs/read", certsController.Read)
 		r.POST("/certs/privatekey", certsController.GetPrivateKey)
 		r.POST("/certs/certificate", certsController.GetCertificate)
+		r.GET("/certs/distribute", certsController.Distribute)
 		r.POST("/certs/generate", certsController.Generate)
+		r.POST("/certs/preflight", certsController.Preflight)
+		r.POST("/certs/sweep", certsController.Sweep)
 		r.POST("/certs/delete", certsController.Delete)
+		r.POST("/certs/restore", certsController.Restore)
+		r.POST("/certs/sans/add", certsController.AddSans)
+		r.POST("/certs/sans/remove", certsController.RemoveSans)
+		r.POST("/certs/versions/list", certsController.ListVersions)
+		r.POST("/certs/versions/privatekey", certsController.GetVersionPrivateKey)
+		r.POST("/certs/versions/certificate", certsController.GetVersionCertificate)
+		r.POST("/certs/rollback", certsController.Rollback)
 		r.POST("/certs/webhook/update", certsController.UpdateWebhook)
 		r.POST("/certs/webhook/delete", certsController.DeleteWebhook)
+		r.POST("/certs/consumers/list", certsController.ListConsumers)
+		r.POST("/certs/labels/update", certsController.UpdateLabels)
+		r.POST("/certs/bulk/delete", certsController.BulkDelete)
+		r.POST("/certs/bulk/labels", certsController.BulkUpdateLabels)
+		r.GET("/webhooks/selectors/list", certsController.ListSelectorWebhooks)
+		r.POST("/webhooks/selectors/update", certsController.UpdateSelectorWebhook)
+		r.POST("/webhooks/selectors/delete", certsController.DeleteSelectorWebhook)
+		r.GET("/zones/list", zoneController.List)
+		r.POST("/zones/caa/update", zoneController.UpdateCAA)
+		r.POST("/zones/caa/delete", zoneController.DeleteCAA)
+		r.POST("/agents/report", certsController.ReportAgent)
+		r.GET("/consumers/stale", certsController.ListStaleConsumers)
+		r.POST("/admin/backup", adminController.Backup)
+		r.GET("/admin/backup/list", adminController.ListBackups)
+		if env.ACME_SERVER_ENABLED {
+			r.GET("/eab/list", acmeServerController.ListEabKeys)
+			r.POST("/eab/create", acmeServerController.CreateEabKey)
+			r.POST("/eab/delete", acmeServerController.DeleteEabKey)
+		}
 	}
 
 	port := env.SERVICE_PORT
 	r.Run(":" + strconv.Itoa(port))
 }
+
+// storageDSN returns the SQLite path or the PostgreSQL connection string of the configured storage
+func storageDSN() string {
+	if env.STORAGE == repository.StoragePostgres {
+		return env.POSTGRES_DSN
+	}
+	return env.SQLITE_PATH
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/widhaprasa/go-acme-service/env"
+	"github.com/widhaprasa/go-acme-service/repository"
+	"github.com/widhaprasa/go-acme-service/repository/migration"
+	"github.com/widhaprasa/go-acme-service/repository/sqldb"
+)
+
+// runMigrate handles `migrate status` and `migrate apply [-dry-run] [-backup=false]`, backing up by default
+// on SQLite only as PostgreSQL is backed up with pg_dump
+func runMigrate(args []string) {
+
+	usage := "Usage: migrate status | migrate apply [-dry-run] [-backup=false]"
+	if len(args) == 0 {
+		log.Fatal(usage)
+	}
+
+	db, err := repository.OpenDb(env.STORAGE, storageDSN())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	migrator := migration.Migrator{
+		Db:   db,
+		Path: storageDSN(),
+	}
+
+	switch args[0] {
+	case "status":
+		list, err := migrator.Status()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED")
+		for _, v := range list {
+			applied := "pending"
+			if v.AppliedTs > 0 {
+				applied = time.UnixMilli(v.AppliedTs).Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\n", v.Version, v.Name, applied)
+		}
+		w.Flush()
+
+	case "apply":
+		flags := flag.NewFlagSet("migrate apply", flag.ExitOnError)
+		dryRun := flags.Bool("dry-run", false, "run pending migrations in a transaction which is rolled back")
+		backup := flags.Bool("backup", db.Dialect == sqldb.Sqlite, "back up the database before applying, SQLite only")
+		flags.Parse(args[1:])
+
+		ts := time.Now().UnixMilli()
+
+		pending, err := migrator.Pending()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if len(pending) == 0 {
+			fmt.Println("No pending migrations")
+			return
+		}
+
+		if *backup && !*dryRun {
+			path, err := migrator.Backup(ts)
+			if err != nil {
+				log.Fatal(err)
+			}
+			fmt.Println("Backed up database to", path)
+		}
+
+		applied, err := migrator.Apply(ts, *dryRun)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, v := range applied {
+			if *dryRun {
+				fmt.Printf("Would apply %d %s\n", v.Version, v.Name)
+			} else {
+				fmt.Printf("Applied %d %s\n", v.Version, v.Name)
+			}
+		}
+
+	default:
+		log.Fatal(usage)
+	}
+}
@@ -0,0 +1,302 @@
+package acmeserver
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"sort"
+	"sync"
+)
+
+// MemoryAcmeServerRepository keeps the ACME server in memory, used for tests and the embedded mode
+type MemoryAcmeServerRepository struct {
+	mu       sync.RWMutex
+	eabKeys  map[string]EabKey
+	accounts map[string]Account
+	orders   map[string]Order
+	nonceKey []byte
+	nonces   map[string]int64
+	lastId   int64
+}
+
+func NewMemoryAcmeServerRepository() *MemoryAcmeServerRepository {
+	return &MemoryAcmeServerRepository{
+		eabKeys:  map[string]EabKey{},
+		accounts: map[string]Account{},
+		orders:   map[string]Order{},
+		nonces:   map[string]int64{},
+	}
+}
+
+func (a *MemoryAcmeServerRepository) GetEabKey(keyId string) (EabKey, error) {
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	key, exists := a.eabKeys[keyId]
+	if !exists {
+		return EabKey{}, sql.ErrNoRows
+	}
+
+	return key, nil
+}
+
+func (a *MemoryAcmeServerRepository) ListEabKeys() ([]EabKey, error) {
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	result := []EabKey{}
+	for _, key := range a.eabKeys {
+		key.HmacKey = nil
+		result = append(result, key)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Id < result[j].Id
+	})
+
+	return result, nil
+}
+
+func (a *MemoryAcmeServerRepository) InsertEabKey(key EabKey) (sql.Result, error) {
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if _, exists := a.eabKeys[key.KeyId]; exists {
+		return nil, errors.New("EAB key " + key.KeyId + " already exists")
+	}
+	a.lastId++
+	key.Id = a.lastId
+	a.eabKeys[key.KeyId] = key
+
+	return driver.RowsAffected(1), nil
+}
+
+func (a *MemoryAcmeServerRepository) DeleteEabKey(keyId string) (sql.Result, error) {
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if _, exists := a.eabKeys[keyId]; !exists {
+		return driver.RowsAffected(0), nil
+	}
+	delete(a.eabKeys, keyId)
+
+	return driver.RowsAffected(1), nil
+}
+
+func (a *MemoryAcmeServerRepository) GetAccount(accountId string) (Account, error) {
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	account, exists := a.accounts[accountId]
+	if !exists {
+		return Account{}, sql.ErrNoRows
+	}
+
+	return account, nil
+}
+
+func (a *MemoryAcmeServerRepository) GetAccountByThumbprint(thumbprint string) (Account, error) {
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	for _, account := range a.accounts {
+		if account.Thumbprint == thumbprint {
+			return account, nil
+		}
+	}
+
+	return Account{}, sql.ErrNoRows
+}
+
+func (a *MemoryAcmeServerRepository) InsertAccount(account Account) (sql.Result, error) {
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, existing := range a.accounts {
+		if existing.AccountId == account.AccountId || existing.Thumbprint == account.Thumbprint {
+			return nil, errors.New("ACME account already exists")
+		}
+	}
+	a.lastId++
+	account.Id = a.lastId
+	a.accounts[account.AccountId] = account
+
+	return driver.RowsAffected(1), nil
+}
+
+func (a *MemoryAcmeServerRepository) UpdateAccount(account Account) (sql.Result, error) {
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	existing, exists := a.accounts[account.AccountId]
+	if !exists {
+		return driver.RowsAffected(0), nil
+	}
+	existing.Contact = account.Contact
+	existing.Status = account.Status
+	a.accounts[account.AccountId] = existing
+
+	return driver.RowsAffected(1), nil
+}
+
+func (a *MemoryAcmeServerRepository) GetOrder(orderId string) (Order, error) {
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	order, exists := a.orders[orderId]
+	if !exists {
+		return Order{}, sql.ErrNoRows
+	}
+
+	return order, nil
+}
+
+func (a *MemoryAcmeServerRepository) GetOrderBySerial(accountId string, serial string) (Order, error) {
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	var result *Order
+	for _, order := range a.orders {
+		if serial != "" && order.AccountId == accountId && order.Serial == serial && (result == nil || order.Id < result.Id) {
+			result = &order
+		}
+	}
+	if result == nil {
+		return Order{}, sql.ErrNoRows
+	}
+
+	return *result, nil
+}
+
+func (a *MemoryAcmeServerRepository) ListOrders(accountId string) ([]Order, error) {
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	result := []Order{}
+	for _, order := range a.orders {
+		if order.AccountId == accountId {
+			result = append(result, order)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Id < result[j].Id
+	})
+
+	return result, nil
+}
+
+func (a *MemoryAcmeServerRepository) ListOrdersByStatus(status string) ([]Order, error) {
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	result := []Order{}
+	for _, order := range a.orders {
+		if order.Status == status {
+			result = append(result, order)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Id < result[j].Id
+	})
+
+	return result, nil
+}
+
+func (a *MemoryAcmeServerRepository) InsertOrder(order Order) (sql.Result, error) {
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if _, exists := a.orders[order.OrderId]; exists {
+		return nil, errors.New("ACME order " + order.OrderId + " already exists")
+	}
+	a.lastId++
+	order.Id = a.lastId
+	a.orders[order.OrderId] = order
+
+	return driver.RowsAffected(1), nil
+}
+
+func (a *MemoryAcmeServerRepository) UpdateOrder(order Order, status string) (sql.Result, error) {
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	existing, exists := a.orders[order.OrderId]
+	if !exists || existing.Status != status {
+		return driver.RowsAffected(0), nil
+	}
+	existing.Status = order.Status
+	existing.Error = order.Error
+	existing.Certificate = order.Certificate
+	existing.Serial = order.Serial
+	existing.UpdatedTs = order.UpdatedTs
+	a.orders[order.OrderId] = existing
+
+	return driver.RowsAffected(1), nil
+}
+
+func (a *MemoryAcmeServerRepository) GetNonceKey() ([]byte, error) {
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	if a.nonceKey == nil {
+		return nil, sql.ErrNoRows
+	}
+
+	return a.nonceKey, nil
+}
+
+func (a *MemoryAcmeServerRepository) InsertNonceKey(key []byte, ts int64) (sql.Result, error) {
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.nonceKey != nil {
+		return driver.RowsAffected(0), nil
+	}
+	a.nonceKey = key
+
+	return driver.RowsAffected(1), nil
+}
+
+func (a *MemoryAcmeServerRepository) UseNonce(nonce string, expiresTs int64) (sql.Result, error) {
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if _, exists := a.nonces[nonce]; exists {
+		return driver.RowsAffected(0), nil
+	}
+	a.nonces[nonce] = expiresTs
+
+	return driver.RowsAffected(1), nil
+}
+
+func (a *MemoryAcmeServerRepository) DeleteExpiredNonces(ts int64) (sql.Result, error) {
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	count := 0
+	for nonce, expiresTs := range a.nonces {
+		if expiresTs < ts {
+			delete(a.nonces, nonce)
+			count++
+		}
+	}
+
+	return driver.RowsAffected(count), nil
+}
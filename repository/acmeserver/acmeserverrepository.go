@@ -0,0 +1,367 @@
+package acmeserver
+
+import (
+	"database/sql"
+	"log"
+	"strings"
+
+	"github.com/widhaprasa/go-acme-service/repository/sqldb"
+	"github.com/widhaprasa/go-acme-service/secret"
+)
+
+// AcmeServerRepository stores the EAB keys, accounts and orders of the ACME server
+type AcmeServerRepository interface {
+	GetEabKey(keyId string) (EabKey, error)
+	ListEabKeys() ([]EabKey, error)
+	InsertEabKey(key EabKey) (sql.Result, error)
+	DeleteEabKey(keyId string) (sql.Result, error)
+	GetAccount(accountId string) (Account, error)
+	GetAccountByThumbprint(thumbprint string) (Account, error)
+	InsertAccount(account Account) (sql.Result, error)
+	UpdateAccount(account Account) (sql.Result, error)
+	GetOrder(orderId string) (Order, error)
+	GetOrderBySerial(accountId string, serial string) (Order, error)
+	ListOrders(accountId string) ([]Order, error)
+	ListOrdersByStatus(status string) ([]Order, error)
+	InsertOrder(order Order) (sql.Result, error)
+	UpdateOrder(order Order, status string) (sql.Result, error)
+	GetNonceKey() ([]byte, error)
+	InsertNonceKey(key []byte, ts int64) (sql.Result, error)
+	UseNonce(nonce string, expiresTs int64) (sql.Result, error)
+	DeleteExpiredNonces(ts int64) (sql.Result, error)
+}
+
+type SqlAcmeServerRepository struct {
+	Db *sqldb.DB
+
+	// Keyring encrypts HMAC keys at rest, they are stored as plaintext if nil
+	Keyring *secret.Keyring
+}
+
+// EabKey lets ACME clients register accounts, ordering certificates of Domains issued under the
+// upstream account of Email
+type EabKey struct {
+	Id        int64
+	KeyId     string
+	HmacKey   []byte
+	Email     string
+	Domains   []string
+	CreatedTs int64
+}
+
+// Account is an ACME account registered with an EAB key, identified by the thumbprint of its key
+type Account struct {
+	Id         int64
+	AccountId  string
+	Thumbprint string
+	Jwk        []byte
+	KeyId      string
+	Contact    []string
+	Status     string
+	CreatedTs  int64
+}
+
+// Order is an ACME order, Error is the detail of the problem making it invalid
+type Order struct {
+	Id          int64
+	OrderId     string
+	AccountId   string
+	Identifiers []string
+	Status      string
+	Error       string
+	Certificate []byte
+	Serial      string
+	ExpiresTs   int64
+	CreatedTs   int64
+	UpdatedTs   int64
+}
+
+func (a *SqlAcmeServerRepository) GetEabKey(keyId string) (EabKey, error) {
+
+	row := a.Db.QueryRow("SELECT id, key_id, private_key, data_key, email, domains, created_ts FROM acme_eab WHERE key_id = ?", keyId)
+
+	var result EabKey
+	var dataKey []byte
+	var domains string
+	err := row.Scan(&result.Id, &result.KeyId, &result.HmacKey, &dataKey, &result.Email, &domains, &result.CreatedTs)
+	if err != nil {
+		return EabKey{}, err
+	}
+	result.Domains = splitList(domains)
+
+	result.HmacKey, err = a.Keyring.OpenValue(result.HmacKey, dataKey, secret.AAD("acme_eab", result.KeyId))
+	if err != nil {
+		log.Println("Unable to decrypt EAB key:", err)
+		return EabKey{}, err
+	}
+
+	return result, nil
+}
+
+// ListEabKeys returns the EAB keys without their HMAC key
+func (a *SqlAcmeServerRepository) ListEabKeys() ([]EabKey, error) {
+
+	rows, err := a.Db.Query("SELECT id, key_id, email, domains, created_ts FROM acme_eab ORDER BY id")
+	if err != nil {
+		log.Println("Unable to query EAB key:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []EabKey{}
+	for rows.Next() {
+		var item EabKey
+		var domains string
+
+		err = rows.Scan(&item.Id, &item.KeyId, &item.Email, &domains, &item.CreatedTs)
+		if err != nil {
+			log.Println("Unable to scan EAB key row:", err)
+			return nil, err
+		}
+		item.Domains = splitList(domains)
+
+		result = append(result, item)
+	}
+
+	return result, rows.Err()
+}
+
+func (a *SqlAcmeServerRepository) InsertEabKey(key EabKey) (sql.Result, error) {
+
+	hmacKey, dataKey, err := a.Keyring.SealValue(key.HmacKey, secret.AAD("acme_eab", key.KeyId))
+	if err != nil {
+		return nil, err
+	}
+
+	return a.Db.Exec(`
+		INSERT INTO acme_eab(key_id, private_key, data_key, email, domains, created_ts)
+		VALUES(?, ?, ?, ?, ?, ?)`,
+		key.KeyId, hmacKey, dataKey, key.Email, strings.Join(key.Domains, ","), key.CreatedTs)
+}
+
+func (a *SqlAcmeServerRepository) DeleteEabKey(keyId string) (sql.Result, error) {
+
+	return a.Db.Exec(`
+		DELETE FROM acme_eab WHERE key_id = ?`,
+		keyId)
+}
+
+const accountColumns = "id, account_id, thumbprint, jwk, key_id, contact, status, created_ts"
+
+func (a *SqlAcmeServerRepository) GetAccount(accountId string) (Account, error) {
+	return scanAccount(a.Db.QueryRow("SELECT "+accountColumns+" FROM acme_account WHERE account_id = ?", accountId))
+}
+
+func (a *SqlAcmeServerRepository) GetAccountByThumbprint(thumbprint string) (Account, error) {
+	return scanAccount(a.Db.QueryRow("SELECT "+accountColumns+" FROM acme_account WHERE thumbprint = ?", thumbprint))
+}
+
+func (a *SqlAcmeServerRepository) InsertAccount(account Account) (sql.Result, error) {
+
+	return a.Db.Exec(`
+		INSERT INTO acme_account(account_id, thumbprint, jwk, key_id, contact, status, created_ts)
+		VALUES(?, ?, ?, ?, ?, ?, ?)`,
+		account.AccountId, account.Thumbprint, account.Jwk, account.KeyId, strings.Join(account.Contact, ","), account.Status, account.CreatedTs)
+}
+
+// UpdateAccount updates the contact and status of an account
+func (a *SqlAcmeServerRepository) UpdateAccount(account Account) (sql.Result, error) {
+
+	return a.Db.Exec(`
+		UPDATE acme_account SET contact = ?, status = ? WHERE account_id = ?`,
+		strings.Join(account.Contact, ","), account.Status, account.AccountId)
+}
+
+const orderColumns = "id, order_id, account_id, identifiers, status, error, certificate, serial, expires_ts, created_ts, updated_ts"
+
+func (a *SqlAcmeServerRepository) GetOrder(orderId string) (Order, error) {
+	return scanOrder(a.Db.QueryRow("SELECT "+orderColumns+" FROM acme_order WHERE order_id = ?", orderId))
+}
+
+// GetOrderBySerial returns the first order of an account issued the certificate of serial. Orders of several
+// accounts can share a serial when they got the same stored cert
+func (a *SqlAcmeServerRepository) GetOrderBySerial(accountId string, serial string) (Order, error) {
+	return scanOrder(a.Db.QueryRow("SELECT "+orderColumns+" FROM acme_order WHERE account_id = ? AND serial = ? ORDER BY id LIMIT 1",
+		accountId, serial))
+}
+
+// ListOrders returns the orders of an account, oldest first
+func (a *SqlAcmeServerRepository) ListOrders(accountId string) ([]Order, error) {
+
+	rows, err := a.Db.Query("SELECT "+orderColumns+" FROM acme_order WHERE account_id = ? ORDER BY id", accountId)
+	if err != nil {
+		log.Println("Unable to query ACME order:", err)
+		return nil, err
+	}
+	return scanOrders(rows)
+}
+
+// ListOrdersByStatus returns the orders having status, oldest first
+func (a *SqlAcmeServerRepository) ListOrdersByStatus(status string) ([]Order, error) {
+
+	rows, err := a.Db.Query("SELECT "+orderColumns+" FROM acme_order WHERE status = ? ORDER BY id", status)
+	if err != nil {
+		log.Println("Unable to query ACME order:", err)
+		return nil, err
+	}
+	return scanOrders(rows)
+}
+
+func scanOrders(rows *sql.Rows) ([]Order, error) {
+
+	defer rows.Close()
+
+	result := []Order{}
+	for rows.Next() {
+		item, err := scanOrder(rows)
+		if err != nil {
+			log.Println("Unable to scan ACME order row:", err)
+			return nil, err
+		}
+		result = append(result, item)
+	}
+
+	return result, rows.Err()
+}
+
+func (a *SqlAcmeServerRepository) InsertOrder(order Order) (sql.Result, error) {
+
+	return a.Db.Exec(`
+		INSERT INTO acme_order(order_id, account_id, identifiers, status, error, certificate, serial, expires_ts, created_ts, updated_ts)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		order.OrderId, order.AccountId, strings.Join(order.Identifiers, ","), order.Status, order.Error, order.Certificate, order.Serial,
+		order.ExpiresTs, order.CreatedTs, order.UpdatedTs)
+}
+
+// UpdateOrder updates the status, error and certificate of an order still having status, affecting no row
+// if another request changed it meanwhile
+func (a *SqlAcmeServerRepository) UpdateOrder(order Order, status string) (sql.Result, error) {
+
+	return a.Db.Exec(`
+		UPDATE acme_order SET status = ?, error = ?, certificate = ?, serial = ?, updated_ts = ? WHERE order_id = ? AND status = ?`,
+		order.Status, order.Error, order.Certificate, order.Serial, order.UpdatedTs, order.OrderId, status)
+}
+
+// Name of the key signing nonces
+const nonceKeyName = "nonce"
+
+// GetNonceKey returns the key signing nonces, sql.ErrNoRows until one is inserted
+func (a *SqlAcmeServerRepository) GetNonceKey() ([]byte, error) {
+
+	row := a.Db.QueryRow("SELECT private_key, data_key FROM acme_nonce_key WHERE name = ?", nonceKeyName)
+
+	var key, dataKey []byte
+	err := row.Scan(&key, &dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err = a.Keyring.OpenValue(key, dataKey, secret.AAD("acme_nonce_key", nonceKeyName))
+	if err != nil {
+		log.Println("Unable to decrypt nonce key:", err)
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// InsertNonceKey inserts the key signing nonces unless another replica did first
+func (a *SqlAcmeServerRepository) InsertNonceKey(key []byte, ts int64) (sql.Result, error) {
+
+	key, dataKey, err := a.Keyring.SealValue(key, secret.AAD("acme_nonce_key", nonceKeyName))
+	if err != nil {
+		return nil, err
+	}
+
+	return a.Db.Exec(`
+		INSERT INTO acme_nonce_key(name, private_key, data_key, created_ts)
+		VALUES(?, ?, ?, ?)
+		ON CONFLICT(name) DO NOTHING`,
+		nonceKeyName, key, dataKey, ts)
+}
+
+// UseNonce records nonce as used until expiresTs, affecting no row if it was used already
+func (a *SqlAcmeServerRepository) UseNonce(nonce string, expiresTs int64) (sql.Result, error) {
+
+	return a.Db.Exec(`
+		INSERT INTO acme_nonce(nonce, expires_ts)
+		VALUES(?, ?)
+		ON CONFLICT(nonce) DO NOTHING`,
+		nonce, expiresTs)
+}
+
+func (a *SqlAcmeServerRepository) DeleteExpiredNonces(ts int64) (sql.Result, error) {
+
+	return a.Db.Exec(`
+		DELETE FROM acme_nonce WHERE expires_ts < ?`,
+		ts)
+}
+
+// EncryptPrivateKeys seals the plaintext HMAC keys and nonce key, returning how many were sealed
+func (a *SqlAcmeServerRepository) EncryptPrivateKeys() (int, error) {
+
+	count, err := sqldb.EncryptRows(a.Db, a.Keyring, "acme_eab", "key_id")
+	if err != nil {
+		return count, err
+	}
+	nonceCount, err := sqldb.EncryptRows(a.Db, a.Keyring, "acme_nonce_key", "name")
+	return count + nonceCount, err
+}
+
+// RewrapDataKeys wraps the data keys of HMAC keys and nonce key with the current master key
+func (a *SqlAcmeServerRepository) RewrapDataKeys() (int, error) {
+
+	count, err := sqldb.RewrapRows(a.Db, a.Keyring, "acme_eab")
+	if err != nil {
+		return count, err
+	}
+	nonceCount, err := sqldb.RewrapRows(a.Db, a.Keyring, "acme_nonce_key")
+	return count + nonceCount, err
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanAccount(row scanner) (Account, error) {
+
+	var result Account
+	var contact string
+
+	err := row.Scan(&result.Id, &result.AccountId, &result.Thumbprint, &result.Jwk, &result.KeyId, &contact, &result.Status, &result.CreatedTs)
+	if err != nil {
+		return Account{}, err
+	}
+	result.Contact = splitList(contact)
+
+	return result, nil
+}
+
+func scanOrder(row scanner) (Order, error) {
+
+	var result Order
+	var identifiers string
+	var error_, serial sql.NullString
+
+	err := row.Scan(&result.Id, &result.OrderId, &result.AccountId, &identifiers, &result.Status, &error_, &result.Certificate, &serial,
+		&result.ExpiresTs, &result.CreatedTs, &result.UpdatedTs)
+	if err != nil {
+		return Order{}, err
+	}
+	result.Identifiers = splitList(identifiers)
+	result.Error = error_.String
+	result.Serial = serial.String
+
+	return result, nil
+}
+
+func splitList(list string) []string {
+
+	result := []string{}
+	for _, item := range strings.Split(list, ",") {
+		if item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
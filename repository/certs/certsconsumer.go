@@ -0,0 +1,83 @@
+package certs
+
+import (
+	"database/sql"
+	"log"
+
+	"github.com/widhaprasa/go-acme-service/repository/sqldb"
+)
+
+// Consumer is the version of a cert last fetched or reported by a consumer, and when it was seen
+type Consumer struct {
+	Id       int64
+	Main     string
+	Consumer string
+	Serial   string
+	SeenTs   int64
+
+	// Set by listings: the current serial of the active cert and the expiry of the version held, zero if unknown
+	CurrentSerial string
+	NotAfterTs    int64
+}
+
+func (c *SqlCertsRepository) UpsertConsumer(consumer Consumer) (sql.Result, error) {
+
+	return c.Db.Exec(`
+		INSERT INTO cert_consumer(main, consumer, serial, seen_ts)
+		VALUES(?, ?, ?, ?)
+		ON CONFLICT(main, consumer)
+		DO UPDATE SET serial = excluded.serial, seen_ts = excluded.seen_ts`,
+		consumer.Main, consumer.Consumer, consumer.Serial, consumer.SeenTs)
+}
+
+// ListConsumers returns the consumers of main by name
+func (c *SqlCertsRepository) ListConsumers(main string) ([]Consumer, error) {
+	return c.listConsumers("WHERE cert_consumer.main = ?", main)
+}
+
+// ListAllConsumers returns the consumers of every cert by main then name
+func (c *SqlCertsRepository) ListAllConsumers() ([]Consumer, error) {
+	return c.listConsumers("")
+}
+
+func (c *SqlCertsRepository) listConsumers(where string, args ...any) ([]Consumer, error) {
+
+	rows, err := c.Db.Query(`SELECT cert_consumer.id, cert_consumer.main, cert_consumer.consumer, cert_consumer.serial, cert_consumer.seen_ts,
+			certs.serial, cert_version.not_after_ts
+		FROM cert_consumer
+		LEFT JOIN certs ON certs.main = cert_consumer.main AND certs.deleted_ts IS NULL
+		LEFT JOIN cert_version ON cert_version.main = cert_consumer.main AND cert_version.serial = cert_consumer.serial
+		`+where+` ORDER BY cert_consumer.main, cert_consumer.consumer`, args...)
+	if err != nil {
+		log.Println("Unable to query cert consumer:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []Consumer{}
+	for rows.Next() {
+		var item Consumer
+		var currentSerial sql.NullString
+		var notAfterTs sql.NullInt64
+
+		err = rows.Scan(&item.Id, &item.Main, &item.Consumer, &item.Serial, &item.SeenTs, &currentSerial, &notAfterTs)
+		if err != nil {
+			log.Println("Unable to scan cert consumer row:", err)
+			return nil, err
+		}
+		item.CurrentSerial = currentSerial.String
+		item.NotAfterTs = notAfterTs.Int64
+
+		result = append(result, item)
+	}
+
+	return result, rows.Err()
+}
+
+func deleteConsumers(tx *sqldb.Tx, main string) error {
+
+	_, err := tx.Exec(`
+		DELETE FROM cert_consumer WHERE main = ?`,
+		main)
+	return err
+}
@@ -0,0 +1,302 @@
+package certs
+
+import (
+	"errors"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/widhaprasa/go-acme-service/repository/sqldb"
+)
+
+const (
+	maxLabelKey   = 63
+	maxLabelValue = 256
+	maxLabels     = 64
+)
+
+var labelKeyRegexp = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9._/-]*[A-Za-z0-9])?$`)
+
+// Operators of a label selector requirement
+const (
+	OpEquals    = "="
+	OpNotEquals = "!="
+	OpExists    = "exists"
+	OpNotExists = "!exists"
+)
+
+// Requirement is a single condition of a label selector
+type Requirement struct {
+	Key   string
+	Op    string
+	Value string
+}
+
+// Selector matches the labels meeting all of its requirements
+type Selector []Requirement
+
+// ValidateLabel returns an error if key or value cannot be used as a label
+func ValidateLabel(key string, value string) error {
+
+	if len(key) > maxLabelKey || !labelKeyRegexp.MatchString(key) {
+		return errors.New("Invalid label key " + key + ", use up to 63 letters, digits, '.', '_', '/' or '-'")
+	}
+	if len(value) > maxLabelValue || strings.Contains(value, ",") {
+		return errors.New("Invalid value of label " + key + ", use up to 256 characters without ','")
+	}
+	return nil
+}
+
+// ParseSelector parses comma separated requirements: key=value, key!=value, key to require the label and !key to exclude it
+func ParseSelector(selector string) (Selector, error) {
+
+	result := Selector{}
+	for _, part := range strings.Split(selector, ",") {
+
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		var requirement Requirement
+		switch {
+		case strings.Contains(part, "!="):
+			kv := strings.SplitN(part, "!=", 2)
+			requirement = Requirement{Key: strings.TrimSpace(kv[0]), Op: OpNotEquals, Value: strings.TrimSpace(kv[1])}
+		case strings.Contains(part, "="):
+			kv := strings.SplitN(strings.Replace(part, "==", "=", 1), "=", 2)
+			requirement = Requirement{Key: strings.TrimSpace(kv[0]), Op: OpEquals, Value: strings.TrimSpace(kv[1])}
+		case strings.HasPrefix(part, "!"):
+			requirement = Requirement{Key: strings.TrimSpace(part[1:]), Op: OpNotExists}
+		default:
+			requirement = Requirement{Key: part, Op: OpExists}
+		}
+
+		err := ValidateLabel(requirement.Key, requirement.Value)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, requirement)
+	}
+
+	return result, nil
+}
+
+// Matches reports whether labels meet every requirement of the selector
+func (s Selector) Matches(labels map[string]string) bool {
+
+	for _, r := range s {
+		value, exists := labels[r.Key]
+		switch r.Op {
+		case OpEquals:
+			if !exists || value != r.Value {
+				return false
+			}
+		case OpNotEquals:
+			if exists && value == r.Value {
+				return false
+			}
+		case OpExists:
+			if !exists {
+				return false
+			}
+		case OpNotExists:
+			if exists {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Positive reports whether the selector has a key=value or key requirement, negative ones alone match most certs
+func (s Selector) Positive() bool {
+
+	for _, r := range s {
+		if r.Op == OpEquals || r.Op == OpExists {
+			return true
+		}
+	}
+	return false
+}
+
+func (s Selector) String() string {
+
+	parts := []string{}
+	for _, r := range s {
+		switch r.Op {
+		case OpEquals, OpNotEquals:
+			parts = append(parts, r.Key+r.Op+r.Value)
+		case OpExists:
+			parts = append(parts, r.Key)
+		case OpNotExists:
+			parts = append(parts, "!"+r.Key)
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// conditions returns the SQL conditions on certs and their arguments matching the selector
+func (s Selector) conditions() ([]string, []any) {
+
+	conditions := []string{}
+	args := []any{}
+	for _, r := range s {
+		switch r.Op {
+		case OpEquals:
+			conditions = append(conditions, "EXISTS (SELECT 1 FROM cert_label WHERE cert_label.main = certs.main AND cert_label.key = ? AND cert_label.value = ?)")
+			args = append(args, r.Key, r.Value)
+		case OpNotEquals:
+			conditions = append(conditions, "NOT EXISTS (SELECT 1 FROM cert_label WHERE cert_label.main = certs.main AND cert_label.key = ? AND cert_label.value = ?)")
+			args = append(args, r.Key, r.Value)
+		case OpExists:
+			conditions = append(conditions, "EXISTS (SELECT 1 FROM cert_label WHERE cert_label.main = certs.main AND cert_label.key = ?)")
+			args = append(args, r.Key)
+		case OpNotExists:
+			conditions = append(conditions, "NOT EXISTS (SELECT 1 FROM cert_label WHERE cert_label.main = certs.main AND cert_label.key = ?)")
+			args = append(args, r.Key)
+		}
+	}
+	return conditions, args
+}
+
+// GetLabels returns the labels of main
+func (c *SqlCertsRepository) GetLabels(main string) (map[string]string, error) {
+
+	labelMap, err := c.mapLabels([]string{main})
+	if err != nil {
+		return nil, err
+	}
+	return labelMap[main], nil
+}
+
+// UpdateLabels sets and removes labels of main, then checks the label count limit
+func (c *SqlCertsRepository) UpdateLabels(main string, set map[string]string, remove []string) error {
+
+	for key, value := range set {
+		err := ValidateLabel(key, value)
+		if err != nil {
+			return err
+		}
+	}
+
+	tx, err := c.Db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, key := range remove {
+		_, err = tx.Exec(`
+			DELETE FROM cert_label WHERE main = ? AND key = ?`,
+			main, key)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, key := range sortedKeys(set) {
+		_, err = tx.Exec(`
+			INSERT INTO cert_label(main, key, value)
+			VALUES(?, ?, ?)
+			ON CONFLICT(main, key)
+			DO UPDATE SET value = excluded.value`,
+			main, key, set[key])
+		if err != nil {
+			return err
+		}
+	}
+
+	var count int
+	err = tx.QueryRow("SELECT COUNT(*) FROM cert_label WHERE main = ?", main).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count > maxLabels {
+		return errors.New("A cert can have at most 64 labels")
+	}
+
+	return tx.Commit()
+}
+
+// UpdateNotes replaces the notes of main
+func (c *SqlCertsRepository) UpdateNotes(main string, notes string) error {
+
+	_, err := c.Db.Exec(`
+		UPDATE certs SET notes = ? WHERE main = ?`,
+		notes, main)
+	return err
+}
+
+// mapLabels returns the labels of each of mains, every main having at least an empty map
+func (c *SqlCertsRepository) mapLabels(mains []string) (map[string]map[string]string, error) {
+
+	result := map[string]map[string]string{}
+	if len(mains) == 0 {
+		return result, nil
+	}
+
+	anys := make([]any, len(mains))
+	preparedStatements := make([]string, len(mains))
+	for i, main := range mains {
+		anys[i] = main
+		preparedStatements[i] = "?"
+		result[main] = map[string]string{}
+	}
+
+	rows, err := c.Db.Query("SELECT main, key, value FROM cert_label WHERE main IN ("+strings.Join(preparedStatements, ", ")+")", anys...)
+	if err != nil {
+		log.Println("Unable to query cert label:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var main, key, value string
+		err = rows.Scan(&main, &key, &value)
+		if err != nil {
+			log.Println("Unable to scan cert label row:", err)
+			return nil, err
+		}
+		result[main][key] = value
+	}
+
+	return result, rows.Err()
+}
+
+// attachLabels sets the labels of certs
+func (c *SqlCertsRepository) attachLabels(certs []Cert) error {
+
+	mains := make([]string, len(certs))
+	for i, cert := range certs {
+		mains[i] = cert.Main
+	}
+
+	labelMap, err := c.mapLabels(mains)
+	if err != nil {
+		return err
+	}
+	for i := range certs {
+		certs[i].Labels = labelMap[certs[i].Main]
+	}
+	return nil
+}
+
+func deleteLabels(tx *sqldb.Tx, main string) error {
+
+	_, err := tx.Exec(`
+		DELETE FROM cert_label WHERE main = ?`,
+		main)
+	return err
+}
+
+func sortedKeys(labels map[string]string) []string {
+
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,314 @@
+package certs
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// Sort keys of a certs listing
+const (
+	SortMain     = "main"
+	SortNotAfter = "not_after"
+	SortUpserted = "upserted"
+)
+
+// Statuses of a cert, a listing without status excludes trashed certs
+const (
+	StatusValid   = "valid"
+	StatusExpired = "expired"
+	StatusTrashed = "trashed"
+)
+
+const (
+	DefaultListLimit = 100
+	MaxListLimit     = 1000
+)
+
+// CertsQuery filters, sorts and paginates a listing of certs
+type CertsQuery struct {
+	Ts     int64
+	Cursor string
+	Limit  int
+	Sort   string
+	Desc   bool
+
+	// Filters, zero values match every cert
+	ExpiringBeforeTs int64
+	San              string
+	Email            string
+	Issuer           string
+	Status           string
+	Labels           Selector
+}
+
+// CertsPage is a page of listed certs, without private keys and certificates.
+// NextCursor is empty on the last page
+type CertsPage struct {
+	Certs      []Cert
+	NextCursor string
+}
+
+// ErrInvalidCursor is returned for a cursor not issued by a listing with the same sort
+var ErrInvalidCursor = errors.New("Invalid cursor")
+
+// Listing never reads the private key and certificate blobs
+const certsListColumns = "id, main, sans, email, not_before_ts, not_after_ts, upserted_ts, deleted_ts, serial, issuer, notes"
+
+type listCursor struct {
+	Sort  string `json:"s"`
+	Desc  bool   `json:"d"`
+	Value string `json:"v"`
+	Id    int64  `json:"i"`
+}
+
+func (q CertsQuery) sortKey() string {
+	if q.Sort == "" {
+		return SortMain
+	}
+	return q.Sort
+}
+
+func (q CertsQuery) limit() int {
+	if q.Limit <= 0 {
+		return DefaultListLimit
+	}
+	if q.Limit > MaxListLimit {
+		return MaxListLimit
+	}
+	return q.Limit
+}
+
+// sortValue returns the value of the sort key of cert
+func (q CertsQuery) sortValue(cert Cert) string {
+
+	switch q.sortKey() {
+	case SortNotAfter:
+		return strconv.FormatInt(cert.NotAfterTs, 10)
+	case SortUpserted:
+		return strconv.FormatInt(cert.UpsertedTs, 10)
+	}
+	return cert.Main
+}
+
+func (q CertsQuery) encodeCursor(cert Cert) string {
+
+	data, _ := json.Marshal(listCursor{
+		Sort:  q.sortKey(),
+		Desc:  q.Desc,
+		Value: q.sortValue(cert),
+		Id:    cert.Id,
+	})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func (q CertsQuery) decodeCursor() (*listCursor, error) {
+
+	if q.Cursor == "" {
+		return nil, nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(q.Cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var cursor listCursor
+	err = json.Unmarshal(data, &cursor)
+	if err != nil || cursor.Sort != q.sortKey() || cursor.Desc != q.Desc {
+		return nil, ErrInvalidCursor
+	}
+	if cursor.Sort != SortMain {
+		if _, err := strconv.ParseInt(cursor.Value, 10, 64); err != nil {
+			return nil, ErrInvalidCursor
+		}
+	}
+
+	return &cursor, nil
+}
+
+// matches reports whether cert passes the status and filters of the query
+func (q CertsQuery) matches(cert Cert) bool {
+
+	switch q.Status {
+	case "":
+		if cert.DeletedTs != 0 {
+			return false
+		}
+	case StatusValid:
+		if cert.DeletedTs != 0 || cert.NotAfterTs < q.Ts {
+			return false
+		}
+	case StatusExpired:
+		if cert.DeletedTs != 0 || cert.NotAfterTs >= q.Ts {
+			return false
+		}
+	case StatusTrashed:
+		if cert.DeletedTs == 0 {
+			return false
+		}
+	}
+
+	if q.ExpiringBeforeTs > 0 && cert.NotAfterTs >= q.ExpiringBeforeTs {
+		return false
+	}
+	if q.San != "" && !strings.Contains(strings.ToLower(strings.Join(cert.Sans, ",")), strings.ToLower(q.San)) {
+		return false
+	}
+	if q.Email != "" && cert.Email != q.Email {
+		return false
+	}
+	if q.Issuer != "" && cert.Issuer != q.Issuer {
+		return false
+	}
+	if !q.Labels.Matches(cert.Labels) {
+		return false
+	}
+
+	return true
+}
+
+// ListCertsPage returns a page of the certs matching query, ordered by the sort key then id
+func (c *SqlCertsRepository) ListCertsPage(query CertsQuery) (CertsPage, error) {
+
+	cursor, err := query.decodeCursor()
+	if err != nil {
+		return CertsPage{}, err
+	}
+
+	var sortColumn string
+	switch query.sortKey() {
+	case SortMain:
+		sortColumn = "main"
+	case SortNotAfter:
+		sortColumn = "not_after_ts"
+	case SortUpserted:
+		sortColumn = "upserted_ts"
+	default:
+		return CertsPage{}, errors.New("Unknown sort: " + query.Sort)
+	}
+
+	conditions := []string{}
+	args := []any{}
+
+	switch query.Status {
+	case "":
+		conditions = append(conditions, "deleted_ts IS NULL")
+	case StatusValid:
+		conditions = append(conditions, "deleted_ts IS NULL", "not_after_ts >= ?")
+		args = append(args, query.Ts)
+	case StatusExpired:
+		conditions = append(conditions, "deleted_ts IS NULL", "not_after_ts < ?")
+		args = append(args, query.Ts)
+	case StatusTrashed:
+		conditions = append(conditions, "deleted_ts IS NOT NULL")
+	default:
+		return CertsPage{}, errors.New("Unknown status: " + query.Status)
+	}
+
+	if query.ExpiringBeforeTs > 0 {
+		conditions = append(conditions, "not_after_ts < ?")
+		args = append(args, query.ExpiringBeforeTs)
+	}
+	if query.San != "" {
+		conditions = append(conditions, `lower(sans) LIKE ? ESCAPE '\'`)
+		args = append(args, "%"+escapeLike(strings.ToLower(query.San))+"%")
+	}
+	if query.Email != "" {
+		conditions = append(conditions, "email = ?")
+		args = append(args, query.Email)
+	}
+	if query.Issuer != "" {
+		conditions = append(conditions, "issuer = ?")
+		args = append(args, query.Issuer)
+	}
+	labelConditions, labelArgs := query.Labels.conditions()
+	conditions = append(conditions, labelConditions...)
+	args = append(args, labelArgs...)
+
+	direction, comparison := "ASC", ">"
+	if query.Desc {
+		direction, comparison = "DESC", "<"
+	}
+
+	if cursor != nil {
+		var value any = cursor.Value
+		if cursor.Sort != SortMain {
+			value, _ = strconv.ParseInt(cursor.Value, 10, 64)
+		}
+		conditions = append(conditions, "("+sortColumn+" "+comparison+" ? OR ("+sortColumn+" = ? AND id "+comparison+" ?))")
+		args = append(args, value, value, cursor.Id)
+	}
+
+	// One more row than the limit tells whether there is a next page
+	limit := query.limit()
+	args = append(args, limit+1)
+
+	rows, err := c.Db.Query("SELECT "+certsListColumns+" FROM certs WHERE "+strings.Join(conditions, " AND ")+
+		" ORDER BY "+sortColumn+" "+direction+", id "+direction+" LIMIT ?", args...)
+	if err != nil {
+		log.Println("Unable to query certs:", err)
+		return CertsPage{}, err
+	}
+	defer rows.Close()
+
+	result := CertsPage{
+		Certs: []Cert{},
+	}
+	for rows.Next() {
+		item, err := scanListedCert(rows)
+		if err != nil {
+			log.Println("Unable to scan certs row:", err)
+			return CertsPage{}, err
+		}
+		result.Certs = append(result.Certs, item)
+	}
+	err = rows.Err()
+	if err != nil {
+		return CertsPage{}, err
+	}
+
+	if len(result.Certs) > limit {
+		result.Certs = result.Certs[:limit]
+		result.NextCursor = query.encodeCursor(result.Certs[limit-1])
+	}
+
+	err = c.attachLabels(result.Certs)
+	if err != nil {
+		return CertsPage{}, err
+	}
+
+	return result, nil
+}
+
+func scanListedCert(row scanner) (Cert, error) {
+
+	var result Cert
+	var sans string
+	var deletedTs sql.NullInt64
+	var serial, issuer, notes sql.NullString
+
+	err := row.Scan(&result.Id, &result.Main, &sans, &result.Email, &result.NotBeforeTs, &result.NotAfterTs, &result.UpsertedTs,
+		&deletedTs, &serial, &issuer, &notes)
+	if err != nil {
+		return Cert{}, err
+	}
+	result.Sans = splitSans(sans)
+	result.DeletedTs = deletedTs.Int64
+	result.Serial = serial.String
+	result.Issuer = issuer.String
+	result.Notes = notes.String
+
+	return result, nil
+}
+
+func escapeLike(value string) string {
+
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, "%", `\%`)
+	return strings.ReplaceAll(value, "_", `\_`)
+}
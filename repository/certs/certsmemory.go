@@ -0,0 +1,540 @@
+package certs
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	webhookrepository "github.com/widhaprasa/go-acme-service/repository/webhook"
+)
+
+// MemoryCertsRepository keeps certs in memory, used for tests and the embedded mode
+type MemoryCertsRepository struct {
+	mu        sync.RWMutex
+	certs     map[string]Cert
+	versions  map[string][]CertVersion
+	labels    map[string]map[string]string
+	consumers map[string]map[string]Consumer
+	lastId    int64
+
+	// Webhooks of purged certs are deleted from it, if not nil
+	Webhooks *webhookrepository.MemoryWebhookRepository
+}
+
+func NewMemoryCertsRepository() *MemoryCertsRepository {
+	return &MemoryCertsRepository{
+		certs:     map[string]Cert{},
+		versions:  map[string][]CertVersion{},
+		labels:    map[string]map[string]string{},
+		consumers: map[string]map[string]Consumer{},
+	}
+}
+
+func (c *MemoryCertsRepository) GetCerts(domain string, wildcard bool) (Cert, error) {
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	domain = normalizeDomain(domain)
+
+	mains := c.findMains(domain, false)
+	if len(mains) == 0 && wildcard && !strings.HasPrefix(domain, "*.") {
+		if i := strings.Index(domain, "."); i > 0 {
+			mains = c.findMains("*"+domain[i:], false)
+		}
+	}
+
+	switch len(mains) {
+	case 0:
+		return Cert{}, sql.ErrNoRows
+	case 1:
+		return c.withLabels(c.certs[mains[0]]), nil
+	default:
+		return Cert{}, &ConflictError{Domain: domain, Candidates: mains}
+	}
+}
+
+func (c *MemoryCertsRepository) findMains(identifier string, trashed bool) []string {
+
+	mains := []string{}
+	for main, cert := range c.certs {
+		if (cert.DeletedTs != 0) != trashed {
+			continue
+		}
+		for _, san := range cert.Sans {
+			if strings.ToLower(strings.TrimSpace(san)) == identifier {
+				mains = append(mains, main)
+				break
+			}
+		}
+	}
+	sort.Strings(mains)
+
+	return mains
+}
+
+func (c *MemoryCertsRepository) GetCertsByMain(main string) (Cert, error) {
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	cert, exists := c.certs[main]
+	if !exists || cert.DeletedTs != 0 {
+		return Cert{}, sql.ErrNoRows
+	}
+
+	return c.withLabels(cert), nil
+}
+
+func (c *MemoryCertsRepository) ListOverlappingCerts(domains []string) ([]Cert, error) {
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	mainMap := map[string]struct{}{}
+	for _, domain := range domains {
+		for _, main := range c.findMains(strings.ToLower(domain), false) {
+			mainMap[main] = struct{}{}
+		}
+	}
+
+	mains := make([]string, 0, len(mainMap))
+	for main := range mainMap {
+		mains = append(mains, main)
+	}
+	sort.Strings(mains)
+
+	result := []Cert{}
+	for _, main := range mains {
+		result = append(result, c.withLabels(c.certs[main]))
+	}
+
+	return result, nil
+}
+
+func (c *MemoryCertsRepository) ListCerts() ([]Cert, error) {
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	result := []Cert{}
+	for _, cert := range c.certs {
+		if cert.DeletedTs != 0 {
+			continue
+		}
+		result = append(result, copyCert(cert))
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Id < result[j].Id
+	})
+
+	return result, nil
+}
+
+func (c *MemoryCertsRepository) ListCertsPage(query CertsQuery) (CertsPage, error) {
+
+	cursor, err := query.decodeCursor()
+	if err != nil {
+		return CertsPage{}, err
+	}
+	switch query.sortKey() {
+	case SortMain, SortNotAfter, SortUpserted:
+	default:
+		return CertsPage{}, errors.New("Unknown sort: " + query.Sort)
+	}
+	switch query.Status {
+	case "", StatusValid, StatusExpired, StatusTrashed:
+	default:
+		return CertsPage{}, errors.New("Unknown status: " + query.Status)
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	list := []Cert{}
+	for _, cert := range c.certs {
+		cert = c.withLabels(cert)
+		if !query.matches(cert) {
+			continue
+		}
+		cert.PrivateKey = nil
+		cert.Certificate = nil
+		cert.Metadata = nil
+		list = append(list, cert)
+	}
+
+	// Order by the sort key then id, reversed when descending
+	less := func(a Cert, b Cert) bool {
+		if query.sortKey() == SortMain {
+			if a.Main != b.Main {
+				return a.Main < b.Main
+			}
+		} else {
+			av, _ := strconv.ParseInt(query.sortValue(a), 10, 64)
+			bv, _ := strconv.ParseInt(query.sortValue(b), 10, 64)
+			if av != bv {
+				return av < bv
+			}
+		}
+		return a.Id < b.Id
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if query.Desc {
+			return less(list[j], list[i])
+		}
+		return less(list[i], list[j])
+	})
+
+	result := CertsPage{
+		Certs: []Cert{},
+	}
+	limit := query.limit()
+	for _, cert := range list {
+
+		if cursor != nil {
+			last := Cert{Id: cursor.Id, Main: cursor.Value}
+			ts, _ := strconv.ParseInt(cursor.Value, 10, 64)
+			last.NotAfterTs, last.UpsertedTs = ts, ts
+
+			if query.Desc && !less(cert, last) || !query.Desc && !less(last, cert) {
+				continue
+			}
+		}
+
+		if len(result.Certs) == limit {
+			result.NextCursor = query.encodeCursor(result.Certs[limit-1])
+			break
+		}
+		result.Certs = append(result.Certs, cert)
+	}
+
+	return result, nil
+}
+
+func (c *MemoryCertsRepository) UpsertCerts(cert Cert, version VersionInfo) (sql.Result, error) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cert = copyCert(cert)
+	cert.DeletedTs = 0
+	cert.Serial = version.Serial
+	cert.Issuer = version.Issuer
+	cert.Labels = nil
+	if existing, exists := c.certs[cert.Main]; exists {
+		cert.Id = existing.Id
+		cert.Notes = existing.Notes
+	} else {
+		c.lastId++
+		cert.Id = c.lastId
+	}
+	c.certs[cert.Main] = cert
+
+	// A version is immutable, promoting it again keeps the original one
+	for _, v := range c.versions[cert.Main] {
+		if v.Serial == version.Serial {
+			return driver.RowsAffected(1), nil
+		}
+	}
+	c.lastId++
+	c.versions[cert.Main] = append(c.versions[cert.Main], CertVersion{
+		Id:          c.lastId,
+		Main:        cert.Main,
+		Sans:        cert.Sans,
+		Email:       cert.Email,
+		PrivateKey:  cert.PrivateKey,
+		Certificate: cert.Certificate,
+		NotBeforeTs: cert.NotBeforeTs,
+		NotAfterTs:  cert.NotAfterTs,
+		CreatedTs:   cert.UpsertedTs,
+		VersionInfo: version,
+	})
+
+	return driver.RowsAffected(1), nil
+}
+
+func (c *MemoryCertsRepository) PurgeCerts(main string, deletedTs int64) (sql.Result, error) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cert, exists := c.certs[main]
+	if !exists || cert.DeletedTs == 0 || cert.DeletedTs > deletedTs {
+		return driver.RowsAffected(0), nil
+	}
+	delete(c.certs, main)
+	delete(c.versions, main)
+	delete(c.labels, main)
+	delete(c.consumers, main)
+	if c.Webhooks != nil {
+		c.Webhooks.DeleteWebhook(main)
+	}
+
+	return driver.RowsAffected(1), nil
+}
+
+func (c *MemoryCertsRepository) TrashCerts(main string, ts int64) (sql.Result, error) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cert, exists := c.certs[main]
+	if !exists || cert.DeletedTs != 0 {
+		return driver.RowsAffected(0), nil
+	}
+	cert.DeletedTs = ts
+	c.certs[main] = cert
+
+	return driver.RowsAffected(1), nil
+}
+
+func (c *MemoryCertsRepository) RestoreCerts(main string) (sql.Result, error) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cert, exists := c.certs[main]
+	if !exists || cert.DeletedTs == 0 {
+		return driver.RowsAffected(0), nil
+	}
+	cert.DeletedTs = 0
+	c.certs[main] = cert
+
+	return driver.RowsAffected(1), nil
+}
+
+func (c *MemoryCertsRepository) GetTrashedCerts(domain string) (Cert, error) {
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	domain = normalizeDomain(domain)
+
+	mains := c.findMains(domain, true)
+	switch len(mains) {
+	case 0:
+		return Cert{}, sql.ErrNoRows
+	case 1:
+		return c.withLabels(c.certs[mains[0]]), nil
+	default:
+		return Cert{}, &ConflictError{Domain: domain, Candidates: mains}
+	}
+}
+
+func (c *MemoryCertsRepository) ListTrashedCerts() ([]Cert, error) {
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	result := []Cert{}
+	for _, cert := range c.certs {
+		if cert.DeletedTs == 0 {
+			continue
+		}
+		result = append(result, copyCert(cert))
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].DeletedTs != result[j].DeletedTs {
+			return result[i].DeletedTs < result[j].DeletedTs
+		}
+		return result[i].Id < result[j].Id
+	})
+
+	return result, nil
+}
+
+func (c *MemoryCertsRepository) ListVersions(main string) ([]CertVersion, error) {
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	result := []CertVersion{}
+	for _, v := range c.versions[main] {
+		v.Sans = append([]string{}, v.Sans...)
+		v.PrivateKey = nil
+		v.Certificate = nil
+		result = append(result, v)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].CreatedTs != result[j].CreatedTs {
+			return result[i].CreatedTs > result[j].CreatedTs
+		}
+		return result[i].Id > result[j].Id
+	})
+
+	return result, nil
+}
+
+func (c *MemoryCertsRepository) GetVersion(main string, serial string) (CertVersion, error) {
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	for _, v := range c.versions[main] {
+		if v.Serial == serial {
+			v.Sans = append([]string{}, v.Sans...)
+			return v, nil
+		}
+	}
+
+	return CertVersion{}, sql.ErrNoRows
+}
+
+func (c *MemoryCertsRepository) GetLabels(main string) (map[string]string, error) {
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return copyLabels(c.labels[main]), nil
+}
+
+func (c *MemoryCertsRepository) UpdateLabels(main string, set map[string]string, remove []string) error {
+
+	for key, value := range set {
+		err := ValidateLabel(key, value)
+		if err != nil {
+			return err
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	labels := copyLabels(c.labels[main])
+	for _, key := range remove {
+		delete(labels, key)
+	}
+	for key, value := range set {
+		labels[key] = value
+	}
+	if len(labels) > maxLabels {
+		return errors.New("A cert can have at most 64 labels")
+	}
+	c.labels[main] = labels
+
+	return nil
+}
+
+func (c *MemoryCertsRepository) UpdateNotes(main string, notes string) error {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if cert, exists := c.certs[main]; exists {
+		cert.Notes = notes
+		c.certs[main] = cert
+	}
+	return nil
+}
+
+func (c *MemoryCertsRepository) UpdateMetadata(main string, serial string, metadata Metadata) (sql.Result, error) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cert, exists := c.certs[main]
+	if !exists || cert.Serial != serial {
+		return driver.RowsAffected(0), nil
+	}
+	cert.Metadata = &metadata
+	c.certs[main] = cert
+
+	return driver.RowsAffected(1), nil
+}
+
+// withLabels returns a copy of cert along with its labels
+func (c *MemoryCertsRepository) withLabels(cert Cert) Cert {
+	cert = copyCert(cert)
+	cert.Labels = copyLabels(c.labels[cert.Main])
+	return cert
+}
+
+func copyCert(cert Cert) Cert {
+	cert.Sans = append([]string{}, cert.Sans...)
+	return cert
+}
+
+func copyLabels(labels map[string]string) map[string]string {
+
+	result := map[string]string{}
+	for key, value := range labels {
+		result[key] = value
+	}
+	return result
+}
+
+func (c *MemoryCertsRepository) UpsertConsumer(consumer Consumer) (sql.Result, error) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	consumers, exists := c.consumers[consumer.Main]
+	if !exists {
+		consumers = map[string]Consumer{}
+		c.consumers[consumer.Main] = consumers
+	}
+	if existing, exists := consumers[consumer.Consumer]; exists {
+		consumer.Id = existing.Id
+	} else {
+		c.lastId++
+		consumer.Id = c.lastId
+	}
+	consumers[consumer.Consumer] = consumer
+
+	return driver.RowsAffected(1), nil
+}
+
+func (c *MemoryCertsRepository) ListConsumers(main string) ([]Consumer, error) {
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	result := []Consumer{}
+	for _, v := range c.consumers[main] {
+		result = append(result, c.withVersion(v))
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Consumer < result[j].Consumer
+	})
+
+	return result, nil
+}
+
+func (c *MemoryCertsRepository) ListAllConsumers() ([]Consumer, error) {
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	result := []Consumer{}
+	for _, consumers := range c.consumers {
+		for _, v := range consumers {
+			result = append(result, c.withVersion(v))
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Main != result[j].Main {
+			return result[i].Main < result[j].Main
+		}
+		return result[i].Consumer < result[j].Consumer
+	})
+
+	return result, nil
+}
+
+// withVersion sets the current serial of the cert of consumer and the expiry of the version it holds
+func (c *MemoryCertsRepository) withVersion(consumer Consumer) Consumer {
+
+	if cert, exists := c.certs[consumer.Main]; exists && cert.DeletedTs == 0 {
+		consumer.CurrentSerial = cert.Serial
+	}
+	for _, v := range c.versions[consumer.Main] {
+		if v.Serial == consumer.Serial {
+			consumer.NotAfterTs = v.NotAfterTs
+		}
+	}
+	return consumer
+}
@@ -0,0 +1,56 @@
+package certs
+
+import (
+	"database/sql"
+	"encoding/json"
+)
+
+// Metadata holds the details parsed from the PEM of a cert when it is upserted, so reads never reparse it
+type Metadata struct {
+	Serial             string   `json:"serial"`
+	Subject            string   `json:"subject"`
+	Issuer             string   `json:"issuer"`
+	Chain              []string `json:"chain"`
+	KeyType            string   `json:"key_type"`
+	KeySize            int      `json:"key_size"`
+	Fingerprint        string   `json:"fingerprint_sha256"`
+	SpkiFingerprint    string   `json:"spki_sha256"`
+	SignatureAlgorithm string   `json:"signature_algorithm"`
+	CA                 string   `json:"ca"`
+
+	// ACME Renewal Information, the window is only known if the CA supports it
+	AriCertId        string `json:"ari_cert_id,omitempty"`
+	AriWindowStartTs int64  `json:"ari_window_start_ts,omitempty"`
+	AriWindowEndTs   int64  `json:"ari_window_end_ts,omitempty"`
+}
+
+// UpdateMetadata sets the metadata of main if its current version is still serial
+func (c *SqlCertsRepository) UpdateMetadata(main string, serial string, metadata Metadata) (sql.Result, error) {
+
+	data, _ := json.Marshal(metadata)
+	return c.Db.Exec(`
+		UPDATE certs SET metadata = ? WHERE main = ? AND COALESCE(serial, '') = ?`,
+		data, main, serial)
+}
+
+func marshalMetadata(metadata *Metadata) []byte {
+
+	if metadata == nil {
+		return nil
+	}
+	data, _ := json.Marshal(metadata)
+	return data
+}
+
+func unmarshalMetadata(data []byte) *Metadata {
+
+	if len(data) == 0 {
+		return nil
+	}
+	var result Metadata
+	err := json.Unmarshal(data, &result)
+	if err != nil {
+		return nil
+	}
+	return &result
+}
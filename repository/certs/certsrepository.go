@@ -3,66 +3,191 @@ package certs
 import (
 	"database/sql"
 	"log"
+	"sort"
 	"strings"
 
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/widhaprasa/go-acme-service/acme"
+	"github.com/widhaprasa/go-acme-service/repository/sqldb"
+	"github.com/widhaprasa/go-acme-service/secret"
 )
 
-type CertsRepository struct {
-	Db *sql.DB
+// CertsRepository stores certs along with their SAN identifiers and version history.
+// Trashed certs are only returned by the trash methods
+type CertsRepository interface {
+	GetCerts(domain string, wildcard bool) (Cert, error)
+	GetCertsByMain(main string) (Cert, error)
+	ListOverlappingCerts(domains []string) ([]Cert, error)
+	ListCerts() ([]Cert, error)
+	ListCertsPage(query CertsQuery) (CertsPage, error)
+	UpsertCerts(cert Cert, version VersionInfo) (sql.Result, error)
+	PurgeCerts(main string, deletedTs int64) (sql.Result, error)
+	TrashCerts(main string, ts int64) (sql.Result, error)
+	RestoreCerts(main string) (sql.Result, error)
+	GetTrashedCerts(domain string) (Cert, error)
+	ListTrashedCerts() ([]Cert, error)
+	GetLabels(main string) (map[string]string, error)
+	UpdateLabels(main string, set map[string]string, remove []string) error
+	UpdateNotes(main string, notes string) error
+	UpdateMetadata(main string, serial string, metadata Metadata) (sql.Result, error)
+	UpsertConsumer(consumer Consumer) (sql.Result, error)
+	ListConsumers(main string) ([]Consumer, error)
+	ListAllConsumers() ([]Consumer, error)
+	ListVersions(main string) ([]CertVersion, error)
+	GetVersion(main string, serial string) (CertVersion, error)
 }
 
-func (c *CertsRepository) CreateTable() (sql.Result, error) {
+type SqlCertsRepository struct {
+	Db *sqldb.DB
 
-	return c.Db.Exec(`CREATE TABLE IF NOT EXISTS certs(
-		id INTEGER PRIMARY KEY,
-		main TEXT UNIQUE,
-		sans TEXT,
-		email TEXT,
-		private_key BLOB,
-		certificate BLOB,
-		not_before_ts INTEGER,
-		not_after_ts INTEGER,
-		upserted_ts INTEGER
-	);`)
+	// Keyring encrypts private keys at rest, they are stored as plaintext if nil
+	Keyring *secret.Keyring
 }
 
-func (c *CertsRepository) GetCerts(main string) (map[string]any, error) {
+type Cert struct {
+	Id          int64
+	Main        string
+	Sans        []string
+	Email       string
+	PrivateKey  []byte
+	Certificate []byte
+	NotBeforeTs int64
+	NotAfterTs  int64
+	UpsertedTs  int64
 
-	stmt, err := c.Db.Prepare("SELECT * FROM certs WHERE sans LIKE ?")
+	// Serial and issuer of the current version
+	Serial string
+	Issuer string
+
+	// Time the cert was moved to the trash, zero if it is not trashed
+	DeletedTs int64
+
+	// Labels are only set by single cert reads and listing pages
+	Labels map[string]string
+	Notes  string
+
+	// Metadata parsed at upsert, not set by listing pages nor for certs stored before it was introduced
+	Metadata *Metadata
+}
+
+// ConflictError is returned when a domain resolves to more than one certificate
+type ConflictError struct {
+	Domain     string
+	Candidates []string
+}
+
+func (e *ConflictError) Error() string {
+	return "Domain " + e.Domain + " matches multiple certs: " + strings.Join(e.Candidates, ", ")
+}
+
+// normalizeDomain returns domain as SANs are stored, an IDN in its ASCII form, only lower cased if it is invalid
+func normalizeDomain(domain string) string {
+
+	normalized, err := acme.ValidateDomain(domain)
 	if err != nil {
-		log.Println("Unable to query certs:", err)
+		return strings.ToLower(strings.TrimSuffix(strings.TrimSpace(domain), "."))
+	}
+	return normalized
+}
+
+const certsColumns = "id, main, sans, email, private_key, data_key, certificate, not_before_ts, not_after_ts, upserted_ts, deleted_ts, serial, issuer, notes, metadata"
+
+// GetCerts returns the cert having domain as an exact SAN. When wildcard is set and there is no exact match,
+// the cert having the wildcard of the parent domain is returned, e.g. foo.example.com resolves to *.example.com.
+// A ConflictError is returned when more than one cert matches
+func (c *SqlCertsRepository) GetCerts(domain string, wildcard bool) (Cert, error) {
+
+	domain = normalizeDomain(domain)
+
+	mains, err := c.findMains(domain, false)
+	if err != nil {
+		return Cert{}, err
+	}
+
+	if len(mains) == 0 && wildcard && !strings.HasPrefix(domain, "*.") {
+		if i := strings.Index(domain, "."); i > 0 {
+			mains, err = c.findMains("*"+domain[i:], false)
+			if err != nil {
+				return Cert{}, err
+			}
+		}
+	}
+
+	switch len(mains) {
+	case 0:
+		return Cert{}, sql.ErrNoRows
+	case 1:
+		return c.GetCertsByMain(mains[0])
+	default:
+		return Cert{}, &ConflictError{Domain: domain, Candidates: mains}
+	}
+}
+
+// findMains returns the mains of the active or trashed certs having identifier as a SAN
+func (c *SqlCertsRepository) findMains(identifier string, trashed bool) ([]string, error) {
+
+	state := "IS NULL"
+	if trashed {
+		state = "IS NOT NULL"
+	}
+
+	rows, err := c.Db.Query(`SELECT DISTINCT cert_identifier.main FROM cert_identifier
+		JOIN certs ON certs.main = cert_identifier.main
+		WHERE cert_identifier.identifier = ? AND certs.deleted_ts `+state, identifier)
+	if err != nil {
+		log.Println("Unable to query cert identifier:", err)
 		return nil, err
 	}
-	defer stmt.Close()
+	defer rows.Close()
 
-	row := stmt.QueryRow("%" + main + "%")
-	var id, notBeforeTs, notAfterTs, upsertedTs int
-	var sans, email string
-	var privateKey, certificate []byte
+	mains := []string{}
+	for rows.Next() {
+		var main string
+		err = rows.Scan(&main)
+		if err != nil {
+			log.Println("Unable to scan cert identifier row:", err)
+			return nil, err
+		}
+		mains = append(mains, main)
+	}
+	sort.Strings(mains)
+
+	return mains, nil
+}
+
+func (c *SqlCertsRepository) GetCertsByMain(main string) (Cert, error) {
+	return c.getCertsByMain(main, false)
+}
+
+func (c *SqlCertsRepository) getCertsByMain(main string, trashed bool) (Cert, error) {
+
+	state := "IS NULL"
+	if trashed {
+		state = "IS NOT NULL"
+	}
+
+	stmt, err := c.Db.Prepare("SELECT " + certsColumns + " FROM certs WHERE main = ? AND deleted_ts " + state)
+	if err != nil {
+		log.Println("Unable to query certs:", err)
+		return Cert{}, err
+	}
+	defer stmt.Close()
 
-	err = row.Scan(&id, &main, &sans, &email, &privateKey, &certificate, &notBeforeTs, &notAfterTs, &upsertedTs)
+	result, err := c.scanCert(stmt.QueryRow(main))
 	if err != nil {
 		log.Println("Unable to scan certs row:", err)
-		return nil, err
+		return Cert{}, err
 	}
 
-	result := map[string]any{
-		"id":            id,
-		"main":          main,
-		"sans":          sans,
-		"email":         email,
-		"private_key":   privateKey,
-		"certificate":   certificate,
-		"not_before_ts": notBeforeTs,
-		"not_after_ts":  notAfterTs,
-		"upserted_ts":   upsertedTs,
+	result.Labels, err = c.GetLabels(main)
+	if err != nil {
+		return Cert{}, err
 	}
 
 	return result, nil
 }
 
-func (c *CertsRepository) GetCertsByMain(domains []string) (map[string]any, error) {
+// ListOverlappingCerts returns every cert sharing at least one SAN with domains
+func (c *SqlCertsRepository) ListOverlappingCerts(domains []string) ([]Cert, error) {
 
 	// Create prepared statements
 	count := len(domains)
@@ -70,94 +195,303 @@ func (c *CertsRepository) GetCertsByMain(domains []string) (map[string]any, erro
 	anys := make([]any, count)
 	preparedStatements := make([]string, count)
 	for i := 0; i < count; i++ {
-		anys[i] = domains[i]
+		anys[i] = strings.ToLower(domains[i])
 		preparedStatements[i] = "?"
 	}
 
-	stmt, err := c.Db.Prepare("SELECT * FROM certs WHERE main IN (" + strings.Join(preparedStatements, ", ") + ")")
+	rows, err := c.Db.Query(`SELECT DISTINCT cert_identifier.main FROM cert_identifier
+		JOIN certs ON certs.main = cert_identifier.main
+		WHERE cert_identifier.identifier IN (`+strings.Join(preparedStatements, ", ")+`) AND certs.deleted_ts IS NULL
+		ORDER BY cert_identifier.main`, anys...)
 	if err != nil {
-		log.Println("Unable to query certs:", err)
+		log.Println("Unable to query cert identifier:", err)
 		return nil, err
 	}
-	defer stmt.Close()
-
-	row := stmt.QueryRow(anys...)
-	var id, notBeforeTs, notAfterTs, upsertedTs int
-	var main, sans, email string
-	var privateKey, certificate []byte
 
-	err = row.Scan(&id, &main, &sans, &email, &privateKey, &certificate, &notBeforeTs, &notAfterTs, &upsertedTs)
-	if err != nil {
-		log.Println("Unable to scan certs row:", err)
-		return nil, err
+	var mains []string
+	for rows.Next() {
+		var main string
+		err = rows.Scan(&main)
+		if err != nil {
+			rows.Close()
+			log.Println("Unable to scan cert identifier row:", err)
+			return nil, err
+		}
+		mains = append(mains, main)
 	}
+	rows.Close()
 
-	result := map[string]any{
-		"id":            id,
-		"main":          main,
-		"sans":          sans,
-		"email":         email,
-		"private_key":   privateKey,
-		"certificate":   certificate,
-		"not_before_ts": notBeforeTs,
-		"not_after_ts":  notAfterTs,
-		"upserted_ts":   upsertedTs,
+	result := []Cert{}
+	for _, main := range mains {
+		item, err := c.GetCertsByMain(main)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, item)
 	}
 
 	return result, nil
 }
 
-func (c *CertsRepository) ListCerts() ([]any, error) {
+func (c *SqlCertsRepository) ListCerts() ([]Cert, error) {
+	return c.listCerts("SELECT " + certsColumns + " FROM certs WHERE deleted_ts IS NULL")
+}
+
+func (c *SqlCertsRepository) listCerts(query string) ([]Cert, error) {
 
-	rows, err := c.Db.Query("SELECT * FROM certs")
+	rows, err := c.Db.Query(query)
 	if err != nil {
 		log.Println("Unable to query certs:", err)
 		return nil, err
 	}
 	defer rows.Close()
 
-	result := []any{}
+	result := []Cert{}
 	for rows.Next() {
-		var id, notBeforeTs, notAfterTs, upsertedTs int
-		var main, sans, email string
-		var privateKey, certificate []byte
-
-		err = rows.Scan(&id, &main, &sans, &email, &privateKey, &certificate, &notBeforeTs, &notAfterTs, &upsertedTs)
+		item, err := c.scanCert(rows)
 		if err != nil {
 			log.Println("Unable to scan certs row:", err)
 			return nil, err
 		}
-
-		item := map[string]any{
-			"id":            id,
-			"main":          main,
-			"sans":          sans,
-			"email":         email,
-			"private_key":   privateKey,
-			"certificate":   certificate,
-			"not_before_ts": notBeforeTs,
-			"not_after_ts":  notAfterTs,
-			"upserted_ts":   upsertedTs,
-		}
 		result = append(result, item)
 	}
 
 	return result, nil
 }
 
-func (c *CertsRepository) UpsertCerts(main string, sans string, email string, privateKey, certificate []byte, notBeforeTs int64, notAfterTs int64, upsertedTs int64) (sql.Result, error) {
-	return c.Db.Exec(`
-		INSERT INTO certs(main, sans, email, private_key, certificate, not_before_ts, not_after_ts, upserted_ts)
-		VALUES(?, ?, ?, ?, ?, ?, ?, ?)
+// UpsertCerts stores the cert as the current one of main and keeps it in the version history.
+// A trashed cert of main is taken out of the trash
+func (c *SqlCertsRepository) UpsertCerts(cert Cert, version VersionInfo) (sql.Result, error) {
+
+	tx, err := c.Db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	privateKey, dataKey, err := c.Keyring.SealValue(cert.PrivateKey, secret.AAD("certs", cert.Main))
+	if err != nil {
+		return nil, err
+	}
+
+	sans := strings.Join(cert.Sans, ",")
+	result, err := tx.Exec(`
+		INSERT INTO certs(main, sans, email, private_key, data_key, certificate, not_before_ts, not_after_ts, upserted_ts, serial, issuer, metadata)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 		ON CONFLICT(main)
-		DO UPDATE SET sans = excluded.sans, email = excluded.email, private_key = excluded.private_key, certificate = excluded.certificate, not_before_ts = excluded.not_before_ts,
-			not_after_ts = excluded.not_after_ts, upserted_ts = excluded.upserted_ts;`,
-		main, sans, email, privateKey, certificate, notBeforeTs, notAfterTs, upsertedTs)
+		DO UPDATE SET sans = excluded.sans, email = excluded.email, private_key = excluded.private_key, data_key = excluded.data_key, certificate = excluded.certificate,
+			not_before_ts = excluded.not_before_ts, not_after_ts = excluded.not_after_ts, upserted_ts = excluded.upserted_ts, deleted_ts = NULL,
+			serial = excluded.serial, issuer = excluded.issuer, metadata = excluded.metadata;`,
+		cert.Main, sans, cert.Email, privateKey, dataKey, cert.Certificate, cert.NotBeforeTs, cert.NotAfterTs, cert.UpsertedTs, version.Serial, version.Issuer,
+		marshalMetadata(cert.Metadata))
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.replaceIdentifiers(tx, cert.Main, cert.Sans)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.insertVersion(tx, cert, version)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, tx.Commit()
 }
 
-func (c *CertsRepository) DeleteCerts(main string) (sql.Result, error) {
+// PurgeCerts permanently deletes the cert of main along with its version history and webhook,
+// if it was trashed at or before deletedTs
+func (c *SqlCertsRepository) PurgeCerts(main string, deletedTs int64) (sql.Result, error) {
+
+	tx, err := c.Db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+		DELETE FROM certs WHERE main = ? AND deleted_ts <= ?`,
+		main, deletedTs)
+	if err != nil {
+		return nil, err
+	}
+
+	// Restored or reissued meanwhile
+	count, err := result.RowsAffected()
+	if err != nil || count == 0 {
+		return result, err
+	}
+
+	_, err = tx.Exec(`
+		DELETE FROM cert_identifier WHERE main = ?`,
+		main)
+	if err != nil {
+		return nil, err
+	}
+
+	err = deleteLabels(tx, main)
+	if err != nil {
+		return nil, err
+	}
+
+	err = deleteConsumers(tx, main)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.deleteVersions(tx, main)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(`
+		DELETE FROM webhook WHERE main = ?`,
+		main)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, tx.Commit()
+}
+
+// TrashCerts moves the active cert of main to the trash at ts
+func (c *SqlCertsRepository) TrashCerts(main string, ts int64) (sql.Result, error) {
 
 	return c.Db.Exec(`
-		DELETE FROM certs WHERE main = ?`,
+		UPDATE certs SET deleted_ts = ? WHERE main = ? AND deleted_ts IS NULL`,
+		ts, main)
+}
+
+// RestoreCerts takes the trashed cert of main out of the trash
+func (c *SqlCertsRepository) RestoreCerts(main string) (sql.Result, error) {
+
+	return c.Db.Exec(`
+		UPDATE certs SET deleted_ts = NULL WHERE main = ? AND deleted_ts IS NOT NULL`,
 		main)
 }
+
+// GetTrashedCerts returns the trashed cert having domain as an exact SAN
+func (c *SqlCertsRepository) GetTrashedCerts(domain string) (Cert, error) {
+
+	domain = normalizeDomain(domain)
+
+	mains, err := c.findMains(domain, true)
+	if err != nil {
+		return Cert{}, err
+	}
+
+	switch len(mains) {
+	case 0:
+		return Cert{}, sql.ErrNoRows
+	case 1:
+		return c.getCertsByMain(mains[0], true)
+	default:
+		for _, main := range mains {
+			if main == domain {
+				return c.getCertsByMain(main, true)
+			}
+		}
+		return Cert{}, &ConflictError{Domain: domain, Candidates: mains}
+	}
+}
+
+// ListTrashedCerts returns the trashed certs, oldest trashed first
+func (c *SqlCertsRepository) ListTrashedCerts() ([]Cert, error) {
+	return c.listCerts("SELECT " + certsColumns + " FROM certs WHERE deleted_ts IS NOT NULL ORDER BY deleted_ts, id")
+}
+
+func (c *SqlCertsRepository) replaceIdentifiers(tx *sqldb.Tx, main string, sans []string) error {
+
+	_, err := tx.Exec(`
+		DELETE FROM cert_identifier WHERE main = ?`,
+		main)
+	if err != nil {
+		return err
+	}
+
+	for _, identifier := range sans {
+		identifier = strings.ToLower(strings.TrimSpace(identifier))
+		if identifier == "" {
+			continue
+		}
+		_, err = tx.Exec(`
+			INSERT INTO cert_identifier(main, identifier)
+			VALUES(?, ?)
+			ON CONFLICT(main, identifier) DO NOTHING`,
+			main, identifier)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func (c *SqlCertsRepository) scanCert(row scanner) (Cert, error) {
+
+	var result Cert
+	var sans string
+	var dataKey []byte
+	var deletedTs sql.NullInt64
+	var serial, issuer, notes sql.NullString
+	var metadata []byte
+
+	err := row.Scan(&result.Id, &result.Main, &sans, &result.Email, &result.PrivateKey, &dataKey, &result.Certificate,
+		&result.NotBeforeTs, &result.NotAfterTs, &result.UpsertedTs, &deletedTs, &serial, &issuer, &notes, &metadata)
+	if err != nil {
+		return Cert{}, err
+	}
+	result.Sans = splitSans(sans)
+	result.DeletedTs = deletedTs.Int64
+	result.Serial = serial.String
+	result.Issuer = issuer.String
+	result.Notes = notes.String
+	result.Metadata = unmarshalMetadata(metadata)
+
+	result.PrivateKey, err = c.Keyring.OpenValue(result.PrivateKey, dataKey, secret.AAD("certs", result.Main))
+	if err != nil {
+		return Cert{}, err
+	}
+
+	return result, nil
+}
+
+func splitSans(sans string) []string {
+
+	result := []string{}
+	for _, san := range strings.Split(sans, ",") {
+		if san != "" {
+			result = append(result, san)
+		}
+	}
+	return result
+}
+
+// EncryptPrivateKeys seals the plaintext private keys of certs and their versions, returning how many were sealed
+func (c *SqlCertsRepository) EncryptPrivateKeys() (int, error) {
+
+	count, err := sqldb.EncryptRows(c.Db, c.Keyring, "certs", "main")
+	if err != nil {
+		return count, err
+	}
+
+	versionCount, err := sqldb.EncryptRows(c.Db, c.Keyring, "cert_version", "main", "serial")
+	return count + versionCount, err
+}
+
+// RewrapDataKeys wraps the data keys of certs and their versions with the current master key
+func (c *SqlCertsRepository) RewrapDataKeys() (int, error) {
+
+	count, err := sqldb.RewrapRows(c.Db, c.Keyring, "certs")
+	if err != nil {
+		return count, err
+	}
+
+	versionCount, err := sqldb.RewrapRows(c.Db, c.Keyring, "cert_version")
+	return count + versionCount, err
+}
@@ -0,0 +1,117 @@
+package certs
+
+import (
+	"log"
+	"strings"
+
+	"github.com/widhaprasa/go-acme-service/repository/sqldb"
+	"github.com/widhaprasa/go-acme-service/secret"
+)
+
+// VersionInfo identifies a cert version and what produced it
+type VersionInfo struct {
+	Serial         string
+	KeyFingerprint string
+	Issuer         string
+	Trigger        string
+}
+
+type CertVersion struct {
+	Id          int64
+	Main        string
+	Sans        []string
+	Email       string
+	PrivateKey  []byte
+	Certificate []byte
+	NotBeforeTs int64
+	NotAfterTs  int64
+	CreatedTs   int64
+	VersionInfo
+}
+
+func (c *SqlCertsRepository) insertVersion(tx *sqldb.Tx, cert Cert, version VersionInfo) error {
+
+	privateKey, dataKey, err := c.Keyring.SealValue(cert.PrivateKey, secret.AAD("cert_version", cert.Main, version.Serial))
+	if err != nil {
+		return err
+	}
+
+	// A version is immutable, promoting it again keeps the original row
+	_, err = tx.Exec(`
+		INSERT INTO cert_version(main, serial, sans, email, private_key, data_key, certificate, not_before_ts, not_after_ts,
+			key_fingerprint, issuer, trigger, created_ts)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(main, serial) DO NOTHING`,
+		cert.Main, version.Serial, strings.Join(cert.Sans, ","), cert.Email, privateKey, dataKey, cert.Certificate, cert.NotBeforeTs, cert.NotAfterTs,
+		version.KeyFingerprint, version.Issuer, version.Trigger, cert.UpsertedTs)
+	return err
+}
+
+// ListVersions returns the versions of main without blobs, newest first
+func (c *SqlCertsRepository) ListVersions(main string) ([]CertVersion, error) {
+
+	rows, err := c.Db.Query(`SELECT id, main, serial, sans, email, not_before_ts, not_after_ts, key_fingerprint, issuer, trigger, created_ts
+		FROM cert_version WHERE main = ? ORDER BY created_ts DESC, id DESC`, main)
+	if err != nil {
+		log.Println("Unable to query cert version:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []CertVersion{}
+	for rows.Next() {
+		var item CertVersion
+		var sans string
+
+		err = rows.Scan(&item.Id, &item.Main, &item.Serial, &sans, &item.Email, &item.NotBeforeTs, &item.NotAfterTs,
+			&item.KeyFingerprint, &item.Issuer, &item.Trigger, &item.CreatedTs)
+		if err != nil {
+			log.Println("Unable to scan cert version row:", err)
+			return nil, err
+		}
+		item.Sans = splitSans(sans)
+
+		result = append(result, item)
+	}
+
+	return result, nil
+}
+
+func (c *SqlCertsRepository) GetVersion(main string, serial string) (CertVersion, error) {
+
+	stmt, err := c.Db.Prepare(`SELECT id, main, serial, sans, email, private_key, data_key, certificate, not_before_ts, not_after_ts,
+		key_fingerprint, issuer, trigger, created_ts FROM cert_version WHERE main = ? AND serial = ?`)
+	if err != nil {
+		log.Println("Unable to query cert version:", err)
+		return CertVersion{}, err
+	}
+	defer stmt.Close()
+
+	var result CertVersion
+	var sans string
+	var dataKey []byte
+
+	err = stmt.QueryRow(main, serial).Scan(&result.Id, &result.Main, &result.Serial, &sans, &result.Email, &result.PrivateKey, &dataKey, &result.Certificate,
+		&result.NotBeforeTs, &result.NotAfterTs, &result.KeyFingerprint, &result.Issuer, &result.Trigger, &result.CreatedTs)
+	if err != nil {
+		log.Println("Unable to scan cert version row:", err)
+		return CertVersion{}, err
+	}
+	result.Sans = splitSans(sans)
+
+	result.PrivateKey, err = c.Keyring.OpenValue(result.PrivateKey, dataKey, secret.AAD("cert_version", result.Main, result.Serial))
+	if err != nil {
+		log.Println("Unable to decrypt cert version private key:", err)
+		return CertVersion{}, err
+	}
+
+	return result, nil
+}
+
+func (c *SqlCertsRepository) deleteVersions(tx *sqldb.Tx, main string) error {
+
+	_, err := tx.Exec(`
+		DELETE FROM cert_version WHERE main = ?`,
+		main)
+	return err
+}
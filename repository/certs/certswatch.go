@@ -0,0 +1,59 @@
+package certs
+
+import (
+	"database/sql"
+	"sync"
+)
+
+// Watcher tells when the cert of a main is upserted
+type Watcher interface {
+
+	// Watch returns a channel closed on the next upsert of main
+	Watch(main string) <-chan struct{}
+}
+
+// WatchedCertsRepository notifies the watchers of a main when its cert is upserted through it.
+// Upserts made by other processes sharing the database are not seen
+type WatchedCertsRepository struct {
+	CertsRepository
+
+	mu       sync.Mutex
+	watchers map[string]chan struct{}
+}
+
+func NewWatchedCertsRepository(certsRepository CertsRepository) *WatchedCertsRepository {
+	return &WatchedCertsRepository{
+		CertsRepository: certsRepository,
+		watchers:        map[string]chan struct{}{},
+	}
+}
+
+func (c *WatchedCertsRepository) Watch(main string) <-chan struct{} {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ch, exists := c.watchers[main]
+	if !exists {
+		ch = make(chan struct{})
+		c.watchers[main] = ch
+	}
+	return ch
+}
+
+func (c *WatchedCertsRepository) UpsertCerts(cert Cert, version VersionInfo) (sql.Result, error) {
+
+	result, err := c.CertsRepository.UpsertCerts(cert, version)
+	if err != nil {
+		return result, err
+	}
+
+	c.mu.Lock()
+	if ch, exists := c.watchers[cert.Main]; exists {
+		close(ch)
+		delete(c.watchers, cert.Main)
+	}
+	c.mu.Unlock()
+
+	return result, nil
+}
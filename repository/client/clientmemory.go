@@ -0,0 +1,62 @@
+package client
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"sync"
+)
+
+// MemoryClientRepository keeps accounts in memory, used for tests and the embedded mode
+type MemoryClientRepository struct {
+	mu       sync.RWMutex
+	accounts map[string]Account
+	lastId   int64
+}
+
+func NewMemoryClientRepository() *MemoryClientRepository {
+	return &MemoryClientRepository{
+		accounts: map[string]Account{},
+	}
+}
+
+func (c *MemoryClientRepository) GetClient(email string) (Account, error) {
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	account, exists := c.accounts[email]
+	if !exists {
+		return Account{}, sql.ErrNoRows
+	}
+
+	return account, nil
+}
+
+func (c *MemoryClientRepository) UpsertClient(account Account) (sql.Result, error) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if existing, exists := c.accounts[account.Email]; exists {
+		account.Id = existing.Id
+	} else {
+		c.lastId++
+		account.Id = c.lastId
+	}
+	c.accounts[account.Email] = account
+
+	return driver.RowsAffected(1), nil
+}
+
+func (c *MemoryClientRepository) DeleteClient(email string) (sql.Result, error) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, exists := c.accounts[email]; !exists {
+		return driver.RowsAffected(0), nil
+	}
+	delete(c.accounts, email)
+
+	return driver.RowsAffected(1), nil
+}
@@ -4,67 +4,87 @@ import (
 	"database/sql"
 	"log"
 
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/widhaprasa/go-acme-service/repository/sqldb"
+	"github.com/widhaprasa/go-acme-service/secret"
 )
 
-type ClientRepository struct {
-	Db *sql.DB
+// ClientRepository stores the ACME account of each email
+type ClientRepository interface {
+	GetClient(email string) (Account, error)
+	UpsertClient(account Account) (sql.Result, error)
+	DeleteClient(email string) (sql.Result, error)
 }
 
-func (c *ClientRepository) CreateTable() (sql.Result, error) {
+type SqlClientRepository struct {
+	Db *sqldb.DB
 
-	return c.Db.Exec(`CREATE TABLE IF NOT EXISTS client(
-		id INTEGER PRIMARY KEY,
-		email TEXT UNIQUE,
-		uri TEXT,
-		private_key BLOB,
-		upserted_ts INTEGER
-	);`)
+	// Keyring encrypts account keys at rest, they are stored as plaintext if nil
+	Keyring *secret.Keyring
 }
 
-func (c *ClientRepository) GetClient(email string) (map[string]any, error) {
+// Account is an ACME account registered for an email
+type Account struct {
+	Id         int64
+	Email      string
+	Uri        string
+	PrivateKey []byte
+	UpsertedTs int64
+}
+
+func (c *SqlClientRepository) GetClient(email string) (Account, error) {
 
-	stmt, err := c.Db.Prepare("SELECT * FROM client WHERE email = ?")
+	stmt, err := c.Db.Prepare("SELECT id, email, uri, private_key, data_key, upserted_ts FROM client WHERE email = ?")
 	if err != nil {
 		log.Println("Unable to query client:", err)
-		return nil, err
+		return Account{}, err
 	}
 	defer stmt.Close()
 
-	row := stmt.QueryRow(email)
-	var id, upsertedTs int
-	var uri string
-	var privateKey []byte
-
-	err = row.Scan(&id, &email, &uri, &privateKey, &upsertedTs)
+	var result Account
+	var dataKey []byte
+	err = stmt.QueryRow(email).Scan(&result.Id, &result.Email, &result.Uri, &result.PrivateKey, &dataKey, &result.UpsertedTs)
 	if err != nil {
 		log.Println("Unable to scan client row:", err)
-		return nil, err
+		return Account{}, err
 	}
 
-	result := map[string]any{
-		"id":          id,
-		"email":       email,
-		"uri":         uri,
-		"private_key": privateKey,
-		"upserted_ts": upsertedTs,
+	result.PrivateKey, err = c.Keyring.OpenValue(result.PrivateKey, dataKey, secret.AAD("client", result.Email))
+	if err != nil {
+		log.Println("Unable to decrypt client private key:", err)
+		return Account{}, err
 	}
 
 	return result, nil
 }
 
-func (c *ClientRepository) UpsertClient(email string, uri string, privateKey []byte, upsertedTs int64) (sql.Result, error) {
+func (c *SqlClientRepository) UpsertClient(account Account) (sql.Result, error) {
+
+	privateKey, dataKey, err := c.Keyring.SealValue(account.PrivateKey, secret.AAD("client", account.Email))
+	if err != nil {
+		return nil, err
+	}
+
 	return c.Db.Exec(`
-		INSERT INTO client(email, uri, private_key, upserted_ts)
-		VALUES(?, ?, ?, ?)
+		INSERT INTO client(email, uri, private_key, data_key, upserted_ts)
+		VALUES(?, ?, ?, ?, ?)
 		ON CONFLICT(email)
-		DO UPDATE SET uri = excluded.uri, private_key = excluded.private_key, upserted_ts = excluded.upserted_ts;`,
-		email, uri, privateKey, upsertedTs)
+		DO UPDATE SET uri = excluded.uri, private_key = excluded.private_key, data_key = excluded.data_key, upserted_ts = excluded.upserted_ts;`,
+		account.Email, account.Uri, privateKey, dataKey, account.UpsertedTs)
 }
 
-func (c *ClientRepository) DeleteClient(email string) (sql.Result, error) {
+func (c *SqlClientRepository) DeleteClient(email string) (sql.Result, error) {
 
 	return c.Db.Exec(`
 		DELETE FROM client WHERE email = ?`,
 		email)
 }
+
+// EncryptPrivateKeys seals the plaintext account keys, returning how many were sealed
+func (c *SqlClientRepository) EncryptPrivateKeys() (int, error) {
+	return sqldb.EncryptRows(c.Db, c.Keyring, "client", "email")
+}
+
+// RewrapDataKeys wraps the data keys of account keys with the current master key
+func (c *SqlClientRepository) RewrapDataKeys() (int, error) {
+	return sqldb.RewrapRows(c.Db, c.Keyring, "client")
+}
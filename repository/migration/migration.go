@@ -0,0 +1,279 @@
+package migration
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/widhaprasa/go-acme-service/repository/sqldb"
+)
+
+type Migration struct {
+	Version  int
+	Name     string
+	Sqlite   string
+	Postgres string
+}
+
+// Statements returns the SQL of migration for dialect
+func (m Migration) Statements(dialect string) string {
+	if dialect == sqldb.Postgres {
+		return m.Postgres
+	}
+	return m.Sqlite
+}
+
+// Lock key serializing upgrades of replicas sharing a database
+const lockKey = 0x61636d65
+
+// ErrBackupUnsupported is returned when the database cannot be backed up by the migrator
+var ErrBackupUnsupported = errors.New("Database backup is not supported, back up PostgreSQL with pg_dump")
+
+// Status is a known migration and when it was applied, AppliedTs is 0 if pending
+type Status struct {
+	Version   int    `json:"version"`
+	Name      string `json:"name"`
+	AppliedTs int64  `json:"applied_ts"`
+}
+
+// Migrator applies Migrations to Db, Path is the DSN of an SQLite database
+type Migrator struct {
+	Db   *sqldb.DB
+	Path string
+}
+
+func (m *Migrator) createTable() error {
+
+	_, err := m.Db.Exec(`CREATE TABLE IF NOT EXISTS schema_version(
+		version INTEGER PRIMARY KEY,
+		name TEXT,
+		applied_ts BIGINT
+	);`)
+	return err
+}
+
+// Version returns the latest applied migration, 0 if none
+func (m *Migrator) Version() (int, error) {
+
+	err := m.createTable()
+	if err != nil {
+		return 0, err
+	}
+
+	var version sql.NullInt64
+	err = m.Db.QueryRow("SELECT MAX(version) FROM schema_version").Scan(&version)
+	if err != nil {
+		return 0, err
+	}
+
+	return int(version.Int64), nil
+}
+
+func (m *Migrator) Status() ([]Status, error) {
+
+	err := m.createTable()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := m.Db.Query("SELECT version, name, applied_ts FROM schema_version ORDER BY version")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]Status{}
+	for rows.Next() {
+		var item Status
+		err = rows.Scan(&item.Version, &item.Name, &item.AppliedTs)
+		if err != nil {
+			return nil, err
+		}
+		applied[item.Version] = item
+	}
+
+	result := []Status{}
+	for _, migration := range Migrations {
+		item, exists := applied[migration.Version]
+		if !exists {
+			item = Status{Version: migration.Version, Name: migration.Name}
+		}
+		result = append(result, item)
+	}
+
+	return result, nil
+}
+
+// Pending returns the migrations not applied yet, failing if the database is newer than this build
+func (m *Migrator) Pending() ([]Migration, error) {
+
+	version, err := m.Version()
+	if err != nil {
+		return nil, err
+	}
+
+	latest := Migrations[len(Migrations)-1].Version
+	if version > latest {
+		return nil, fmt.Errorf("Database schema version %d is newer than the supported version %d", version, latest)
+	}
+
+	result := []Migration{}
+	for _, migration := range Migrations {
+		if migration.Version > version {
+			result = append(result, migration)
+		}
+	}
+
+	return result, nil
+}
+
+// Apply runs the pending migrations, each in its own transaction. With dryRun every pending migration
+// runs in a single transaction which is rolled back
+func (m *Migrator) Apply(ts int64, dryRun bool) ([]Migration, error) {
+
+	pending, err := m.Pending()
+	if err != nil {
+		return nil, err
+	}
+	if len(pending) == 0 {
+		return pending, nil
+	}
+
+	if dryRun {
+		tx, err := m.Db.Begin()
+		if err != nil {
+			return nil, err
+		}
+		defer tx.Rollback()
+
+		for _, migration := range pending {
+			err = apply(tx, migration, ts)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return pending, nil
+	}
+
+	for _, migration := range pending {
+		log.Println("Apply migration:", migration.Version, migration.Name)
+
+		tx, err := m.Db.Begin()
+		if err != nil {
+			return nil, err
+		}
+		err = apply(tx, migration, ts)
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		err = tx.Commit()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return pending, nil
+}
+
+func apply(tx *sqldb.Tx, migration Migration, ts int64) error {
+
+	statements := migration.Statements(tx.Dialect)
+	if strings.TrimSpace(statements) != "" {
+		_, err := tx.Exec(statements)
+		if err != nil {
+			return fmt.Errorf("Migration %d %s failed: %w", migration.Version, migration.Name, err)
+		}
+	}
+
+	_, err := tx.Exec(`
+		INSERT INTO schema_version(version, name, applied_ts)
+		VALUES(?, ?, ?)`,
+		migration.Version, migration.Name, ts)
+	return err
+}
+
+// Backup copies the SQLite database next to Path, suffixed with the current schema version and ts
+func (m *Migrator) Backup(ts int64) (string, error) {
+
+	if m.Db.Dialect != sqldb.Sqlite {
+		return "", ErrBackupUnsupported
+	}
+	file := sqldb.FilePath(m.Path)
+	if file == "" {
+		return "", errors.New("Database has no file to back up")
+	}
+
+	version, err := m.Version()
+	if err != nil {
+		return "", err
+	}
+
+	path := file + ".v" + strconv.Itoa(version) + "-" + strconv.FormatInt(ts, 10) + ".bak"
+	if _, err := os.Stat(path); err == nil {
+		return "", errors.New("Backup " + path + " already exists")
+	}
+
+	_, err = m.Db.Exec("VACUUM INTO ?", path)
+	if err != nil {
+		return "", err
+	}
+
+	return path, nil
+}
+
+// Upgrade applies the pending migrations, backing up a database holding data first
+func (m *Migrator) Upgrade(ts int64) error {
+
+	// Replicas starting together upgrade one at a time
+	unlock, err := m.Db.Lock(context.Background(), lockKey)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	pending, err := m.Pending()
+	if err != nil {
+		return err
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	// A new database has nothing to back up
+	tables, err := m.countTables()
+	if err != nil {
+		return err
+	}
+	if tables > 0 {
+		path, err := m.Backup(ts)
+		if errors.Is(err, ErrBackupUnsupported) {
+			log.Println(err)
+		} else if err != nil {
+			log.Println("Unable to back up database before migration:", err)
+			return err
+		} else {
+			log.Println("Backed up database to:", path)
+		}
+	}
+
+	_, err = m.Apply(ts, false)
+	return err
+}
+
+func (m *Migrator) countTables() (int, error) {
+
+	query := "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name <> 'schema_version'"
+	if m.Db.Dialect == sqldb.Postgres {
+		query = "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = current_schema() AND table_name <> 'schema_version'"
+	}
+
+	var tables int
+	err := m.Db.QueryRow(query).Scan(&tables)
+	return tables, err
+}
@@ -0,0 +1,253 @@
+package migration
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/widhaprasa/go-acme-service/repository/sqldb"
+)
+
+// newTestMigrator opens an SQLite database in a temporary directory through dsn, where %s is the file path
+func newTestMigrator(t *testing.T, dsn string) (*Migrator, string) {
+
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "acme.db")
+	dsn = strings.ReplaceAll(dsn, "%s", path)
+	db, err := sqldb.Open(sqldb.Sqlite, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return &Migrator{Db: db, Path: dsn}, path
+}
+
+func TestBackupFileURI(t *testing.T) {
+
+	migrator, path := newTestMigrator(t, "file:%s?_fk=1")
+	_, err := migrator.Apply(1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	backup, err := migrator.Backup(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Dir(backup) != filepath.Dir(path) || !strings.HasPrefix(filepath.Base(backup), "acme.db.v") {
+		t.Fatalf("backup %s, want it next to %s", backup, path)
+	}
+	if _, err := os.Stat(backup); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestApply(t *testing.T) {
+
+	migrator, _ := newTestMigrator(t, "%s")
+	latest := Migrations[len(Migrations)-1].Version
+
+	// A dry run leaves the database as it was
+	applied, err := migrator.Apply(1, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != len(Migrations) {
+		t.Fatalf("dry run applied %d migrations, want %d", len(applied), len(Migrations))
+	}
+	if version, _ := migrator.Version(); version != 0 {
+		t.Fatalf("version %d after a dry run, want 0", version)
+	}
+	if tables, _ := migrator.countTables(); tables != 0 {
+		t.Fatalf("%d tables after a dry run, want none", tables)
+	}
+
+	_, err = migrator.Apply(2, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version, _ := migrator.Version(); version != latest {
+		t.Fatalf("version %d, want %d", version, latest)
+	}
+	list, err := migrator.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, item := range list {
+		if item.AppliedTs != 2 {
+			t.Errorf("migration %d %s not applied", item.Version, item.Name)
+		}
+	}
+
+	// Nothing left to apply
+	applied, err = migrator.Apply(3, false)
+	if err != nil || len(applied) != 0 {
+		t.Fatalf("applied %d migrations again: %v", len(applied), err)
+	}
+}
+
+func TestApplyFailure(t *testing.T) {
+
+	migrator, _ := newTestMigrator(t, "%s")
+	_, err := migrator.Apply(1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	latest := Migrations[len(Migrations)-1].Version
+
+	// A failing migration is rolled back as a whole, the version stays
+	previous := Migrations
+	t.Cleanup(func() { Migrations = previous })
+	Migrations = append(append([]Migration{}, previous...), Migration{
+		Version: latest + 1,
+		Name:    "broken",
+		Sqlite: `
+			CREATE TABLE broken(id INTEGER PRIMARY KEY);
+			INSERT INTO missing VALUES(1);`,
+	})
+
+	_, err = migrator.Apply(2, false)
+	if err == nil {
+		t.Fatal("broken migration applied")
+	}
+	if version, _ := migrator.Version(); version != latest {
+		t.Fatalf("version %d after a failure, want %d", version, latest)
+	}
+	var count int
+	err = migrator.Db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name = 'broken'").Scan(&count)
+	if err != nil || count != 0 {
+		t.Fatalf("table of the failed migration was kept: %v", err)
+	}
+}
+
+func TestUpgradeFromEveryVersion(t *testing.T) {
+
+	previous := Migrations
+	t.Cleanup(func() { Migrations = previous })
+	latest := previous[len(previous)-1].Version
+
+	for i := 1; i < len(previous); i++ {
+
+		// A database left at an older version, holding a cert
+		Migrations = previous[:i]
+		migrator, path := newTestMigrator(t, "%s")
+		_, err := migrator.Apply(1, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = migrator.Db.Exec("INSERT INTO certs(main, sans) VALUES(?, ?)", "example.com", `["example.com"]`)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		Migrations = previous
+		err = migrator.Upgrade(2)
+		if err != nil {
+			t.Fatalf("upgrade from version %d: %v", i, err)
+		}
+		if version, _ := migrator.Version(); version != latest {
+			t.Fatalf("upgrade from version %d: version %d, want %d", i, version, latest)
+		}
+
+		var main string
+		err = migrator.Db.QueryRow("SELECT main FROM certs").Scan(&main)
+		if err != nil || main != "example.com" {
+			t.Fatalf("upgrade from version %d lost the cert: %v", i, err)
+		}
+
+		// Backed up at the version it was upgraded from
+		backup := path + ".v" + strconv.Itoa(previous[i-1].Version) + "-2.bak"
+		if _, err := os.Stat(backup); err != nil {
+			t.Fatalf("upgrade from version %d: %v", i, err)
+		}
+	}
+}
+
+// newTestPostgresMigrator opens the PostgreSQL database of POSTGRES_TEST_DSN in a schema of its own, dropped on cleanup
+func newTestPostgresMigrator(t *testing.T, dsn string, schema string) *Migrator {
+
+	t.Helper()
+	admin, err := sqldb.Open(sqldb.Postgres, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { admin.Close() })
+	_, err = admin.Exec("DROP SCHEMA IF EXISTS " + schema + " CASCADE")
+	if err == nil {
+		_, err = admin.Exec("CREATE SCHEMA " + schema)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { admin.Exec("DROP SCHEMA IF EXISTS " + schema + " CASCADE") })
+
+	// Every pooled connection works in the schema
+	if strings.Contains(dsn, "://") {
+		separator := "?"
+		if strings.Contains(dsn, "?") {
+			separator = "&"
+		}
+		dsn += separator + "search_path=" + schema
+	} else {
+		dsn += " search_path=" + schema
+	}
+	db, err := sqldb.Open(sqldb.Postgres, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return &Migrator{Db: db, Path: dsn}
+}
+
+func TestUpgradePostgres(t *testing.T) {
+
+	dsn := os.Getenv("POSTGRES_TEST_DSN")
+	if dsn == "" {
+		t.Skip("POSTGRES_TEST_DSN is not set")
+	}
+
+	previous := Migrations
+	t.Cleanup(func() { Migrations = previous })
+	latest := previous[len(previous)-1].Version
+
+	for i := 0; i < len(previous); i++ {
+
+		// A new database, or one left at an older version holding a cert
+		migrator := newTestPostgresMigrator(t, dsn, fmt.Sprintf("acme_test_v%d", i))
+		if i > 0 {
+			Migrations = previous[:i]
+			_, err := migrator.Apply(1, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = migrator.Db.Exec("INSERT INTO certs(main, sans) VALUES(?, ?)", "example.com", `["example.com"]`)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// Without a file to back up, the upgrade goes ahead
+		Migrations = previous
+		err := migrator.Upgrade(2)
+		if err != nil {
+			t.Fatalf("upgrade from version %d: %v", i, err)
+		}
+		if version, _ := migrator.Version(); version != latest {
+			t.Fatalf("upgrade from version %d: version %d, want %d", i, version, latest)
+		}
+		if pending, err := migrator.Pending(); err != nil || len(pending) != 0 {
+			t.Fatalf("upgrade from version %d: %d pending: %v", i, len(pending), err)
+		}
+
+		if i > 0 {
+			var main string
+			err = migrator.Db.QueryRow("SELECT main FROM certs").Scan(&main)
+			if err != nil || main != "example.com" {
+				t.Fatalf("upgrade from version %d lost the cert: %v", i, err)
+			}
+		}
+	}
+}
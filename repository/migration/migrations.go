@@ -0,0 +1,382 @@
+package migration
+
+// Migrations are applied in order of version, an applied migration must never be changed
+var Migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "create tables",
+		Sqlite: `
+			CREATE TABLE IF NOT EXISTS certs(
+				id INTEGER PRIMARY KEY,
+				main TEXT UNIQUE,
+				sans TEXT,
+				email TEXT,
+				private_key BLOB,
+				certificate BLOB,
+				not_before_ts INTEGER,
+				not_after_ts INTEGER,
+				upserted_ts INTEGER
+			);
+			CREATE TABLE IF NOT EXISTS cert_identifier(
+				id INTEGER PRIMARY KEY,
+				main TEXT,
+				identifier TEXT,
+				UNIQUE(main, identifier)
+			);
+			CREATE INDEX IF NOT EXISTS cert_identifier_identifier ON cert_identifier(identifier);
+			CREATE TABLE IF NOT EXISTS cert_version(
+				id INTEGER PRIMARY KEY,
+				main TEXT,
+				serial TEXT,
+				sans TEXT,
+				email TEXT,
+				private_key BLOB,
+				certificate BLOB,
+				not_before_ts INTEGER,
+				not_after_ts INTEGER,
+				key_fingerprint TEXT,
+				issuer TEXT,
+				trigger TEXT,
+				created_ts INTEGER,
+				UNIQUE(main, serial)
+			);
+			CREATE TABLE IF NOT EXISTS client(
+				id INTEGER PRIMARY KEY,
+				email TEXT UNIQUE,
+				uri TEXT,
+				private_key BLOB,
+				upserted_ts INTEGER
+			);
+			CREATE TABLE IF NOT EXISTS webhook(
+				id INTEGER PRIMARY KEY,
+				main TEXT UNIQUE,
+				url TEXT,
+				headers BLOB
+			);
+			CREATE TABLE IF NOT EXISTS zone(
+				id INTEGER PRIMARY KEY,
+				zone TEXT UNIQUE,
+				caa_managed INTEGER,
+				caa_account_uri INTEGER,
+				caa_records BLOB,
+				upserted_ts INTEGER
+			);`,
+		Postgres: `
+			CREATE TABLE IF NOT EXISTS certs(
+				id BIGSERIAL PRIMARY KEY,
+				main TEXT UNIQUE,
+				sans TEXT,
+				email TEXT,
+				private_key BYTEA,
+				certificate BYTEA,
+				not_before_ts BIGINT,
+				not_after_ts BIGINT,
+				upserted_ts BIGINT
+			);
+			CREATE TABLE IF NOT EXISTS cert_identifier(
+				id BIGSERIAL PRIMARY KEY,
+				main TEXT,
+				identifier TEXT,
+				UNIQUE(main, identifier)
+			);
+			CREATE INDEX IF NOT EXISTS cert_identifier_identifier ON cert_identifier(identifier);
+			CREATE TABLE IF NOT EXISTS cert_version(
+				id BIGSERIAL PRIMARY KEY,
+				main TEXT,
+				serial TEXT,
+				sans TEXT,
+				email TEXT,
+				private_key BYTEA,
+				certificate BYTEA,
+				not_before_ts BIGINT,
+				not_after_ts BIGINT,
+				key_fingerprint TEXT,
+				issuer TEXT,
+				trigger TEXT,
+				created_ts BIGINT,
+				UNIQUE(main, serial)
+			);
+			CREATE TABLE IF NOT EXISTS client(
+				id BIGSERIAL PRIMARY KEY,
+				email TEXT UNIQUE,
+				uri TEXT,
+				private_key BYTEA,
+				upserted_ts BIGINT
+			);
+			CREATE TABLE IF NOT EXISTS webhook(
+				id BIGSERIAL PRIMARY KEY,
+				main TEXT UNIQUE,
+				url TEXT,
+				headers BYTEA
+			);
+			CREATE TABLE IF NOT EXISTS zone(
+				id BIGSERIAL PRIMARY KEY,
+				zone TEXT UNIQUE,
+				caa_managed BOOLEAN,
+				caa_account_uri BOOLEAN,
+				caa_records BYTEA,
+				upserted_ts BIGINT
+			);`,
+	},
+	{
+		// Certs stored before the identifier table existed
+		Version: 2,
+		Name:    "index cert identifiers",
+		Sqlite: `
+			WITH RECURSIVE split(main, identifier, rest) AS (
+				SELECT main, '', sans || ',' FROM certs WHERE main NOT IN (SELECT main FROM cert_identifier)
+				UNION ALL
+				SELECT main, lower(trim(substr(rest, 1, instr(rest, ',') - 1))), substr(rest, instr(rest, ',') + 1)
+				FROM split WHERE rest <> ''
+			)
+			INSERT OR IGNORE INTO cert_identifier(main, identifier)
+			SELECT main, identifier FROM split WHERE identifier <> '';`,
+		// PostgreSQL storage was introduced with the identifier table
+		Postgres: ``,
+	},
+	{
+		// Wrapped data key of an encrypted private key, NULL while stored as plaintext
+		Version: 3,
+		Name:    "add private key data keys",
+		Sqlite: `
+			ALTER TABLE certs ADD COLUMN data_key BLOB;
+			ALTER TABLE cert_version ADD COLUMN data_key BLOB;
+			ALTER TABLE client ADD COLUMN data_key BLOB;`,
+		Postgres: `
+			ALTER TABLE certs ADD COLUMN data_key BYTEA;
+			ALTER TABLE cert_version ADD COLUMN data_key BYTEA;
+			ALTER TABLE client ADD COLUMN data_key BYTEA;`,
+	},
+	{
+		// Time a cert was moved to the trash, NULL while it is active
+		Version: 4,
+		Name:    "add cert trash",
+		Sqlite: `
+			ALTER TABLE certs ADD COLUMN deleted_ts INTEGER;
+			CREATE INDEX IF NOT EXISTS certs_deleted_ts ON certs(deleted_ts);`,
+		Postgres: `
+			ALTER TABLE certs ADD COLUMN deleted_ts BIGINT;
+			CREATE INDEX IF NOT EXISTS certs_deleted_ts ON certs(deleted_ts);`,
+	},
+	{
+		// Serial and issuer of the current version, taken from the version holding the same certificate.
+		// Indexes serve the sorts and filters of the certs listing
+		Version: 5,
+		Name:    "add cert listing columns",
+		Sqlite: `
+			ALTER TABLE certs ADD COLUMN serial TEXT;
+			ALTER TABLE certs ADD COLUMN issuer TEXT;
+			UPDATE certs SET
+				serial = (SELECT serial FROM cert_version WHERE cert_version.main = certs.main AND cert_version.certificate = certs.certificate LIMIT 1),
+				issuer = (SELECT issuer FROM cert_version WHERE cert_version.main = certs.main AND cert_version.certificate = certs.certificate LIMIT 1);
+			CREATE INDEX IF NOT EXISTS certs_not_after_ts ON certs(not_after_ts, id);
+			CREATE INDEX IF NOT EXISTS certs_upserted_ts ON certs(upserted_ts, id);
+			CREATE INDEX IF NOT EXISTS certs_email ON certs(email);
+			CREATE INDEX IF NOT EXISTS certs_issuer ON certs(issuer);`,
+		Postgres: `
+			ALTER TABLE certs ADD COLUMN serial TEXT;
+			ALTER TABLE certs ADD COLUMN issuer TEXT;
+			UPDATE certs SET
+				serial = (SELECT serial FROM cert_version WHERE cert_version.main = certs.main AND cert_version.certificate = certs.certificate LIMIT 1),
+				issuer = (SELECT issuer FROM cert_version WHERE cert_version.main = certs.main AND cert_version.certificate = certs.certificate LIMIT 1);
+			CREATE INDEX IF NOT EXISTS certs_not_after_ts ON certs(not_after_ts, id);
+			CREATE INDEX IF NOT EXISTS certs_upserted_ts ON certs(upserted_ts, id);
+			CREATE INDEX IF NOT EXISTS certs_email ON certs(email);
+			CREATE INDEX IF NOT EXISTS certs_issuer ON certs(issuer);`,
+	},
+	{
+		// Labels of certs by main, kept while the cert is reissued, and webhooks of the certs matching a label selector
+		Version: 6,
+		Name:    "add cert labels",
+		Sqlite: `
+			ALTER TABLE certs ADD COLUMN notes TEXT;
+			CREATE TABLE IF NOT EXISTS cert_label(
+				id INTEGER PRIMARY KEY,
+				main TEXT,
+				key TEXT,
+				value TEXT,
+				UNIQUE(main, key)
+			);
+			CREATE INDEX IF NOT EXISTS cert_label_key_value ON cert_label(key, value);
+			CREATE TABLE IF NOT EXISTS webhook_selector(
+				id INTEGER PRIMARY KEY,
+				selector TEXT UNIQUE,
+				url TEXT,
+				headers BLOB
+			);`,
+		Postgres: `
+			ALTER TABLE certs ADD COLUMN notes TEXT;
+			CREATE TABLE IF NOT EXISTS cert_label(
+				id BIGSERIAL PRIMARY KEY,
+				main TEXT,
+				key TEXT,
+				value TEXT,
+				UNIQUE(main, key)
+			);
+			CREATE INDEX IF NOT EXISTS cert_label_key_value ON cert_label(key, value);
+			CREATE TABLE IF NOT EXISTS webhook_selector(
+				id BIGSERIAL PRIMARY KEY,
+				selector TEXT UNIQUE,
+				url TEXT,
+				headers BYTEA
+			);`,
+	},
+	{
+		// Filled on start for the certs stored before
+		Version: 7,
+		Name:    "add cert metadata",
+		Sqlite: `
+			ALTER TABLE certs ADD COLUMN metadata BLOB;`,
+		Postgres: `
+			ALTER TABLE certs ADD COLUMN metadata BYTEA;`,
+	},
+	{
+		// Version of a cert last held by each of its consumers
+		Version: 8,
+		Name:    "add cert consumers",
+		Sqlite: `
+			CREATE TABLE IF NOT EXISTS cert_consumer(
+				id INTEGER PRIMARY KEY,
+				main TEXT,
+				consumer TEXT,
+				serial TEXT,
+				seen_ts INTEGER,
+				UNIQUE(main, consumer)
+			);`,
+		Postgres: `
+			CREATE TABLE IF NOT EXISTS cert_consumer(
+				id BIGSERIAL PRIMARY KEY,
+				main TEXT,
+				consumer TEXT,
+				serial TEXT,
+				seen_ts BIGINT,
+				UNIQUE(main, consumer)
+			);`,
+	},
+	{
+		// EAB keys, accounts and orders of the ACME server, the HMAC key of an EAB key is kept in
+		// private_key so it is encrypted at rest like the other keys
+		Version: 9,
+		Name:    "add acme server",
+		Sqlite: `
+			CREATE TABLE IF NOT EXISTS acme_eab(
+				id INTEGER PRIMARY KEY,
+				key_id TEXT UNIQUE,
+				private_key BLOB,
+				data_key BLOB,
+				email TEXT,
+				domains TEXT,
+				created_ts INTEGER
+			);
+			CREATE TABLE IF NOT EXISTS acme_account(
+				id INTEGER PRIMARY KEY,
+				account_id TEXT UNIQUE,
+				thumbprint TEXT UNIQUE,
+				jwk BLOB,
+				key_id TEXT,
+				contact TEXT,
+				status TEXT,
+				created_ts INTEGER
+			);
+			CREATE TABLE IF NOT EXISTS acme_order(
+				id INTEGER PRIMARY KEY,
+				order_id TEXT UNIQUE,
+				account_id TEXT,
+				identifiers TEXT,
+				status TEXT,
+				error TEXT,
+				certificate BLOB,
+				serial TEXT,
+				expires_ts INTEGER,
+				created_ts INTEGER,
+				updated_ts INTEGER
+			);
+			CREATE INDEX IF NOT EXISTS acme_order_account_id ON acme_order(account_id, id);
+			CREATE INDEX IF NOT EXISTS acme_order_serial ON acme_order(serial);`,
+		Postgres: `
+			CREATE TABLE IF NOT EXISTS acme_eab(
+				id BIGSERIAL PRIMARY KEY,
+				key_id TEXT UNIQUE,
+				private_key BYTEA,
+				data_key BYTEA,
+				email TEXT,
+				domains TEXT,
+				created_ts BIGINT
+			);
+			CREATE TABLE IF NOT EXISTS acme_account(
+				id BIGSERIAL PRIMARY KEY,
+				account_id TEXT UNIQUE,
+				thumbprint TEXT UNIQUE,
+				jwk BYTEA,
+				key_id TEXT,
+				contact TEXT,
+				status TEXT,
+				created_ts BIGINT
+			);
+			CREATE TABLE IF NOT EXISTS acme_order(
+				id BIGSERIAL PRIMARY KEY,
+				order_id TEXT UNIQUE,
+				account_id TEXT,
+				identifiers TEXT,
+				status TEXT,
+				error TEXT,
+				certificate BYTEA,
+				serial TEXT,
+				expires_ts BIGINT,
+				created_ts BIGINT,
+				updated_ts BIGINT
+			);
+			CREATE INDEX IF NOT EXISTS acme_order_account_id ON acme_order(account_id, id);
+			CREATE INDEX IF NOT EXISTS acme_order_serial ON acme_order(serial);`,
+	},
+	{
+		// Key signing the nonces of the ACME server, shared by replicas, and the nonces used within their
+		// lifetime so each is accepted once
+		Version: 10,
+		Name:    "add acme nonces",
+		Sqlite: `
+			CREATE TABLE IF NOT EXISTS acme_nonce_key(
+				id INTEGER PRIMARY KEY,
+				name TEXT UNIQUE,
+				private_key BLOB,
+				data_key BLOB,
+				created_ts INTEGER
+			);
+			CREATE TABLE IF NOT EXISTS acme_nonce(
+				nonce TEXT PRIMARY KEY,
+				expires_ts INTEGER
+			);
+			CREATE INDEX IF NOT EXISTS acme_nonce_expires_ts ON acme_nonce(expires_ts);`,
+		Postgres: `
+			CREATE TABLE IF NOT EXISTS acme_nonce_key(
+				id BIGSERIAL PRIMARY KEY,
+				name TEXT UNIQUE,
+				private_key BYTEA,
+				data_key BYTEA,
+				created_ts BIGINT
+			);
+			CREATE TABLE IF NOT EXISTS acme_nonce(
+				nonce TEXT PRIMARY KEY,
+				expires_ts BIGINT
+			);
+			CREATE INDEX IF NOT EXISTS acme_nonce_expires_ts ON acme_nonce(expires_ts);`,
+	},
+	{
+		Version: 11,
+		Name:    "add challenge records",
+		Sqlite: `
+			CREATE TABLE IF NOT EXISTS challenge_record(
+				fqdn TEXT,
+				value TEXT,
+				created_ts INTEGER,
+				PRIMARY KEY(fqdn, value)
+			);`,
+		Postgres: `
+			CREATE TABLE IF NOT EXISTS challenge_record(
+				fqdn TEXT,
+				value TEXT,
+				created_ts BIGINT,
+				PRIMARY KEY(fqdn, value)
+			);`,
+	},
+}
@@ -0,0 +1,198 @@
+package repository
+
+import (
+	"errors"
+	"time"
+
+	acmeserverrepository "github.com/widhaprasa/go-acme-service/repository/acmeserver"
+	certsrepository "github.com/widhaprasa/go-acme-service/repository/certs"
+	clientrepository "github.com/widhaprasa/go-acme-service/repository/client"
+	"github.com/widhaprasa/go-acme-service/repository/migration"
+	"github.com/widhaprasa/go-acme-service/repository/sqldb"
+	webhookrepository "github.com/widhaprasa/go-acme-service/repository/webhook"
+	zonerepository "github.com/widhaprasa/go-acme-service/repository/zone"
+	"github.com/widhaprasa/go-acme-service/secret"
+)
+
+// Storage backends
+const (
+	StorageSqlite   = sqldb.Sqlite
+	StoragePostgres = sqldb.Postgres
+	StorageMemory   = "memory"
+)
+
+// Repositories groups the repositories of one storage backend
+type Repositories struct {
+	Certs      certsrepository.CertsRepository
+	Client     clientrepository.ClientRepository
+	Webhook    webhookrepository.WebhookRepository
+	Zone       zonerepository.ZoneRepository
+	AcmeServer acmeserverrepository.AcmeServerRepository
+	Db         *sqldb.DB
+
+	// CertsWatcher is notified of the upserts made through Certs
+	CertsWatcher certsrepository.Watcher
+}
+
+// Open returns the repositories of storage, upgrading the schema when backed by a database.
+// dsn is the file path for SQLite and the connection string for PostgreSQL. Private keys are
+// encrypted at rest with keyring unless it is nil
+func Open(storage string, dsn string, keyring *secret.Keyring) (*Repositories, error) {
+
+	if storage == StorageMemory {
+		return NewMemory(), nil
+	}
+
+	db, err := OpenDb(storage, dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	migrator := migration.Migrator{
+		Db:   db,
+		Path: dsn,
+	}
+	err = migrator.Upgrade(time.Now().UnixMilli())
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return NewSql(db, keyring), nil
+}
+
+// OpenDb opens the database of storage as is, without migrating
+func OpenDb(storage string, dsn string) (*sqldb.DB, error) {
+
+	switch storage {
+	case StorageSqlite, "":
+		return sqldb.Open(sqldb.Sqlite, dsn)
+	case StoragePostgres:
+		if dsn == "" {
+			return nil, errors.New("PostgreSQL storage requires a DSN")
+		}
+		return sqldb.Open(sqldb.Postgres, dsn)
+	}
+
+	return nil, errors.New("Unknown storage: " + storage)
+}
+
+// NewMemory returns repositories kept in memory, nothing is persisted
+func NewMemory() *Repositories {
+
+	webhookRepository := webhookrepository.NewMemoryWebhookRepository()
+	memoryCertsRepository := certsrepository.NewMemoryCertsRepository()
+	memoryCertsRepository.Webhooks = webhookRepository
+	certsRepository := certsrepository.NewWatchedCertsRepository(memoryCertsRepository)
+
+	return &Repositories{
+		Certs:        certsRepository,
+		Client:       clientrepository.NewMemoryClientRepository(),
+		Webhook:      webhookRepository,
+		Zone:         zonerepository.NewMemoryZoneRepository(),
+		AcmeServer:   acmeserverrepository.NewMemoryAcmeServerRepository(),
+		CertsWatcher: certsRepository,
+	}
+}
+
+// NewSql returns the repositories of db as is, without migrating
+func NewSql(db *sqldb.DB, keyring *secret.Keyring) *Repositories {
+
+	certsRepository := certsrepository.NewWatchedCertsRepository(&certsrepository.SqlCertsRepository{
+		Db:      db,
+		Keyring: keyring,
+	})
+	clientRepository := &clientrepository.SqlClientRepository{
+		Db:      db,
+		Keyring: keyring,
+	}
+	webhookRepository := &webhookrepository.SqlWebhookRepository{
+		Db: db,
+	}
+	zoneRepository := &zonerepository.SqlZoneRepository{
+		Db: db,
+	}
+	acmeServerRepository := &acmeserverrepository.SqlAcmeServerRepository{
+		Db:      db,
+		Keyring: keyring,
+	}
+
+	return &Repositories{
+		Certs:      certsRepository,
+		Client:     clientRepository,
+		Webhook:    webhookRepository,
+		Zone:       zoneRepository,
+		AcmeServer: acmeServerRepository,
+		Db:         db,
+
+		CertsWatcher: certsRepository,
+	}
+}
+
+func (r *Repositories) Close() error {
+
+	if r.Db == nil {
+		return nil
+	}
+	return r.Db.Close()
+}
+
+// EncryptPrivateKeys seals every private key of db still stored as plaintext
+func EncryptPrivateKeys(db *sqldb.DB, keyring *secret.Keyring) (int, error) {
+
+	certsRepository := &certsrepository.SqlCertsRepository{
+		Db:      db,
+		Keyring: keyring,
+	}
+	count, err := certsRepository.EncryptPrivateKeys()
+	if err != nil {
+		return count, err
+	}
+
+	clientRepository := &clientrepository.SqlClientRepository{
+		Db:      db,
+		Keyring: keyring,
+	}
+	clientCount, err := clientRepository.EncryptPrivateKeys()
+	count += clientCount
+	if err != nil {
+		return count, err
+	}
+
+	acmeServerRepository := &acmeserverrepository.SqlAcmeServerRepository{
+		Db:      db,
+		Keyring: keyring,
+	}
+	eabCount, err := acmeServerRepository.EncryptPrivateKeys()
+	return count + eabCount, err
+}
+
+// RewrapDataKeys wraps every data key of db with the current master key of keyring
+func RewrapDataKeys(db *sqldb.DB, keyring *secret.Keyring) (int, error) {
+
+	certsRepository := &certsrepository.SqlCertsRepository{
+		Db:      db,
+		Keyring: keyring,
+	}
+	count, err := certsRepository.RewrapDataKeys()
+	if err != nil {
+		return count, err
+	}
+
+	clientRepository := &clientrepository.SqlClientRepository{
+		Db:      db,
+		Keyring: keyring,
+	}
+	clientCount, err := clientRepository.RewrapDataKeys()
+	count += clientCount
+	if err != nil {
+		return count, err
+	}
+
+	acmeServerRepository := &acmeserverrepository.SqlAcmeServerRepository{
+		Db:      db,
+		Keyring: keyring,
+	}
+	eabCount, err := acmeServerRepository.RewrapDataKeys()
+	return count + eabCount, err
+}
@@ -0,0 +1,388 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	certsrepository "github.com/widhaprasa/go-acme-service/repository/certs"
+	clientrepository "github.com/widhaprasa/go-acme-service/repository/client"
+	webhookrepository "github.com/widhaprasa/go-acme-service/repository/webhook"
+)
+
+// eachStorage runs test against the memory repositories and a new SQLite database
+func eachStorage(t *testing.T, test func(t *testing.T, repositories *Repositories)) {
+
+	t.Run("memory", func(t *testing.T) {
+		test(t, NewMemory())
+	})
+	t.Run("sqlite", func(t *testing.T) {
+		repositories, err := Open(StorageSqlite, filepath.Join(t.TempDir(), "acme.db"), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { repositories.Close() })
+		test(t, repositories)
+	})
+}
+
+func TestGetCerts(t *testing.T) {
+
+	eachStorage(t, func(t *testing.T, repositories *Repositories) {
+
+		for _, cert := range []certsrepository.Cert{
+			{Main: "xn--bcher-kva.example", Sans: []string{"xn--bcher-kva.example"}},
+			{Main: "example.com", Sans: []string{"example.com", "www.example.com"}},
+			{Main: "www.example.com", Sans: []string{"www.example.com"}},
+			{Main: "*.example.org", Sans: []string{"*.example.org"}},
+		} {
+			_, err := repositories.Certs.UpsertCerts(cert, certsrepository.VersionInfo{Serial: cert.Main})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		for domain, main := range map[string]string{
+			"bücher.example":        "xn--bcher-kva.example",
+			"BÜCHER.example.":       "xn--bcher-kva.example",
+			"xn--bcher-kva.example": "xn--bcher-kva.example",
+			"Example.com":           "example.com",
+		} {
+			cert, err := repositories.Certs.GetCerts(domain, false)
+			if err != nil || cert.Main != main {
+				t.Errorf("%s: got %q %v, want %s", domain, cert.Main, err, main)
+			}
+		}
+
+		cert, err := repositories.Certs.GetCerts("foo.example.org", true)
+		if err != nil || cert.Main != "*.example.org" {
+			t.Errorf("wildcard: got %q %v", cert.Main, err)
+		}
+
+		// Even the cert named after the domain does not settle a conflict
+		_, err = repositories.Certs.GetCerts("www.example.com", false)
+		var conflictErr *certsrepository.ConflictError
+		if !errors.As(err, &conflictErr) || len(conflictErr.Candidates) != 2 {
+			t.Errorf("conflict: got %v, want both candidates", err)
+		}
+
+		// Trashed certs are looked up the same way
+		_, err = repositories.Certs.TrashCerts("xn--bcher-kva.example", 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, err = repositories.Certs.GetTrashedCerts("BÜCHER.example.")
+		if err != nil || cert.Main != "xn--bcher-kva.example" {
+			t.Errorf("trashed: got %q %v", cert.Main, err)
+		}
+	})
+}
+
+// upsertListedCerts stores certs of mains, sharing their expiry by groups of three so sort keys are equal
+func upsertListedCerts(t *testing.T, repositories *Repositories, mains []string) {
+
+	t.Helper()
+	for i, main := range mains {
+		_, err := repositories.Certs.UpsertCerts(certsrepository.Cert{
+			Main:       main,
+			Sans:       []string{main},
+			NotAfterTs: int64(1000 * (i / 3)),
+			UpsertedTs: 1,
+		}, certsrepository.VersionInfo{Serial: main})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestListCertsPage(t *testing.T) {
+
+	eachStorage(t, func(t *testing.T, repositories *Repositories) {
+
+		mains := []string{"g.com", "c.com", "e.com", "a.com", "f.com", "b.com", "d.com"}
+		upsertListedCerts(t, repositories, mains)
+
+		for _, test := range []struct {
+			sort string
+			desc bool
+		}{
+			{certsrepository.SortNotAfter, false},
+			{certsrepository.SortNotAfter, true},
+			{certsrepository.SortUpserted, false},
+			{certsrepository.SortMain, true},
+		} {
+			query := certsrepository.CertsQuery{Sort: test.sort, Desc: test.desc, Limit: 2}
+
+			// Walking the pages lists every cert once, ordered by the sort key then id
+			listed := []certsrepository.Cert{}
+			for pages := 0; ; pages++ {
+				if pages > len(mains) {
+					t.Fatalf("%s: cursor does not advance", test.sort)
+				}
+				page, err := repositories.Certs.ListCertsPage(query)
+				if err != nil {
+					t.Fatal(err)
+				}
+				listed = append(listed, page.Certs...)
+				if page.NextCursor == "" {
+					break
+				}
+				query.Cursor = page.NextCursor
+			}
+
+			if len(listed) != len(mains) {
+				t.Fatalf("%s desc %v: listed %d certs, want %d", test.sort, test.desc, len(listed), len(mains))
+			}
+			seen := map[string]bool{}
+			for i, cert := range listed {
+				if seen[cert.Main] {
+					t.Fatalf("%s desc %v: %s listed twice", test.sort, test.desc, cert.Main)
+				}
+				seen[cert.Main] = true
+				if i == 0 {
+					continue
+				}
+				previous := listed[i-1]
+				var ordered bool
+				switch test.sort {
+				case certsrepository.SortNotAfter:
+					ordered = previous.NotAfterTs < cert.NotAfterTs || previous.NotAfterTs == cert.NotAfterTs && previous.Id < cert.Id
+				case certsrepository.SortUpserted:
+					ordered = previous.Id < cert.Id
+				case certsrepository.SortMain:
+					ordered = previous.Main < cert.Main
+				}
+				if test.desc {
+					ordered = !ordered
+				}
+				if !ordered {
+					t.Fatalf("%s desc %v: %s listed before %s", test.sort, test.desc, previous.Main, cert.Main)
+				}
+			}
+		}
+
+		// A cursor is only valid for the listing that issued it
+		page, _ := repositories.Certs.ListCertsPage(certsrepository.CertsQuery{Sort: certsrepository.SortNotAfter, Limit: 2})
+		for _, query := range []certsrepository.CertsQuery{
+			{Sort: certsrepository.SortMain, Cursor: page.NextCursor},
+			{Sort: certsrepository.SortNotAfter, Desc: true, Cursor: page.NextCursor},
+			{Cursor: "invalid"},
+		} {
+			_, err := repositories.Certs.ListCertsPage(query)
+			if !errors.Is(err, certsrepository.ErrInvalidCursor) {
+				t.Errorf("cursor of another listing: got %v", err)
+			}
+		}
+	})
+}
+
+func TestLabelSelectors(t *testing.T) {
+
+	eachStorage(t, func(t *testing.T, repositories *Repositories) {
+
+		upsertListedCerts(t, repositories, []string{"a.com", "b.com", "c.com", "d.com"})
+		for main, labels := range map[string]map[string]string{
+			"a.com": {"env": "prod", "team": "web"},
+			"b.com": {"env": "prod", "team": "api"},
+			"c.com": {"env": "dev"},
+		} {
+			err := repositories.Certs.UpdateLabels(main, labels, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		for selector, want := range map[string]string{
+			"env=prod":            "a.com,b.com",
+			"env==prod,team!=web": "b.com",
+			"team":                "a.com,b.com",
+			"!team":               "c.com,d.com",
+			"env!=prod":           "c.com,d.com",
+			"!env":                "d.com",
+			"env=prod, !team":     "",
+			"":                    "a.com,b.com,c.com,d.com",
+		} {
+			labels, err := certsrepository.ParseSelector(selector)
+			if err != nil {
+				t.Fatal(err)
+			}
+			page, err := repositories.Certs.ListCertsPage(certsrepository.CertsQuery{Labels: labels})
+			if err != nil {
+				t.Fatal(err)
+			}
+			mains := []string{}
+			for _, cert := range page.Certs {
+				mains = append(mains, cert.Main)
+			}
+			if got := strings.Join(mains, ","); got != want {
+				t.Errorf("%q: listed %s, want %s", selector, got, want)
+			}
+		}
+	})
+
+	for _, selector := range []string{"=prod", "bad key=x", "-env", "env=" + strings.Repeat("x", 257)} {
+		_, err := certsrepository.ParseSelector(selector)
+		if err == nil {
+			t.Errorf("%q: parsed, want an error", selector)
+		}
+	}
+}
+
+// rowsAffected returns a function reporting the rows affected by a result, failing on its error
+func rowsAffected(t *testing.T) func(result sql.Result, err error) int64 {
+
+	return func(result sql.Result, err error) int64 {
+
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+		count, err := result.RowsAffected()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return count
+	}
+}
+
+func TestRepositoriesParity(t *testing.T) {
+
+	eachStorage(t, func(t *testing.T, repositories *Repositories) {
+
+		// Missing rows are reported as sql.ErrNoRows
+		if _, err := repositories.Client.GetClient("a@example.com"); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("GetClient: got %v, want sql.ErrNoRows", err)
+		}
+		if _, err := repositories.Webhook.GetWebhook("example.com"); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("GetWebhook: got %v, want sql.ErrNoRows", err)
+		}
+		if _, err := repositories.Certs.GetCertsByMain("example.com"); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("GetCertsByMain: got %v, want sql.ErrNoRows", err)
+		}
+
+		// Upserting twice keeps the id
+		account := clientrepository.Account{Email: "a@example.com", Uri: "https://ca/acct/1", PrivateKey: []byte("key")}
+		rowsAffected(t)(repositories.Client.UpsertClient(account))
+		first, err := repositories.Client.GetClient(account.Email)
+		if err != nil {
+			t.Fatal(err)
+		}
+		account.Uri = "https://ca/acct/2"
+		rowsAffected(t)(repositories.Client.UpsertClient(account))
+		second, err := repositories.Client.GetClient(account.Email)
+		if err != nil || second.Id != first.Id || second.Uri != account.Uri || string(second.PrivateKey) != "key" {
+			t.Errorf("UpsertClient: got %+v %v, first %+v", second, err, first)
+		}
+		if count := rowsAffected(t)(repositories.Client.DeleteClient(account.Email)); count != 1 {
+			t.Errorf("DeleteClient: %d rows, want 1", count)
+		}
+		if count := rowsAffected(t)(repositories.Client.DeleteClient(account.Email)); count != 0 {
+			t.Errorf("DeleteClient again: %d rows, want 0", count)
+		}
+
+		webhook := webhookrepository.Webhook{Main: "example.com", Url: "https://hook", Headers: map[string]any{"X-Token": "t"}}
+		rowsAffected(t)(repositories.Webhook.UpsertWebhook(webhook))
+		webhooks, err := repositories.Webhook.MapWebhook([]string{"example.com", "other.com"})
+		if err != nil || len(webhooks) != 1 || webhooks["example.com"].Url != webhook.Url || webhooks["example.com"].Headers["X-Token"] != "t" {
+			t.Errorf("MapWebhook: got %v %v", webhooks, err)
+		}
+		if count := rowsAffected(t)(repositories.Webhook.DeleteWebhook(webhook.Main)); count != 1 {
+			t.Errorf("DeleteWebhook: %d rows, want 1", count)
+		}
+
+		// Trash, restore and purge only affect certs in the expected state
+		_, err = repositories.Certs.UpsertCerts(certsrepository.Cert{
+			Main: "example.com",
+			Sans: []string{"example.com", "www.example.com"},
+		}, certsrepository.VersionInfo{Serial: "01"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count := rowsAffected(t)(repositories.Certs.PurgeCerts("example.com", 10)); count != 0 {
+			t.Errorf("PurgeCerts live: %d rows, want 0", count)
+		}
+		if count := rowsAffected(t)(repositories.Certs.TrashCerts("example.com", 5)); count != 1 {
+			t.Errorf("TrashCerts: %d rows, want 1", count)
+		}
+		if count := rowsAffected(t)(repositories.Certs.TrashCerts("example.com", 6)); count != 0 {
+			t.Errorf("TrashCerts again: %d rows, want 0", count)
+		}
+		if _, err := repositories.Certs.GetCerts("www.example.com", false); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("GetCerts trashed: got %v, want sql.ErrNoRows", err)
+		}
+		trashed, err := repositories.Certs.GetTrashedCerts("www.example.com")
+		if err != nil || trashed.Main != "example.com" || trashed.DeletedTs != 5 {
+			t.Errorf("GetTrashedCerts: got %+v %v", trashed, err)
+		}
+		if count := rowsAffected(t)(repositories.Certs.RestoreCerts("example.com")); count != 1 {
+			t.Errorf("RestoreCerts: %d rows, want 1", count)
+		}
+		rowsAffected(t)(repositories.Certs.TrashCerts("example.com", 5))
+		if count := rowsAffected(t)(repositories.Certs.PurgeCerts("example.com", 4)); count != 0 {
+			t.Errorf("PurgeCerts before deletion: %d rows, want 0", count)
+		}
+		if count := rowsAffected(t)(repositories.Certs.PurgeCerts("example.com", 5)); count != 1 {
+			t.Errorf("PurgeCerts: %d rows, want 1", count)
+		}
+		versions, err := repositories.Certs.ListVersions("example.com")
+		if err != nil || len(versions) != 0 {
+			t.Errorf("ListVersions purged: got %v %v", versions, err)
+		}
+	})
+}
+
+func TestPurgeCertsWebhook(t *testing.T) {
+
+	eachStorage(t, func(t *testing.T, repositories *Repositories) {
+
+		for _, main := range []string{"a.com", "b.com"} {
+			_, err := repositories.Certs.UpsertCerts(certsrepository.Cert{Main: main, Sans: []string{main}},
+				certsrepository.VersionInfo{Serial: main})
+			if err == nil {
+				_, err = repositories.Webhook.UpsertWebhook(webhookrepository.Webhook{Main: main, Url: "https://hook"})
+			}
+			if err == nil {
+				_, err = repositories.Certs.TrashCerts(main, 5)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// The webhook is deleted along with the purged cert only
+		if count := rowsAffected(t)(repositories.Certs.PurgeCerts("a.com", 5)); count != 1 {
+			t.Fatalf("PurgeCerts: %d rows, want 1", count)
+		}
+		if _, err := repositories.Webhook.GetWebhook("a.com"); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("GetWebhook purged: got %v, want sql.ErrNoRows", err)
+		}
+		if _, err := repositories.Webhook.GetWebhook("b.com"); err != nil {
+			t.Errorf("GetWebhook trashed: %v", err)
+		}
+	})
+}
+
+func TestListCertsIssuer(t *testing.T) {
+
+	eachStorage(t, func(t *testing.T, repositories *Repositories) {
+
+		for main, issuer := range map[string]string{"a.com": "R11", "b.com": "E6", "c.com": "R11"} {
+			_, err := repositories.Certs.UpsertCerts(certsrepository.Cert{Main: main, Sans: []string{main}},
+				certsrepository.VersionInfo{Serial: main, Issuer: issuer})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// Filtered on the issuer reported by the listing
+		page, err := repositories.Certs.ListCertsPage(certsrepository.CertsQuery{Issuer: "R11"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page.Certs) != 2 || page.Certs[0].Main != "a.com" || page.Certs[1].Main != "c.com" || page.Certs[0].Issuer != "R11" {
+			t.Errorf("issuer R11: got %+v", page.Certs)
+		}
+	})
+}
@@ -0,0 +1,104 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// ErrSnapshotUnsupported is returned when the database cannot take an online snapshot
+var ErrSnapshotUnsupported = errors.New("Online snapshot is only supported for SQLite, back up PostgreSQL with pg_dump")
+
+// Snapshot copies the database to a new SQLite file at path with the online backup API, writers are
+// only blocked while a step copies pages
+func (d *DB) Snapshot(ctx context.Context, path string) error {
+
+	if d.Dialect != Sqlite {
+		return ErrSnapshotUnsupported
+	}
+	if _, err := os.Stat(path); err == nil {
+		return errors.New("Snapshot " + path + " already exists")
+	}
+
+	dest, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return err
+	}
+	defer dest.Close()
+
+	destConn, err := dest.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer destConn.Close()
+
+	srcConn, err := d.DB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer srcConn.Close()
+
+	return destConn.Raw(func(destDriverConn any) error {
+		return srcConn.Raw(func(srcDriverConn any) error {
+
+			destSqlite, ok := destDriverConn.(*sqlite3.SQLiteConn)
+			if !ok {
+				return ErrSnapshotUnsupported
+			}
+			srcSqlite, ok := srcDriverConn.(*sqlite3.SQLiteConn)
+			if !ok {
+				return ErrSnapshotUnsupported
+			}
+
+			backup, err := destSqlite.Backup("main", srcSqlite, "main")
+			if err != nil {
+				return err
+			}
+
+			// Copy a batch of pages per step so writers are not held off for the whole copy
+			for {
+				done, err := backup.Step(256)
+				if err != nil {
+					backup.Finish()
+					return err
+				}
+				if done {
+					break
+				}
+				select {
+				case <-ctx.Done():
+					backup.Finish()
+					return ctx.Err()
+				case <-time.After(10 * time.Millisecond):
+				}
+			}
+
+			return backup.Finish()
+		})
+	})
+}
+
+// CheckIntegrity runs the SQLite integrity check on the database file at path
+func CheckIntegrity(path string) error {
+
+	db, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var result string
+	err = db.QueryRow("PRAGMA integrity_check").Scan(&result)
+	if err != nil {
+		return err
+	}
+	if result != "ok" {
+		return errors.New("Integrity check of " + path + " failed: " + result)
+	}
+
+	return nil
+}
@@ -0,0 +1,104 @@
+package sqldb
+
+import (
+	"github.com/widhaprasa/go-acme-service/secret"
+)
+
+type plaintextRow struct {
+	id         int64
+	keys       []string
+	privateKey []byte
+}
+
+// EncryptRows seals every plaintext private_key of table, keyColumns identify the row in its AAD
+func EncryptRows(db *DB, keyring *secret.Keyring, table string, keyColumns ...string) (int, error) {
+
+	columns := ""
+	for _, column := range keyColumns {
+		columns += column + ", "
+	}
+
+	rows, err := db.Query("SELECT id, " + columns + "private_key FROM " + table + " WHERE data_key IS NULL AND private_key IS NOT NULL")
+	if err != nil {
+		return 0, err
+	}
+
+	var list []plaintextRow
+	for rows.Next() {
+		item := plaintextRow{keys: make([]string, len(keyColumns))}
+		dest := []any{&item.id}
+		for i := range item.keys {
+			dest = append(dest, &item.keys[i])
+		}
+		dest = append(dest, &item.privateKey)
+
+		err = rows.Scan(dest...)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		list = append(list, item)
+	}
+	rows.Close()
+
+	count := 0
+	for _, item := range list {
+		privateKey, dataKey, err := keyring.Seal(item.privateKey, secret.AAD(table, item.keys...))
+		if err != nil {
+			return count, err
+		}
+
+		// A row written meanwhile is already sealed
+		_, err = db.Exec("UPDATE "+table+" SET private_key = ?, data_key = ? WHERE id = ? AND data_key IS NULL",
+			privateKey, dataKey, item.id)
+		if err != nil {
+			return count, err
+		}
+		count++
+	}
+
+	return count, nil
+}
+
+// RewrapRows wraps the data keys of table with the current master key of keyring
+func RewrapRows(db *DB, keyring *secret.Keyring, table string) (int, error) {
+
+	rows, err := db.Query("SELECT id, data_key FROM " + table + " WHERE data_key IS NOT NULL")
+	if err != nil {
+		return 0, err
+	}
+
+	dataKeys := map[int64][]byte{}
+	for rows.Next() {
+		var id int64
+		var dataKey []byte
+		err = rows.Scan(&id, &dataKey)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		dataKeys[id] = dataKey
+	}
+	rows.Close()
+
+	count := 0
+	for id, dataKey := range dataKeys {
+		rewrapped, changed, err := keyring.Rewrap(dataKey)
+		if err != nil {
+			return count, err
+		}
+		if !changed {
+			continue
+		}
+
+		// A row sealed again meanwhile has another data key and is left alone
+		_, err = db.Exec("UPDATE "+table+" SET data_key = ? WHERE id = ? AND data_key = ?",
+			rewrapped, id, dataKey)
+		if err != nil {
+			return count, err
+		}
+		count++
+	}
+
+	return count, nil
+}
@@ -0,0 +1,129 @@
+package sqldb
+
+import (
+	"crypto/rand"
+	"path/filepath"
+	"testing"
+
+	"github.com/widhaprasa/go-acme-service/secret"
+)
+
+func newTestKeyring(t *testing.T, previous ...[]byte) (*secret.Keyring, []byte) {
+
+	t.Helper()
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyring, err := secret.NewKeyring(key, previous...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keyring, key
+}
+
+// newTestAccounts returns a database with an account table holding plaintext private keys
+func newTestAccounts(t *testing.T) *DB {
+
+	t.Helper()
+	db, err := Open(Sqlite, filepath.Join(t.TempDir(), "acme.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	_, err = db.Exec("CREATE TABLE account (id INTEGER PRIMARY KEY, email TEXT, private_key BLOB, data_key BLOB)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, email := range []string{"a@example.com", "b@example.com"} {
+		_, err = db.Exec("INSERT INTO account (email, private_key) VALUES (?, ?)", email, []byte("key of "+email))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err = db.Exec("INSERT INTO account (email) VALUES (?)", "none@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+// openAccount opens the private key of email with keyring
+func openAccount(t *testing.T, db *DB, keyring *secret.Keyring, email string) (string, error) {
+
+	t.Helper()
+	var privateKey, dataKey []byte
+	err := db.QueryRow("SELECT private_key, data_key FROM account WHERE email = ?", email).Scan(&privateKey, &dataKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dataKey == nil {
+		t.Fatalf("%s: private key is not encrypted", email)
+	}
+	plaintext, err := keyring.OpenValue(privateKey, dataKey, secret.AAD("account", email))
+	return string(plaintext), err
+}
+
+func TestEncryptRows(t *testing.T) {
+
+	db := newTestAccounts(t)
+	keyring, _ := newTestKeyring(t)
+
+	count, err := EncryptRows(db, keyring, "account", "email")
+	if err != nil || count != 2 {
+		t.Fatalf("EncryptRows: got %d %v, want 2", count, err)
+	}
+	for _, email := range []string{"a@example.com", "b@example.com"} {
+		plaintext, err := openAccount(t, db, keyring, email)
+		if err != nil || plaintext != "key of "+email {
+			t.Errorf("%s: got %q %v", email, plaintext, err)
+		}
+	}
+
+	// Rows already encrypted are left alone
+	count, err = EncryptRows(db, keyring, "account", "email")
+	if err != nil || count != 0 {
+		t.Errorf("EncryptRows again: got %d %v, want 0", count, err)
+	}
+	plaintext, err := openAccount(t, db, keyring, "a@example.com")
+	if err != nil || plaintext != "key of a@example.com" {
+		t.Errorf("after EncryptRows again: got %q %v", plaintext, err)
+	}
+}
+
+func TestRewrapRows(t *testing.T) {
+
+	db := newTestAccounts(t)
+	oldKeyring, oldKey := newTestKeyring(t)
+	_, err := EncryptRows(db, oldKeyring, "account", "email")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyring, newKey := newTestKeyring(t, oldKey)
+	count, err := RewrapRows(db, keyring, "account")
+	if err != nil || count != 2 {
+		t.Fatalf("RewrapRows: got %d %v, want 2", count, err)
+	}
+
+	// Rewrapped rows open with the new master key alone and no longer with the old one
+	newKeyring, err := secret.NewKeyring(newKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext, err := openAccount(t, db, newKeyring, "b@example.com")
+	if err != nil || plaintext != "key of b@example.com" {
+		t.Errorf("new key: got %q %v", plaintext, err)
+	}
+	if _, err = openAccount(t, db, oldKeyring, "b@example.com"); err == nil {
+		t.Error("old key still opens the rewrapped row")
+	}
+
+	count, err = RewrapRows(db, keyring, "account")
+	if err != nil || count != 0 {
+		t.Errorf("RewrapRows again: got %d %v, want 0", count, err)
+	}
+}
@@ -0,0 +1,173 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// SQL dialects
+const (
+	Sqlite   = "sqlite"
+	Postgres = "postgres"
+)
+
+// DB is a database written with ? placeholders, rebound to the dialect on every query
+type DB struct {
+	*sql.DB
+	Dialect string
+}
+
+func Open(dialect string, dsn string) (*DB, error) {
+
+	var driverName string
+	switch dialect {
+	case Sqlite:
+		driverName = "sqlite3"
+	case Postgres:
+		driverName = "postgres"
+	default:
+		return nil, errors.New("Unknown SQL dialect: " + dialect)
+	}
+
+	db, err := sql.Open(driverName, dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DB{DB: db, Dialect: dialect}, nil
+}
+
+// FilePath returns the file of an SQLite DSN, which is either a path or a file: URI with parameters,
+// empty for an in-memory database
+func FilePath(dsn string) string {
+
+	path, query, _ := strings.Cut(dsn, "?")
+	if !strings.HasPrefix(path, "file:") {
+		if path == ":memory:" {
+			return ""
+		}
+		return dsn
+	}
+
+	path = strings.TrimPrefix(path, "file:")
+	if strings.HasPrefix(path, "//") {
+		// Authority of file://host/path, only the local host is allowed by SQLite
+		_, path, _ = strings.Cut(strings.TrimPrefix(path, "//"), "/")
+		path = "/" + path
+	}
+	if unescaped, err := url.PathUnescape(path); err == nil {
+		path = unescaped
+	}
+
+	values, _ := url.ParseQuery(query)
+	if path == "" || path == ":memory:" || values.Get("mode") == "memory" {
+		return ""
+	}
+	return path
+}
+
+func (d *DB) Exec(query string, args ...any) (sql.Result, error) {
+	return d.DB.Exec(Rebind(d.Dialect, query), args...)
+}
+
+func (d *DB) Query(query string, args ...any) (*sql.Rows, error) {
+	return d.DB.Query(Rebind(d.Dialect, query), args...)
+}
+
+func (d *DB) QueryRow(query string, args ...any) *sql.Row {
+	return d.DB.QueryRow(Rebind(d.Dialect, query), args...)
+}
+
+func (d *DB) Prepare(query string) (*sql.Stmt, error) {
+	return d.DB.Prepare(Rebind(d.Dialect, query))
+}
+
+func (d *DB) Begin() (*Tx, error) {
+
+	tx, err := d.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Tx{Tx: tx, Dialect: d.Dialect}, nil
+}
+
+// Lock holds a lock shared by every process using the database until the returned func is called,
+// PostgreSQL uses an advisory lock while SQLite already serializes writers
+func (d *DB) Lock(ctx context.Context, key int64) (func(), error) {
+
+	if d.Dialect != Postgres {
+		return func() {}, nil
+	}
+
+	conn, err := d.DB.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", key)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return func() {
+		conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", key)
+		conn.Close()
+	}, nil
+}
+
+type Tx struct {
+	*sql.Tx
+	Dialect string
+}
+
+func (t *Tx) Exec(query string, args ...any) (sql.Result, error) {
+	return t.Tx.Exec(Rebind(t.Dialect, query), args...)
+}
+
+func (t *Tx) Query(query string, args ...any) (*sql.Rows, error) {
+	return t.Tx.Query(Rebind(t.Dialect, query), args...)
+}
+
+func (t *Tx) QueryRow(query string, args ...any) *sql.Row {
+	return t.Tx.QueryRow(Rebind(t.Dialect, query), args...)
+}
+
+// Rebind replaces ? placeholders outside of quoted strings with $1, $2... for PostgreSQL
+func Rebind(dialect string, query string) string {
+
+	if dialect != Postgres || !strings.Contains(query, "?") {
+		return query
+	}
+
+	var sb strings.Builder
+	sb.Grow(len(query) + 8)
+
+	n := 0
+	var quote rune
+	for _, r := range query {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"':
+			quote = r
+		case r == '?':
+			n++
+			sb.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		sb.WriteRune(r)
+	}
+
+	return sb.String()
+}
@@ -0,0 +1,51 @@
+package sqldb
+
+import "testing"
+
+func TestFilePath(t *testing.T) {
+
+	tests := []struct {
+		dsn  string
+		want string
+	}{
+		{"acme.db", "acme.db"},
+		{"/var/lib/acme/acme.db", "/var/lib/acme/acme.db"},
+		{"file:acme.db", "acme.db"},
+		{"file:acme.db?_fk=1&cache=shared", "acme.db"},
+		{"file:/var/lib/acme/acme%20v2.db?_fk=1", "/var/lib/acme/acme v2.db"},
+		{"file:///var/lib/acme/acme.db?_fk=1", "/var/lib/acme/acme.db"},
+		{"file://localhost/var/lib/acme/acme.db", "/var/lib/acme/acme.db"},
+		{":memory:", ""},
+		{"file::memory:?cache=shared", ""},
+		{"file:acme?mode=memory&cache=shared", ""},
+	}
+	for _, test := range tests {
+		if got := FilePath(test.dsn); got != test.want {
+			t.Errorf("FilePath(%q) = %q, want %q", test.dsn, got, test.want)
+		}
+	}
+}
+
+func TestRebind(t *testing.T) {
+
+	tests := []struct {
+		dialect string
+		query   string
+		want    string
+	}{
+		{Sqlite, "SELECT * FROM certs WHERE main = ?", "SELECT * FROM certs WHERE main = ?"},
+		{Postgres, "SELECT * FROM certs", "SELECT * FROM certs"},
+		{Postgres, "SELECT * FROM certs WHERE main = ?", "SELECT * FROM certs WHERE main = $1"},
+		{Postgres, "UPDATE certs SET sans = ?, ts = ? WHERE main = ?", "UPDATE certs SET sans = $1, ts = $2 WHERE main = $3"},
+		{Postgres, "SELECT main FROM certs WHERE main IN (?,?,?,?,?,?,?,?,?,?)", "SELECT main FROM certs WHERE main IN ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10)"},
+		{Postgres, "SELECT '?' FROM certs WHERE main = ?", "SELECT '?' FROM certs WHERE main = $1"},
+		{Postgres, `SELECT "a?" FROM certs WHERE main = ?`, `SELECT "a?" FROM certs WHERE main = $1`},
+		{Postgres, "SELECT 'it''s ?' WHERE a = ? AND b = '?'", "SELECT 'it''s ?' WHERE a = $1 AND b = '?'"},
+		{Postgres, "SELECT ? || 'ü?' || ?", "SELECT $1 || 'ü?' || $2"},
+	}
+	for _, test := range tests {
+		if got := Rebind(test.dialect, test.query); got != test.want {
+			t.Errorf("Rebind(%q, %q) = %q, want %q", test.dialect, test.query, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package webhook
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"sort"
+	"sync"
+)
+
+// MemoryWebhookRepository keeps webhooks in memory, used for tests and the embedded mode
+type MemoryWebhookRepository struct {
+	mu        sync.RWMutex
+	webhooks  map[string]Webhook
+	selectors map[string]SelectorWebhook
+	lastId    int64
+}
+
+func NewMemoryWebhookRepository() *MemoryWebhookRepository {
+	return &MemoryWebhookRepository{
+		webhooks:  map[string]Webhook{},
+		selectors: map[string]SelectorWebhook{},
+	}
+}
+
+func (w *MemoryWebhookRepository) GetWebhook(main string) (Webhook, error) {
+
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	webhook, exists := w.webhooks[main]
+	if !exists {
+		return Webhook{}, sql.ErrNoRows
+	}
+
+	return webhook, nil
+}
+
+func (w *MemoryWebhookRepository) ListWebhook() ([]Webhook, error) {
+
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	result := []Webhook{}
+	for _, webhook := range w.webhooks {
+		result = append(result, webhook)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Id < result[j].Id
+	})
+
+	return result, nil
+}
+
+func (w *MemoryWebhookRepository) MapWebhook(mains []string) (map[string]Webhook, error) {
+
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	result := map[string]Webhook{}
+	for _, main := range mains {
+		if webhook, exists := w.webhooks[main]; exists {
+			result[main] = webhook
+		}
+	}
+
+	return result, nil
+}
+
+func (w *MemoryWebhookRepository) UpsertWebhook(webhook Webhook) (sql.Result, error) {
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if webhook.Headers == nil {
+		webhook.Headers = map[string]any{}
+	}
+	if existing, exists := w.webhooks[webhook.Main]; exists {
+		webhook.Id = existing.Id
+	} else {
+		w.lastId++
+		webhook.Id = w.lastId
+	}
+	w.webhooks[webhook.Main] = webhook
+
+	return driver.RowsAffected(1), nil
+}
+
+func (w *MemoryWebhookRepository) DeleteWebhook(main string) (sql.Result, error) {
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if _, exists := w.webhooks[main]; !exists {
+		return driver.RowsAffected(0), nil
+	}
+	delete(w.webhooks, main)
+
+	return driver.RowsAffected(1), nil
+}
+
+func (w *MemoryWebhookRepository) ListSelectorWebhook() ([]SelectorWebhook, error) {
+
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	result := []SelectorWebhook{}
+	for _, webhook := range w.selectors {
+		result = append(result, webhook)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Id < result[j].Id
+	})
+
+	return result, nil
+}
+
+func (w *MemoryWebhookRepository) UpsertSelectorWebhook(webhook SelectorWebhook) (sql.Result, error) {
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if webhook.Headers == nil {
+		webhook.Headers = map[string]any{}
+	}
+	if existing, exists := w.selectors[webhook.Selector]; exists {
+		webhook.Id = existing.Id
+	} else {
+		w.lastId++
+		webhook.Id = w.lastId
+	}
+	w.selectors[webhook.Selector] = webhook
+
+	return driver.RowsAffected(1), nil
+}
+
+func (w *MemoryWebhookRepository) DeleteSelectorWebhook(selector string) (sql.Result, error) {
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if _, exists := w.selectors[selector]; !exists {
+		return driver.RowsAffected(0), nil
+	}
+	delete(w.selectors, selector)
+
+	return driver.RowsAffected(1), nil
+}
@@ -4,150 +4,205 @@ import (
 	"database/sql"
 	"encoding/json"
 	"log"
+	"strings"
 
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/widhaprasa/go-acme-service/repository/sqldb"
 )
 
-type WebhookRepository struct {
-	Db *sql.DB
+// WebhookRepository stores the webhook of each cert main and the webhooks of label selectors
+type WebhookRepository interface {
+	GetWebhook(main string) (Webhook, error)
+	ListWebhook() ([]Webhook, error)
+	MapWebhook(mains []string) (map[string]Webhook, error)
+	UpsertWebhook(webhook Webhook) (sql.Result, error)
+	DeleteWebhook(main string) (sql.Result, error)
+	ListSelectorWebhook() ([]SelectorWebhook, error)
+	UpsertSelectorWebhook(webhook SelectorWebhook) (sql.Result, error)
+	DeleteSelectorWebhook(selector string) (sql.Result, error)
 }
 
-func (w *WebhookRepository) CreateTable() (sql.Result, error) {
+type SqlWebhookRepository struct {
+	Db *sqldb.DB
+}
 
-	return w.Db.Exec(`CREATE TABLE IF NOT EXISTS webhook(
-		id INTEGER PRIMARY KEY,
-		main TEXT UNIQUE,
-		url TEXT,
-		headers BLOB
-	);`)
+type Webhook struct {
+	Id      int64
+	Main    string
+	Url     string
+	Headers map[string]any
 }
 
-func (w *WebhookRepository) GetWebhook(main string) (map[string]any, error) {
+const webhookColumns = "id, main, url, headers"
+
+func (w *SqlWebhookRepository) GetWebhook(main string) (Webhook, error) {
 
-	stmt, err := w.Db.Prepare("SELECT * FROM webhook WHERE main = ?")
+	stmt, err := w.Db.Prepare("SELECT " + webhookColumns + " FROM webhook WHERE main = ?")
 	if err != nil {
 		log.Println("Unable to query webhook:", err)
-		return nil, err
+		return Webhook{}, err
 	}
 	defer stmt.Close()
 
-	row := stmt.QueryRow(main)
-	var id int
-	var url string
-	var headers []byte
-
-	err = row.Scan(&id, &main, &url, &headers)
+	result, err := scanWebhook(stmt.QueryRow(main))
 	if err != nil {
 		log.Println("Unable to scan webhook row:", err)
-		return nil, err
+		return Webhook{}, err
 	}
 
-	var headerMap map[string]any
-	err = json.Unmarshal(headers, &headerMap)
+	return result, nil
+}
+
+func (w *SqlWebhookRepository) ListWebhook() ([]Webhook, error) {
+
+	rows, err := w.Db.Query("SELECT " + webhookColumns + " FROM webhook")
 	if err != nil {
-		headerMap = map[string]any{}
+		log.Println("Unable to query webhook:", err)
+		return nil, err
 	}
+	defer rows.Close()
 
-	result := map[string]any{
-		"id":      id,
-		"main":    main,
-		"url":     url,
-		"headers": headerMap,
+	result := []Webhook{}
+	for rows.Next() {
+		item, err := scanWebhook(rows)
+		if err != nil {
+			log.Println("Unable to scan webhook row:", err)
+			return nil, err
+		}
+		result = append(result, item)
 	}
 
 	return result, nil
 }
 
-func (w *WebhookRepository) ListWebhook() ([]any, error) {
+// MapWebhook returns the webhooks of mains by main, mains without a webhook are left out
+func (w *SqlWebhookRepository) MapWebhook(mains []string) (map[string]Webhook, error) {
+
+	result := map[string]Webhook{}
+	if len(mains) == 0 {
+		return result, nil
+	}
+
+	anys := make([]any, len(mains))
+	preparedStatements := make([]string, len(mains))
+	for i, main := range mains {
+		anys[i] = main
+		preparedStatements[i] = "?"
+	}
 
-	rows, err := w.Db.Query("SELECT * FROM webhook")
+	rows, err := w.Db.Query("SELECT "+webhookColumns+" FROM webhook WHERE main IN ("+strings.Join(preparedStatements, ", ")+")", anys...)
 	if err != nil {
 		log.Println("Unable to query webhook:", err)
 		return nil, err
 	}
 	defer rows.Close()
 
-	result := []any{}
 	for rows.Next() {
-		var id int
-		var main, url string
-		var headers []byte
-
-		err = rows.Scan(&id, &main, &url, &headers)
+		item, err := scanWebhook(rows)
 		if err != nil {
 			log.Println("Unable to scan webhook row:", err)
 			return nil, err
 		}
+		result[item.Main] = item
+	}
 
-		var headerMap map[string]any
-		err = json.Unmarshal(headers, &headerMap)
-		if err != nil {
-			headerMap = map[string]any{}
-		}
+	return result, rows.Err()
+}
 
-		item := map[string]any{
-			"id":      id,
-			"main":    main,
-			"url":     url,
-			"headers": headerMap,
-		}
-		result = append(result, item)
-	}
+func (w *SqlWebhookRepository) UpsertWebhook(webhook Webhook) (sql.Result, error) {
 
-	return result, nil
+	headers, _ := json.Marshal(webhook.Headers)
+
+	return w.Db.Exec(`
+		INSERT INTO webhook(main, url, headers)
+		VALUES(?, ?, ?)
+		ON CONFLICT(main)
+		DO UPDATE SET url = excluded.url, headers = excluded.headers;`,
+		webhook.Main, webhook.Url, headers)
 }
 
-func (w *WebhookRepository) MapWebhook() (map[string]any, error) {
+func (w *SqlWebhookRepository) DeleteWebhook(main string) (sql.Result, error) {
+
+	return w.Db.Exec(`
+		DELETE FROM webhook WHERE main = ?`,
+		main)
+}
 
-	rows, err := w.Db.Query("SELECT * FROM webhook")
+// SelectorWebhook receives the events of every cert whose labels match the selector
+type SelectorWebhook struct {
+	Id       int64
+	Selector string
+	Url      string
+	Headers  map[string]any
+}
+
+func (w *SqlWebhookRepository) ListSelectorWebhook() ([]SelectorWebhook, error) {
+
+	rows, err := w.Db.Query("SELECT id, selector, url, headers FROM webhook_selector ORDER BY id")
 	if err != nil {
-		log.Println("Unable to query webhook:", err)
+		log.Println("Unable to query webhook selector:", err)
 		return nil, err
 	}
 	defer rows.Close()
 
-	result := map[string]any{}
+	result := []SelectorWebhook{}
 	for rows.Next() {
-		var id int
-		var main, url string
+		var item SelectorWebhook
 		var headers []byte
 
-		err = rows.Scan(&id, &main, &url, &headers)
+		err = rows.Scan(&item.Id, &item.Selector, &item.Url, &headers)
 		if err != nil {
-			log.Println("Unable to scan webhook row:", err)
+			log.Println("Unable to scan webhook selector row:", err)
 			return nil, err
 		}
 
-		var headerMap map[string]any
-		err = json.Unmarshal(headers, &headerMap)
-		if err != nil {
-			headerMap = map[string]any{}
+		err = json.Unmarshal(headers, &item.Headers)
+		if err != nil || item.Headers == nil {
+			item.Headers = map[string]any{}
 		}
 
-		result[main] = map[string]any{
-			"url":     url,
-			"headers": headerMap,
-		}
+		result = append(result, item)
 	}
 
 	return result, nil
 }
 
-func (w *WebhookRepository) UpsertWebhook(main string, url string, headerMap map[string]any) (sql.Result, error) {
+func (w *SqlWebhookRepository) UpsertSelectorWebhook(webhook SelectorWebhook) (sql.Result, error) {
 
-	headers, _ := json.Marshal(headerMap)
+	headers, _ := json.Marshal(webhook.Headers)
 
 	return w.Db.Exec(`
-		INSERT INTO webhook(main, url, headers)
+		INSERT INTO webhook_selector(selector, url, headers)
 		VALUES(?, ?, ?)
-		ON CONFLICT(main)
+		ON CONFLICT(selector)
 		DO UPDATE SET url = excluded.url, headers = excluded.headers;`,
-		main, url, headers)
+		webhook.Selector, webhook.Url, headers)
 }
 
-func (w *WebhookRepository) DeleteWebhook(main string) (sql.Result, error) {
+func (w *SqlWebhookRepository) DeleteSelectorWebhook(selector string) (sql.Result, error) {
 
 	return w.Db.Exec(`
-		DELETE FROM webhook WHERE main = ?`,
-		main)
+		DELETE FROM webhook_selector WHERE selector = ?`,
+		selector)
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanWebhook(row scanner) (Webhook, error) {
+
+	var result Webhook
+	var headers []byte
+
+	err := row.Scan(&result.Id, &result.Main, &result.Url, &headers)
+	if err != nil {
+		return Webhook{}, err
+	}
+
+	err = json.Unmarshal(headers, &result.Headers)
+	if err != nil || result.Headers == nil {
+		result.Headers = map[string]any{}
+	}
+
+	return result, nil
 }
@@ -0,0 +1,53 @@
+package zone
+
+import (
+	"database/sql"
+	"log"
+)
+
+// ChallengeRecord is a DNS-01 challenge TXT record created by the service, kept until it is cleaned up
+type ChallengeRecord struct {
+	Fqdn      string
+	Value     string
+	CreatedTs int64
+}
+
+func (z *SqlZoneRepository) InsertChallengeRecord(record ChallengeRecord) (sql.Result, error) {
+
+	return z.Db.Exec(`
+		INSERT INTO challenge_record(fqdn, value, created_ts)
+		VALUES(?, ?, ?)
+		ON CONFLICT(fqdn, value)
+		DO UPDATE SET created_ts = excluded.created_ts`,
+		record.Fqdn, record.Value, record.CreatedTs)
+}
+
+func (z *SqlZoneRepository) ListChallengeRecords() ([]ChallengeRecord, error) {
+
+	rows, err := z.Db.Query("SELECT fqdn, value, created_ts FROM challenge_record ORDER BY created_ts")
+	if err != nil {
+		log.Println("Unable to query challenge record:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []ChallengeRecord{}
+	for rows.Next() {
+		var item ChallengeRecord
+		err = rows.Scan(&item.Fqdn, &item.Value, &item.CreatedTs)
+		if err != nil {
+			log.Println("Unable to scan challenge record row:", err)
+			return nil, err
+		}
+		result = append(result, item)
+	}
+
+	return result, nil
+}
+
+func (z *SqlZoneRepository) DeleteChallengeRecord(fqdn string, value string) (sql.Result, error) {
+
+	return z.Db.Exec(`
+		DELETE FROM challenge_record WHERE fqdn = ? AND value = ?`,
+		fqdn, value)
+}
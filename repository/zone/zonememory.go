@@ -0,0 +1,144 @@
+package zone
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"sort"
+	"sync"
+)
+
+// MemoryZoneRepository keeps zones in memory, used for tests and the embedded mode
+type MemoryZoneRepository struct {
+	mu         sync.RWMutex
+	zones      map[string]Zone
+	challenges map[[2]string]ChallengeRecord
+	lastId     int64
+}
+
+func NewMemoryZoneRepository() *MemoryZoneRepository {
+	return &MemoryZoneRepository{
+		zones:      map[string]Zone{},
+		challenges: map[[2]string]ChallengeRecord{},
+	}
+}
+
+func (z *MemoryZoneRepository) GetZone(zone string) (Zone, error) {
+
+	z.mu.RLock()
+	defer z.mu.RUnlock()
+
+	result, exists := z.zones[zone]
+	if !exists {
+		return Zone{}, sql.ErrNoRows
+	}
+
+	return copyZone(result), nil
+}
+
+func (z *MemoryZoneRepository) ListZone() ([]Zone, error) {
+
+	z.mu.RLock()
+	defer z.mu.RUnlock()
+
+	result := []Zone{}
+	for _, zone := range z.zones {
+		result = append(result, copyZone(zone))
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Id < result[j].Id
+	})
+
+	return result, nil
+}
+
+func (z *MemoryZoneRepository) UpsertZone(zone Zone) (sql.Result, error) {
+
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	// Like the SQLite upsert, CAA records of an existing zone are kept
+	if existing, exists := z.zones[zone.Zone]; exists {
+		zone.Id = existing.Id
+		zone.CAARecords = existing.CAARecords
+	} else {
+		z.lastId++
+		zone.Id = z.lastId
+		zone.CAARecords = []string{}
+	}
+	z.zones[zone.Zone] = zone
+
+	return driver.RowsAffected(1), nil
+}
+
+func (z *MemoryZoneRepository) UpdateCAARecords(zone string, caaRecordIds []string) (sql.Result, error) {
+
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	existing, exists := z.zones[zone]
+	if !exists {
+		return driver.RowsAffected(0), nil
+	}
+	existing.CAARecords = append([]string{}, caaRecordIds...)
+	z.zones[zone] = existing
+
+	return driver.RowsAffected(1), nil
+}
+
+func (z *MemoryZoneRepository) DeleteZone(zone string) (sql.Result, error) {
+
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	if _, exists := z.zones[zone]; !exists {
+		return driver.RowsAffected(0), nil
+	}
+	delete(z.zones, zone)
+
+	return driver.RowsAffected(1), nil
+}
+
+func (z *MemoryZoneRepository) InsertChallengeRecord(record ChallengeRecord) (sql.Result, error) {
+
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	z.challenges[[2]string{record.Fqdn, record.Value}] = record
+
+	return driver.RowsAffected(1), nil
+}
+
+func (z *MemoryZoneRepository) ListChallengeRecords() ([]ChallengeRecord, error) {
+
+	z.mu.RLock()
+	defer z.mu.RUnlock()
+
+	result := []ChallengeRecord{}
+	for _, record := range z.challenges {
+		result = append(result, record)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CreatedTs < result[j].CreatedTs
+	})
+
+	return result, nil
+}
+
+func (z *MemoryZoneRepository) DeleteChallengeRecord(fqdn string, value string) (sql.Result, error) {
+
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	key := [2]string{fqdn, value}
+	if _, exists := z.challenges[key]; !exists {
+		return driver.RowsAffected(0), nil
+	}
+	delete(z.challenges, key)
+
+	return driver.RowsAffected(1), nil
+}
+
+func copyZone(zone Zone) Zone {
+	zone.CAARecords = append([]string{}, zone.CAARecords...)
+	return zone
+}
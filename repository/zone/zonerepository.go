@@ -0,0 +1,125 @@
+package zone
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+
+	"github.com/widhaprasa/go-acme-service/repository/sqldb"
+)
+
+// ZoneRepository stores the zones having managed CAA records, and the challenge records created by the service
+type ZoneRepository interface {
+	GetZone(zone string) (Zone, error)
+	ListZone() ([]Zone, error)
+	UpsertZone(zone Zone) (sql.Result, error)
+	UpdateCAARecords(zone string, caaRecordIds []string) (sql.Result, error)
+	DeleteZone(zone string) (sql.Result, error)
+
+	InsertChallengeRecord(record ChallengeRecord) (sql.Result, error)
+	ListChallengeRecords() ([]ChallengeRecord, error)
+	DeleteChallengeRecord(fqdn string, value string) (sql.Result, error)
+}
+
+type SqlZoneRepository struct {
+	Db *sqldb.DB
+}
+
+type Zone struct {
+	Id            int64
+	Zone          string
+	CAAManaged    bool
+	CAAAccountUri bool
+	CAARecords    []string
+	UpsertedTs    int64
+}
+
+const zoneColumns = "id, zone, caa_managed, caa_account_uri, caa_records, upserted_ts"
+
+func (z *SqlZoneRepository) GetZone(zone string) (Zone, error) {
+
+	stmt, err := z.Db.Prepare("SELECT " + zoneColumns + " FROM zone WHERE zone = ?")
+	if err != nil {
+		log.Println("Unable to query zone:", err)
+		return Zone{}, err
+	}
+	defer stmt.Close()
+
+	result, err := scanZone(stmt.QueryRow(zone))
+	if err != nil {
+		log.Println("Unable to scan zone row:", err)
+		return Zone{}, err
+	}
+
+	return result, nil
+}
+
+func (z *SqlZoneRepository) ListZone() ([]Zone, error) {
+
+	rows, err := z.Db.Query("SELECT " + zoneColumns + " FROM zone")
+	if err != nil {
+		log.Println("Unable to query zone:", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []Zone{}
+	for rows.Next() {
+		item, err := scanZone(rows)
+		if err != nil {
+			log.Println("Unable to scan zone row:", err)
+			return nil, err
+		}
+		result = append(result, item)
+	}
+
+	return result, nil
+}
+
+func (z *SqlZoneRepository) UpsertZone(zone Zone) (sql.Result, error) {
+
+	return z.Db.Exec(`
+		INSERT INTO zone(zone, caa_managed, caa_account_uri, caa_records, upserted_ts)
+		VALUES(?, ?, ?, ?, ?)
+		ON CONFLICT(zone)
+		DO UPDATE SET caa_managed = excluded.caa_managed, caa_account_uri = excluded.caa_account_uri, upserted_ts = excluded.upserted_ts;`,
+		zone.Zone, zone.CAAManaged, zone.CAAAccountUri, []byte("[]"), zone.UpsertedTs)
+}
+
+func (z *SqlZoneRepository) UpdateCAARecords(zone string, caaRecordIds []string) (sql.Result, error) {
+
+	caaRecords, _ := json.Marshal(caaRecordIds)
+
+	return z.Db.Exec(`
+		UPDATE zone SET caa_records = ? WHERE zone = ?`,
+		caaRecords, zone)
+}
+
+func (z *SqlZoneRepository) DeleteZone(zone string) (sql.Result, error) {
+
+	return z.Db.Exec(`
+		DELETE FROM zone WHERE zone = ?`,
+		zone)
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanZone(row scanner) (Zone, error) {
+
+	var result Zone
+	var caaRecords []byte
+
+	err := row.Scan(&result.Id, &result.Zone, &result.CAAManaged, &result.CAAAccountUri, &caaRecords, &result.UpsertedTs)
+	if err != nil {
+		return Zone{}, err
+	}
+
+	err = json.Unmarshal(caaRecords, &result.CAARecords)
+	if err != nil || result.CAARecords == nil {
+		result.CAARecords = []string{}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,269 @@
+package secret
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"os"
+	"strings"
+)
+
+const (
+	keySize       = 32
+	keyIdSize     = 8
+	wrapVersion   = 1
+	wrappedSize   = 1 + keyIdSize + 12 + keySize + 16
+	minCiphertext = 12 + 16
+)
+
+var ErrNoKeyring = errors.New("Private key is encrypted but no master key is configured")
+
+// Keyring wraps per-row data keys with a master key. Data keys wrapped by a previous master key
+// can still be unwrapped until they are rewrapped
+type Keyring struct {
+	currentId []byte
+	keys      map[string]cipher.AEAD
+}
+
+// NewKeyring returns a keyring sealing with current and opening with current or any of previous
+func NewKeyring(current []byte, previous ...[]byte) (*Keyring, error) {
+
+	k := &Keyring{
+		keys: map[string]cipher.AEAD{},
+	}
+
+	for i, key := range append([][]byte{current}, previous...) {
+		if len(key) != keySize {
+			return nil, errors.New("Master key must be 32 bytes")
+		}
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, err
+		}
+
+		id := keyId(key)
+		if i == 0 {
+			k.currentId = id
+		}
+		k.keys[string(id)] = aead
+	}
+
+	return k, nil
+}
+
+// CurrentId returns the hex id of the master key used for sealing
+func (k *Keyring) CurrentId() string {
+	return hex.EncodeToString(k.currentId)
+}
+
+// Seal encrypts plaintext with a new data key bound to aad, returning the ciphertext and the wrapped data key
+func (k *Keyring) Seal(plaintext []byte, aad string) ([]byte, []byte, error) {
+
+	dataKey := make([]byte, keySize)
+	_, err := rand.Read(dataKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	ciphertext, err := seal(aead, plaintext, []byte(aad))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	wrapped, err := k.wrap(dataKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return ciphertext, wrapped, nil
+}
+
+// Open decrypts ciphertext sealed with the wrapped data key and aad
+func (k *Keyring) Open(ciphertext []byte, wrapped []byte, aad string) ([]byte, error) {
+
+	if k == nil {
+		return nil, ErrNoKeyring
+	}
+
+	dataKey, err := k.unwrap(wrapped)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < minCiphertext {
+		return nil, errors.New("Ciphertext is too short")
+	}
+
+	nonceSize := aead.NonceSize()
+	plaintext, err := aead.Open(nil, ciphertext[:nonceSize], ciphertext[nonceSize:], []byte(aad))
+	if err != nil {
+		return nil, errors.New("Unable to decrypt private key, it was altered or sealed for another row")
+	}
+
+	return plaintext, nil
+}
+
+// Rewrap wraps the data key again with the current master key, reporting false if it already was
+func (k *Keyring) Rewrap(wrapped []byte) ([]byte, bool, error) {
+
+	if len(wrapped) == wrappedSize && bytes.Equal(wrapped[1:1+keyIdSize], k.currentId) {
+		return wrapped, false, nil
+	}
+
+	dataKey, err := k.unwrap(wrapped)
+	if err != nil {
+		return nil, false, err
+	}
+
+	rewrapped, err := k.wrap(dataKey)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return rewrapped, true, nil
+}
+
+// wrapped data key layout: version | master key id | nonce | sealed data key
+func (k *Keyring) wrap(dataKey []byte) ([]byte, error) {
+
+	sealed, err := seal(k.keys[string(k.currentId)], dataKey, k.currentId)
+	if err != nil {
+		return nil, err
+	}
+
+	wrapped := append([]byte{wrapVersion}, k.currentId...)
+	return append(wrapped, sealed...), nil
+}
+
+func (k *Keyring) unwrap(wrapped []byte) ([]byte, error) {
+
+	if len(wrapped) != wrappedSize || wrapped[0] != wrapVersion {
+		return nil, errors.New("Invalid wrapped data key")
+	}
+
+	id := wrapped[1 : 1+keyIdSize]
+	aead, exists := k.keys[string(id)]
+	if !exists {
+		return nil, errors.New("Data key is wrapped by unknown master key " + hex.EncodeToString(id))
+	}
+
+	sealed := wrapped[1+keyIdSize:]
+	nonceSize := aead.NonceSize()
+	dataKey, err := aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], id)
+	if err != nil {
+		return nil, errors.New("Unable to unwrap data key")
+	}
+
+	return dataKey, nil
+}
+
+// LoadKey reads a base64 master key from value, or from the file at path if value is empty.
+// A file may also hold the raw 32 bytes. Returns nil if neither is set
+func LoadKey(value string, path string) ([]byte, error) {
+
+	if value == "" && path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if len(data) == keySize {
+			return data, nil
+		}
+		value = string(data)
+	}
+
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, nil
+	}
+
+	key, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, errors.New("Master key must be base64 encoded")
+	}
+	if len(key) != keySize {
+		return nil, errors.New("Master key must be 32 bytes")
+	}
+
+	return key, nil
+}
+
+func keyId(key []byte) []byte {
+	sum := sha256.Sum256(key)
+	return sum[:keyIdSize]
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func seal(aead cipher.AEAD, plaintext []byte, aad []byte) ([]byte, error) {
+
+	nonce := make([]byte, aead.NonceSize())
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+// AAD binds a sealed value to its row, so it cannot be copied to another one
+func AAD(table string, keys ...string) string {
+	return table + ":" + strings.Join(keys, ":")
+}
+
+// SealValue seals plaintext for storage, returning it as is with a nil data key if the keyring is nil
+func (k *Keyring) SealValue(plaintext []byte, aad string) ([]byte, []byte, error) {
+
+	if k == nil || plaintext == nil {
+		return plaintext, nil, nil
+	}
+	return k.Seal(plaintext, aad)
+}
+
+// OpenValue opens a stored value, returning it as is if it has no data key
+func (k *Keyring) OpenValue(value []byte, dataKey []byte, aad string) ([]byte, error) {
+
+	if dataKey == nil {
+		return value, nil
+	}
+	return k.Open(value, dataKey, aad)
+}
+
+// SealEnvelope seals plaintext with a new data key, returning the wrapped data key followed by the ciphertext
+func (k *Keyring) SealEnvelope(plaintext []byte, aad string) ([]byte, error) {
+
+	ciphertext, wrapped, err := k.Seal(plaintext, aad)
+	if err != nil {
+		return nil, err
+	}
+	return append(wrapped, ciphertext...), nil
+}
+
+// OpenEnvelope opens an envelope sealed by SealEnvelope
+func (k *Keyring) OpenEnvelope(envelope []byte, aad string) ([]byte, error) {
+
+	if len(envelope) < wrappedSize {
+		return nil, errors.New("Envelope is too short")
+	}
+	return k.Open(envelope[wrappedSize:], envelope[:wrappedSize], aad)
+}
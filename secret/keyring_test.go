@@ -0,0 +1,136 @@
+package secret
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+)
+
+func newTestKey(t *testing.T) []byte {
+
+	t.Helper()
+	key := make([]byte, keySize)
+	_, err := rand.Read(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func newTestKeyring(t *testing.T, current []byte, previous ...[]byte) *Keyring {
+
+	t.Helper()
+	keyring, err := NewKeyring(current, previous...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keyring
+}
+
+func TestSealOpen(t *testing.T) {
+
+	keyring := newTestKeyring(t, newTestKey(t))
+	plaintext := []byte("private key")
+	aad := AAD("certs", "example.com")
+
+	ciphertext, wrapped, err := keyring.Seal(plaintext, aad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(ciphertext, plaintext) {
+		t.Error("ciphertext holds the plaintext")
+	}
+
+	opened, err := keyring.Open(ciphertext, wrapped, aad)
+	if err != nil || !bytes.Equal(opened, plaintext) {
+		t.Errorf("Open: got %q %v", opened, err)
+	}
+
+	// A value copied to another row does not open
+	_, err = keyring.Open(ciphertext, wrapped, AAD("certs", "example.org"))
+	if err == nil {
+		t.Error("Open with the AAD of another row succeeded")
+	}
+
+	// Nor does it open under another master key
+	_, err = newTestKeyring(t, newTestKey(t)).Open(ciphertext, wrapped, aad)
+	if err == nil {
+		t.Error("Open with the wrong master key succeeded")
+	}
+
+	var nilKeyring *Keyring
+	if _, err = nilKeyring.Open(ciphertext, wrapped, aad); err != ErrNoKeyring {
+		t.Errorf("Open without keyring: got %v, want ErrNoKeyring", err)
+	}
+}
+
+func TestRewrap(t *testing.T) {
+
+	oldKey := newTestKey(t)
+	newKey := newTestKey(t)
+	oldKeyring := newTestKeyring(t, oldKey)
+	aad := AAD("client", "a@example.com")
+
+	ciphertext, wrapped, err := oldKeyring.Seal([]byte("account key"), aad)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The rotated keyring still opens data keys wrapped by the previous master key
+	keyring := newTestKeyring(t, newKey, oldKey)
+	if _, err = keyring.Open(ciphertext, wrapped, aad); err != nil {
+		t.Fatal(err)
+	}
+
+	rewrapped, changed, err := keyring.Rewrap(wrapped)
+	if err != nil || !changed {
+		t.Fatalf("Rewrap: got %v %v", changed, err)
+	}
+	opened, err := newTestKeyring(t, newKey).Open(ciphertext, rewrapped, aad)
+	if err != nil || string(opened) != "account key" {
+		t.Errorf("Open rewrapped with the new key: got %q %v", opened, err)
+	}
+	if _, err = oldKeyring.Open(ciphertext, rewrapped, aad); err == nil {
+		t.Error("Open rewrapped with the old key succeeded")
+	}
+
+	again, changed, err := keyring.Rewrap(rewrapped)
+	if err != nil || changed || !bytes.Equal(again, rewrapped) {
+		t.Errorf("Rewrap again: got %v %v", changed, err)
+	}
+}
+
+func TestSealValue(t *testing.T) {
+
+	aad := AAD("certs", "example.com")
+
+	// Without a keyring values are stored as plaintext with a nil data key
+	var nilKeyring *Keyring
+	value, dataKey, err := nilKeyring.SealValue([]byte("private key"), aad)
+	if err != nil || string(value) != "private key" || dataKey != nil {
+		t.Errorf("SealValue without keyring: got %q %v %v", value, dataKey, err)
+	}
+
+	keyring := newTestKeyring(t, newTestKey(t))
+	opened, err := keyring.OpenValue([]byte("private key"), nil, aad)
+	if err != nil || string(opened) != "private key" {
+		t.Errorf("OpenValue plaintext: got %q %v", opened, err)
+	}
+
+	value, dataKey, err = keyring.SealValue([]byte("private key"), aad)
+	if err != nil || dataKey == nil {
+		t.Fatalf("SealValue: got %v %v", dataKey, err)
+	}
+	opened, err = keyring.OpenValue(value, dataKey, aad)
+	if err != nil || string(opened) != "private key" {
+		t.Errorf("OpenValue: got %q %v", opened, err)
+	}
+	if _, err = nilKeyring.OpenValue(value, dataKey, aad); err != ErrNoKeyring {
+		t.Errorf("OpenValue without keyring: got %v, want ErrNoKeyring", err)
+	}
+
+	value, dataKey, err = keyring.SealValue(nil, aad)
+	if err != nil || value != nil || dataKey != nil {
+		t.Errorf("SealValue nil: got %v %v %v", value, dataKey, err)
+	}
+}
@@ -0,0 +1,128 @@
+package acmeserver
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	acmeserverrepository "github.com/widhaprasa/go-acme-service/repository/acmeserver"
+)
+
+// Lifetime of an unused nonce
+const nonceLifetime = time.Hour
+
+// Clock skew tolerated between the replicas issuing and using a nonce
+const nonceSkew = time.Minute
+
+// nonces are stateless until used. A nonce is the time it was issued and a random part, signed with a key
+// shared by replicas through the repository. Only used nonces are stored, until their lifetime ends
+type nonces struct {
+	repository acmeserverrepository.AcmeServerRepository
+
+	mu  sync.Mutex
+	key []byte
+}
+
+func (n *nonces) issue() (string, error) {
+
+	key, err := n.signingKey()
+	if err != nil {
+		return "", err
+	}
+
+	b := make([]byte, 16, 32)
+	binary.BigEndian.PutUint64(b, uint64(time.Now().UnixMilli()))
+	_, err = rand.Read(b[8:])
+	if err != nil {
+		return "", err
+	}
+	b = append(b, sign(key, b)...)
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func (n *nonces) use(nonce string) bool {
+
+	b, err := base64.RawURLEncoding.DecodeString(nonce)
+	if err != nil || len(b) != 32 {
+		return false
+	}
+
+	key, err := n.signingKey()
+	if err != nil {
+		return false
+	}
+	if !hmac.Equal(b[16:], sign(key, b[:16])) {
+		return false
+	}
+
+	now := time.Now()
+	issued := time.UnixMilli(int64(binary.BigEndian.Uint64(b)))
+	if issued.After(now.Add(nonceSkew)) || now.After(issued.Add(nonceLifetime)) {
+		return false
+	}
+
+	result, err := n.repository.UseNonce(nonce, issued.Add(nonceLifetime+nonceSkew).UnixMilli())
+	if err != nil {
+		log.Println("Unable to use nonce:", err)
+		return false
+	}
+	count, _ := result.RowsAffected()
+	return count == 1
+}
+
+// signingKey returns the key signing nonces, created by the first replica needing one
+func (n *nonces) signingKey() ([]byte, error) {
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.key != nil {
+		return n.key, nil
+	}
+
+	key, err := n.repository.GetNonceKey()
+	if errors.Is(err, sql.ErrNoRows) {
+		key = make([]byte, 32)
+		_, err = rand.Read(key)
+		if err != nil {
+			return nil, err
+		}
+		_, err = n.repository.InsertNonceKey(key, time.Now().UnixMilli())
+		if err != nil {
+			log.Println("Unable to insert nonce key:", err)
+			return nil, err
+		}
+
+		// Another replica may have inserted its key first
+		key, err = n.repository.GetNonceKey()
+	}
+	if err != nil {
+		log.Println("Unable to get nonce key:", err)
+		return nil, err
+	}
+
+	n.key = key
+	return key, nil
+}
+
+func sign(key []byte, data []byte) []byte {
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)[:16]
+}
+
+func randomId(size int) string {
+
+	b := make([]byte, size)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,52 @@
+package acmeserver
+
+import "net/http"
+
+// ACME error types, RFC 8555 section 6.7
+const (
+	ProblemAccountDoesNotExist     = "urn:ietf:params:acme:error:accountDoesNotExist"
+	ProblemAlreadyRevoked          = "urn:ietf:params:acme:error:alreadyRevoked"
+	ProblemBadCSR                  = "urn:ietf:params:acme:error:badCSR"
+	ProblemBadNonce                = "urn:ietf:params:acme:error:badNonce"
+	ProblemBadRevocationReason     = "urn:ietf:params:acme:error:badRevocationReason"
+	ProblemBadSignatureAlgorithm   = "urn:ietf:params:acme:error:badSignatureAlgorithm"
+	ProblemExternalAccountRequired = "urn:ietf:params:acme:error:externalAccountRequired"
+	ProblemMalformed               = "urn:ietf:params:acme:error:malformed"
+	ProblemOrderNotReady           = "urn:ietf:params:acme:error:orderNotReady"
+	ProblemRateLimited             = "urn:ietf:params:acme:error:rateLimited"
+	ProblemRejectedIdentifier      = "urn:ietf:params:acme:error:rejectedIdentifier"
+	ProblemServerInternal          = "urn:ietf:params:acme:error:serverInternal"
+	ProblemUnauthorized            = "urn:ietf:params:acme:error:unauthorized"
+	ProblemUnsupportedIdentifier   = "urn:ietf:params:acme:error:unsupportedIdentifier"
+)
+
+// Problem is an ACME problem document, RFC 7807
+type Problem struct {
+	Type        string    `json:"type"`
+	Detail      string    `json:"detail"`
+	Status      int       `json:"status"`
+	Subproblems []Problem `json:"subproblems,omitempty"`
+}
+
+func (p *Problem) Error() string {
+	return p.Detail
+}
+
+func problem(type_ string, detail string) *Problem {
+
+	status := http.StatusBadRequest
+	switch type_ {
+	case ProblemUnauthorized, ProblemOrderNotReady, ProblemRejectedIdentifier:
+		status = http.StatusForbidden
+	case ProblemRateLimited:
+		status = http.StatusTooManyRequests
+	case ProblemServerInternal:
+		status = http.StatusInternalServerError
+	}
+
+	return &Problem{
+		Type:   type_,
+		Detail: detail,
+		Status: status,
+	}
+}
@@ -0,0 +1,596 @@
+package acmeserver
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/x509"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	legoacme "github.com/go-acme/lego/v4/acme"
+	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/go-jose/go-jose/v4"
+
+	"github.com/widhaprasa/go-acme-service/acme"
+	acmeserverrepository "github.com/widhaprasa/go-acme-service/repository/acmeserver"
+	certsservice "github.com/widhaprasa/go-acme-service/service/certs"
+)
+
+// Statuses of accounts and orders, RFC 8555 section 7.1.6
+const (
+	StatusValid       = "valid"
+	StatusDeactivated = "deactivated"
+	StatusReady       = "ready"
+	StatusProcessing  = "processing"
+	StatusInvalid     = "invalid"
+)
+
+// Lifetime of an order not yet finalized
+const orderLifetime = 7 * 24 * time.Hour
+
+// Time an order may be processing before it is given up, its issuance lost to a restart or taking too long
+const processingTimeout = 3 * time.Hour
+
+// Identifier is an ACME identifier, only dns is supported
+type Identifier struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// AcmeServerService runs an ACME server for internal clients. Accounts are registered with an EAB key,
+// which limits the domains they may order. The EAB key and the domain policy stand in for the challenges,
+// so the authorizations of an order are valid as soon as it is created, and certificates are issued upstream
+// under the account of the EAB key
+type AcmeServerService struct {
+	AcmeServerRepository acmeserverrepository.AcmeServerRepository
+	CertsService         *certsservice.CertsService
+
+	nonces *nonces
+}
+
+func NewAcmeServerService(acmeServerRepository acmeserverrepository.AcmeServerRepository, certsService *certsservice.CertsService) *AcmeServerService {
+	return &AcmeServerService{
+		AcmeServerRepository: acmeServerRepository,
+		CertsService:         certsService,
+		nonces: &nonces{
+			repository: acmeServerRepository,
+		},
+	}
+}
+
+func (a *AcmeServerService) NewNonce() (string, error) {
+	return a.nonces.issue()
+}
+
+// UseNonce reports whether nonce was issued by a replica within its lifetime and not used yet
+func (a *AcmeServerService) UseNonce(nonce string) bool {
+	return a.nonces.use(nonce)
+}
+
+// PruneNonces deletes the used nonces past their lifetime, they are rejected as expired anyway
+func (a *AcmeServerService) PruneNonces(ts int64) {
+
+	result, err := a.AcmeServerRepository.DeleteExpiredNonces(ts)
+	if err != nil {
+		log.Println("Unable to prune nonces:", err)
+		return
+	}
+	if count, _ := result.RowsAffected(); count > 0 {
+		log.Println("Prune nonces:", count)
+	}
+}
+
+func (a *AcmeServerService) InitNonceSchedule() {
+
+	pruneInterval := time.Hour // Default interval, prune used nonces hourly
+
+	ticker := time.NewTicker(pruneInterval)
+	done := make(chan bool)
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				ts := time.Now().UnixMilli()
+				a.PruneNonces(ts)
+			case <-done:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+}
+
+// CreateEabKey creates an EAB key whose accounts may order domains, issued under the account of email.
+// A domain of the form *.example.com allows every name under example.com, including wildcards
+func (a *AcmeServerService) CreateEabKey(ts int64, email string, domains []string) (acmeserverrepository.EabKey, error) {
+
+	domains, err := acme.ValidateDomains(domains)
+	if err != nil {
+		return acmeserverrepository.EabKey{}, err
+	}
+
+	hmacKey := make([]byte, 32)
+	_, err = rand.Read(hmacKey)
+	if err != nil {
+		return acmeserverrepository.EabKey{}, err
+	}
+
+	key := acmeserverrepository.EabKey{
+		KeyId:     randomId(16),
+		HmacKey:   hmacKey,
+		Email:     email,
+		Domains:   domains,
+		CreatedTs: ts,
+	}
+	_, err = a.AcmeServerRepository.InsertEabKey(key)
+	if err != nil {
+		log.Println("Failed to insert EAB key:", err)
+		return acmeserverrepository.EabKey{}, err
+	}
+
+	log.Println("Create EAB key", key.KeyId, "for", email)
+	return key, nil
+}
+
+// NewAccount registers the account of jwk bound to the EAB key of eab, the JWS signing jwk with the HMAC key
+// of the EAB key for url. An account already registered with jwk is returned as is, along with false
+func (a *AcmeServerService) NewAccount(ts int64, jwk *jose.JSONWebKey, contact []string, onlyReturnExisting bool, eab json.RawMessage,
+	url string) (acmeserverrepository.Account, bool, error) {
+
+	thumbprint, err := thumbprint(jwk)
+	if err != nil {
+		return acmeserverrepository.Account{}, false, problem(ProblemMalformed, "Invalid account key")
+	}
+
+	account, err := a.AcmeServerRepository.GetAccountByThumbprint(thumbprint)
+	if err == nil {
+		if account.Status != StatusValid {
+			return acmeserverrepository.Account{}, false, problem(ProblemUnauthorized, "Account is "+account.Status)
+		}
+		return account, false, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return acmeserverrepository.Account{}, false, problem(ProblemServerInternal, "Unable to read account")
+	}
+	if onlyReturnExisting {
+		return acmeserverrepository.Account{}, false, problem(ProblemAccountDoesNotExist, "Account does not exist")
+	}
+
+	if len(eab) == 0 {
+		return acmeserverrepository.Account{}, false, problem(ProblemExternalAccountRequired, "External account binding is required")
+	}
+	keyId, err := a.verifyEab(jwk, eab, url)
+	if err != nil {
+		return acmeserverrepository.Account{}, false, err
+	}
+
+	jwkJSON, err := jwk.MarshalJSON()
+	if err != nil {
+		return acmeserverrepository.Account{}, false, problem(ProblemMalformed, "Invalid account key")
+	}
+
+	account = acmeserverrepository.Account{
+		AccountId:  randomId(16),
+		Thumbprint: thumbprint,
+		Jwk:        jwkJSON,
+		KeyId:      keyId,
+		Contact:    contact,
+		Status:     StatusValid,
+		CreatedTs:  ts,
+	}
+	_, err = a.AcmeServerRepository.InsertAccount(account)
+	if err != nil {
+		log.Println("Failed to insert ACME account:", err)
+		return acmeserverrepository.Account{}, false, problem(ProblemServerInternal, "Unable to create account")
+	}
+
+	log.Println("Create ACME account", account.AccountId, "with EAB key", keyId)
+	return account, true, nil
+}
+
+// verifyEab returns the id of the EAB key signing the external account binding of jwk, RFC 8555 section 7.3.4
+func (a *AcmeServerService) verifyEab(jwk *jose.JSONWebKey, eab json.RawMessage, url string) (string, error) {
+
+	jws, err := jose.ParseSignedJSON(string(eab), []jose.SignatureAlgorithm{jose.HS256, jose.HS384, jose.HS512})
+	if err != nil || len(jws.Signatures) != 1 {
+		return "", problem(ProblemMalformed, "Invalid external account binding")
+	}
+	header := jws.Signatures[0].Protected
+	if header.Nonce != "" {
+		return "", problem(ProblemMalformed, "External account binding must not have a nonce")
+	}
+	if eabUrl, _ := header.ExtraHeaders["url"].(string); eabUrl != url {
+		return "", problem(ProblemMalformed, "External account binding url does not match")
+	}
+
+	key, err := a.AcmeServerRepository.GetEabKey(header.KeyID)
+	if err != nil {
+		return "", problem(ProblemUnauthorized, "Unknown external account binding key")
+	}
+
+	payload, err := jws.Verify(key.HmacKey)
+	if err != nil {
+		return "", problem(ProblemUnauthorized, "Invalid external account binding signature")
+	}
+
+	var boundJwk jose.JSONWebKey
+	err = boundJwk.UnmarshalJSON(payload)
+	if err != nil {
+		return "", problem(ProblemMalformed, "Invalid external account binding key")
+	}
+	bound, err := thumbprint(&boundJwk)
+	if err != nil {
+		return "", problem(ProblemMalformed, "Invalid external account binding key")
+	}
+	expected, _ := thumbprint(jwk)
+	if !hmac.Equal([]byte(bound), []byte(expected)) {
+		return "", problem(ProblemUnauthorized, "External account binding is for another key")
+	}
+
+	return key.KeyId, nil
+}
+
+// Account returns a valid account along with its EAB key, which must still exist
+func (a *AcmeServerService) Account(accountId string) (acmeserverrepository.Account, acmeserverrepository.EabKey, error) {
+
+	account, err := a.AcmeServerRepository.GetAccount(accountId)
+	if err != nil {
+		return acmeserverrepository.Account{}, acmeserverrepository.EabKey{}, problem(ProblemAccountDoesNotExist, "Account does not exist")
+	}
+	if account.Status != StatusValid {
+		return acmeserverrepository.Account{}, acmeserverrepository.EabKey{}, problem(ProblemUnauthorized, "Account is "+account.Status)
+	}
+
+	key, err := a.AcmeServerRepository.GetEabKey(account.KeyId)
+	if err != nil {
+		return acmeserverrepository.Account{}, acmeserverrepository.EabKey{}, problem(ProblemUnauthorized, "External account binding key was revoked")
+	}
+
+	return account, key, nil
+}
+
+// AccountKey returns the key of an account
+func (a *AcmeServerService) AccountKey(account acmeserverrepository.Account) (*jose.JSONWebKey, error) {
+
+	var jwk jose.JSONWebKey
+	err := jwk.UnmarshalJSON(account.Jwk)
+	if err != nil {
+		return nil, err
+	}
+	return &jwk, nil
+}
+
+// UpdateAccount updates the contact of an account when set, and deactivates it when status is deactivated
+func (a *AcmeServerService) UpdateAccount(account acmeserverrepository.Account, contact []string, status string) (acmeserverrepository.Account, error) {
+
+	if contact == nil && status == "" {
+		return account, nil
+	}
+	if contact != nil {
+		account.Contact = contact
+	}
+	if status != "" {
+		if status != StatusDeactivated {
+			return account, problem(ProblemMalformed, "Account status can only be set to deactivated")
+		}
+		account.Status = StatusDeactivated
+	}
+
+	_, err := a.AcmeServerRepository.UpdateAccount(account)
+	if err != nil {
+		log.Println("Failed to update ACME account", account.AccountId, ":", err)
+		return account, problem(ProblemServerInternal, "Unable to update account")
+	}
+
+	return account, nil
+}
+
+// NewOrder creates an order of identifiers allowed by the EAB key of the account, ready to be finalized
+func (a *AcmeServerService) NewOrder(ts int64, account acmeserverrepository.Account, key acmeserverrepository.EabKey,
+	identifiers []Identifier) (acmeserverrepository.Order, error) {
+
+	var values []string
+	for _, v := range identifiers {
+		if v.Type != "dns" {
+			return acmeserverrepository.Order{}, problem(ProblemUnsupportedIdentifier, "Identifier type "+v.Type+" is not supported")
+		}
+		values = append(values, v.Value)
+	}
+
+	domains, err := acme.ValidateDomains(values)
+	if err != nil {
+		return acmeserverrepository.Order{}, problem(ProblemRejectedIdentifier, err.Error())
+	}
+
+	var subproblems []Problem
+	for _, domain := range domains {
+		if !Allowed(key.Domains, domain) {
+			subproblem := *problem(ProblemRejectedIdentifier, domain+" is not allowed by the external account binding key")
+			subproblems = append(subproblems, subproblem)
+		}
+	}
+	if len(subproblems) > 0 {
+		result := problem(ProblemRejectedIdentifier, "Domains are not allowed by the external account binding key")
+		result.Subproblems = subproblems
+		return acmeserverrepository.Order{}, result
+	}
+
+	order := acmeserverrepository.Order{
+		OrderId:     randomId(16),
+		AccountId:   account.AccountId,
+		Identifiers: domains,
+		Status:      StatusReady,
+		ExpiresTs:   time.UnixMilli(ts).Add(orderLifetime).UnixMilli(),
+		CreatedTs:   ts,
+		UpdatedTs:   ts,
+	}
+	_, err = a.AcmeServerRepository.InsertOrder(order)
+	if err != nil {
+		log.Println("Failed to insert ACME order:", err)
+		return acmeserverrepository.Order{}, problem(ProblemServerInternal, "Unable to create order")
+	}
+
+	log.Println("Create ACME order", order.OrderId, "for", strings.Join(domains, ", "))
+	return order, nil
+}
+
+// Order returns an order of the account, an order past its expiry before being finalized is invalid
+func (a *AcmeServerService) Order(ts int64, account acmeserverrepository.Account, orderId string) (acmeserverrepository.Order, error) {
+
+	order, err := a.AcmeServerRepository.GetOrder(orderId)
+	if err != nil || order.AccountId != account.AccountId {
+		return acmeserverrepository.Order{}, &Problem{Type: ProblemMalformed, Detail: "Order does not exist", Status: http.StatusNotFound}
+	}
+
+	if order.Status == StatusReady && ts > order.ExpiresTs {
+		order.Status = StatusInvalid
+		order.Error = "Order expired"
+	}
+	if order.Status == StatusProcessing && ts > time.UnixMilli(order.UpdatedTs).Add(processingTimeout).UnixMilli() {
+		order = a.abandonOrder(ts, order)
+	}
+
+	return order, nil
+}
+
+// ExpireOrders makes invalid the orders processing for longer than their timeout at ts
+func (a *AcmeServerService) ExpireOrders(ts int64) error {
+
+	list, err := a.AcmeServerRepository.ListOrdersByStatus(StatusProcessing)
+	if err != nil {
+		log.Println("Unable to list processing ACME orders:", err)
+		return err
+	}
+
+	for _, order := range list {
+		if ts > time.UnixMilli(order.UpdatedTs).Add(processingTimeout).UnixMilli() {
+			a.abandonOrder(ts, order)
+		}
+	}
+
+	return nil
+}
+
+// abandonOrder makes invalid a processing order whose certificate was not issued in time
+func (a *AcmeServerService) abandonOrder(ts int64, order acmeserverrepository.Order) acmeserverrepository.Order {
+
+	order.Status = StatusInvalid
+	order.Error = "Certificate was not issued in time"
+	order.UpdatedTs = ts
+
+	_, err := a.AcmeServerRepository.UpdateOrder(order, StatusProcessing)
+	if err != nil {
+		log.Println("Failed to update ACME order", order.OrderId, ":", err)
+	}
+
+	log.Println("Abandon ACME order", order.OrderId)
+	return order
+}
+
+func (a *AcmeServerService) ListOrders(account acmeserverrepository.Account) ([]acmeserverrepository.Order, error) {
+	return a.AcmeServerRepository.ListOrders(account.AccountId)
+}
+
+// Finalize issues the certificate of a ready order for csr. A stored cert holding the key of csr is returned
+// right away, otherwise the order is processing until the certificate is issued upstream
+func (a *AcmeServerService) Finalize(ts int64, account acmeserverrepository.Account, key acmeserverrepository.EabKey, orderId string,
+	csrDER []byte) (acmeserverrepository.Order, error) {
+
+	order, err := a.Order(ts, account, orderId)
+	if err != nil {
+		return order, err
+	}
+	if order.Status != StatusReady {
+		return order, problem(ProblemOrderNotReady, "Order is "+order.Status)
+	}
+
+	csr, err := x509.ParseCertificateRequest(csrDER)
+	if err != nil {
+		return order, problem(ProblemBadCSR, "Invalid CSR")
+	}
+	err = csr.CheckSignature()
+	if err != nil {
+		return order, problem(ProblemBadCSR, "Invalid CSR signature")
+	}
+	if len(csr.IPAddresses) > 0 || len(csr.EmailAddresses) > 0 || len(csr.URIs) > 0 {
+		return order, problem(ProblemBadCSR, "CSR may only have DNS names")
+	}
+
+	var names []string
+	for _, name := range certcrypto.ExtractDomainsCSR(csr) {
+		names = append(names, strings.ToLower(name))
+	}
+	if !sameSet(names, order.Identifiers) {
+		return order, problem(ProblemBadCSR, "CSR names do not match the order identifiers")
+	}
+
+	// Claim the order, a concurrent finalize sees it processing
+	order.Status = StatusProcessing
+	order.UpdatedTs = ts
+	result, err := a.AcmeServerRepository.UpdateOrder(order, StatusReady)
+	if err != nil {
+		log.Println("Failed to update ACME order", order.OrderId, ":", err)
+		return order, problem(ProblemServerInternal, "Unable to update order")
+	}
+	if count, _ := result.RowsAffected(); count == 0 {
+		return order, problem(ProblemOrderNotReady, "Order is already being finalized")
+	}
+
+	// Stored cert issued for the same key
+	if certificate_, ok := a.CertsService.StoredForCSR(ts, csr); ok {
+		log.Println("Finalize ACME order", order.OrderId, "with a stored cert")
+		return a.completeOrder(order, certificate_, nil), nil
+	}
+
+	err = a.CertsService.IssueForCSR(ts, key.Email, csr, func(certificate_ []byte, err error) {
+		a.completeOrder(order, certificate_, err)
+	})
+	if err != nil {
+		order.Status = StatusReady
+		a.AcmeServerRepository.UpdateOrder(order, StatusProcessing)
+		return order, problem(ProblemRateLimited, err.Error())
+	}
+
+	log.Println("Finalize ACME order", order.OrderId, "issuing upstream")
+	return order, nil
+}
+
+// completeOrder makes an order valid with its certificate, or invalid if issuing it failed
+func (a *AcmeServerService) completeOrder(order acmeserverrepository.Order, certificate_ []byte, err error) acmeserverrepository.Order {
+
+	order.UpdatedTs = time.Now().UnixMilli()
+	if err == nil {
+		bundle, parseErr := certcrypto.ParsePEMBundle(certificate_)
+		if parseErr != nil || len(bundle) == 0 {
+			err = errors.New("Invalid issued certificate")
+		} else {
+			order.Status = StatusValid
+			order.Certificate = certificate_
+			order.Serial = hex.EncodeToString(bundle[0].SerialNumber.Bytes())
+		}
+	}
+	if err != nil {
+		order.Status = StatusInvalid
+		order.Error = err.Error()
+	}
+
+	// An order abandoned meanwhile stays invalid
+	result, err := a.AcmeServerRepository.UpdateOrder(order, StatusProcessing)
+	if err != nil {
+		log.Println("Failed to update ACME order", order.OrderId, ":", err)
+		return order
+	}
+	if count, _ := result.RowsAffected(); count == 0 {
+		log.Println("ACME order", order.OrderId, "is no longer processing")
+	}
+	return order
+}
+
+// Revoke revokes at the CA a certificate issued upstream to an order of the account. A stored cert returned
+// to an order is not revoked, as the service still serves it
+func (a *AcmeServerService) Revoke(ts int64, account acmeserverrepository.Account, key acmeserverrepository.EabKey, certDER []byte,
+	reason *uint) error {
+
+	crt, err := x509.ParseCertificate(certDER)
+	if err != nil {
+		return problem(ProblemMalformed, "Invalid certificate")
+	}
+	if reason != nil && (*reason > 10 || *reason == 7) {
+		return problem(ProblemBadRevocationReason, "Invalid revocation reason")
+	}
+
+	_, err = a.AcmeServerRepository.GetOrderBySerial(account.AccountId, hex.EncodeToString(crt.SerialNumber.Bytes()))
+	if err != nil {
+		return problem(ProblemUnauthorized, "Certificate was not issued to this account")
+	}
+
+	// A stored cert handed to an order is still served by the service
+	stored, err := a.CertsService.IsStored(crt)
+	if err != nil {
+		return problem(ProblemServerInternal, "Unable to read stored certs")
+	}
+	if stored {
+		return problem(ProblemUnauthorized, "Certificate is managed by the service and can not be revoked by an account")
+	}
+
+	certificate_ := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: crt.Raw})
+	err = a.CertsService.RevokeCertificate(ts, key.Email, certificate_, reason)
+	if err != nil {
+		var problemDetails *legoacme.ProblemDetails
+		if errors.As(err, &problemDetails) {
+			return &Problem{Type: problemDetails.Type, Detail: problemDetails.Detail, Status: problemDetails.HTTPStatus}
+		}
+		return problem(ProblemServerInternal, err.Error())
+	}
+
+	return nil
+}
+
+// Allowed reports whether domain matches one of patterns, *.example.com matching every name under example.com
+func Allowed(patterns []string, domain string) bool {
+
+	for _, pattern := range patterns {
+		if pattern == domain {
+			return true
+		}
+		if base, ok := strings.CutPrefix(pattern, "*."); ok && strings.HasSuffix(strings.TrimPrefix(domain, "*."), "."+base) {
+			return true
+		}
+	}
+	return false
+}
+
+func thumbprint(jwk *jose.JSONWebKey) (string, error) {
+
+	if jwk == nil || !jwk.Valid() || !jwk.IsPublic() {
+		return "", errors.New("Invalid key")
+	}
+	b, err := jwk.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func sameSet(a []string, b []string) bool {
+
+	a = append([]string{}, a...)
+	b = append([]string{}, b...)
+	sort.Strings(a)
+	sort.Strings(b)
+
+	// Names of a CSR may repeat
+	a = compact(a)
+	b = compact(b)
+
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func compact(sorted []string) []string {
+
+	result := []string{}
+	for i, v := range sorted {
+		if i == 0 || v != sorted[i-1] {
+			result = append(result, v)
+		}
+	}
+	return result
+}
@@ -0,0 +1,155 @@
+package acmeserver
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/widhaprasa/go-acme-service/repository"
+	acmeserverrepository "github.com/widhaprasa/go-acme-service/repository/acmeserver"
+	certsservice "github.com/widhaprasa/go-acme-service/service/certs"
+	"github.com/widhaprasa/go-acme-service/service/client"
+	"github.com/widhaprasa/go-acme-service/service/zone"
+)
+
+func newTestService(t *testing.T) *AcmeServerService {
+
+	t.Helper()
+	repositories := repository.NewMemory()
+	clientService := client.ClientService{
+		Clientrepository: repositories.Client,
+	}
+	zoneService := &zone.ZoneService{
+		ZoneRepository:   repositories.Zone,
+		ClientRepository: repositories.Client,
+	}
+	certsService := certsservice.NewCertsService(repositories.Certs, clientService, repositories.Webhook, zoneService)
+	return NewAcmeServerService(repositories.AcmeServer, &certsService)
+}
+
+func TestAllowed(t *testing.T) {
+
+	patterns := []string{"*.a.com", "b.com"}
+	tests := []struct {
+		domain string
+		want   bool
+	}{
+		{"x.a.com", true},
+		{"x.y.a.com", true},
+		{"*.a.com", true},
+		{"*.x.a.com", true},
+		{"a.com", false},
+		{"evila.com", false},
+		{"x.evila.com", false},
+		{"*.evila.com", false},
+		{"a.com.evil.com", false},
+		{"b.com", true},
+		{"x.b.com", false},
+		{"*.b.com", false},
+		{"evilb.com", false},
+	}
+	for _, test := range tests {
+		if got := Allowed(patterns, test.domain); got != test.want {
+			t.Errorf("Allowed(%v, %q) = %v, want %v", patterns, test.domain, got, test.want)
+		}
+	}
+}
+
+func TestNonces(t *testing.T) {
+
+	service := newTestService(t)
+
+	nonce, err := service.NewNonce()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !service.UseNonce(nonce) {
+		t.Fatal("fresh nonce was rejected")
+	}
+	if service.UseNonce(nonce) {
+		t.Fatal("used nonce was accepted")
+	}
+
+	// Another replica shares the signing key through the repository
+	replica := NewAcmeServerService(service.AcmeServerRepository, service.CertsService)
+	nonce, _ = service.NewNonce()
+	if !replica.UseNonce(nonce) {
+		t.Fatal("nonce of another replica was rejected")
+	}
+	if service.UseNonce(nonce) {
+		t.Fatal("nonce used on another replica was accepted")
+	}
+
+	// Past its lifetime, even when signed
+	key, _ := service.nonces.signingKey()
+	b := make([]byte, 16)
+	binary.BigEndian.PutUint64(b, uint64(time.Now().Add(-nonceLifetime-time.Minute).UnixMilli()))
+	expired := base64.RawURLEncoding.EncodeToString(append(b, sign(key, b)...))
+	if service.UseNonce(expired) {
+		t.Fatal("expired nonce was accepted")
+	}
+
+	for _, invalid := range []string{"", "not base64!", base64.RawURLEncoding.EncodeToString(make([]byte, 32))} {
+		if service.UseNonce(invalid) {
+			t.Fatalf("invalid nonce %q was accepted", invalid)
+		}
+	}
+
+	// Used nonces are pruned once they expire
+	service.PruneNonces(time.Now().Add(nonceLifetime + 2*nonceSkew).UnixMilli())
+	result, _ := service.AcmeServerRepository.UseNonce(nonce, 0)
+	if count, _ := result.RowsAffected(); count != 1 {
+		t.Fatal("expired used nonce was not pruned")
+	}
+}
+
+func TestExpireOrders(t *testing.T) {
+
+	service := newTestService(t)
+	now := time.Now()
+
+	orders := map[string]acmeserverrepository.Order{
+		"stale":  {OrderId: "stale", AccountId: "a", Status: StatusProcessing, UpdatedTs: now.Add(-processingTimeout - time.Minute).UnixMilli()},
+		"recent": {OrderId: "recent", AccountId: "a", Status: StatusProcessing, UpdatedTs: now.Add(-time.Minute).UnixMilli()},
+		"ready":  {OrderId: "ready", AccountId: "a", Status: StatusReady, ExpiresTs: now.Add(time.Hour).UnixMilli()},
+	}
+	for _, order := range orders {
+		_, err := service.AcmeServerRepository.InsertOrder(order)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := service.ExpireOrders(now.UnixMilli())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"stale":  StatusInvalid,
+		"recent": StatusProcessing,
+		"ready":  StatusReady,
+	}
+	for orderId, status := range want {
+		order, _ := service.AcmeServerRepository.GetOrder(orderId)
+		if order.Status != status {
+			t.Errorf("order %s: status %q, want %q", orderId, order.Status, status)
+		}
+	}
+
+	// A completion arriving after the order was given up leaves it invalid
+	order, _ := service.AcmeServerRepository.GetOrder("stale")
+	order.Status = StatusProcessing
+	service.completeOrder(order, nil, nil)
+	order, _ = service.AcmeServerRepository.GetOrder("stale")
+	if order.Status != StatusInvalid || order.Error != "Certificate was not issued in time" {
+		t.Fatalf("abandoned order: status %q error %q, want it unchanged", order.Status, order.Error)
+	}
+
+	// Reading an order past its timeout gives it up too
+	order, err = service.Order(now.Add(processingTimeout+time.Hour).UnixMilli(), acmeserverrepository.Account{AccountId: "a"}, "recent")
+	if err != nil || order.Status != StatusInvalid {
+		t.Fatalf("read stale order: status %q %v, want invalid", order.Status, err)
+	}
+}
@@ -0,0 +1,272 @@
+package agent
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Delays before polling again after a failure
+const (
+	retryDelay    = 30 * time.Second
+	notFoundDelay = 5 * time.Minute
+)
+
+// Agent pulls certs from the service and keeps them written to disk
+type Agent struct {
+	config *Config
+	client *client
+
+	mu    sync.Mutex
+	state map[string]certState
+}
+
+// certState is the version of a cert last written
+type certState struct {
+	ETag   string `json:"etag"`
+	Serial string `json:"serial"`
+}
+
+func NewAgent(config *Config) (*Agent, error) {
+
+	agent := &Agent{
+		config: config,
+		client: newClient(config),
+		state:  map[string]certState{},
+	}
+
+	data, err := os.ReadFile(config.StatePath)
+	if err == nil {
+		err = json.Unmarshal(data, &agent.state)
+		if err != nil {
+			log.Println("Ignoring invalid agent state", config.StatePath, ":", err)
+			agent.state = map[string]certState{}
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	// Files removed since are written again
+	for _, cert := range config.Certs {
+		for _, file := range cert.Files {
+			if _, err := os.Stat(file.Path); err != nil {
+				delete(agent.state, cert.Domain)
+				break
+			}
+		}
+	}
+
+	return agent, nil
+}
+
+// Run keeps every cert up to date until ctx is done
+func (a *Agent) Run(ctx context.Context) {
+
+	var wg sync.WaitGroup
+	for _, cert := range a.config.Certs {
+		wg.Add(1)
+		go func(cert CertConfig) {
+			defer wg.Done()
+			a.watch(ctx, cert)
+		}(cert)
+	}
+	wg.Wait()
+}
+
+// Once syncs every cert without waiting for a new version
+func (a *Agent) Once(ctx context.Context) error {
+
+	var result error
+	for _, cert := range a.config.Certs {
+		err := a.sync(ctx, cert, false)
+		if err != nil {
+			log.Println("Unable to sync certificate", cert.Domain, ":", err)
+			result = err
+		}
+	}
+	return result
+}
+
+func (a *Agent) watch(ctx context.Context, cert CertConfig) {
+
+	for ctx.Err() == nil {
+
+		err := a.sync(ctx, cert, true)
+		if err == nil || ctx.Err() != nil {
+			continue
+		}
+
+		delay := retryDelay
+		if errors.Is(err, ErrNotFound) {
+			delay = notFoundDelay
+		}
+		log.Println("Unable to sync certificate", cert.Domain, ":", err, ", retrying in", delay)
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(delay):
+		}
+	}
+}
+
+// sync fetches the cert, writes its files and runs the reload commands if the version changed,
+// then reports the version held
+func (a *Agent) sync(ctx context.Context, cert CertConfig, wait bool) error {
+
+	held := a.getState(cert.Domain)
+
+	release, err := a.client.fetch(ctx, cert, held.ETag, wait)
+	if err != nil {
+		return err
+	}
+
+	if release != nil {
+		serial, changed, err := a.write(cert, release.Data)
+		if err != nil {
+			return err
+		}
+
+		if changed {
+			log.Println("Write certificate", cert.Domain, "version", serial)
+			a.reload(ctx, cert)
+		}
+
+		held = certState{ETag: release.ETag, Serial: serial}
+		err = a.setState(cert.Domain, held)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = a.client.report(ctx, cert, held.Serial)
+	if err != nil {
+		log.Println("Unable to report certificate", cert.Domain, ":", err)
+	}
+	return nil
+}
+
+// write splits a combined PEM into the files of the cert, returning its serial and whether a file changed
+func (a *Agent) write(cert CertConfig, combined []byte) (string, bool, error) {
+
+	var privateKey []byte
+	var certificates [][]byte
+	var leaf *x509.Certificate
+
+	for rest := combined; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+
+		switch {
+		case block.Type == "CERTIFICATE":
+			if leaf == nil {
+				crt, err := x509.ParseCertificate(block.Bytes)
+				if err != nil {
+					return "", false, err
+				}
+				leaf = crt
+			}
+			certificates = append(certificates, pem.EncodeToMemory(block))
+		case strings.HasSuffix(block.Type, "PRIVATE KEY"):
+			privateKey = pem.EncodeToMemory(block)
+		}
+	}
+	if leaf == nil || privateKey == nil {
+		return "", false, errors.New("Certificate " + cert.Domain + " lacks a certificate or private key")
+	}
+
+	fullchain := joinPEM(certificates)
+	contents := map[string][]byte{
+		ContentCert:      certificates[0],
+		ContentChain:     joinPEM(certificates[1:]),
+		ContentFullchain: fullchain,
+		ContentPrivkey:   privateKey,
+		ContentCombined:  append(append([]byte{}, privateKey...), fullchain...),
+	}
+
+	// Every file is staged before any is replaced, so a failed write leaves the previous version in place
+	staged := []*stagedFile{}
+	defer func() {
+		for _, file := range staged {
+			file.discard()
+		}
+	}()
+	for _, file := range cert.Files {
+		stagedFile, err := stageFile(file, contents[file.Content])
+		if err != nil {
+			return "", false, err
+		}
+		if stagedFile != nil {
+			staged = append(staged, stagedFile)
+		}
+	}
+	for _, file := range staged {
+		err := file.commit()
+		if err != nil {
+			return "", false, err
+		}
+	}
+
+	return hex.EncodeToString(leaf.SerialNumber.Bytes()), len(staged) > 0, nil
+}
+
+func (a *Agent) reload(ctx context.Context, cert CertConfig) {
+
+	for _, command := range cert.Reload {
+		output, err := exec.CommandContext(ctx, "sh", "-c", command).CombinedOutput()
+		if err != nil {
+			log.Println("Unable to reload", cert.Domain, "with", command, ":", err, strings.TrimSpace(string(output)))
+			continue
+		}
+		log.Println("Reload", cert.Domain, "with", command)
+	}
+}
+
+func (a *Agent) getState(domain string) certState {
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.state[domain]
+}
+
+// setState records the version of domain and saves the state of every cert atomically
+func (a *Agent) setState(domain string, state certState) error {
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.state[domain] = state
+	data, _ := json.MarshalIndent(a.state, "", "  ")
+
+	tmp := a.config.StatePath + ".tmp"
+	err := os.MkdirAll(filepath.Dir(a.config.StatePath), 0755)
+	if err == nil {
+		err = os.WriteFile(tmp, data, 0600)
+	}
+	if err == nil {
+		err = os.Rename(tmp, a.config.StatePath)
+	}
+	return err
+}
+
+func joinPEM(blocks [][]byte) []byte {
+
+	result := []byte{}
+	for _, block := range blocks {
+		result = append(result, block...)
+	}
+	return result
+}
@@ -0,0 +1,249 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// newTestCombined returns a private key, a leaf of main with serial and its issuer, as PEM blocks
+func newTestCombined(t *testing.T, main string, serial int64) ([]byte, []byte, []byte) {
+
+	t.Helper()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: main},
+		DNSNames:     []string{main},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, caTemplate, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER})
+}
+
+func newTestAgent(t *testing.T, url string, cert CertConfig) *Agent {
+
+	t.Helper()
+	agent, err := NewAgent(&Config{
+		Url:       url,
+		Id:        "test",
+		StatePath: filepath.Join(t.TempDir(), "state.json"),
+		Certs:     []CertConfig{cert},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return agent
+}
+
+func TestWrite(t *testing.T) {
+
+	dir := t.TempDir()
+	current, err := user.Current()
+	if err != nil {
+		t.Fatal(err)
+	}
+	group, err := user.LookupGroupId(current.Gid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert := CertConfig{
+		Domain: "example.com",
+		Files: []FileConfig{
+			{Path: filepath.Join(dir, "cert.pem"), Content: ContentCert},
+			{Path: filepath.Join(dir, "chain.pem"), Content: ContentChain},
+			{Path: filepath.Join(dir, "fullchain.pem"), Content: ContentFullchain, Owner: current.Username, Group: group.Name},
+			{Path: filepath.Join(dir, "privkey.pem"), Content: ContentPrivkey},
+			{Path: filepath.Join(dir, "sub", "combined.pem"), Content: ContentCombined},
+			{Path: filepath.Join(dir, "shared.pem"), Content: ContentCert, Mode: "0640"},
+		},
+	}
+	agent := newTestAgent(t, "", cert)
+
+	// The service puts the private key first, followed by the chain
+	privateKey, leaf, ca := newTestCombined(t, "example.com", 0x1234)
+	combined := append(append(append([]byte{}, privateKey...), leaf...), ca...)
+
+	serial, changed, err := agent.write(cert, combined)
+	if err != nil || serial != "1234" || !changed {
+		t.Fatalf("write: got %q %v %v", serial, changed, err)
+	}
+
+	for _, test := range []struct {
+		name    string
+		content []byte
+		mode    os.FileMode
+	}{
+		{"cert.pem", leaf, 0644},
+		{"chain.pem", ca, 0644},
+		{"fullchain.pem", append(append([]byte{}, leaf...), ca...), 0644},
+		{"privkey.pem", privateKey, 0600},
+		{filepath.Join("sub", "combined.pem"), combined, 0600},
+		{"shared.pem", leaf, 0640},
+	} {
+		path := filepath.Join(dir, test.name)
+		data, err := os.ReadFile(path)
+		if err != nil || !bytes.Equal(data, test.content) {
+			t.Errorf("%s: unexpected content %v", test.name, err)
+		}
+		info, err := os.Stat(path)
+		if err != nil || info.Mode().Perm() != test.mode {
+			t.Errorf("%s: mode %v %v, want %v", test.name, info.Mode().Perm(), err, test.mode)
+		}
+	}
+	info, err := os.Stat(filepath.Join(dir, "fullchain.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok &&
+		(strconv.Itoa(int(stat.Uid)) != current.Uid || strconv.Itoa(int(stat.Gid)) != current.Gid) {
+		t.Errorf("fullchain.pem: owner %d:%d, want %s:%s", stat.Uid, stat.Gid, current.Uid, current.Gid)
+	}
+
+	// The same content changes nothing but the permissions
+	err = os.Chmod(filepath.Join(dir, "privkey.pem"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, changed, err = agent.write(cert, combined)
+	if err != nil || changed {
+		t.Errorf("write again: got %v %v, want unchanged", changed, err)
+	}
+	info, err = os.Stat(filepath.Join(dir, "privkey.pem"))
+	if err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("privkey.pem: mode not restored %v", err)
+	}
+
+	// A combined PEM lacking the private key is refused
+	_, _, err = agent.write(cert, append(append([]byte{}, leaf...), ca...))
+	if err == nil {
+		t.Error("write without private key succeeded")
+	}
+}
+
+func TestWriteFailure(t *testing.T) {
+
+	dir := t.TempDir()
+	blocked := filepath.Join(dir, "blocked")
+	err := os.WriteFile(blocked, nil, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert := CertConfig{
+		Domain: "example.com",
+		Files: []FileConfig{
+			{Path: filepath.Join(dir, "fullchain.pem"), Content: ContentFullchain},
+			{Path: filepath.Join(dir, "privkey.pem"), Content: ContentPrivkey},
+		},
+	}
+	agent := newTestAgent(t, "", cert)
+
+	privateKey, leaf, ca := newTestCombined(t, "example.com", 1)
+	_, _, err = agent.write(cert, append(append(append([]byte{}, privateKey...), leaf...), ca...))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The private key can not be written, so the new chain is not put in place either
+	cert.Files[1].Path = filepath.Join(blocked, "privkey.pem")
+	newKey, newLeaf, newCA := newTestCombined(t, "example.com", 2)
+	_, _, err = agent.write(cert, append(append(append([]byte{}, newKey...), newLeaf...), newCA...))
+	if err == nil {
+		t.Fatal("write to a blocked path succeeded")
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "fullchain.pem"))
+	if err != nil || !bytes.Equal(data, append(append([]byte{}, leaf...), ca...)) {
+		t.Errorf("fullchain.pem was replaced: %v", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil || len(entries) != 3 {
+		t.Errorf("staged files left behind: %v %v", entries, err)
+	}
+}
+
+func TestOnceReload(t *testing.T) {
+
+	privateKey, leaf, ca := newTestCombined(t, "example.com", 1)
+	combined := append(append(append([]byte{}, privateKey...), leaf...), ca...)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/certs/distribute" {
+			w.Header().Set("ETag", `"01-combined"`)
+			w.Write(combined)
+		}
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	reloads := filepath.Join(dir, "reloads")
+	cert := CertConfig{
+		Domain: "example.com",
+		Files:  []FileConfig{{Path: filepath.Join(dir, "combined.pem"), Content: ContentCombined}},
+		Reload: []string{"echo reload >> " + reloads},
+	}
+	agent := newTestAgent(t, server.URL, cert)
+
+	// The second sync gets the same content and does not reload
+	for i := 0; i < 2; i++ {
+		err := agent.Once(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data, err := os.ReadFile(reloads)
+	if err != nil || string(data) != "reload\n" {
+		t.Errorf("reloads: got %q %v, want a single reload", data, err)
+	}
+	if state := agent.getState("example.com"); state.Serial != "01" || state.ETag != `"01-combined"` {
+		t.Errorf("state: got %+v", state)
+	}
+}
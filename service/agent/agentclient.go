@@ -0,0 +1,118 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned when the service has no cert for a domain
+var ErrNotFound = errors.New("Certificate not found")
+
+// Release is a version of a cert fetched from the service, in combined format
+type Release struct {
+	ETag string
+	Data []byte
+}
+
+type client struct {
+	config *Config
+	http   *http.Client
+}
+
+func newClient(config *Config) *client {
+
+	return &client{
+		config: config,
+		http: &http.Client{
+			// Polls are held up to the wait
+			Timeout: time.Duration(config.Wait)*time.Second + 30*time.Second,
+		},
+	}
+}
+
+// fetch gets the cert from the distribution endpoint, returning nil when the version of etag is still current.
+// With wait, the request is held until a new version is stored or the configured wait elapses
+func (c *client) fetch(ctx context.Context, cert CertConfig, etag string, wait bool) (*Release, error) {
+
+	query := url.Values{}
+	query.Set("domain", cert.Domain)
+	query.Set("wildcard", strconv.FormatBool(cert.Wildcard))
+	query.Set("format", ContentCombined)
+	if wait && etag != "" {
+		query.Set("wait", strconv.Itoa(c.config.Wait))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(c.config.Url, "/")+"/certs/distribute?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	c.authorize(req)
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		data, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		return &Release{ETag: resp.Header.Get("ETag"), Data: data}, nil
+	case http.StatusNotModified:
+		return nil, nil
+	case http.StatusNotFound:
+		return nil, ErrNotFound
+	}
+	return nil, errors.New("Unexpected status of " + cert.Domain + ": " + resp.Status)
+}
+
+// report tells the service which version of a cert the agent holds
+func (c *client) report(ctx context.Context, cert CertConfig, serial string) error {
+
+	body, _ := json.Marshal(map[string]any{
+		"agent":    c.config.Id,
+		"domain":   cert.Domain,
+		"wildcard": cert.Wildcard,
+		"serial":   serial,
+	})
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(c.config.Url, "/")+"/agents/report", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	c.authorize(req)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.New("Unexpected status reporting " + cert.Domain + ": " + resp.Status)
+	}
+	return nil
+}
+
+func (c *client) authorize(req *http.Request) {
+
+	if c.config.Username != "" {
+		req.SetBasicAuth(c.config.Username, c.config.Password)
+	}
+	req.Header.Set("X-Agent-Id", c.config.Id)
+}
@@ -0,0 +1,179 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// Contents of a file written by the agent
+const (
+	ContentCert      = "cert"
+	ContentChain     = "chain"
+	ContentFullchain = "fullchain"
+	ContentPrivkey   = "privkey"
+	ContentCombined  = "combined"
+)
+
+// LayoutCertbot writes cert.pem, chain.pem, fullchain.pem and privkey.pem to the dir of a cert
+const LayoutCertbot = "certbot"
+
+const (
+	DefaultWait = 300
+	MaxWait     = 300
+)
+
+// Config of an agent, read from a JSON file
+type Config struct {
+
+	// Url of the service, along with its basic authentication credentials if enabled
+	Url      string `json:"url"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+
+	// Id reported to the service, the host name by default
+	Id string `json:"id"`
+
+	// StatePath keeps the version of each cert written, next to the config file by default
+	StatePath string `json:"state_path"`
+
+	// Wait is how long a poll is held by the service, in seconds
+	Wait int `json:"wait"`
+
+	Certs []CertConfig `json:"certs"`
+}
+
+type CertConfig struct {
+	Domain   string `json:"domain"`
+	Wildcard bool   `json:"wildcard"`
+
+	// Layout writes a predefined set of files to Dir, in addition to Files
+	Layout string `json:"layout"`
+	Dir    string `json:"dir"`
+
+	Files []FileConfig `json:"files"`
+
+	// Defaults of the files of this cert
+	Owner string `json:"owner"`
+	Group string `json:"group"`
+	Mode  string `json:"mode"`
+
+	// Reload commands run by sh when a file of this cert changed
+	Reload []string `json:"reload"`
+}
+
+type FileConfig struct {
+	Path    string `json:"path"`
+	Content string `json:"content"`
+	Owner   string `json:"owner"`
+	Group   string `json:"group"`
+
+	// Octal permissions, 0600 for private keys and 0644 otherwise by default
+	Mode string `json:"mode"`
+}
+
+// LoadConfig reads and validates the config at path, filling in defaults
+func LoadConfig(path string) (*Config, error) {
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var config Config
+	err = json.Unmarshal(data, &config)
+	if err != nil {
+		return nil, errors.New("Invalid agent config " + path + ": " + err.Error())
+	}
+
+	if config.Url == "" {
+		return nil, errors.New("Agent config requires url")
+	}
+	if config.Id == "" {
+		config.Id, err = os.Hostname()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if config.StatePath == "" {
+		config.StatePath = filepath.Join(filepath.Dir(path), "agent-state.json")
+	}
+	if config.Wait <= 0 {
+		config.Wait = DefaultWait
+	}
+	if config.Wait > MaxWait {
+		config.Wait = MaxWait
+	}
+	if len(config.Certs) == 0 {
+		return nil, errors.New("Agent config requires at least one cert")
+	}
+
+	for i := range config.Certs {
+		err = config.Certs[i].prepare()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &config, nil
+}
+
+// prepare expands the layout into files and applies the defaults of the cert to them
+func (c *CertConfig) prepare() error {
+
+	if c.Domain == "" {
+		return errors.New("Agent config requires the domain of every cert")
+	}
+
+	switch c.Layout {
+	case "":
+	case LayoutCertbot:
+		if c.Dir == "" {
+			return errors.New("Layout " + c.Layout + " of " + c.Domain + " requires dir")
+		}
+		for _, content := range []string{ContentCert, ContentChain, ContentFullchain, ContentPrivkey} {
+			c.Files = append(c.Files, FileConfig{
+				Path:    filepath.Join(c.Dir, content+".pem"),
+				Content: content,
+			})
+		}
+	default:
+		return errors.New("Unknown layout of " + c.Domain + ": " + c.Layout)
+	}
+
+	if len(c.Files) == 0 {
+		return errors.New("Agent config requires files or a layout for " + c.Domain)
+	}
+
+	for i := range c.Files {
+		file := &c.Files[i]
+		if file.Path == "" {
+			return errors.New("Agent config requires the path of every file of " + c.Domain)
+		}
+
+		switch file.Content {
+		case ContentCert, ContentChain, ContentFullchain, ContentPrivkey, ContentCombined:
+		default:
+			return errors.New("Unknown content of " + file.Path + ": " + file.Content)
+		}
+
+		if file.Owner == "" {
+			file.Owner = c.Owner
+		}
+		if file.Group == "" {
+			file.Group = c.Group
+		}
+		if file.Mode == "" {
+			file.Mode = c.Mode
+		}
+		if file.Mode != "" {
+			if _, err := strconv.ParseUint(file.Mode, 8, 32); err != nil {
+				return errors.New("Invalid mode of " + file.Path + ": " + file.Mode)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,111 @@
+package agent
+
+import (
+	"bytes"
+	"io/fs"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+)
+
+// stagedFile is the new content of a file written next to it, put in place by commit
+type stagedFile struct {
+	tmp  string
+	path string
+}
+
+// stageFile writes data next to path, owned and permissioned as configured. It returns nil if the content is
+// unchanged, only fixing the ownership and permissions of path then
+func stageFile(file FileConfig, data []byte) (*stagedFile, error) {
+
+	mode := fs.FileMode(0644)
+	if file.Content == ContentPrivkey || file.Content == ContentCombined {
+		mode = 0600
+	}
+	if file.Mode != "" {
+		value, _ := strconv.ParseUint(file.Mode, 8, 32)
+		mode = fs.FileMode(value)
+	}
+
+	uid, gid, err := lookupOwner(file.Owner, file.Group)
+	if err != nil {
+		return nil, err
+	}
+
+	// Unchanged content only gets its ownership and permissions fixed
+	existing, err := os.ReadFile(file.Path)
+	if err == nil && bytes.Equal(existing, data) {
+		err = os.Chmod(file.Path, mode)
+		if err == nil && (uid >= 0 || gid >= 0) {
+			err = os.Chown(file.Path, uid, gid)
+		}
+		return nil, err
+	}
+
+	dir := filepath.Dir(file.Path)
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	// Written next to the target so the rename stays on the same file system
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(file.Path)+".tmp-*")
+	if err != nil {
+		return nil, err
+	}
+
+	err = tmp.Chmod(mode)
+	if err == nil && (uid >= 0 || gid >= 0) {
+		err = tmp.Chown(uid, gid)
+	}
+	if err == nil {
+		_, err = tmp.Write(data)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	closeErr := tmp.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+
+	return &stagedFile{tmp: tmp.Name(), path: file.Path}, nil
+}
+
+// commit atomically replaces the file with its staged content
+func (s *stagedFile) commit() error {
+	return os.Rename(s.tmp, s.path)
+}
+
+// discard removes the staged content if it was not committed
+func (s *stagedFile) discard() {
+	os.Remove(s.tmp)
+}
+
+// lookupOwner returns the uid and gid of owner and group, -1 for the ones not set
+func lookupOwner(owner string, group string) (int, int, error) {
+
+	uid, gid := -1, -1
+
+	if owner != "" {
+		u, err := user.Lookup(owner)
+		if err != nil {
+			return 0, 0, err
+		}
+		uid, _ = strconv.Atoi(u.Uid)
+	}
+	if group != "" {
+		g, err := user.LookupGroup(group)
+		if err != nil {
+			return 0, 0, err
+		}
+		gid, _ = strconv.Atoi(g.Gid)
+	}
+
+	return uid, gid, nil
+}
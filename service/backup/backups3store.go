@@ -0,0 +1,119 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// S3Config locates a bucket of an S3 compatible object storage, such as MinIO
+type S3Config struct {
+	Endpoint  string
+	Bucket    string
+	Prefix    string
+	AccessKey string
+	SecretKey string
+	Region    string
+	Secure    bool
+}
+
+// S3Store keeps snapshots as objects of a bucket under a key prefix
+type S3Store struct {
+	client *minio.Client
+	bucket string
+	prefix string
+}
+
+// NewS3Store returns the store of config, creating its bucket if it does not exist
+func NewS3Store(ctx context.Context, config S3Config) (*S3Store, error) {
+
+	if config.Bucket == "" {
+		return nil, errors.New("S3 backup store requires a bucket")
+	}
+
+	client, err := minio.New(config.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(config.AccessKey, config.SecretKey, ""),
+		Secure: config.Secure,
+		Region: config.Region,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	exists, err := client.BucketExists(ctx, config.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		err = client.MakeBucket(ctx, config.Bucket, minio.MakeBucketOptions{
+			Region: config.Region,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	prefix := config.Prefix
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	return &S3Store{
+		client: client,
+		bucket: config.Bucket,
+		prefix: prefix,
+	}, nil
+}
+
+func (s *S3Store) Put(ctx context.Context, name string, path string) error {
+
+	_, err := s.client.FPutObject(ctx, s.bucket, s.prefix+name, path, minio.PutObjectOptions{
+		ContentType: "application/octet-stream",
+	})
+	return err
+}
+
+func (s *S3Store) Get(ctx context.Context, name string, path string) error {
+
+	if _, ok := ParseSnapshotName(name); !ok {
+		return errors.New("Invalid snapshot name: " + name)
+	}
+	return s.client.FGetObject(ctx, s.bucket, s.prefix+name, path, minio.GetObjectOptions{})
+}
+
+func (s *S3Store) List(ctx context.Context) ([]Snapshot, error) {
+
+	list := []Snapshot{}
+	for object := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{
+		Prefix: s.prefix + snapshotPrefix,
+	}) {
+		if object.Err != nil {
+			return nil, object.Err
+		}
+
+		snapshot, ok := ParseSnapshotName(strings.TrimPrefix(object.Key, s.prefix))
+		if !ok {
+			continue
+		}
+		snapshot.Size = object.Size
+
+		list = append(list, snapshot)
+	}
+
+	return list, nil
+}
+
+func (s *S3Store) Delete(ctx context.Context, name string) error {
+
+	if _, ok := ParseSnapshotName(name); !ok {
+		return errors.New("Invalid snapshot name: " + name)
+	}
+	return s.client.RemoveObject(ctx, s.bucket, s.prefix+name, minio.RemoveObjectOptions{})
+}
+
+func (s *S3Store) String() string {
+	return "s3://" + s.bucket + "/" + s.prefix
+}
@@ -0,0 +1,30 @@
+package backup
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+func (b *BackupService) InitBackupSchedule(backupInterval time.Duration) {
+
+	ticker := time.NewTicker(backupInterval)
+	done := make(chan bool)
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				ts := time.Now().UnixMilli()
+				snapshot, err := b.Backup(context.Background(), ts)
+				if err != nil {
+					log.Println("Unable to back up database:", err)
+					continue
+				}
+				log.Println("Backed up database to", b.Store.String(), "as", snapshot.Name)
+			case <-done:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+}
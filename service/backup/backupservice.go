@@ -0,0 +1,238 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/widhaprasa/go-acme-service/repository/sqldb"
+	"github.com/widhaprasa/go-acme-service/secret"
+)
+
+// Latest names the newest snapshot of the store
+const Latest = "latest"
+
+// BackupService takes online snapshots of the SQLite database into a store
+type BackupService struct {
+	Db    *sqldb.DB
+	Store Store
+
+	// Snapshots are sealed with the master key when not nil
+	Keyring *secret.Keyring
+
+	// Number of snapshots kept by pruning, zero keeps all of them
+	Retention int
+
+	mutex sync.Mutex
+}
+
+// Backup snapshots the database into the store, then prunes the snapshots beyond retention
+func (b *BackupService) Backup(ctx context.Context, ts int64) (Snapshot, error) {
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	dir, err := os.MkdirTemp("", "acme-backup")
+	if err != nil {
+		return Snapshot{}, err
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "acme.db")
+	err = b.Db.Snapshot(ctx, path)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	err = sqldb.CheckIntegrity(path)
+	if err != nil {
+		return Snapshot{}, err
+	}
+
+	name := SnapshotName(ts, b.Keyring != nil)
+	if b.Keyring != nil {
+		path, err = b.seal(path, filepath.Join(dir, name))
+		if err != nil {
+			return Snapshot{}, err
+		}
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return Snapshot{}, err
+	}
+
+	err = b.Store.Put(ctx, name, path)
+	if err != nil {
+		return Snapshot{}, err
+	}
+
+	snapshot, _ := ParseSnapshotName(name)
+	snapshot.Size = info.Size()
+
+	_, err = b.prune(ctx)
+	if err != nil {
+		return snapshot, err
+	}
+
+	return snapshot, nil
+}
+
+// List returns the snapshots of the store, newest first
+func (b *BackupService) List(ctx context.Context) ([]Snapshot, error) {
+
+	list, err := b.Store.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Ts > list[j].Ts
+	})
+	return list, nil
+}
+
+// Prune deletes the oldest snapshots beyond retention, returning the number deleted
+func (b *BackupService) Prune(ctx context.Context) (int, error) {
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return b.prune(ctx)
+}
+
+func (b *BackupService) prune(ctx context.Context) (int, error) {
+
+	if b.Retention <= 0 {
+		return 0, nil
+	}
+
+	list, err := b.List(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for i := b.Retention; i < len(list); i++ {
+		err = b.Store.Delete(ctx, list[i].Name)
+		if err != nil {
+			return count, err
+		}
+		count++
+	}
+
+	return count, nil
+}
+
+// Restore replaces the SQLite database at path with the snapshot of name, or the newest one for Latest.
+// The database must not be in use, the replaced file is kept next to it
+func (b *BackupService) Restore(ctx context.Context, name string, path string, ts int64) (Snapshot, error) {
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if name == Latest {
+		list, err := b.List(ctx)
+		if err != nil {
+			return Snapshot{}, err
+		}
+		if len(list) == 0 {
+			return Snapshot{}, errors.New("No snapshot in " + b.Store.String())
+		}
+		name = list[0].Name
+	}
+
+	snapshot, ok := ParseSnapshotName(name)
+	if !ok {
+		return Snapshot{}, errors.New("Invalid snapshot name: " + name)
+	}
+
+	// Stage next to the database, so it is moved into place by a rename
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".restore")
+	if err != nil {
+		return Snapshot{}, err
+	}
+	defer os.RemoveAll(dir)
+
+	staged := filepath.Join(dir, name)
+	err = b.Store.Get(ctx, name, staged)
+	if err != nil {
+		return Snapshot{}, err
+	}
+
+	if snapshot.Encrypted {
+		staged, err = b.open(staged, filepath.Join(dir, "acme.db"))
+		if err != nil {
+			return Snapshot{}, err
+		}
+	}
+	err = sqldb.CheckIntegrity(staged)
+	if err != nil {
+		return Snapshot{}, err
+	}
+
+	info, err := os.Stat(staged)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	snapshot.Size = info.Size()
+
+	if _, err := os.Stat(path); err == nil {
+		err = os.Rename(path, path+".pre-restore-"+strconv.FormatInt(ts, 10))
+		if err != nil {
+			return Snapshot{}, err
+		}
+	}
+
+	// Journal of the replaced database must not be applied to the restored one
+	for _, suffix := range []string{"-wal", "-shm", "-journal"} {
+		err = os.Remove(path + suffix)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return Snapshot{}, err
+		}
+	}
+
+	err = os.Rename(staged, path)
+	if err != nil {
+		return Snapshot{}, err
+	}
+
+	return snapshot, nil
+}
+
+func (b *BackupService) seal(path string, sealedPath string) (string, error) {
+
+	plaintext, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	envelope, err := b.Keyring.SealEnvelope(plaintext, secret.AAD("backup"))
+	if err != nil {
+		return "", err
+	}
+
+	return sealedPath, os.WriteFile(sealedPath, envelope, 0600)
+}
+
+func (b *BackupService) open(path string, openedPath string) (string, error) {
+
+	if b.Keyring == nil {
+		return "", errors.New("Snapshot is encrypted but no master key is configured")
+	}
+
+	envelope, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	plaintext, err := b.Keyring.OpenEnvelope(envelope, secret.AAD("backup"))
+	if err != nil {
+		return "", errors.New("Unable to decrypt snapshot: " + err.Error())
+	}
+
+	return openedPath, os.WriteFile(openedPath, plaintext, 0600)
+}
@@ -0,0 +1,212 @@
+package backup
+
+import (
+	"context"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/widhaprasa/go-acme-service/repository/sqldb"
+	"github.com/widhaprasa/go-acme-service/secret"
+)
+
+// newTestDb returns a SQLite database at path holding value
+func newTestDb(t *testing.T, path string, value string) *sqldb.DB {
+
+	t.Helper()
+	db, err := sqldb.Open(sqldb.Sqlite, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	_, err = db.Exec("CREATE TABLE item (value TEXT)")
+	if err == nil {
+		_, err = db.Exec("INSERT INTO item (value) VALUES (?)", value)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// readTestDb returns the value held by the SQLite database at path
+func readTestDb(t *testing.T, path string) string {
+
+	t.Helper()
+	db, err := sqldb.Open(sqldb.Sqlite, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var value string
+	err = db.QueryRow("SELECT value FROM item").Scan(&value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return value
+}
+
+func newTestKeyring(t *testing.T) *secret.Keyring {
+
+	t.Helper()
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyring, err := secret.NewKeyring(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keyring
+}
+
+func TestBackupRestore(t *testing.T) {
+
+	for _, encrypted := range []bool{false, true} {
+		t.Run("encrypted="+strconv.FormatBool(encrypted), func(t *testing.T) {
+
+			ctx := context.Background()
+			dir := t.TempDir()
+			path := filepath.Join(dir, "acme.db")
+			db := newTestDb(t, path, "before")
+
+			backupService := &BackupService{
+				Db:    db,
+				Store: &DirStore{Dir: filepath.Join(dir, "backups")},
+			}
+			if encrypted {
+				backupService.Keyring = newTestKeyring(t)
+			}
+
+			snapshot, err := backupService.Backup(ctx, 1000)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if snapshot.Encrypted != encrypted || snapshot.Name != SnapshotName(1000, encrypted) || snapshot.Size == 0 {
+				t.Fatalf("Backup: got %+v", snapshot)
+			}
+
+			_, err = db.Exec("UPDATE item SET value = ?", "after")
+			if err != nil {
+				t.Fatal(err)
+			}
+			db.Close()
+
+			if encrypted {
+				// The snapshot does not open without the master key it was sealed with
+				for _, keyring := range []*secret.Keyring{nil, newTestKeyring(t)} {
+					other := &BackupService{Store: backupService.Store, Keyring: keyring}
+					_, err = other.Restore(ctx, Latest, path, 2000)
+					if err == nil {
+						t.Fatal("Restore with another master key succeeded")
+					}
+				}
+				if readTestDb(t, path) != "after" {
+					t.Fatal("failed restore replaced the database")
+				}
+			}
+
+			restored, err := backupService.Restore(ctx, Latest, path, 3000)
+			if err != nil || restored.Name != snapshot.Name {
+				t.Fatalf("Restore: got %+v %v", restored, err)
+			}
+			if value := readTestDb(t, path); value != "before" {
+				t.Errorf("restored value %q, want before", value)
+			}
+			if value := readTestDb(t, path+".pre-restore-3000"); value != "after" {
+				t.Errorf("replaced value %q, want after", value)
+			}
+		})
+	}
+}
+
+func TestBackupRetention(t *testing.T) {
+
+	ctx := context.Background()
+	dir := t.TempDir()
+	store := &DirStore{Dir: filepath.Join(dir, "backups")}
+	backupService := &BackupService{
+		Db:        newTestDb(t, filepath.Join(dir, "acme.db"), "value"),
+		Store:     store,
+		Retention: 2,
+	}
+
+	// Files which are not snapshots are neither counted nor deleted
+	err := os.MkdirAll(store.Dir, 0700)
+	if err == nil {
+		err = os.WriteFile(filepath.Join(store.Dir, "notes.txt"), []byte("keep"), 0600)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ts := range []int64{3000, 1000, 4000, 2000} {
+		_, err = backupService.Backup(ctx, ts)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The last backup, although older, is pruned along with the older ones
+	list, err := backupService.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0].Ts != 4000 || list[1].Ts != 3000 {
+		t.Errorf("List: got %+v, want the snapshots of 4000 and 3000", list)
+	}
+	if _, err = os.Stat(filepath.Join(store.Dir, "notes.txt")); err != nil {
+		t.Error(err)
+	}
+
+	backupService.Retention = 1
+	count, err := backupService.Prune(ctx)
+	if err != nil || count != 1 {
+		t.Errorf("Prune: got %d %v, want 1", count, err)
+	}
+}
+
+func TestRestoreIntegrity(t *testing.T) {
+
+	ctx := context.Background()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "acme.db")
+	store := &DirStore{Dir: filepath.Join(dir, "backups")}
+	db := newTestDb(t, path, "current")
+	db.Close()
+
+	// A snapshot whose pages were overwritten
+	err := os.MkdirAll(store.Dir, 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 100; i < len(data); i++ {
+		data[i] = 0xff
+	}
+	name := SnapshotName(1000, false)
+	err = os.WriteFile(filepath.Join(store.Dir, name), data, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	backupService := &BackupService{Store: store}
+	_, err = backupService.Restore(ctx, name, path, 2000)
+	if err == nil {
+		t.Fatal("Restore of a corrupted snapshot succeeded")
+	}
+	if value := readTestDb(t, path); value != "current" {
+		t.Errorf("value %q, want current", value)
+	}
+	if _, err = os.Stat(path + ".pre-restore-2000"); !os.IsNotExist(err) {
+		t.Errorf("database was moved aside: %v", err)
+	}
+}
@@ -0,0 +1,180 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	snapshotPrefix    = "acme-"
+	snapshotExtension = ".db"
+	encryptExtension  = ".enc"
+	snapshotTsLayout  = "20060102T150405.000Z"
+)
+
+// Snapshot is a backup of the database kept by a store
+type Snapshot struct {
+	Name      string `json:"name"`
+	Size      int64  `json:"size"`
+	Ts        int64  `json:"ts"`
+	Encrypted bool   `json:"encrypted"`
+}
+
+// Store keeps snapshot files by name
+type Store interface {
+	Put(ctx context.Context, name string, path string) error
+	Get(ctx context.Context, name string, path string) error
+	List(ctx context.Context) ([]Snapshot, error)
+	Delete(ctx context.Context, name string) error
+	String() string
+}
+
+// SnapshotName returns the name of a snapshot taken at ts
+func SnapshotName(ts int64, encrypted bool) string {
+
+	name := snapshotPrefix + time.UnixMilli(ts).UTC().Format(snapshotTsLayout) + snapshotExtension
+	if encrypted {
+		name += encryptExtension
+	}
+	return name
+}
+
+// ParseSnapshotName returns the snapshot of name, false if name is not a snapshot
+func ParseSnapshotName(name string) (Snapshot, bool) {
+
+	snapshot := Snapshot{
+		Name: name,
+	}
+
+	if strings.HasSuffix(name, encryptExtension) {
+		snapshot.Encrypted = true
+		name = strings.TrimSuffix(name, encryptExtension)
+	}
+	if !strings.HasPrefix(name, snapshotPrefix) || !strings.HasSuffix(name, snapshotExtension) {
+		return Snapshot{}, false
+	}
+
+	t, err := time.Parse(snapshotTsLayout, strings.TrimSuffix(strings.TrimPrefix(name, snapshotPrefix), snapshotExtension))
+	if err != nil {
+		return Snapshot{}, false
+	}
+	snapshot.Ts = t.UnixMilli()
+
+	return snapshot, true
+}
+
+// DirStore keeps snapshots in a local directory
+type DirStore struct {
+	Dir string
+}
+
+func (d *DirStore) Put(ctx context.Context, name string, path string) error {
+
+	err := os.MkdirAll(d.Dir, 0700)
+	if err != nil {
+		return err
+	}
+
+	// Copy next to the destination first, so a partial snapshot never carries its name
+	tmp, err := os.CreateTemp(d.Dir, ".tmp-"+name)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	err = copyFile(tmp, path)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), filepath.Join(d.Dir, name))
+}
+
+func (d *DirStore) Get(ctx context.Context, name string, path string) error {
+
+	if _, ok := ParseSnapshotName(name); !ok {
+		return errors.New("Invalid snapshot name: " + name)
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	err = copyFile(file, filepath.Join(d.Dir, name))
+	if err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func (d *DirStore) List(ctx context.Context) ([]Snapshot, error) {
+
+	entries, err := os.ReadDir(d.Dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return []Snapshot{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	list := []Snapshot{}
+	for _, entry := range entries {
+
+		if entry.IsDir() {
+			continue
+		}
+		snapshot, ok := ParseSnapshotName(entry.Name())
+		if !ok {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		snapshot.Size = info.Size()
+
+		list = append(list, snapshot)
+	}
+
+	return list, nil
+}
+
+func (d *DirStore) Delete(ctx context.Context, name string) error {
+
+	if _, ok := ParseSnapshotName(name); !ok {
+		return errors.New("Invalid snapshot name: " + name)
+	}
+	return os.Remove(filepath.Join(d.Dir, name))
+}
+
+func (d *DirStore) String() string {
+	return d.Dir
+}
+
+func copyFile(dst io.Writer, path string) error {
+
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	_, err = io.Copy(dst, src)
+	return err
+}
@@ -0,0 +1,60 @@
+package certs
+
+import (
+	"time"
+
+	certsrepository "github.com/widhaprasa/go-acme-service/repository/certs"
+)
+
+// DefaultExpiringWithin flags the consumers holding a version expiring within it
+const DefaultExpiringWithin = 14 * 24 * time.Hour
+
+// ConsumerStatus is a consumer flagged when the version it holds is superseded or about to expire
+type ConsumerStatus struct {
+	certsrepository.Consumer
+	Superseded bool
+	Expiring   bool
+}
+
+func (s ConsumerStatus) Stale() bool {
+	return s.Superseded || s.Expiring
+}
+
+// ConsumerReport returns the consumers of main with the state of the version they hold at ts
+func (c *CertsService) ConsumerReport(ts int64, main string, expiringWithin time.Duration) ([]ConsumerStatus, error) {
+
+	list, err := c.certsRepository.ListConsumers(main)
+	if err != nil {
+		return nil, err
+	}
+	return consumerStatuses(ts, list, expiringWithin, false), nil
+}
+
+// StaleConsumers returns the consumers of every cert holding a superseded or expiring version at ts
+func (c *CertsService) StaleConsumers(ts int64, expiringWithin time.Duration) ([]ConsumerStatus, error) {
+
+	list, err := c.certsRepository.ListAllConsumers()
+	if err != nil {
+		return nil, err
+	}
+	return consumerStatuses(ts, list, expiringWithin, true), nil
+}
+
+func consumerStatuses(ts int64, list []certsrepository.Consumer, expiringWithin time.Duration, staleOnly bool) []ConsumerStatus {
+
+	result := []ConsumerStatus{}
+	for _, v := range list {
+		status := ConsumerStatus{
+			Consumer: v,
+
+			// A trashed cert has no current version to catch up with
+			Superseded: v.CurrentSerial != "" && v.Serial != v.CurrentSerial,
+			Expiring:   v.NotAfterTs > 0 && v.NotAfterTs < ts+expiringWithin.Milliseconds(),
+		}
+		if staleOnly && !status.Stale() {
+			continue
+		}
+		result = append(result, status)
+	}
+	return result
+}
@@ -0,0 +1,181 @@
+package certs
+
+import (
+	"bytes"
+	"crypto/x509"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/go-acme/lego/v4/certificate"
+	"github.com/widhaprasa/go-acme-service/acme"
+)
+
+// IssueForCSR queues the issuance of a certificate of csr under the account of email, handing the
+// certificate to done once issued. The certificate is not stored, its private key stays with the requester
+func (c *CertsService) IssueForCSR(ts int64, email string, csr *x509.CertificateRequest, done func(certificate_ []byte, err error)) error {
+
+	domains := certcrypto.ExtractDomainsCSR(csr)
+	if len(domains) == 0 {
+		return errors.New("CSR has no domain")
+	}
+	log.Println("Issue certs for CSR:", domains[0])
+
+	result := c.AddJob(Job{
+		Ts:      ts,
+		Type:    "csr",
+		Email:   email,
+		Main:    domains[0],
+		Domains: domains,
+		CSR:     csr,
+		Done:    done,
+	})
+
+	if !result {
+		return errors.New("Busy. Please try again later")
+	}
+
+	return nil
+}
+
+func (c *CertsService) csrCertsJob(job Job) ([]byte, error) {
+
+	main := job.Main
+	domains := job.Domains
+
+	client, err := c.clientService.GetClient(job.Ts, job.Email, main)
+	if err != nil {
+		log.Println("Unable to get client:", job.Email)
+		return nil, err
+	}
+
+	// Ensure CAA on managed zones
+	err = c.zoneService.EnsureCAA(domains, job.Email)
+	if err != nil {
+		log.Println("Unable to ensure CAA for domain", main, ":", err)
+		return nil, err
+	}
+
+	request := certificate.ObtainForCSRRequest{
+		CSR:            job.CSR,
+		Bundle:         true,
+		PreferredChain: "ISRG Root X1", // Default preferred chain
+	}
+
+	c.inflight.add(domains)
+	cert, err := client.Certificate.ObtainForCSR(request)
+	c.inflight.remove(domains)
+	if err != nil {
+		log.Println("Error generating certificate for CSR", main, ":", err)
+		return nil, err
+	}
+	if cert == nil || len(cert.Certificate) == 0 {
+		log.Println("Certificate for CSR", main, "is empty")
+		return nil, errors.New("Empty certificate")
+	}
+
+	log.Println("Success generating certificate for CSR", main)
+	return cert.Certificate, nil
+}
+
+// StoredForCSR returns the stored cert having exactly the domains of csr as SANs when it holds the key of csr
+// and is not due for renewal at ts, so a requester holding that key gets it without a new issuance
+func (c *CertsService) StoredForCSR(ts int64, csr *x509.CertificateRequest) ([]byte, bool) {
+
+	domains, err := acme.ValidateDomains(certcrypto.ExtractDomainsCSR(csr))
+	if err != nil {
+		return nil, false
+	}
+
+	certs, err := c.certsRepository.GetCerts(domains[0], false)
+	if err != nil || !sameSet(certs.Sans, domains) {
+		return nil, false
+	}
+	certificate_ := certs.Certificate
+
+	bundle, err := certcrypto.ParsePEMBundle(certificate_)
+	if err != nil || len(bundle) == 0 {
+		return nil, false
+	}
+
+	// Same renewal period as RenewCerts
+	if bundle[0].NotAfter.Before(time.UnixMilli(ts).Add(renewPeriod)) {
+		return nil, false
+	}
+
+	leafKey, err := x509.MarshalPKIXPublicKey(bundle[0].PublicKey)
+	if err != nil {
+		return nil, false
+	}
+	csrKey, err := x509.MarshalPKIXPublicKey(csr.PublicKey)
+	if err != nil || !bytes.Equal(leafKey, csrKey) {
+		return nil, false
+	}
+
+	return certificate_, true
+}
+
+// IsStored reports whether crt is a version of a stored cert, active or in the trash
+func (c *CertsService) IsStored(crt *x509.Certificate) (bool, error) {
+
+	serial := hex.EncodeToString(crt.SerialNumber.Bytes())
+	domains := certcrypto.ExtractDomains(crt)
+	if len(domains) == 0 {
+		return false, nil
+	}
+
+	list, err := c.certsRepository.ListOverlappingCerts(domains)
+	if err != nil {
+		return false, err
+	}
+	for _, domain := range domains {
+		trashed, err := c.certsRepository.GetTrashedCerts(domain)
+		if err == nil {
+			list = append(list, trashed)
+		}
+	}
+
+	for _, certs := range list {
+		_, err := c.certsRepository.GetVersion(certs.Main, serial)
+		if err == nil {
+			return true, nil
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return false, err
+		}
+	}
+
+	return false, nil
+}
+
+// RevokeCertificate revokes a certificate at the CA under the account of email
+func (c *CertsService) RevokeCertificate(ts int64, email string, certificate_ []byte, reason *uint) error {
+
+	bundle, err := certcrypto.ParsePEMBundle(certificate_)
+	if err != nil || len(bundle) == 0 {
+		return errors.New("Invalid certificate")
+	}
+
+	main := bundle[0].Subject.CommonName
+	if len(bundle[0].DNSNames) > 0 {
+		main = bundle[0].DNSNames[0]
+	}
+
+	client, err := c.clientService.GetClient(ts, email, main)
+	if err != nil {
+		log.Println("Unable to get client:", email)
+		return err
+	}
+
+	err = client.Certificate.RevokeWithReason(certificate_, reason)
+	if err != nil {
+		log.Println("Unable to revoke certificate", main, ":", err)
+		return err
+	}
+
+	log.Println("Success revoking certificate", main)
+	return nil
+}
@@ -0,0 +1,73 @@
+package certs
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"testing"
+	"time"
+
+	certsrepository "github.com/widhaprasa/go-acme-service/repository/certs"
+)
+
+func newTestCSR(t *testing.T, key crypto.Signer, domains ...string) *x509.CertificateRequest {
+
+	t.Helper()
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{DNSNames: domains}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return csr
+}
+
+func TestStoredForCSR(t *testing.T) {
+
+	certsService, repositories := newTestCertsService(t)
+	keys := map[string]*ecdsa.PrivateKey{}
+	for _, main := range []string{"example.com", "*.example.org"} {
+		privateKey, certificate_, key, _ := newTestBundle(t, main)
+		_, err := repositories.Certs.UpsertCerts(certsrepository.Cert{
+			Main:        main,
+			Sans:        []string{main},
+			PrivateKey:  privateKey,
+			Certificate: certificate_,
+		}, certsrepository.VersionInfo{Serial: main})
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys[main] = key
+	}
+	key := keys["example.com"]
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+
+	tests := []struct {
+		name string
+		ts   time.Time
+		csr  *x509.CertificateRequest
+		want bool
+	}{
+		{"same names and key", now, newTestCSR(t, key, "example.com"), true},
+		{"names in another case", now, newTestCSR(t, key, "EXAMPLE.com"), true},
+		{"more names", now, newTestCSR(t, key, "example.com", "www.example.com"), false},
+		{"name covered by a wildcard", now, newTestCSR(t, keys["*.example.org"], "foo.example.org"), false},
+		{"wildcard", now, newTestCSR(t, keys["*.example.org"], "*.example.org"), true},
+		{"another key", now, newTestCSR(t, otherKey, "example.com"), false},
+		{"due for renewal", now.Add(90*24*time.Hour - renewPeriod), newTestCSR(t, key, "example.com"), false},
+	}
+	for _, test := range tests {
+		_, ok := certsService.StoredForCSR(test.ts.UnixMilli(), test.csr)
+		if ok != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, ok, test.want)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package certs
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"io/fs"
+	"strings"
+	"time"
+
+	"github.com/go-acme/lego/v4/certcrypto"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// Output formats of a certificate
+const (
+	FormatFullchain = "fullchain"
+	FormatLeaf      = "leaf"
+	FormatChain     = "chain"
+	FormatCombined  = "combined"
+	FormatDer       = "der"
+	FormatPkcs12    = "pkcs12"
+	FormatJks       = "jks"
+	FormatZip       = "zip"
+)
+
+// Output formats of a private key
+const (
+	FormatPem   = "pem"
+	FormatPkcs8 = "pkcs8"
+)
+
+// Output is a cert encoded in an output format. Filename is only set for binary formats
+type Output struct {
+	Data        []byte
+	ContentType string
+	Filename    string
+}
+
+// EncodeCertificate encodes the PEM bundle of main in format, a password is required by pkcs12 and jks
+func EncodeCertificate(main string, privateKey []byte, certificate_ []byte, format string, password string) (Output, error) {
+
+	if format == "" || format == FormatFullchain {
+		return Output{Data: certificate_, ContentType: "text/plain"}, nil
+	}
+
+	bundle, err := certcrypto.ParsePEMBundle(certificate_)
+	if err != nil {
+		return Output{}, err
+	}
+	leaf := encodeCertificates(bundle[:1])
+	chain := encodeCertificates(bundle[1:])
+	name := strings.TrimPrefix(main, "*.")
+
+	switch format {
+	case FormatLeaf:
+		return Output{Data: leaf, ContentType: "text/plain"}, nil
+
+	case FormatChain:
+		return Output{Data: chain, ContentType: "text/plain"}, nil
+
+	case FormatCombined:
+		data := append(append([]byte{}, privateKey...), certificate_...)
+		return Output{Data: data, ContentType: "text/plain"}, nil
+
+	case FormatDer:
+		return Output{Data: bundle[0].Raw, ContentType: "application/pkix-cert", Filename: name + ".der"}, nil
+
+	case FormatPkcs12:
+		if password == "" {
+			return Output{}, errors.New("Password is required by pkcs12")
+		}
+		key, err := certcrypto.ParsePEMPrivateKey(privateKey)
+		if err != nil {
+			return Output{}, err
+		}
+		data, err := pkcs12.Modern.Encode(key, bundle[0], bundle[1:], password)
+		if err != nil {
+			return Output{}, err
+		}
+		return Output{Data: data, ContentType: "application/x-pkcs12", Filename: name + ".pfx"}, nil
+
+	case FormatJks:
+		if password == "" {
+			return Output{}, errors.New("Password is required by jks")
+		}
+		pkcs8, err := privateKeyPkcs8(privateKey)
+		if err != nil {
+			return Output{}, err
+		}
+		data, err := encodeJKS(main, pkcs8, bundle, password, time.Now())
+		if err != nil {
+			return Output{}, err
+		}
+		return Output{Data: data, ContentType: "application/x-java-keystore", Filename: name + ".jks"}, nil
+
+	case FormatZip:
+		data, err := encodeZip(name, []zipFile{
+			{"cert.pem", leaf, 0644},
+			{"chain.pem", chain, 0644},
+			{"fullchain.pem", certificate_, 0644},
+			{"privkey.pem", privateKey, 0600},
+		})
+		if err != nil {
+			return Output{}, err
+		}
+		return Output{Data: data, ContentType: "application/zip", Filename: name + ".zip"}, nil
+	}
+
+	return Output{}, errors.New("Unknown format: " + format)
+}
+
+// EncodePrivateKey encodes the PEM private key in format
+func EncodePrivateKey(privateKey []byte, format string) (Output, error) {
+
+	switch format {
+	case "", FormatPem:
+		return Output{Data: privateKey, ContentType: "text/plain"}, nil
+
+	case FormatPkcs8:
+		pkcs8, err := privateKeyPkcs8(privateKey)
+		if err != nil {
+			return Output{}, err
+		}
+		data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8})
+		return Output{Data: data, ContentType: "text/plain"}, nil
+	}
+
+	return Output{}, errors.New("Unknown format: " + format)
+}
+
+func privateKeyPkcs8(privateKey []byte) ([]byte, error) {
+
+	key, err := certcrypto.ParsePEMPrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	return x509.MarshalPKCS8PrivateKey(key)
+}
+
+func encodeCertificates(certs []*x509.Certificate) []byte {
+
+	result := []byte{}
+	for _, crt := range certs {
+		result = append(result, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: crt.Raw})...)
+	}
+	return result
+}
+
+type zipFile struct {
+	name string
+	data []byte
+	mode fs.FileMode
+}
+
+// encodeZip writes files under the dir folder, as certbot lays out a live certificate
+func encodeZip(dir string, files []zipFile) ([]byte, error) {
+
+	var buf bytes.Buffer
+	writer := zip.NewWriter(&buf)
+
+	for _, file := range files {
+		header := &zip.FileHeader{
+			Name:     dir + "/" + file.name,
+			Method:   zip.Deflate,
+			Modified: time.Now(),
+		}
+		header.SetMode(file.mode)
+
+		w, err := writer.CreateHeader(header)
+		if err != nil {
+			return nil, err
+		}
+		_, err = w.Write(file.data)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := writer.Close()
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
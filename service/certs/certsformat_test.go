@@ -0,0 +1,271 @@
+package certs
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"testing"
+	"time"
+
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// newTestBundle returns a leaf of main signed by a test CA, as the PEM private key and full chain
+func newTestBundle(t *testing.T, main string) ([]byte, []byte, *ecdsa.PrivateKey, []*x509.Certificate) {
+
+	t.Helper()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(365 * 24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, _ := x509.ParseCertificate(caDER)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: main},
+		DNSNames:     []string{main},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, _ := x509.ParseCertificate(der)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privateKey := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	certificate := append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER})...)
+	return privateKey, certificate, key, []*x509.Certificate{leaf, ca}
+}
+
+type testJKSEntry struct {
+	alias string
+	ts    int64
+	key   []byte
+	chain [][]byte
+}
+
+// decodeTestJKS reads a JKS keystore the way the JDK does, checking the integrity digest and
+// recovering the private keys through the Sun KeyProtector
+func decodeTestJKS(data []byte, password string) ([]testJKSEntry, error) {
+
+	passwordBytes := jksPassword(password)
+	if len(data) < sha1.Size {
+		return nil, errors.New("keystore is too short")
+	}
+	body, sum := data[:len(data)-sha1.Size], data[len(data)-sha1.Size:]
+	digest := sha1.New()
+	digest.Write(passwordBytes)
+	digest.Write([]byte("Mighty Aphrodite"))
+	digest.Write(body)
+	if !bytes.Equal(digest.Sum(nil), sum) {
+		return nil, errors.New("keystore was tampered with, or password was incorrect")
+	}
+
+	r := bytes.NewReader(body)
+	var magic, version, count uint32
+	binary.Read(r, binary.BigEndian, &magic)
+	binary.Read(r, binary.BigEndian, &version)
+	binary.Read(r, binary.BigEndian, &count)
+	if magic != jksMagic || version != jksVersion {
+		return nil, errors.New("not a JKS keystore")
+	}
+
+	entries := []testJKSEntry{}
+	for i := uint32(0); i < count; i++ {
+		var tag uint32
+		binary.Read(r, binary.BigEndian, &tag)
+		if tag != jksPrivateKeyEntry {
+			return nil, errors.New("not a private key entry")
+		}
+		entry := testJKSEntry{alias: readTestUTF(r)}
+		binary.Read(r, binary.BigEndian, &entry.ts)
+
+		var info jksEncryptedPrivateKeyInfo
+		_, err := asn1.Unmarshal(readTestBytes(r), &info)
+		if err != nil {
+			return nil, err
+		}
+		if !info.Algorithm.Algorithm.Equal(jksKeyProtectorOid) {
+			return nil, errors.New("not protected by the Sun KeyProtector")
+		}
+		entry.key, err = recoverTestJKSKey(info.EncryptedData, passwordBytes)
+		if err != nil {
+			return nil, err
+		}
+
+		var chainLength uint32
+		binary.Read(r, binary.BigEndian, &chainLength)
+		for j := uint32(0); j < chainLength; j++ {
+			if certType := readTestUTF(r); certType != "X.509" {
+				return nil, errors.New("unexpected certificate type " + certType)
+			}
+			entry.chain = append(entry.chain, readTestBytes(r))
+		}
+		entries = append(entries, entry)
+	}
+	if r.Len() != 0 {
+		return nil, errors.New("trailing data")
+	}
+	return entries, nil
+}
+
+// recoverTestJKSKey is KeyProtector.recover of the JDK
+func recoverTestJKSKey(protected []byte, passwordBytes []byte) ([]byte, error) {
+
+	if len(protected) < 2*sha1.Size {
+		return nil, errors.New("protected key is too short")
+	}
+	salt := protected[:sha1.Size]
+	encrypted := protected[sha1.Size : len(protected)-sha1.Size]
+	check := protected[len(protected)-sha1.Size:]
+
+	key := make([]byte, len(encrypted))
+	digest := salt
+	for i := 0; i < len(encrypted); i += sha1.Size {
+		h := sha1.New()
+		h.Write(passwordBytes)
+		h.Write(digest)
+		digest = h.Sum(nil)
+		for j := 0; j < sha1.Size && i+j < len(encrypted); j++ {
+			key[i+j] = encrypted[i+j] ^ digest[j]
+		}
+	}
+
+	h := sha1.New()
+	h.Write(passwordBytes)
+	h.Write(key)
+	if !bytes.Equal(h.Sum(nil), check) {
+		return nil, errors.New("cannot recover key")
+	}
+	return key, nil
+}
+
+func readTestUTF(r io.Reader) string {
+
+	var length uint16
+	binary.Read(r, binary.BigEndian, &length)
+	b := make([]byte, length)
+	io.ReadFull(r, b)
+	return string(b)
+}
+
+func readTestBytes(r io.Reader) []byte {
+
+	var length uint32
+	binary.Read(r, binary.BigEndian, &length)
+	b := make([]byte, length)
+	io.ReadFull(r, b)
+	return b
+}
+
+func TestEncodeJKS(t *testing.T) {
+
+	privateKey, certificate, key, chain := newTestBundle(t, "*.example.com")
+	password := "pässwörd"
+
+	output, err := EncodeCertificate("*.example.com", privateKey, certificate, FormatJks, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output.Filename != "example.com.jks" {
+		t.Errorf("filename %q", output.Filename)
+	}
+
+	entries, err := decodeTestJKS(output.Data, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("%d entries, want 1", len(entries))
+	}
+	entry := entries[0]
+	if entry.alias != "*.example.com" {
+		t.Errorf("alias %q, want the main domain", entry.alias)
+	}
+
+	recovered, err := x509.ParsePKCS8PrivateKey(entry.key)
+	if err != nil {
+		t.Fatalf("recovered key is not PKCS#8: %v", err)
+	}
+	if signer, ok := recovered.(crypto.Signer); !ok || !key.PublicKey.Equal(signer.Public()) {
+		t.Fatal("recovered key does not match")
+	}
+
+	if len(entry.chain) != len(chain) {
+		t.Fatalf("chain of %d certificates, want %d", len(entry.chain), len(chain))
+	}
+	for i, der := range entry.chain {
+		if !bytes.Equal(der, chain[i].Raw) {
+			t.Errorf("certificate %d of the chain does not match", i)
+		}
+	}
+
+	// A wrong password fails the integrity check
+	_, err = decodeTestJKS(output.Data, "password")
+	if err == nil {
+		t.Fatal("keystore opened with a wrong password")
+	}
+}
+
+func TestEncodePkcs12(t *testing.T) {
+
+	privateKey, certificate, key, chain := newTestBundle(t, "example.com")
+
+	output, err := EncodeCertificate("example.com", privateKey, certificate, FormatPkcs12, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decodedKey, leaf, caCerts, err := pkcs12.DecodeChain(output.Data, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signer, ok := decodedKey.(crypto.Signer); !ok || !key.PublicKey.Equal(signer.Public()) {
+		t.Fatal("decoded key does not match")
+	}
+	if !leaf.Equal(chain[0]) {
+		t.Fatal("decoded leaf does not match")
+	}
+	if len(caCerts) != 1 || !caCerts[0].Equal(chain[1]) {
+		t.Fatalf("decoded %d chain certificates, want the CA", len(caCerts))
+	}
+
+	_, _, _, err = pkcs12.DecodeChain(output.Data, "wrong")
+	if err == nil {
+		t.Fatal("decoded with a wrong password")
+	}
+}
@@ -0,0 +1,130 @@
+package certs
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"time"
+	"unicode/utf16"
+)
+
+// Sun proprietary key protection algorithm of JKS private key entries
+var jksKeyProtectorOid = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 42, 2, 17, 1, 1}
+
+const (
+	jksMagic           = 0xfeedfeed
+	jksVersion         = 2
+	jksPrivateKeyEntry = 1
+)
+
+type jksEncryptedPrivateKeyInfo struct {
+	Algorithm     pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+// encodeJKS writes a Java keystore holding a single private key entry under alias, protected by password
+func encodeJKS(alias string, pkcs8 []byte, chain []*x509.Certificate, password string, ts time.Time) ([]byte, error) {
+
+	if len(chain) == 0 {
+		return nil, errors.New("Certificate chain is empty")
+	}
+
+	passwordBytes := jksPassword(password)
+	protected, err := jksProtectKey(pkcs8, passwordBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	encryptedKey, err := asn1.Marshal(jksEncryptedPrivateKeyInfo{
+		Algorithm: pkix.AlgorithmIdentifier{
+			Algorithm:  jksKeyProtectorOid,
+			Parameters: asn1.NullRawValue,
+		},
+		EncryptedData: protected,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, uint32(jksMagic))
+	binary.Write(&buf, binary.BigEndian, uint32(jksVersion))
+	binary.Write(&buf, binary.BigEndian, uint32(1))
+
+	binary.Write(&buf, binary.BigEndian, uint32(jksPrivateKeyEntry))
+	err = jksWriteUTF(&buf, alias)
+	if err != nil {
+		return nil, err
+	}
+	binary.Write(&buf, binary.BigEndian, ts.UnixMilli())
+	binary.Write(&buf, binary.BigEndian, uint32(len(encryptedKey)))
+	buf.Write(encryptedKey)
+
+	binary.Write(&buf, binary.BigEndian, uint32(len(chain)))
+	for _, crt := range chain {
+		jksWriteUTF(&buf, "X.509")
+		binary.Write(&buf, binary.BigEndian, uint32(len(crt.Raw)))
+		buf.Write(crt.Raw)
+	}
+
+	// Keystore integrity digest
+	digest := sha1.New()
+	digest.Write(passwordBytes)
+	digest.Write([]byte("Mighty Aphrodite"))
+	digest.Write(buf.Bytes())
+	buf.Write(digest.Sum(nil))
+
+	return buf.Bytes(), nil
+}
+
+// jksProtectKey encrypts the key as the Sun KeyProtector does: a random salt, the key XORed with
+// a SHA-1 keystream of the password and salt, then a SHA-1 check of the password and key
+func jksProtectKey(key []byte, passwordBytes []byte) ([]byte, error) {
+
+	salt := make([]byte, sha1.Size)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return nil, err
+	}
+
+	encrypted := make([]byte, len(key))
+	block := salt
+	for i := 0; i < len(key); i += sha1.Size {
+		sum := sha1.Sum(append(append([]byte{}, passwordBytes...), block...))
+		block = sum[:]
+		for j := 0; j < sha1.Size && i+j < len(key); j++ {
+			encrypted[i+j] = key[i+j] ^ block[j]
+		}
+	}
+
+	check := sha1.Sum(append(append([]byte{}, passwordBytes...), key...))
+
+	result := append([]byte{}, salt...)
+	result = append(result, encrypted...)
+	return append(result, check[:]...), nil
+}
+
+// jksPassword returns the password as UTF-16 big endian
+func jksPassword(password string) []byte {
+
+	result := []byte{}
+	for _, v := range utf16.Encode([]rune(password)) {
+		result = append(result, byte(v>>8), byte(v))
+	}
+	return result
+}
+
+func jksWriteUTF(buf *bytes.Buffer, value string) error {
+
+	if len(value) > 0xffff {
+		return errors.New("Alias is too long")
+	}
+	binary.Write(buf, binary.BigEndian, uint16(len(value)))
+	buf.WriteString(value)
+	return nil
+}
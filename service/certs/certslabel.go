@@ -0,0 +1,79 @@
+package certs
+
+import (
+	"errors"
+	"log"
+
+	certsrepository "github.com/widhaprasa/go-acme-service/repository/certs"
+)
+
+// ErrEmptySelector is returned for a bulk operation without a positive requirement, which would select every cert
+// or nearly so
+var ErrEmptySelector = errors.New("Selector must have at least one key=value or key requirement")
+
+// SelectCerts returns the active certs whose labels match selector, without private keys and certificates
+func (c *CertsService) SelectCerts(selector certsrepository.Selector) ([]certsrepository.Cert, error) {
+
+	if !selector.Positive() {
+		return nil, ErrEmptySelector
+	}
+
+	result := []certsrepository.Cert{}
+	query := certsrepository.CertsQuery{
+		Limit:  certsrepository.MaxListLimit,
+		Labels: selector,
+	}
+	for {
+		page, err := c.certsRepository.ListCertsPage(query)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, page.Certs...)
+
+		if page.NextCursor == "" {
+			return result, nil
+		}
+		query.Cursor = page.NextCursor
+	}
+}
+
+// BulkTrashCerts moves the certs matching selector to the trash, returning their mains
+func (c *CertsService) BulkTrashCerts(ts int64, selector certsrepository.Selector) ([]string, error) {
+
+	list, err := c.SelectCerts(selector)
+	if err != nil {
+		return nil, err
+	}
+
+	mains := []string{}
+	for _, v := range list {
+		err = c.TrashCerts(ts, v.Main)
+		if err != nil {
+			return mains, err
+		}
+		mains = append(mains, v.Main)
+	}
+
+	return mains, nil
+}
+
+// BulkUpdateLabels sets and removes labels of the certs matching selector, returning their mains
+func (c *CertsService) BulkUpdateLabels(selector certsrepository.Selector, set map[string]string, remove []string) ([]string, error) {
+
+	list, err := c.SelectCerts(selector)
+	if err != nil {
+		return nil, err
+	}
+
+	mains := []string{}
+	for _, v := range list {
+		err = c.certsRepository.UpdateLabels(v.Main, set, remove)
+		if err != nil {
+			log.Println("Unable to update labels", v.Main, ":", err)
+			return mains, err
+		}
+		mains = append(mains, v.Main)
+	}
+
+	return mains, nil
+}
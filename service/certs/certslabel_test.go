@@ -0,0 +1,48 @@
+package certs
+
+import (
+	"errors"
+	"testing"
+
+	certsrepository "github.com/widhaprasa/go-acme-service/repository/certs"
+)
+
+func TestBulkTrashCertsSelector(t *testing.T) {
+
+	certsService, repositories := newTestCertsService(t)
+	for main, env := range map[string]string{"a.com": "prod", "b.com": "staging", "c.com": ""} {
+		upsertTestCert(t, repositories, main)
+		if env == "" {
+			continue
+		}
+		err := repositories.Certs.UpdateLabels(main, map[string]string{"env": env}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Negative requirements alone would select nearly every cert
+	for _, value := range []string{"", "!env", "env!=prod", "!team,env!=staging"} {
+		selector, err := certsrepository.ParseSelector(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mains, err := certsService.BulkTrashCerts(1, selector)
+		if !errors.Is(err, ErrEmptySelector) || len(mains) != 0 {
+			t.Errorf("%q: got %v %v, want ErrEmptySelector", value, mains, err)
+		}
+	}
+
+	selector, err := certsrepository.ParseSelector("env,env!=staging")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mains, err := certsService.BulkTrashCerts(1, selector)
+	if err != nil || len(mains) != 1 || mains[0] != "a.com" {
+		t.Fatalf("env,env!=staging: got %v %v, want a.com", mains, err)
+	}
+	list, err := repositories.Certs.ListCerts()
+	if err != nil || len(list) != 2 {
+		t.Errorf("remaining certs: got %d %v, want 2", len(list), err)
+	}
+}
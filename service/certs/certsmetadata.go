@@ -0,0 +1,119 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"log"
+
+	"github.com/go-acme/lego/v4/certcrypto"
+	"github.com/go-acme/lego/v4/certificate"
+	certsrepository "github.com/widhaprasa/go-acme-service/repository/certs"
+)
+
+// certMetadata parses the leaf and chain of a PEM bundle. The ARI window is asked to the CA when certifier is set
+func certMetadata(main string, certificate_ []byte, certifier *certificate.Certifier) *certsrepository.Metadata {
+
+	bundle, err := certcrypto.ParsePEMBundle(certificate_)
+	if err != nil || len(bundle) == 0 {
+		log.Println("Unable to parse certificate of", main, ":", err)
+		return nil
+	}
+	crt := bundle[0]
+
+	fingerprint := sha256.Sum256(crt.Raw)
+	spkiFingerprint := sha256.Sum256(crt.RawSubjectPublicKeyInfo)
+	keyType, keySize := publicKeyInfo(crt)
+
+	ca := crt.Issuer.CommonName
+	if len(crt.Issuer.Organization) > 0 {
+		ca = crt.Issuer.Organization[0]
+	}
+
+	chain := []string{}
+	for _, v := range bundle[1:] {
+		chain = append(chain, v.Subject.String())
+	}
+
+	result := &certsrepository.Metadata{
+		Serial:             hex.EncodeToString(crt.SerialNumber.Bytes()),
+		Subject:            crt.Subject.String(),
+		Issuer:             crt.Issuer.String(),
+		Chain:              chain,
+		KeyType:            keyType,
+		KeySize:            keySize,
+		Fingerprint:        hex.EncodeToString(fingerprint[:]),
+		SpkiFingerprint:    hex.EncodeToString(spkiFingerprint[:]),
+		SignatureAlgorithm: crt.SignatureAlgorithm.String(),
+		CA:                 ca,
+	}
+
+	// Only certs carrying an authority key id have an ARI cert id
+	certId, err := certificate.MakeARICertID(crt)
+	if err != nil {
+		return result
+	}
+	result.AriCertId = certId
+
+	if certifier != nil {
+		info, err := certifier.GetRenewalInfo(certificate.RenewalInfoRequest{Cert: crt})
+		if err != nil {
+			log.Println("Unable to get renewal info of", main, ":", err)
+			return result
+		}
+		result.AriWindowStartTs = info.SuggestedWindow.Start.UnixMilli()
+		result.AriWindowEndTs = info.SuggestedWindow.End.UnixMilli()
+	}
+
+	return result
+}
+
+func publicKeyInfo(crt *x509.Certificate) (string, int) {
+
+	switch key := crt.PublicKey.(type) {
+	case *rsa.PublicKey:
+		return "RSA", key.N.BitLen()
+	case *ecdsa.PublicKey:
+		return "ECDSA", key.Curve.Params().BitSize
+	case ed25519.PublicKey:
+		return "Ed25519", 256
+	}
+	return crt.PublicKeyAlgorithm.String(), 0
+}
+
+// BackfillMetadata parses the metadata of the active and trashed certs stored before it was introduced
+func (c *CertsService) BackfillMetadata() error {
+
+	list, err := c.certsRepository.ListCerts()
+	if err != nil {
+		return err
+	}
+	trashed, err := c.certsRepository.ListTrashedCerts()
+	if err != nil {
+		return err
+	}
+	list = append(list, trashed...)
+
+	for _, v := range list {
+
+		if v.Metadata != nil {
+			continue
+		}
+
+		metadata := certMetadata(v.Main, v.Certificate, nil)
+		if metadata == nil {
+			continue
+		}
+
+		_, err = c.certsRepository.UpdateMetadata(v.Main, v.Serial, *metadata)
+		if err != nil {
+			log.Println("Unable to update metadata", v.Main, ":", err)
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,163 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	certsrepository "github.com/widhaprasa/go-acme-service/repository/certs"
+)
+
+// newTestRSAChain returns the PEM chain of an RSA leaf of main issued by an intermediate of organization
+func newTestRSAChain(t *testing.T, main string, organization string) ([]byte, *x509.Certificate) {
+
+	t.Helper()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test R1", Organization: []string{organization}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(365 * 24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		SubjectKeyId:          []byte{1, 2, 3, 4},
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(0xabcdef),
+		Subject:      pkix.Name{CommonName: main},
+		DNSNames:     []string{main},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, caTemplate, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER})...), leaf
+}
+
+func TestCertMetadata(t *testing.T) {
+
+	chain, leaf := newTestRSAChain(t, "example.com", "Test Org")
+	metadata := certMetadata("example.com", chain, nil)
+	if metadata == nil {
+		t.Fatal("no metadata")
+	}
+
+	fingerprint := sha256.Sum256(leaf.Raw)
+	spkiFingerprint := sha256.Sum256(leaf.RawSubjectPublicKeyInfo)
+	for _, test := range []struct {
+		name string
+		got  any
+		want any
+	}{
+		{"serial", metadata.Serial, "abcdef"},
+		{"subject", metadata.Subject, "CN=example.com"},
+		{"fingerprint", metadata.Fingerprint, hex.EncodeToString(fingerprint[:])},
+		{"spki fingerprint", metadata.SpkiFingerprint, hex.EncodeToString(spkiFingerprint[:])},
+		{"key type", metadata.KeyType, "RSA"},
+		{"key size", metadata.KeySize, 2048},
+		{"ca", metadata.CA, "Test Org"},
+		{"chain", len(metadata.Chain), 1},
+		{"signature algorithm", metadata.SignatureAlgorithm, "ECDSA-SHA256"},
+	} {
+		if test.got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, test.got, test.want)
+		}
+	}
+	if metadata.Chain[0] != "CN=Test R1,O=Test Org" {
+		t.Errorf("chain: got %v", metadata.Chain)
+	}
+
+	// The ARI cert id needs the authority key id of the issuer
+	if metadata.AriCertId == "" || metadata.AriWindowStartTs != 0 {
+		t.Errorf("ari: got %q %d", metadata.AriCertId, metadata.AriWindowStartTs)
+	}
+
+	// An issuer without organization is named by its common name
+	_, ecChain, _, _ := newTestBundle(t, "example.org")
+	metadata = certMetadata("example.org", ecChain, nil)
+	if metadata == nil || metadata.CA != "Test CA" || metadata.KeyType != "ECDSA" || metadata.KeySize != 256 {
+		t.Errorf("ecdsa: got %+v", metadata)
+	}
+
+	if metadata = certMetadata("example.net", []byte("not a certificate"), nil); metadata != nil {
+		t.Errorf("invalid: got %+v", metadata)
+	}
+}
+
+func TestBackfillMetadata(t *testing.T) {
+
+	certsService, repositories := newTestCertsService(t)
+	kept := &certsrepository.Metadata{Serial: "kept"}
+
+	for _, test := range []struct {
+		main     string
+		metadata *certsrepository.Metadata
+		trashed  bool
+	}{
+		{"a.com", kept, false},
+		{"b.com", nil, false},
+		{"c.com", nil, true},
+	} {
+		chain, leaf := newTestRSAChain(t, test.main, "Test Org")
+		_, err := repositories.Certs.UpsertCerts(certsrepository.Cert{
+			Main:        test.main,
+			Sans:        []string{test.main},
+			Certificate: chain,
+			Metadata:    test.metadata,
+		}, versionInfo(leaf, "generate"))
+		if err == nil && test.trashed {
+			_, err = repositories.Certs.TrashCerts(test.main, 1)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := certsService.BackfillMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the certs stored without metadata are parsed, trashed ones included
+	cert, err := repositories.Certs.GetCertsByMain("a.com")
+	if err != nil || cert.Metadata == nil || cert.Metadata.Serial != "kept" {
+		t.Errorf("a.com: got %+v %v, want the stored metadata", cert.Metadata, err)
+	}
+	cert, err = repositories.Certs.GetCertsByMain("b.com")
+	if err != nil || cert.Metadata == nil || cert.Metadata.Serial != "abcdef" {
+		t.Errorf("b.com: got %+v %v, want backfilled metadata", cert.Metadata, err)
+	}
+	cert, err = repositories.Certs.GetTrashedCerts("c.com")
+	if err != nil || cert.Metadata == nil || cert.Metadata.KeyType != "RSA" {
+		t.Errorf("c.com: got %+v %v, want backfilled metadata", cert.Metadata, err)
+	}
+}
@@ -0,0 +1,124 @@
+package certs
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/widhaprasa/go-acme-service/acme"
+	webhookrepository "github.com/widhaprasa/go-acme-service/repository/webhook"
+)
+
+// nextJob returns the job queued by the last call
+func nextJob(t *testing.T, certsService *CertsService) Job {
+
+	t.Helper()
+	select {
+	case job := <-certsService.jobs:
+		return job
+	default:
+		t.Fatal("No job was queued")
+		return Job{}
+	}
+}
+
+func TestModifySans(t *testing.T) {
+
+	certsService, repositories := newTestCertsService(t)
+	upsertTestCert(t, repositories, "a.com", "www.a.com", "xn--bcher-kva.example")
+	upsertTestCert(t, repositories, "b.com")
+
+	// Added and removed domains are compared in their stored form
+	added, removed, err := certsService.ModifySans(1000, "a.com", []string{"API.a.com.", "www.a.com"}, []string{"BÜCHER.example."})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(added, ",") != "api.a.com" || strings.Join(removed, ",") != "xn--bcher-kva.example" {
+		t.Errorf("ModifySans: got added %v removed %v", added, removed)
+	}
+	job := nextJob(t, certsService)
+	if job.Type != "sans" || job.Main != "a.com" || !sameSet(job.Domains, []string{"a.com", "www.a.com", "api.a.com"}) {
+		t.Errorf("job: got %+v", job)
+	}
+
+	for _, test := range []struct {
+		name   string
+		add    []string
+		remove []string
+	}{
+		{"main", nil, []string{"A.com."}},
+		{"unchanged", []string{"www.a.com"}, []string{"unknown.a.com"}},
+		{"invalid", nil, []string{"a.*.a.com"}},
+		{"other cert", []string{"b.com"}, nil},
+	} {
+		_, _, err = certsService.ModifySans(1000, "a.com", test.add, test.remove)
+		if err == nil {
+			t.Errorf("%s: ModifySans succeeded", test.name)
+		}
+	}
+	var domainsErr *acme.DomainsError
+	if _, _, err = certsService.ModifySans(1000, "a.com", nil, []string{"a.*.a.com"}); !errors.As(err, &domainsErr) {
+		t.Errorf("invalid: got %v, want domains error", err)
+	}
+	var overlapErr *OverlapError
+	if _, _, err = certsService.ModifySans(1000, "a.com", []string{"b.com"}, nil); !errors.As(err, &overlapErr) {
+		t.Errorf("other cert: got %v, want overlap error", err)
+	}
+	if len(certsService.jobs) != 0 {
+		t.Errorf("%d jobs queued by refused modifications", len(certsService.jobs))
+	}
+}
+
+func TestModifySansWebhook(t *testing.T) {
+
+	certsService, repositories := newTestCertsService(t)
+	upsertTestCert(t, repositories, "a.com", "www.a.com")
+
+	bodies := make(chan []byte, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies <- body
+	}))
+	defer server.Close()
+	_, err := repositories.Webhook.UpsertWebhook(webhookrepository.Webhook{Main: "a.com", Url: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err = certsService.ModifySans(1000, "a.com", []string{"api.a.com"}, []string{"www.a.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The job pushes the SAN diff to the stored webhook once issued
+	job := nextJob(t, certsService)
+	err = certsService.webhookPush(job.Type, job.Main, job.Email, []byte("key"), []byte("cert"), job.WebhookUrl, job.WebhookHeaders, job.WebhookExtra)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var event struct {
+		Type        string   `json:"type"`
+		Main        string   `json:"main"`
+		SansAdded   []string `json:"sans_added"`
+		SansRemoved []string `json:"sans_removed"`
+	}
+	err = json.Unmarshal(<-bodies, &event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Type != "sans" || event.Main != "a.com" ||
+		strings.Join(event.SansAdded, ",") != "api.a.com" || strings.Join(event.SansRemoved, ",") != "www.a.com" {
+		t.Errorf("event: got %+v", event)
+	}
+
+	// The stored webhook is kept
+	webhook, err := repositories.Webhook.GetWebhook("a.com")
+	if err != nil || webhook.Url != server.URL {
+		t.Errorf("GetWebhook: got %+v %v", webhook, err)
+	}
+}
@@ -1,6 +1,9 @@
 package certs
 
-import "time"
+import (
+	"crypto/x509"
+	"time"
+)
 
 func (c *CertsService) InitRenewSchedule(ts int64) {
 
@@ -25,19 +28,76 @@ func (c *CertsService) InitRenewSchedule(ts int64) {
 	}()
 }
 
+func (c *CertsService) InitSweepSchedule() {
+
+	sweepInterval := time.Hour // Default interval, sweep stale challenge records hourly
+
+	ticker := time.NewTicker(sweepInterval)
+	done := make(chan bool)
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				ts := time.Now().UnixMilli()
+				c.SweepChallenges(ts)
+			case <-done:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+}
+
+func (c *CertsService) InitPurgeSchedule() {
+
+	purgeInterval := time.Hour // Default interval, purge certs trashed beyond retention hourly
+
+	ticker := time.NewTicker(purgeInterval)
+	done := make(chan bool)
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				ts := time.Now().UnixMilli()
+				c.PurgeTrash(ts)
+			case <-done:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+}
+
+// Job is a queued certificate issuance
+type Job struct {
+	Ts             int64
+	Type           string
+	Email          string
+	Main           string
+	Domains        []string
+	WebhookUrl     string
+	WebhookHeaders map[string]any
+	WebhookExtra   map[string]any
+	Labels         map[string]string
+	Notes          string
+
+	// Set for a certificate of a CSR, issued without being stored then handed to Done
+	CSR  *x509.CertificateRequest
+	Done func(certificate_ []byte, err error)
+}
+
 func (c *CertsService) InitJobSchedule() {
 
 	go func() {
-		for payload := range c.jobs {
+		for job := range c.jobs {
 
-			ts := payload["ts"].(int64)
-			email := payload["email"].(string)
-			main := payload["main"].(string)
-			domains := payload["domains"].([]string)
-			webhookUrl := payload["webhook_url"].(string)
-			webhookHeaderMap := payload["webhook_headers"].(map[string]any)
+			if job.CSR != nil {
+				certificate_, err := c.csrCertsJob(job)
+				job.Done(certificate_, err)
+				continue
+			}
 
-			err := c.generateCertsJob(ts, email, main, domains, webhookUrl, webhookHeaderMap)
+			err := c.generateCertsJob(job)
 			if err != nil {
 
 			}
@@ -45,10 +105,10 @@ func (c *CertsService) InitJobSchedule() {
 	}()
 }
 
-func (c *CertsService) AddJob(payload map[string]any) bool {
+func (c *CertsService) AddJob(job Job) bool {
 
 	select {
-	case c.jobs <- payload:
+	case c.jobs <- job:
 		return true
 	default:
 		return false
@@ -4,65 +4,160 @@ import (
 	"bytes"
 	"crypto/tls"
 	"crypto/x509"
+	"database/sql"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"slices"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-acme/lego/v4/certificate"
-	"github.com/widhaprasa/go-acme-service/repository/certs"
-	"github.com/widhaprasa/go-acme-service/repository/webhook"
+	"github.com/widhaprasa/go-acme-service/acme"
+	"github.com/widhaprasa/go-acme-service/env"
+	certsrepository "github.com/widhaprasa/go-acme-service/repository/certs"
+	webhookrepository "github.com/widhaprasa/go-acme-service/repository/webhook"
 	"github.com/widhaprasa/go-acme-service/service/client"
+	"github.com/widhaprasa/go-acme-service/service/zone"
 )
 
 type CertsService struct {
-	certsRepository   certs.CertsRepository
+	certsRepository   certsrepository.CertsRepository
 	clientService     client.ClientService
-	webhookRepository webhook.WebhookRepository
-	jobs              chan map[string]any
+	webhookRepository webhookrepository.WebhookRepository
+	zoneService       *zone.ZoneService
+	jobs              chan Job
+	inflight          *inflightDomains
 }
 
-func NewCertsService(certsrepository certs.CertsRepository, clientservice client.ClientService, webhookRepository webhook.WebhookRepository) CertsService {
+// Handling of requested domains overlapping existing certs
+const (
+	OverlapFail     = "fail"
+	OverlapExtend   = "extend"
+	OverlapSeparate = "separate"
+)
+
+type Overlap struct {
+	Main   string   `json:"main"`
+	Sans   []string `json:"sans"`
+	Shared []string `json:"shared"`
+}
+
+// OverlapError is returned when requested domains overlap existing certs and mode does not allow it
+type OverlapError struct {
+	Mode     string
+	Overlaps []Overlap
+}
+
+func (e *OverlapError) Error() string {
+	var mains []string
+	for _, v := range e.Overlaps {
+		mains = append(mains, v.Main)
+	}
+	return "Domains overlap existing certs: " + strings.Join(mains, ", ")
+}
+
+// TrashedError is returned when the cert to issue would be named after a trashed cert, upserting it would restore the trashed one
+type TrashedError struct {
+	Main string
+}
+
+func (e *TrashedError) Error() string {
+	return "Domain " + e.Main + " is the main of a trashed cert, restore it with /certs/restore or wait until it is purged"
+}
+
+// inflightDomains tracks domains with a challenge in progress
+type inflightDomains struct {
+	mu      sync.Mutex
+	domains map[string]int
+}
+
+func (i *inflightDomains) add(domains []string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	for _, domain := range domains {
+		i.domains[strings.TrimPrefix(domain, "*.")]++
+	}
+}
+
+func (i *inflightDomains) remove(domains []string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	for _, domain := range domains {
+		domain = strings.TrimPrefix(domain, "*.")
+		i.domains[domain]--
+		if i.domains[domain] <= 0 {
+			delete(i.domains, domain)
+		}
+	}
+}
+
+func (i *inflightDomains) contains(domain string) bool {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.domains[strings.TrimPrefix(domain, "*.")] > 0
+}
+
+func NewCertsService(certsRepository certsrepository.CertsRepository, clientService client.ClientService, webhookRepository webhookrepository.WebhookRepository,
+	zoneService *zone.ZoneService) CertsService {
 
 	jobsNumber := 5 // Max job queues
-	jobs := make(chan map[string]any, jobsNumber)
+	jobs := make(chan Job, jobsNumber)
 
 	return CertsService{
-		certsRepository:   certsrepository,
-		clientService:     clientservice,
+		certsRepository:   certsRepository,
+		clientService:     clientService,
 		webhookRepository: webhookRepository,
+		zoneService:       zoneService,
 		jobs:              jobs,
+		inflight: &inflightDomains{
+			domains: map[string]int{},
+		},
 	}
 }
 
-func (c *CertsService) GenerateCerts(ts int64, email string, domains []string, webhookUrl string, webhookHeaderMap map[string]any) (string, error) {
+func (c *CertsService) GenerateCerts(ts int64, email string, domains []string, webhookUrl string, webhookHeaderMap map[string]any,
+	preflight bool, overlap string, labels map[string]string, notes string) (string, error) {
 
-	domains, err := c.validateDomains(domains)
+	domains, err := acme.ValidateDomains(domains)
 	if err != nil {
-		log.Println("No domain was given")
+		log.Println("Invalid domains:", err)
 		return "", err
 	}
-	var main string
 
-	certs, err := c.certsRepository.GetCertsByMain(domains)
+	main, domains, err := c.resolveOverlap(domains, overlap)
 	if err != nil {
-		main = domains[0]
-	} else {
-		main = certs["main"].(string)
+		log.Println("Unable to resolve overlap:", err)
+		return "", err
+	}
+
+	if preflight {
+		result, err := c.Preflight(domains, email)
+		if err != nil {
+			return "", err
+		}
+		if !result.Ok {
+			log.Println("Preflight failed:", domains)
+			return "", &acme.PreflightError{Result: result}
+		}
 	}
 	log.Println("Generate certs:", main)
 
-	result := c.AddJob(map[string]any{
-		"ts":              ts,
-		"email":           email,
-		"main":            main,
-		"domains":         domains,
-		"webhook_url":     webhookUrl,
-		"webhook_headers": webhookHeaderMap,
+	result := c.AddJob(Job{
+		Ts:             ts,
+		Type:           "generate",
+		Email:          email,
+		Main:           main,
+		Domains:        domains,
+		WebhookUrl:     webhookUrl,
+		WebhookHeaders: webhookHeaderMap,
+		Labels:         labels,
+		Notes:          notes,
 	})
 
 	if !result {
@@ -72,7 +167,282 @@ func (c *CertsService) GenerateCerts(ts int64, email string, domains []string, w
 	return main, nil
 }
 
-func (c *CertsService) generateCertsJob(ts int64, email string, main string, domains []string, webhookUrl string, webhookHeaderMap map[string]any) error {
+// ModifySans reissues the cert under the same main with domains added to and removed from its SANs.
+// The stored webhook is kept and receives a "sans" event listing the SAN diff
+func (c *CertsService) ModifySans(ts int64, main string, add []string, remove []string) ([]string, []string, error) {
+
+	certs, err := c.certsRepository.GetCertsByMain(main)
+	if err != nil {
+		return nil, nil, err
+	}
+	email := certs.Email
+	sans := certs.Sans
+
+	if len(add) > 0 {
+		add, err = acme.ValidateDomains(add)
+		if err != nil {
+			log.Println("Invalid domains:", err)
+			return nil, nil, err
+		}
+	}
+
+	// Compared in the stored form, e.g. punycode
+	if len(remove) > 0 {
+		remove, err = acme.ValidateDomains(remove)
+		if err != nil {
+			log.Println("Invalid domains:", err)
+			return nil, nil, err
+		}
+	}
+
+	removeMap := make(map[string]struct{})
+	for _, domain := range remove {
+		if domain == main {
+			return nil, nil, errors.New("Main domain can not be removed")
+		}
+		removeMap[domain] = struct{}{}
+	}
+
+	var domains []string
+	for _, domain := range union(sans, add) {
+		if _, exists := removeMap[domain]; !exists {
+			domains = append(domains, domain)
+		}
+	}
+
+	added := []string{}
+	for _, domain := range domains {
+		if len(intersect([]string{domain}, sans)) == 0 {
+			added = append(added, domain)
+		}
+	}
+	removed := []string{}
+	for _, domain := range sans {
+		if len(intersect([]string{domain}, domains)) == 0 {
+			removed = append(removed, domain)
+		}
+	}
+	if len(added) == 0 && len(removed) == 0 {
+		return nil, nil, errors.New("SANs are unchanged")
+	}
+
+	domains, err = acme.ValidateDomains(domains)
+	if err != nil {
+		log.Println("Invalid domains:", err)
+		return nil, nil, err
+	}
+
+	// Added domains must not belong to another cert
+	if len(added) > 0 {
+		list, err := c.certsRepository.ListOverlappingCerts(added)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		overlaps := []Overlap{}
+		for _, v := range list {
+			if v.Main == main {
+				continue
+			}
+			overlaps = append(overlaps, Overlap{
+				Main:   v.Main,
+				Sans:   v.Sans,
+				Shared: intersect(v.Sans, added),
+			})
+		}
+		if len(overlaps) > 0 {
+			return nil, nil, &OverlapError{Mode: OverlapFail, Overlaps: overlaps}
+		}
+	}
+
+	log.Println("Modify certs SANs:", main, "added:", added, "removed:", removed)
+
+	result := c.AddJob(Job{
+		Ts:             ts,
+		Type:           "sans",
+		Email:          email,
+		Main:           main,
+		Domains:        domains,
+		WebhookUrl:     "",
+		WebhookHeaders: map[string]any{},
+		WebhookExtra: map[string]any{
+			"sans_added":   added,
+			"sans_removed": removed,
+		},
+	})
+
+	if !result {
+		return nil, nil, errors.New("Busy. Please try again later")
+	}
+
+	return added, removed, nil
+}
+
+// resolveOverlap returns the main and SANs of the cert to issue, given the existing certs sharing SANs with domains.
+// Requesting the exact SANs of an existing cert reissues it, any other overlap is handled according to mode
+func (c *CertsService) resolveOverlap(domains []string, mode string) (string, []string, error) {
+
+	list, err := c.certsRepository.ListOverlappingCerts(domains)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(list) == 0 {
+		trashed, err := c.trashedMain(domains[0])
+		if err != nil {
+			return "", nil, err
+		}
+		if trashed {
+			return "", nil, &TrashedError{Main: domains[0]}
+		}
+		return domains[0], domains, nil
+	}
+
+	overlaps := []Overlap{}
+	for _, v := range list {
+		overlaps = append(overlaps, Overlap{
+			Main:   v.Main,
+			Sans:   v.Sans,
+			Shared: intersect(v.Sans, domains),
+		})
+	}
+
+	if len(overlaps) == 1 && sameSet(overlaps[0].Sans, domains) {
+		return overlaps[0].Main, domains, nil
+	}
+
+	switch mode {
+	case OverlapExtend:
+
+		// Only a single cert can be extended
+		if len(overlaps) > 1 {
+			return "", nil, &OverlapError{Mode: mode, Overlaps: overlaps}
+		}
+		return overlaps[0].Main, union(overlaps[0].Sans, domains), nil
+
+	case OverlapSeparate:
+
+		// Name the new cert after the first domain not used as main yet, by an active or a trashed cert
+		for _, domain := range domains {
+			_, err := c.certsRepository.GetCertsByMain(domain)
+			if err == nil {
+				continue
+			}
+			if !errors.Is(err, sql.ErrNoRows) {
+				return "", nil, err
+			}
+			trashed, err := c.trashedMain(domain)
+			if err != nil {
+				return "", nil, err
+			}
+			if !trashed {
+				return domain, domains, nil
+			}
+		}
+		return "", nil, &OverlapError{Mode: mode, Overlaps: overlaps}
+
+	case OverlapFail, "":
+		return "", nil, &OverlapError{Mode: OverlapFail, Overlaps: overlaps}
+
+	default:
+		return "", nil, errors.New("Unknown overlap mode: " + mode)
+	}
+}
+
+// trashedMain reports whether main is the main of a trashed cert
+func (c *CertsService) trashedMain(main string) (bool, error) {
+
+	certs, err := c.certsRepository.GetTrashedCerts(main)
+	if err == nil {
+		return certs.Main == main, nil
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	var conflictErr *certsrepository.ConflictError
+	if errors.As(err, &conflictErr) {
+		return slices.Contains(conflictErr.Candidates, main), nil
+	}
+	return false, err
+}
+
+func (c *CertsService) Preflight(domains []string, email string) (*acme.PreflightResult, error) {
+
+	domains, err := acme.ValidateDomains(domains)
+	if err != nil {
+		log.Println("Invalid domains:", err)
+		return nil, err
+	}
+
+	provider, err := acme.NewDNSProvider(domains[0])
+	if err != nil {
+		log.Println("Unable to initiate Cloudflare DNS Provider:", err)
+		return nil, err
+	}
+	provider = c.zoneService.TrackProvider(provider)
+
+	log.Println("Preflight certs:", domains)
+	c.inflight.add(domains)
+	result := acme.Preflight(domains, provider, env.ACME_CAA_IDENTITY, acme.Resolvers)
+	c.inflight.remove(domains)
+
+	// Report managed CAA, a missing record will be created before issuance
+	result.Ok = true
+	for i := range result.Domains {
+		item := &result.Domains[i]
+
+		managed, zone, present, err := c.zoneService.CAAStatus(item.Domain, email)
+		if managed {
+			check := acme.PreflightCheck{
+				Name: "caa_managed",
+				Ok:   err == nil,
+			}
+			switch {
+			case err != nil:
+				check.Message = err.Error()
+			case present:
+				check.Message = "CAA record present on zone " + zone
+			default:
+				check.Message = "CAA record will be created on zone " + zone
+			}
+			item.Checks = append(item.Checks, check)
+
+			// Only the records at the zone apex are written, a record set found below it still refuses
+			if err == nil {
+				for j := range item.Checks {
+					if item.Checks[j].Name == "caa" && !item.Checks[j].Ok {
+						_, caaErr := acme.CheckCAA(item.Domain, env.ACME_CAA_IDENTITY, acme.Resolvers)
+						var refused *acme.CAAError
+						if errors.As(caaErr, &refused) && strings.EqualFold(strings.TrimSuffix(refused.Name, "."), zone) {
+							item.Checks[j].Ok = true
+							item.Checks[j].Message += " (managed)"
+						} else {
+							item.Checks[j].Message += " (managed records are only written at " + zone + ")"
+						}
+					}
+				}
+			}
+		}
+
+		item.Ok = true
+		for _, check := range item.Checks {
+			if !check.Ok {
+				item.Ok = false
+			}
+		}
+		if !item.Ok {
+			result.Ok = false
+		}
+	}
+
+	return result, nil
+}
+
+func (c *CertsService) generateCertsJob(job Job) error {
+
+	ts := job.Ts
+	email := job.Email
+	main := job.Main
+	domains := job.Domains
 
 	client, err := c.clientService.GetClient(ts, email, main)
 	if err != nil {
@@ -80,13 +450,22 @@ func (c *CertsService) generateCertsJob(ts int64, email string, main string, dom
 		return err
 	}
 
+	// Ensure CAA on managed zones
+	err = c.zoneService.EnsureCAA(domains, email)
+	if err != nil {
+		log.Println("Unable to ensure CAA for domain", main, ":", err)
+		return err
+	}
+
 	request := certificate.ObtainRequest{
 		Domains:        domains,
 		Bundle:         true,
 		PreferredChain: "ISRG Root X1", // Default preferred chain
 	}
 
+	c.inflight.add(domains)
 	cert, err := client.Certificate.Obtain(request)
+	c.inflight.remove(domains)
 	if err != nil {
 		log.Println("Error generating certificate for domain", main, ":", err)
 		return err
@@ -111,26 +490,50 @@ func (c *CertsService) generateCertsJob(ts int64, email string, main string, dom
 	crt, _ := c.getX509Certificate(res)
 
 	// Insert certs to database
-	_, err = c.certsRepository.UpsertCerts(main, strings.Join(domains, ","), email, privateKey, certificate_,
-		crt.NotBefore.UnixMilli(), crt.NotAfter.UnixMilli(), ts)
+	_, err = c.certsRepository.UpsertCerts(certsrepository.Cert{
+		Main:        main,
+		Sans:        domains,
+		Email:       email,
+		PrivateKey:  privateKey,
+		Certificate: certificate_,
+		NotBeforeTs: crt.NotBefore.UnixMilli(),
+		NotAfterTs:  crt.NotAfter.UnixMilli(),
+		UpsertedTs:  ts,
+		Metadata:    certMetadata(main, certificate_, client.Certificate),
+	}, versionInfo(crt, job.Type))
 	if err != nil {
 		log.Println("Failed to insert certs", main, ":", err)
 		return err
 	}
 
+	// Labels and notes given at generate time, before pushing so selector webhooks see them
+	if len(job.Labels) > 0 {
+		err = c.certsRepository.UpdateLabels(main, job.Labels, nil)
+		if err != nil {
+			log.Println("Failed to update labels", main, ":", err)
+		}
+	}
+	if job.Notes != "" {
+		err = c.certsRepository.UpdateNotes(main, job.Notes)
+		if err != nil {
+			log.Println("Failed to update notes", main, ":", err)
+		}
+	}
+
 	// Push to webhook
-	c.webhookPush("generate", main, email, privateKey, certificate_, webhookUrl, webhookHeaderMap)
+	c.webhookPush(job.Type, main, email, privateKey, certificate_, job.WebhookUrl, job.WebhookHeaders, job.WebhookExtra)
 
 	log.Println("Success generating certificate for domain", main)
 	return nil
 }
 
+// Certificates valid for less than this are renewed
+const renewPeriod = 30 * 24 * time.Hour
+
 func (c *CertsService) RenewCerts(ts int64) error {
 
 	log.Println("Run schedule renewing certificates...")
 
-	renewPeriod := 30 * 24 * time.Hour // Default period, renew certificates if they are valid for less than a month
-
 	list, err := c.certsRepository.ListCerts()
 	if err != nil {
 		log.Println("No domain was given")
@@ -139,11 +542,10 @@ func (c *CertsService) RenewCerts(ts int64) error {
 
 	for _, v := range list {
 
-		certsMap := v.(map[string]any)
-		main := certsMap["main"].(string)
-		sans := certsMap["sans"].(string)
-		privateKey := certsMap["private_key"].([]byte)
-		certificate_ := certsMap["certificate"].([]byte)
+		main := v.Main
+		sans := v.Sans
+		privateKey := v.PrivateKey
+		certificate_ := v.Certificate
 
 		res := certificate.Resource{
 			Domain:      main,
@@ -157,18 +559,26 @@ func (c *CertsService) RenewCerts(ts int64) error {
 			// Renew certs
 			log.Println("Renewing certificates:", main)
 
-			email := certsMap["email"].(string)
+			email := v.Email
 			client, err := c.clientService.GetClient(ts, email, main)
 			if err != nil {
 				return err
 			}
 
+			// Ensure CAA on managed zones, the renewal may still pass with the records already there
+			err = c.zoneService.EnsureCAA(sans, email)
+			if err != nil {
+				log.Println("Unable to ensure CAA for domain", main, ":", err)
+			}
+
 			opts := &certificate.RenewOptions{
 				Bundle:         true,
 				PreferredChain: "ISRG Root X1", // Default preferred chain
 			}
 
+			c.inflight.add(sans)
 			renewedCert, err := client.Certificate.RenewWithOptions(res, opts)
+			c.inflight.remove(sans)
 			if err != nil {
 				log.Println("Error renewing certificate for domain", main, ":", err)
 				return err
@@ -189,15 +599,24 @@ func (c *CertsService) RenewCerts(ts int64) error {
 			renewedCrt, _ := c.getX509Certificate(renewedRes)
 
 			// Update new certs to database
-			_, err = c.certsRepository.UpsertCerts(main, sans, email, renewedPrivateKey, renewedCertificate,
-				renewedCrt.NotBefore.UnixMilli(), renewedCrt.NotAfter.UnixMilli(), ts)
+			_, err = c.certsRepository.UpsertCerts(certsrepository.Cert{
+				Main:        main,
+				Sans:        sans,
+				Email:       email,
+				PrivateKey:  renewedPrivateKey,
+				Certificate: renewedCertificate,
+				NotBeforeTs: renewedCrt.NotBefore.UnixMilli(),
+				NotAfterTs:  renewedCrt.NotAfter.UnixMilli(),
+				UpsertedTs:  ts,
+				Metadata:    certMetadata(main, renewedCertificate, client.Certificate),
+			}, versionInfo(renewedCrt, "renew"))
 			if err != nil {
 				log.Println("Failed to update certs", email, ":", err)
 				return err
 			}
 
 			// Push to webhook
-			c.webhookPush("renew", main, email, renewedPrivateKey, renewedCertificate, "", map[string]any{})
+			c.webhookPush("renew", main, email, renewedPrivateKey, renewedCertificate, "", map[string]any{}, map[string]any{})
 
 			log.Println("Success renewing certificate for domain", main)
 		}
@@ -225,54 +644,86 @@ func (c *CertsService) getX509Certificate(res certificate.Resource) (*x509.Certi
 	return crt, err
 }
 
-func (c *CertsService) validateDomains(domains []string) ([]string, error) {
-
-	if len(domains) == 0 {
-		return nil, errors.New("No domain was given")
-	}
-
-	map_ := make(map[string]struct{})
-
-	var result []string
-	for _, str := range domains {
-		if _, exists := map_[str]; !exists {
-			map_[str] = struct{}{}
-			result = append(result, str)
-		}
-	}
-	return result, nil
-}
-
 func (c *CertsService) webhookPush(type_ string, main string, email string, privateKey []byte, certificate_ []byte,
-	webhookUrl string, webhookHeaderMap map[string]any) error {
+	webhookUrl string, webhookHeaderMap map[string]any, webhookExtraMap map[string]any) error {
 
-	webhookBody, _ := json.Marshal(map[string]any{
+	webhookBodyMap := map[string]any{
 		"type":        type_,
 		"main":        main,
 		"email":       email,
 		"private_key": base64.StdEncoding.EncodeToString(privateKey),
 		"certificate": base64.StdEncoding.EncodeToString(certificate_),
-	})
+	}
+	for key, value := range webhookExtraMap {
+		webhookBodyMap[key] = value
+	}
+	webhookBody, _ := json.Marshal(webhookBodyMap)
 
 	if webhookUrl == "" {
 
 		// Retrieve webhook url from db
 		webhook, err := c.webhookRepository.GetWebhook(main)
-		if err != nil {
-			return err
+		if err == nil {
+			webhookUrl = webhook.Url
+			webhookHeaderMap = webhook.Headers
 		}
-		webhookUrl = webhook["url"].(string)
-		webhookHeaderMap = webhook["headers"].(map[string]any)
 
 	} else {
 
 		// Update webhook url if specified
-		_, err := c.webhookRepository.UpsertWebhook(main, webhookUrl, webhookHeaderMap)
+		_, err := c.webhookRepository.UpsertWebhook(webhookrepository.Webhook{
+			Main:    main,
+			Url:     webhookUrl,
+			Headers: webhookHeaderMap,
+		})
 		if err != nil {
 			return err
 		}
 	}
 
+	var err error
+	if webhookUrl != "" {
+		err = webhookPost(type_, main, webhookUrl, webhookHeaderMap, webhookBody)
+	}
+
+	// Push to the webhooks of label selectors matching the cert
+	selectorErr := c.selectorWebhookPush(type_, main, webhookBody)
+	if err == nil {
+		err = selectorErr
+	}
+	return err
+}
+
+func (c *CertsService) selectorWebhookPush(type_ string, main string, webhookBody []byte) error {
+
+	list, err := c.webhookRepository.ListSelectorWebhook()
+	if err != nil || len(list) == 0 {
+		return err
+	}
+
+	labels, err := c.certsRepository.GetLabels(main)
+	if err != nil {
+		return err
+	}
+
+	for _, v := range list {
+
+		selector, parseErr := certsrepository.ParseSelector(v.Selector)
+		if parseErr != nil || !selector.Matches(labels) {
+			continue
+		}
+
+		postErr := webhookPost(type_, main, v.Url, v.Headers, webhookBody)
+		if postErr != nil {
+			err = postErr
+		}
+	}
+
+	return err
+}
+
+func webhookPost(type_ string, main string, webhookUrl string, webhookHeaderMap map[string]any, webhookBody []byte) error {
+
 	log.Println("Push webhook for domain:", main, "to:", webhookUrl, "type:", type_)
 
 	// Push to webhook
@@ -292,3 +743,52 @@ func (c *CertsService) webhookPush(type_ string, main string, email string, priv
 	defer resp.Body.Close()
 	return err
 }
+
+func intersect(a []string, b []string) []string {
+
+	map_ := make(map[string]struct{})
+	for _, str := range b {
+		map_[str] = struct{}{}
+	}
+
+	result := []string{}
+	for _, str := range a {
+		if _, exists := map_[str]; exists {
+			result = append(result, str)
+		}
+	}
+	return result
+}
+
+func union(a []string, b []string) []string {
+
+	map_ := make(map[string]struct{})
+
+	result := []string{}
+	for _, str := range append(append([]string{}, a...), b...) {
+		if _, exists := map_[str]; !exists {
+			map_[str] = struct{}{}
+			result = append(result, str)
+		}
+	}
+	return result
+}
+
+// sameSet reports whether a and b hold the same domains, ignoring order and duplicates
+func sameSet(a []string, b []string) bool {
+
+	a = union(a, nil)
+	b = union(b, nil)
+	if len(a) != len(b) {
+		return false
+	}
+
+	sort.Strings(a)
+	sort.Strings(b)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,141 @@
+package certs
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/widhaprasa/go-acme-service/repository"
+	certsrepository "github.com/widhaprasa/go-acme-service/repository/certs"
+	"github.com/widhaprasa/go-acme-service/service/client"
+	"github.com/widhaprasa/go-acme-service/service/zone"
+)
+
+func newTestCertsService(t *testing.T) (*CertsService, *repository.Repositories) {
+
+	t.Helper()
+	repositories := repository.NewMemory()
+	clientService := client.ClientService{
+		Clientrepository: repositories.Client,
+	}
+	zoneService := &zone.ZoneService{
+		ZoneRepository:   repositories.Zone,
+		ClientRepository: repositories.Client,
+	}
+	certsService := NewCertsService(repositories.Certs, clientService, repositories.Webhook, zoneService)
+	return &certsService, repositories
+}
+
+func upsertTestCert(t *testing.T, repositories *repository.Repositories, main string, sans ...string) {
+
+	t.Helper()
+	_, err := repositories.Certs.UpsertCerts(certsrepository.Cert{
+		Main:  main,
+		Sans:  append([]string{main}, sans...),
+		Email: "admin@example.com",
+	}, certsrepository.VersionInfo{
+		Serial: main + "-1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSameSet(t *testing.T) {
+
+	tests := []struct {
+		a, b []string
+		want bool
+	}{
+		{[]string{"a.com", "b.com"}, []string{"b.com", "a.com"}, true},
+		{[]string{"a.com", "a.com"}, []string{"a.com"}, true},
+		{[]string{"a.com", "b.com"}, []string{"a.com"}, false},
+		{[]string{"a.com"}, []string{"a.com", "b.com"}, false},
+		{[]string{"a.com", "b.com"}, []string{"a.com", "c.com"}, false},
+	}
+	for _, test := range tests {
+		if got := sameSet(test.a, test.b); got != test.want {
+			t.Errorf("sameSet(%v, %v) = %v, want %v", test.a, test.b, got, test.want)
+		}
+	}
+}
+
+func TestResolveOverlap(t *testing.T) {
+
+	certsService, repositories := newTestCertsService(t)
+	upsertTestCert(t, repositories, "a.com", "b.com")
+
+	// Exact SANs reissue the existing cert
+	main, domains, err := certsService.resolveOverlap([]string{"b.com", "a.com"}, OverlapFail)
+	if err != nil || main != "a.com" || len(domains) != 2 {
+		t.Fatalf("exact: got %q %v %v", main, domains, err)
+	}
+
+	// A subset of the SANs must not silently drop b.com
+	_, _, err = certsService.resolveOverlap([]string{"a.com"}, "")
+	var overlapErr *OverlapError
+	if !errors.As(err, &overlapErr) || overlapErr.Mode != OverlapFail {
+		t.Fatalf("subset: got %v, want overlap error", err)
+	}
+
+	// A superset fails by default and extends on request
+	_, _, err = certsService.resolveOverlap([]string{"a.com", "b.com", "c.com"}, OverlapFail)
+	if !errors.As(err, &overlapErr) {
+		t.Fatalf("superset: got %v, want overlap error", err)
+	}
+	main, domains, err = certsService.resolveOverlap([]string{"a.com", "b.com", "c.com"}, OverlapExtend)
+	if err != nil || main != "a.com" || !sameSet(domains, []string{"a.com", "b.com", "c.com"}) {
+		t.Fatalf("extend: got %q %v %v", main, domains, err)
+	}
+
+	// Separate names the new cert after the first domain not used as main
+	main, _, err = certsService.resolveOverlap([]string{"a.com", "c.com"}, OverlapSeparate)
+	if err != nil || main != "c.com" {
+		t.Fatalf("separate: got %q %v", main, err)
+	}
+
+	// Unrelated domains do not overlap
+	main, _, err = certsService.resolveOverlap([]string{"d.com"}, OverlapFail)
+	if err != nil || main != "d.com" {
+		t.Fatalf("unrelated: got %q %v", main, err)
+	}
+}
+
+func TestResolveOverlapTrashed(t *testing.T) {
+
+	certsService, repositories := newTestCertsService(t)
+	upsertTestCert(t, repositories, "a.com", "b.com")
+	upsertTestCert(t, repositories, "c.com")
+	_, err := repositories.Certs.TrashCerts("c.com", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Issuing under the main of a trashed cert would restore and overwrite it
+	_, _, err = certsService.resolveOverlap([]string{"c.com", "d.com"}, OverlapFail)
+	var trashedErr *TrashedError
+	if !errors.As(err, &trashedErr) || trashedErr.Main != "c.com" {
+		t.Fatalf("trashed main: got %v, want trashed error", err)
+	}
+
+	// A trashed SAN that is not its main can still name a new cert
+	upsertTestCert(t, repositories, "e.com", "f.com")
+	_, err = repositories.Certs.TrashCerts("e.com", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	main, _, err := certsService.resolveOverlap([]string{"f.com"}, OverlapFail)
+	if err != nil || main != "f.com" {
+		t.Fatalf("trashed san: got %q %v", main, err)
+	}
+
+	// Separate skips the mains of trashed certs
+	main, _, err = certsService.resolveOverlap([]string{"a.com", "c.com", "d.com"}, OverlapSeparate)
+	if err != nil || main != "d.com" {
+		t.Fatalf("separate: got %q %v", main, err)
+	}
+	_, _, err = certsService.resolveOverlap([]string{"a.com", "c.com"}, OverlapSeparate)
+	var overlapErr *OverlapError
+	if !errors.As(err, &overlapErr) {
+		t.Fatalf("separate without free main: got %v, want overlap error", err)
+	}
+}
@@ -0,0 +1,145 @@
+package certs
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	"github.com/widhaprasa/go-acme-service/acme"
+	"github.com/widhaprasa/go-acme-service/service/zone"
+)
+
+// Challenge records younger than this are left alone, it outlasts the longest propagation wait of an order
+const sweepMinAge = 2 * time.Hour
+
+// SweepChallenges removes the challenge records created by the service that were not cleaned up, records
+// created by anything else are never removed
+func (c *CertsService) SweepChallenges(ts int64) (acme.SweepResult, error) {
+
+	log.Println("Run schedule sweeping challenge records...")
+
+	provider, err := c.zoneService.GetRecordProvider()
+	if err != nil {
+		return acme.SweepResult{}, err
+	}
+
+	zones, err := c.managedZones(provider)
+	if err != nil {
+		return acme.SweepResult{}, err
+	}
+
+	records, err := c.zoneService.ZoneRepository.ListChallengeRecords()
+	if err != nil {
+		return acme.SweepResult{}, err
+	}
+
+	created := map[[2]string]int64{}
+	for _, record := range records {
+		created[[2]string{record.Fqdn, record.Value}] = record.CreatedTs
+
+		// A delegated challenge record is on the zone of its target
+		if ts-record.CreatedTs >= sweepMinAge.Milliseconds() {
+			recordZone, err := provider.FindZone(record.Fqdn)
+			if err == nil && !contains(zones, recordZone) {
+				zones = append(zones, recordZone)
+			}
+		}
+	}
+
+	stale := func(record acme.DNSRecord) bool {
+		key := challengeKey(record)
+		createdTs, exists := created[key]
+		return exists && ts-createdTs >= sweepMinAge.Milliseconds() &&
+			!c.inflight.contains(strings.TrimPrefix(key[0], "_acme-challenge."))
+	}
+
+	result := acme.SweepChallenges(ts, provider, zones, stale)
+	log.Println("Swept challenge records, removed:", len(result.Removed), "skipped:", len(result.Skipped),
+		"failed:", len(result.Failed))
+
+	// Removed records are forgotten, as are stale ones no longer on their zone once every zone was listed
+	seen := map[[2]string]bool{}
+	listed := true
+	for _, item := range result.Removed {
+		key := challengeKey(acme.DNSRecord{Name: item.Name, Content: item.Content})
+		c.zoneService.ZoneRepository.DeleteChallengeRecord(key[0], key[1])
+		seen[key] = true
+	}
+	for _, items := range [][]acme.SweptRecord{result.Skipped, result.Failed} {
+		for _, item := range items {
+			if item.ID == "" {
+				listed = false
+				continue
+			}
+			seen[challengeKey(acme.DNSRecord{Name: item.Name, Content: item.Content})] = true
+		}
+	}
+	if listed {
+		for _, record := range records {
+			key := [2]string{record.Fqdn, record.Value}
+			if !seen[key] && ts-record.CreatedTs >= sweepMinAge.Milliseconds() {
+				c.zoneService.ZoneRepository.DeleteChallengeRecord(record.Fqdn, record.Value)
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// challengeKey identifies a challenge TXT record as challenge records are stored
+func challengeKey(record acme.DNSRecord) [2]string {
+	return [2]string{zone.ChallengeFqdn(record.Name), strings.Trim(record.Content, `"`)}
+}
+
+func contains(list []string, value string) bool {
+
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// managedZones returns the zones of every certificate SAN and every configured zone
+func (c *CertsService) managedZones(provider acme.RecordProvider) ([]string, error) {
+
+	list, err := c.certsRepository.ListCerts()
+	if err != nil {
+		return nil, err
+	}
+
+	var domains []string
+	for _, v := range list {
+		domains = append(domains, v.Sans...)
+	}
+
+	zoneMap := map[string]struct{}{}
+	var result []string
+
+	zoneList, err := c.zoneService.ZoneRepository.ListZone()
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range zoneList {
+		zone := v.Zone
+		if _, exists := zoneMap[zone]; !exists {
+			zoneMap[zone] = struct{}{}
+			result = append(result, zone)
+		}
+	}
+
+	for _, domain := range domains {
+		zone, err := provider.FindZone(domain)
+		if err != nil {
+			log.Println("Unable to find zone for", domain, ":", err)
+			continue
+		}
+		if _, exists := zoneMap[zone]; !exists {
+			zoneMap[zone] = struct{}{}
+			result = append(result, zone)
+		}
+	}
+
+	return result, nil
+}
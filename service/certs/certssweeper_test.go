@@ -0,0 +1,107 @@
+package certs
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/widhaprasa/go-acme-service/acme"
+	zonerepository "github.com/widhaprasa/go-acme-service/repository/zone"
+)
+
+// testRecordProvider keeps the records of a single zone
+type testRecordProvider struct {
+	mu      sync.Mutex
+	zone    string
+	records []acme.DNSRecord
+	lastId  int
+}
+
+func (p *testRecordProvider) FindZone(domain string) (string, error) {
+	return p.zone, nil
+}
+
+func (p *testRecordProvider) ListRecords(zone string, type_ string) ([]acme.DNSRecord, error) {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	result := []acme.DNSRecord{}
+	for _, record := range p.records {
+		if record.Type == type_ {
+			result = append(result, record)
+		}
+	}
+	return result, nil
+}
+
+func (p *testRecordProvider) CreateRecord(zone string, record acme.DNSRecord) (acme.DNSRecord, error) {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.lastId++
+	record.ID = strconv.Itoa(p.lastId)
+	p.records = append(p.records, record)
+	return record, nil
+}
+
+func (p *testRecordProvider) DeleteRecord(zone string, id string) error {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i, record := range p.records {
+		if record.ID == id {
+			p.records = append(p.records[:i], p.records[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func TestSweepChallenges(t *testing.T) {
+
+	service, repositories := newTestCertsService(t)
+	provider := &testRecordProvider{zone: "example.com"}
+	service.zoneService.RecordProvider = provider
+	zoneRepository := repositories.Zone
+	zoneRepository.UpsertZone(zonerepository.Zone{Zone: "example.com", CAAManaged: true})
+
+	now := time.Now()
+	old := now.Add(-sweepMinAge - time.Minute).UnixMilli()
+	txt := func(name string, content string, createdTs int64) {
+		provider.CreateRecord("example.com", acme.DNSRecord{Type: "TXT", Name: name, Content: content, CreatedTs: createdTs})
+	}
+
+	// Stale and recent records of the service, and old records it did not create
+	txt("_acme-challenge.stale.example.com", "stale", old)
+	txt("_acme-challenge.recent.example.com", "recent", now.UnixMilli())
+	txt("_acme-challenge.foreign.example.com", "foreign", old)
+	txt("_acme-challenge.stale.example.com", "foreign", old)
+	txt("other.example.com", "stale", old)
+	for _, record := range []zonerepository.ChallengeRecord{
+		{Fqdn: "_acme-challenge.stale.example.com", Value: "stale", CreatedTs: old},
+		{Fqdn: "_acme-challenge.recent.example.com", Value: "recent", CreatedTs: now.UnixMilli()},
+		{Fqdn: "_acme-challenge.gone.example.com", Value: "gone", CreatedTs: old},
+	} {
+		zoneRepository.InsertChallengeRecord(record)
+	}
+
+	result, err := service.SweepChallenges(now.UnixMilli())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Removed) != 1 || result.Removed[0].Content != "stale" {
+		t.Fatalf("removed %+v, want the stale record of the service only", result.Removed)
+	}
+
+	remaining, _ := provider.ListRecords("example.com", "TXT")
+	if len(remaining) != 4 {
+		t.Fatalf("%d records left, want 4", len(remaining))
+	}
+
+	// The removed and vanished records are forgotten, the recent one is kept
+	records, _ := zoneRepository.ListChallengeRecords()
+	if len(records) != 1 || records[0].Value != "recent" {
+		t.Fatalf("challenge records %+v, want the recent one", records)
+	}
+}
@@ -0,0 +1,97 @@
+package certs
+
+import (
+	"log"
+	"time"
+
+	"github.com/widhaprasa/go-acme-service/env"
+)
+
+// TrashCerts moves the cert of main to the trash, it is no longer listed nor renewed until restored
+func (c *CertsService) TrashCerts(ts int64, main string) error {
+
+	_, err := c.certsRepository.TrashCerts(main, ts)
+	if err != nil {
+		log.Println("Unable to trash certs", main, ":", err)
+		return err
+	}
+
+	log.Println("Trash certs:", main)
+	return nil
+}
+
+// RestoreCerts takes the trashed cert having domain as a SAN out of the trash, returning its main.
+// It fails if an active cert was issued for one of its SANs in the meantime
+func (c *CertsService) RestoreCerts(domain string) (string, error) {
+
+	certs, err := c.certsRepository.GetTrashedCerts(domain)
+	if err != nil {
+		return "", err
+	}
+	main := certs.Main
+
+	list, err := c.certsRepository.ListOverlappingCerts(certs.Sans)
+	if err != nil {
+		return "", err
+	}
+	if len(list) > 0 {
+		overlaps := []Overlap{}
+		for _, v := range list {
+			overlaps = append(overlaps, Overlap{
+				Main:   v.Main,
+				Sans:   v.Sans,
+				Shared: intersect(v.Sans, certs.Sans),
+			})
+		}
+		return "", &OverlapError{Mode: OverlapFail, Overlaps: overlaps}
+	}
+
+	_, err = c.certsRepository.RestoreCerts(main)
+	if err != nil {
+		log.Println("Unable to restore certs", main, ":", err)
+		return "", err
+	}
+
+	log.Println("Restore certs:", main)
+	return main, nil
+}
+
+// PurgeTrash permanently deletes the certs trashed longer than the retention period, along with their
+// version history and webhook, returning the purged mains
+func (c *CertsService) PurgeTrash(ts int64) ([]string, error) {
+
+	retention := time.Duration(env.TRASH_RETENTION) * 24 * time.Hour
+	cutoffTs := ts - retention.Milliseconds()
+
+	list, err := c.certsRepository.ListTrashedCerts()
+	if err != nil {
+		log.Println("Unable to list trashed certs:", err)
+		return nil, err
+	}
+
+	purged := []string{}
+	for _, v := range list {
+
+		if v.DeletedTs > cutoffTs {
+			break
+		}
+		main := v.Main
+
+		result, err := c.certsRepository.PurgeCerts(main, cutoffTs)
+		if err != nil {
+			log.Println("Unable to purge certs", main, ":", err)
+			return purged, err
+		}
+
+		// Restored or reissued since it was listed
+		count, err := result.RowsAffected()
+		if err != nil || count == 0 {
+			continue
+		}
+
+		log.Println("Purge trashed certs:", main)
+		purged = append(purged, main)
+	}
+
+	return purged, nil
+}
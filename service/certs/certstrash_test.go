@@ -0,0 +1,111 @@
+package certs
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/widhaprasa/go-acme-service/env"
+	webhookrepository "github.com/widhaprasa/go-acme-service/repository/webhook"
+)
+
+func TestTrashRestoreCerts(t *testing.T) {
+
+	certsService, repositories := newTestCertsService(t)
+	upsertTestCert(t, repositories, "a.com", "www.a.com")
+
+	err := certsService.TrashCerts(1000, "a.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = repositories.Certs.GetCerts("www.a.com", false); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("GetCerts trashed: got %v, want sql.ErrNoRows", err)
+	}
+
+	// Restored by any of its SANs
+	main, err := certsService.RestoreCerts("WWW.a.com")
+	if err != nil || main != "a.com" {
+		t.Fatalf("RestoreCerts: got %q %v", main, err)
+	}
+	if _, err = repositories.Certs.GetCerts("www.a.com", false); err != nil {
+		t.Fatalf("GetCerts restored: %v", err)
+	}
+
+	if _, err = certsService.RestoreCerts("a.com"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("RestoreCerts active: got %v, want sql.ErrNoRows", err)
+	}
+}
+
+func TestRestoreCertsOverlap(t *testing.T) {
+
+	certsService, repositories := newTestCertsService(t)
+	upsertTestCert(t, repositories, "a.com", "www.a.com")
+	err := certsService.TrashCerts(1000, "a.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A cert issued meanwhile for one of its SANs keeps it in the trash
+	upsertTestCert(t, repositories, "www.a.com")
+	_, err = certsService.RestoreCerts("a.com")
+	var overlapErr *OverlapError
+	if !errors.As(err, &overlapErr) || len(overlapErr.Overlaps) != 1 || overlapErr.Overlaps[0].Main != "www.a.com" {
+		t.Fatalf("RestoreCerts: got %v, want overlap with www.a.com", err)
+	}
+	if _, err = repositories.Certs.GetTrashedCerts("a.com"); err != nil {
+		t.Errorf("GetTrashedCerts: %v", err)
+	}
+}
+
+func TestPurgeTrash(t *testing.T) {
+
+	retention := env.TRASH_RETENTION
+	t.Cleanup(func() { env.TRASH_RETENTION = retention })
+	env.TRASH_RETENTION = 30
+
+	certsService, repositories := newTestCertsService(t)
+	ts := time.Now().UnixMilli()
+	day := (24 * time.Hour).Milliseconds()
+
+	for main, trashedDays := range map[string]int64{"old.com": 31, "new.com": 29, "active.com": 0} {
+		upsertTestCert(t, repositories, main)
+		_, err := repositories.Webhook.UpsertWebhook(webhookrepository.Webhook{Main: main, Url: "https://hook"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if trashedDays > 0 {
+			err = certsService.TrashCerts(ts-trashedDays*day, main)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	purged, err := certsService.PurgeTrash(ts)
+	if err != nil || len(purged) != 1 || purged[0] != "old.com" {
+		t.Fatalf("PurgeTrash: got %v %v, want old.com", purged, err)
+	}
+
+	// The purged cert is gone along with its versions and webhook
+	if _, err = repositories.Certs.GetTrashedCerts("old.com"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetTrashedCerts purged: got %v", err)
+	}
+	versions, err := repositories.Certs.ListVersions("old.com")
+	if err != nil || len(versions) != 0 {
+		t.Errorf("ListVersions purged: got %v %v", versions, err)
+	}
+	webhooks, err := repositories.Webhook.MapWebhook([]string{"old.com", "new.com", "active.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, exists := webhooks["old.com"]; exists {
+		t.Error("webhook of the purged cert was kept")
+	}
+	if len(webhooks) != 2 {
+		t.Errorf("webhooks: got %v, want new.com and active.com", webhooks)
+	}
+	if _, err = repositories.Certs.GetTrashedCerts("new.com"); err != nil {
+		t.Errorf("GetTrashedCerts within retention: %v", err)
+	}
+}
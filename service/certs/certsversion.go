@@ -0,0 +1,180 @@
+package certs
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/go-acme/lego/v4/certificate"
+	certsrepository "github.com/widhaprasa/go-acme-service/repository/certs"
+)
+
+// Version is a cert version flagged when it is the one currently served
+type Version struct {
+	certsrepository.CertVersion
+	Current bool
+}
+
+// versionInfo returns the serial, SPKI SHA-256 fingerprint and issuer identifying a version
+func versionInfo(crt *x509.Certificate, trigger string) certsrepository.VersionInfo {
+
+	if crt == nil {
+		return certsrepository.VersionInfo{Trigger: trigger}
+	}
+
+	fingerprint := sha256.Sum256(crt.RawSubjectPublicKeyInfo)
+	issuer := crt.Issuer.CommonName
+	if issuer == "" {
+		issuer = crt.Issuer.String()
+	}
+
+	return certsrepository.VersionInfo{
+		Serial:         hex.EncodeToString(crt.SerialNumber.Bytes()),
+		KeyFingerprint: hex.EncodeToString(fingerprint[:]),
+		Issuer:         issuer,
+		Trigger:        trigger,
+	}
+}
+
+// ListVersions returns the versions of main, flagging the one currently served
+func (c *CertsService) ListVersions(main string) ([]Version, error) {
+
+	certs, err := c.certsRepository.GetCertsByMain(main)
+	if err != nil {
+		return nil, err
+	}
+
+	res := certificate.Resource{
+		Domain:      main,
+		PrivateKey:  certs.PrivateKey,
+		Certificate: certs.Certificate,
+	}
+	crt, _ := c.getX509Certificate(res)
+	currentSerial := versionInfo(crt, "").Serial
+
+	list, err := c.certsRepository.ListVersions(main)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]Version, 0, len(list))
+	for _, v := range list {
+		result = append(result, Version{CertVersion: v, Current: v.Serial == currentSerial})
+	}
+
+	return result, nil
+}
+
+// RollbackCerts promotes a prior version of main valid beyond the renew period and pushes it to the webhook
+func (c *CertsService) RollbackCerts(ts int64, main string, serial string) error {
+
+	version, err := c.certsRepository.GetVersion(main, serial)
+	if err != nil {
+		return err
+	}
+
+	privateKey := version.PrivateKey
+	certificate_ := version.Certificate
+
+	res := certificate.Resource{
+		Domain:      main,
+		PrivateKey:  privateKey,
+		Certificate: certificate_,
+	}
+	crt, err := c.getX509Certificate(res)
+	if err != nil {
+		return err
+	}
+	if crt.NotAfter.Before(time.Now()) {
+		return errors.New("Version " + serial + " is expired")
+	}
+
+	// The next renewal would replace it right away
+	if crt.NotAfter.Before(time.UnixMilli(ts).Add(renewPeriod)) {
+		return errors.New("Version " + serial + " expires within the renew period and would be renewed at the next run")
+	}
+
+	log.Println("Rollback certs:", main, "to version:", serial)
+
+	email := version.Email
+
+	// The ARI window is fetched with the account of the cert as generate and renew do,
+	// only certs having an ARI cert id need it
+	metadata := certMetadata(main, certificate_, nil)
+	if metadata != nil && metadata.AriCertId != "" {
+		client, err := c.clientService.GetClient(ts, email, main)
+		if err != nil {
+			log.Println("Unable to get client:", email)
+		} else {
+			metadata = certMetadata(main, certificate_, client.Certificate)
+		}
+	}
+
+	_, err = c.certsRepository.UpsertCerts(certsrepository.Cert{
+		Main:        main,
+		Sans:        version.Sans,
+		Email:       email,
+		PrivateKey:  privateKey,
+		Certificate: certificate_,
+		NotBeforeTs: crt.NotBefore.UnixMilli(),
+		NotAfterTs:  crt.NotAfter.UnixMilli(),
+		UpsertedTs:  ts,
+		Metadata:    metadata,
+	}, versionInfo(crt, "rollback"))
+	if err != nil {
+		log.Println("Failed to rollback certs", main, ":", err)
+		return err
+	}
+
+	// Push to webhook
+	c.webhookPush("rollback", main, email, privateKey, certificate_, "", map[string]any{}, map[string]any{
+		"serial": version.Serial,
+	})
+
+	return nil
+}
+
+// BackfillVersions records the current cert of every main having no version yet
+func (c *CertsService) BackfillVersions(ts int64) error {
+
+	list, err := c.certsRepository.ListCerts()
+	if err != nil {
+		return err
+	}
+
+	for _, v := range list {
+
+		main := v.Main
+
+		versions, err := c.certsRepository.ListVersions(main)
+		if err != nil {
+			return err
+		}
+		if len(versions) > 0 {
+			continue
+		}
+
+		res := certificate.Resource{
+			Domain:      main,
+			PrivateKey:  v.PrivateKey,
+			Certificate: v.Certificate,
+		}
+		crt, err := c.getX509Certificate(res)
+		if err != nil {
+			continue
+		}
+
+		if v.Metadata == nil {
+			v.Metadata = certMetadata(main, v.Certificate, nil)
+		}
+		_, err = c.certsRepository.UpsertCerts(v, versionInfo(crt, "backfill"))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
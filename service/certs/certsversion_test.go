@@ -0,0 +1,98 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/widhaprasa/go-acme-service/repository"
+	certsrepository "github.com/widhaprasa/go-acme-service/repository/certs"
+)
+
+// upsertTestVersion stores a self-signed certificate of main valid until notAfter, returning its serial
+func upsertTestVersion(t *testing.T, repositories *repository.Repositories, main string, serialNumber int64,
+	notAfter time.Time) string {
+
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serialNumber),
+		Subject:      pkix.Name{CommonName: main},
+		DNSNames:     []string{main},
+		NotBefore:    notAfter.Add(-90 * 24 * time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	crt, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = repositories.Certs.UpsertCerts(certsrepository.Cert{
+		Main:        main,
+		Sans:        []string{main},
+		Email:       "admin@example.com",
+		PrivateKey:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+		Certificate: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		NotBeforeTs: template.NotBefore.UnixMilli(),
+		NotAfterTs:  template.NotAfter.UnixMilli(),
+	}, versionInfo(crt, "generate"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return versionInfo(crt, "generate").Serial
+}
+
+func TestRollbackCerts(t *testing.T) {
+
+	service, repositories := newTestCertsService(t)
+	now := time.Now()
+
+	expired := upsertTestVersion(t, repositories, "example.com", 1, now.Add(-time.Hour))
+	expiring := upsertTestVersion(t, repositories, "example.com", 2, now.Add(renewPeriod-24*time.Hour))
+	valid := upsertTestVersion(t, repositories, "example.com", 3, now.Add(renewPeriod+24*time.Hour))
+	upsertTestVersion(t, repositories, "example.com", 4, now.Add(80*24*time.Hour))
+
+	tests := []struct {
+		serial string
+		err    string
+	}{
+		{expired, "is expired"},
+		{expiring, "within the renew period"},
+		{valid, ""},
+	}
+	for _, test := range tests {
+		err := service.RollbackCerts(now.UnixMilli(), "example.com", test.serial)
+		if test.err == "" && err != nil {
+			t.Errorf("%s: %v", test.serial, err)
+		}
+		if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("%s: got %v, want %q", test.serial, err, test.err)
+		}
+	}
+
+	certs, err := repositories.Certs.GetCertsByMain("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if certs.Serial != valid {
+		t.Fatalf("current serial %q, want the rolled back version %q", certs.Serial, valid)
+	}
+}
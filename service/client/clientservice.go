@@ -8,23 +8,48 @@ import (
 	"github.com/go-acme/lego/v4/challenge"
 	"github.com/go-acme/lego/v4/challenge/dns01"
 	"github.com/go-acme/lego/v4/lego"
-	"github.com/go-acme/lego/v4/providers/dns/cloudflare"
 	"github.com/go-acme/lego/v4/registration"
 	"github.com/widhaprasa/go-acme-service/acme"
-	"github.com/widhaprasa/go-acme-service/repository/client"
+	"github.com/widhaprasa/go-acme-service/env"
+	clientrepository "github.com/widhaprasa/go-acme-service/repository/client"
 )
 
 type ClientService struct {
-	Clientrepository client.ClientRepository
+	Clientrepository clientrepository.ClientRepository
+
+	// TrackProvider wraps the DNS provider of every client when set, to record the challenge records created
+	TrackProvider func(provider challenge.Provider) challenge.Provider
+}
+
+// Key types accepted in ACME_KEY_TYPE
+var keyTypes = []certcrypto.KeyType{
+	certcrypto.EC256, certcrypto.EC384,
+	certcrypto.RSA2048, certcrypto.RSA3072, certcrypto.RSA4096, certcrypto.RSA8192,
+}
+
+// KeyType returns the key type of issued certificates configured by ACME_KEY_TYPE
+func KeyType() (certcrypto.KeyType, error) {
+
+	for _, keyType := range keyTypes {
+		if string(keyType) == env.ACME_KEY_TYPE {
+			return keyType, nil
+		}
+	}
+	return "", fmt.Errorf("Unknown ACME_KEY_TYPE %q, expected one of %v", env.ACME_KEY_TYPE, keyTypes)
 }
 
 func (c *ClientService) GetClient(ts int64, email string, main string) (*lego.Client, error) {
 
-	// Using production CA server
-	caServer := lego.LEDirectoryProduction
+	// Using configured CA server
+	caServer := env.ACME_CA_SERVER
 	var client *lego.Client
 
-	clientMap, err := c.Clientrepository.GetClient(email)
+	keyType, err := KeyType()
+	if err != nil {
+		return nil, err
+	}
+
+	account, err := c.Clientrepository.GetClient(email)
 	if err != nil {
 		log.Println("Create new user:", email)
 
@@ -37,7 +62,7 @@ func (c *ClientService) GetClient(ts int64, email string, main string) (*lego.Cl
 		// Config for request to LE server
 		config := lego.NewConfig(user)
 		config.CADirURL = caServer
-		config.Certificate.KeyType = certcrypto.RSA4096
+		config.Certificate.KeyType = keyType
 		config.UserAgent = fmt.Sprintf("widhaprasa-acme/%s", "1.0")
 
 		// Create ACME client
@@ -56,7 +81,12 @@ func (c *ClientService) GetClient(ts int64, email string, main string) (*lego.Cl
 		user.Registration = res
 
 		// Save client to database
-		_, err = c.Clientrepository.UpsertClient(email, user.Registration.URI, user.PrivateKey, ts)
+		_, err = c.Clientrepository.UpsertClient(clientrepository.Account{
+			Email:      email,
+			Uri:        user.Registration.URI,
+			PrivateKey: user.PrivateKey,
+			UpsertedTs: ts,
+		})
 		if err != nil {
 			log.Println("Failed to insert client", email, ":", err)
 			return nil, err
@@ -64,11 +94,7 @@ func (c *ClientService) GetClient(ts int64, email string, main string) (*lego.Cl
 
 	} else {
 
-		email = clientMap["email"].(string)
-		uri := clientMap["uri"].(string)
-		privateKey := clientMap["private_key"].([]byte)
-
-		user, err := acme.NewUserFull(email, uri, privateKey)
+		user, err := acme.NewUserFull(account.Email, account.Uri, account.PrivateKey)
 		if err != nil {
 			log.Println("Unable to create user", email, ":", err)
 			return nil, err
@@ -77,7 +103,7 @@ func (c *ClientService) GetClient(ts int64, email string, main string) (*lego.Cl
 		// Config for request to LE server
 		config := lego.NewConfig(user)
 		config.CADirURL = caServer
-		config.Certificate.KeyType = certcrypto.RSA4096
+		config.Certificate.KeyType = keyType
 		config.UserAgent = fmt.Sprintf("widhaprasa-acme/%s", "1.0")
 
 		// Create ACME client
@@ -97,22 +123,17 @@ func (c *ClientService) GetClient(ts int64, email string, main string) (*lego.Cl
 	}
 
 	// Using cloudflare DNS provider
-	isDefault, timeout, interval := acme.GetTimeoutAndIntervalForDomain(main)
-	var dnsProvider challenge.Provider
-	if isDefault {
-		dnsProvider, err = cloudflare.NewDNSProvider()
-	} else {
-		dnsProvider, err = acme.NewCloudflareDNSCustomTimeoutProvider(timeout, interval)
-	}
+	dnsProvider, err := acme.NewDNSProvider(main)
 	if err != nil {
 		log.Println("Unable to initiate Cloudflare DNS Provider:", err)
 		return nil, err
 	}
-
-	resolvers := []string{
-		"1.1.1.1:53",
+	if c.TrackProvider != nil {
+		dnsProvider = c.TrackProvider(dnsProvider)
 	}
 
+	resolvers := acme.Resolvers
+
 	err = client.Challenge.SetDNS01Provider(dnsProvider,
 		dns01.CondOption(len(resolvers) > 0, dns01.AddRecursiveNameservers(resolvers)),
 		dns01.WrapPreCheck(func(domain, fqdn, value string, check dns01.PreCheckFunc) (bool, error) {
@@ -0,0 +1,79 @@
+package zone
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	"github.com/go-acme/lego/v4/challenge"
+	"github.com/go-acme/lego/v4/challenge/dns01"
+
+	zonerepository "github.com/widhaprasa/go-acme-service/repository/zone"
+)
+
+// trackedProvider records the challenge records created through provider, so the sweeper only ever
+// removes records of the service, whichever replica created them
+type trackedProvider struct {
+	provider       challenge.Provider
+	zoneRepository zonerepository.ZoneRepository
+}
+
+// TrackProvider returns provider recording the challenge records it creates until they are cleaned up
+func (z *ZoneService) TrackProvider(provider challenge.Provider) challenge.Provider {
+	return &trackedProvider{
+		provider:       provider,
+		zoneRepository: z.ZoneRepository,
+	}
+}
+
+func (t *trackedProvider) Present(domain, token, keyAuth string) error {
+
+	// Recorded first, a record left behind by a process dying mid-order is then still known
+	info := dns01.GetChallengeInfo(domain, keyAuth)
+	fqdn := ChallengeFqdn(info.EffectiveFQDN)
+	_, err := t.zoneRepository.InsertChallengeRecord(zonerepository.ChallengeRecord{
+		Fqdn:      fqdn,
+		Value:     info.Value,
+		CreatedTs: time.Now().UnixMilli(),
+	})
+	if err != nil {
+		log.Println("Unable to record challenge record", fqdn, ":", err)
+		return err
+	}
+
+	err = t.provider.Present(domain, token, keyAuth)
+	if err != nil {
+		t.zoneRepository.DeleteChallengeRecord(fqdn, info.Value)
+		return err
+	}
+	return nil
+}
+
+func (t *trackedProvider) CleanUp(domain, token, keyAuth string) error {
+
+	err := t.provider.CleanUp(domain, token, keyAuth)
+	if err != nil {
+		return err
+	}
+
+	info := dns01.GetChallengeInfo(domain, keyAuth)
+	_, err = t.zoneRepository.DeleteChallengeRecord(ChallengeFqdn(info.EffectiveFQDN), info.Value)
+	if err != nil {
+		log.Println("Unable to delete challenge record", info.EffectiveFQDN, ":", err)
+	}
+	return nil
+}
+
+// Timeout keeps the propagation timeout of provider
+func (t *trackedProvider) Timeout() (time.Duration, time.Duration) {
+
+	if p, ok := t.provider.(challenge.ProviderTimeout); ok {
+		return p.Timeout()
+	}
+	return dns01.DefaultPropagationTimeout, dns01.DefaultPollingInterval
+}
+
+// ChallengeFqdn normalizes a record name as challenge records are stored, lower case without the trailing dot
+func ChallengeFqdn(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
@@ -0,0 +1,74 @@
+package zone
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-acme/lego/v4/challenge"
+	"github.com/go-acme/lego/v4/challenge/dns01"
+
+	"github.com/widhaprasa/go-acme-service/repository"
+)
+
+type testProvider struct {
+	err error
+}
+
+func (p *testProvider) Present(domain, token, keyAuth string) error {
+	return p.err
+}
+
+func (p *testProvider) CleanUp(domain, token, keyAuth string) error {
+	return nil
+}
+
+func (p *testProvider) Timeout() (time.Duration, time.Duration) {
+	return time.Hour, time.Minute
+}
+
+func TestTrackProvider(t *testing.T) {
+
+	t.Setenv("LEGO_DISABLE_CNAME_SUPPORT", "true")
+	repositories := repository.NewMemory()
+	z := &ZoneService{
+		ZoneRepository:   repositories.Zone,
+		ClientRepository: repositories.Client,
+	}
+	inner := &testProvider{}
+	provider := z.TrackProvider(inner)
+
+	// The propagation timeout of the provider is kept
+	if p, ok := provider.(challenge.ProviderTimeout); !ok {
+		t.Fatal("timeout is not kept")
+	} else if timeout, _ := p.Timeout(); timeout != time.Hour {
+		t.Fatalf("timeout %v, want 1h", timeout)
+	}
+
+	err := provider.Present("A.example.com", "token", "keyAuth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, _ := repositories.Zone.ListChallengeRecords()
+	info := dns01.GetChallengeInfo("A.example.com", "keyAuth")
+	if len(records) != 1 || records[0].Fqdn != "_acme-challenge.a.example.com" || records[0].Value != info.Value {
+		t.Fatalf("challenge records %+v, want the presented one", records)
+	}
+
+	err = provider.CleanUp("A.example.com", "token", "keyAuth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, _ = repositories.Zone.ListChallengeRecords()
+	if len(records) != 0 {
+		t.Fatalf("challenge records %+v after clean up", records)
+	}
+
+	// A record that could not be created is not kept
+	inner.err = errors.New("denied")
+	provider.Present("b.example.com", "token", "keyAuth")
+	records, _ = repositories.Zone.ListChallengeRecords()
+	if len(records) != 0 {
+		t.Fatalf("challenge records %+v after a failed present", records)
+	}
+}
@@ -0,0 +1,222 @@
+package zone
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/widhaprasa/go-acme-service/acme"
+	"github.com/widhaprasa/go-acme-service/env"
+	clientrepository "github.com/widhaprasa/go-acme-service/repository/client"
+	zonerepository "github.com/widhaprasa/go-acme-service/repository/zone"
+)
+
+type ZoneService struct {
+	ZoneRepository   zonerepository.ZoneRepository
+	ClientRepository clientrepository.ClientRepository
+	RecordProvider   acme.RecordProvider
+
+	// Initiates RecordProvider once when it is not set
+	providerOnce sync.Once
+	providerErr  error
+}
+
+func (z *ZoneService) UpdateZone(ts int64, zone string, caaAccountUri bool) error {
+
+	if zone == "" {
+		return errors.New("No zone was given")
+	}
+
+	_, err := z.ZoneRepository.UpsertZone(zonerepository.Zone{
+		Zone:          zone,
+		CAAManaged:    true,
+		CAAAccountUri: caaAccountUri,
+		UpsertedTs:    ts,
+	})
+	if err != nil {
+		log.Println("Failed to upsert zone", zone, ":", err)
+		return err
+	}
+	return nil
+}
+
+// DeleteZone removes the CAA records created for zone and stops managing it
+func (z *ZoneService) DeleteZone(zone string) ([]string, error) {
+
+	zone_, err := z.ZoneRepository.GetZone(zone)
+	if err != nil {
+		return nil, err
+	}
+
+	provider, err := z.GetRecordProvider()
+	if err != nil {
+		return nil, err
+	}
+
+	removed := []string{}
+	remaining := []string{}
+	for _, id := range zone_.CAARecords {
+		err = provider.DeleteRecord(zone, id)
+		if err != nil {
+			log.Println("Failed to delete CAA record", id, "on zone", zone, ":", err)
+			remaining = append(remaining, id)
+			continue
+		}
+		removed = append(removed, id)
+	}
+
+	if len(remaining) > 0 {
+		z.ZoneRepository.UpdateCAARecords(zone, remaining)
+		return removed, errors.New("Unable to delete all CAA records on zone " + zone)
+	}
+
+	_, err = z.ZoneRepository.DeleteZone(zone)
+	if err != nil {
+		return removed, err
+	}
+	return removed, nil
+}
+
+// EnsureCAA creates the CAA records authorizing the configured CA on every managed zone of domains
+func (z *ZoneService) EnsureCAA(domains []string, email string) error {
+
+	for zone, zoneDomains := range z.groupByZone(domains) {
+
+		zone_, err := z.ZoneRepository.GetZone(zone)
+		if err != nil || !zone_.CAAManaged {
+			continue
+		}
+
+		provider, err := z.GetRecordProvider()
+		if err != nil {
+			return err
+		}
+
+		existing, err := provider.ListRecords(zone, "CAA")
+		if err != nil {
+			return err
+		}
+
+		recordIds := zone_.CAARecords
+		for _, wanted := range z.wantedRecords(zone, zoneDomains, zone_.CAAAccountUri, email) {
+
+			if hasRecord(existing, wanted) {
+				continue
+			}
+
+			log.Println("Create CAA record on zone", zone, ":", wanted.Tag, wanted.Value)
+			created, err := provider.CreateRecord(zone, wanted)
+			if err != nil {
+				return err
+			}
+			recordIds = append(recordIds, created.ID)
+
+			_, err = z.ZoneRepository.UpdateCAARecords(zone, recordIds)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// CAAStatus reports whether the zone of domain is managed and already has the wanted CAA records
+func (z *ZoneService) CAAStatus(domain string, email string) (bool, string, bool, error) {
+
+	zone, err := acme.FindZone(domain)
+	if err != nil {
+		return false, "", false, err
+	}
+
+	zone_, err := z.ZoneRepository.GetZone(zone)
+	if err != nil || !zone_.CAAManaged {
+		return false, zone, false, nil
+	}
+
+	provider, err := z.GetRecordProvider()
+	if err != nil {
+		return true, zone, false, err
+	}
+
+	existing, err := provider.ListRecords(zone, "CAA")
+	if err != nil {
+		return true, zone, false, err
+	}
+
+	for _, wanted := range z.wantedRecords(zone, []string{domain}, zone_.CAAAccountUri, email) {
+		if !hasRecord(existing, wanted) {
+			return true, zone, false, nil
+		}
+	}
+	return true, zone, true, nil
+}
+
+func (z *ZoneService) wantedRecords(zone string, domains []string, caaAccountUri bool, email string) []acme.DNSRecord {
+
+	value := env.ACME_CAA_IDENTITY
+	if caaAccountUri && email != "" {
+		account, err := z.ClientRepository.GetClient(email)
+		if err == nil && account.Uri != "" {
+			value += "; accounturi=" + account.Uri
+		}
+	}
+
+	result := []acme.DNSRecord{
+		{Type: "CAA", Name: zone, Tag: "issue", Value: value},
+	}
+	for _, domain := range domains {
+		if strings.HasPrefix(domain, "*.") {
+			result = append(result, acme.DNSRecord{Type: "CAA", Name: zone, Tag: "issuewild", Value: value})
+			break
+		}
+	}
+	return result
+}
+
+func (z *ZoneService) groupByZone(domains []string) map[string][]string {
+
+	result := map[string][]string{}
+	for _, domain := range domains {
+		zone, err := acme.FindZone(domain)
+		if err != nil {
+			log.Println("Unable to find zone for", domain, ":", err)
+			continue
+		}
+		result[zone] = append(result[zone], domain)
+	}
+	return result
+}
+
+func (z *ZoneService) GetRecordProvider() (acme.RecordProvider, error) {
+
+	z.providerOnce.Do(func() {
+		if z.RecordProvider != nil {
+			return
+		}
+		z.RecordProvider, z.providerErr = acme.NewRecordProvider()
+		if z.providerErr != nil {
+			log.Println("Unable to initiate DNS record provider:", z.providerErr)
+		}
+	})
+	if z.providerErr != nil {
+		return nil, z.providerErr
+	}
+	return z.RecordProvider, nil
+}
+
+func hasRecord(records []acme.DNSRecord, wanted acme.DNSRecord) bool {
+
+	normalize := func(value string) string {
+		return strings.ToLower(strings.ReplaceAll(value, " ", ""))
+	}
+
+	for _, record := range records {
+		if strings.EqualFold(strings.TrimSuffix(record.Name, "."), wanted.Name) && record.Tag == wanted.Tag &&
+			normalize(record.Value) == normalize(wanted.Value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,41 @@
+package zone
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/widhaprasa/go-acme-service/acme"
+	"github.com/widhaprasa/go-acme-service/repository"
+)
+
+func TestGetRecordProvider(t *testing.T) {
+
+	t.Setenv("CF_DNS_API_TOKEN", "test")
+	repositories := repository.NewMemory()
+	z := &ZoneService{
+		ZoneRepository:   repositories.Zone,
+		ClientRepository: repositories.Client,
+	}
+
+	// Concurrent callers share a single provider
+	providers := make([]acme.RecordProvider, 8)
+	var wg sync.WaitGroup
+	for i := range providers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			provider, err := z.GetRecordProvider()
+			if err != nil {
+				t.Error(err)
+			}
+			providers[i] = provider
+		}()
+	}
+	wg.Wait()
+
+	for _, provider := range providers {
+		if provider == nil || provider != providers[0] {
+			t.Fatal("callers got different providers")
+		}
+	}
+}